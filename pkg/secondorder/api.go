@@ -0,0 +1,287 @@
+package secondorder
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Statuses of the scans submitted to an APIServer
+const (
+	JobQueued    = "queued"
+	JobRunning   = "running"
+	JobDone      = "done"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+// ScanJob is a scan submitted to an APIServer
+type ScanJob struct {
+	ID     string `json:"id"`
+	Target string `json:"target"`
+	Status string `json:"status"`
+	// Why the scan failed
+	Error     string     `json:"error,omitempty"`
+	Submitted time.Time  `json:"submitted"`
+	Start     *time.Time `json:"start,omitempty"`
+	End       *time.Time `json:"end,omitempty"`
+	// Counts of what the scan found so far, like summary.json
+	Summary *ScanSummary `json:"summary,omitempty"`
+
+	cancel context.CancelFunc
+	result *Result
+}
+
+// APIServer runs the scans submitted to its REST API with a Scanner, for recon pipelines that run second-order as a service
+//   - POST /scans {"target": URL}: queues the scan of a target, the response is its job
+//   - GET /scans: every job, in the order they were submitted
+//   - GET /scans/{id}: a job, with the counts of what its scan found so far
+//   - GET /scans/{id}/results: the Result of a scan, what was found until then while it's running
+//   - DELETE /scans/{id}: cancels a scan, what it found until then is kept
+//
+// finished jobs are kept in memory until the server stops, and their results are saved in the store under their id
+// the scans themselves are evicted from the Scanner once they're finished
+type APIServer struct {
+	scanner *Scanner
+	store   ResultStore
+	token   string
+	// A slot is taken by every running scan
+	slots chan struct{}
+
+	mu   sync.Mutex
+	jobs map[string]*ScanJob
+	ids  []string
+	wg   sync.WaitGroup
+}
+
+// NewAPIServer returns a server that runs at most parallel scans at the same time and saves their results in store, if it isn't nil
+// the requests need an "Authorization: Bearer <token>" header if token isn't empty
+func NewAPIServer(sc *Scanner, store ResultStore, parallel int, token string) *APIServer {
+	if parallel < 1 {
+		parallel = 1
+	}
+	sc.served = true
+	return &APIServer{
+		scanner: sc,
+		store:   store,
+		token:   token,
+		slots:   make(chan struct{}, parallel),
+		jobs:    make(map[string]*ScanJob),
+	}
+}
+
+// Serve answers the requests sent to addr until ctx is cancelled, then cancels the running scans and waits for their results to be saved
+func (s *APIServer) Serve(ctx context.Context, addr string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	server := &http.Server{
+		Addr: addr,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s.handle(ctx, w, r)
+		}),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServe()
+	}()
+
+	var err error
+	select {
+	case err = <-served:
+	case <-ctx.Done():
+		shutdown, done := context.WithTimeout(context.Background(), 5*time.Second)
+		server.Shutdown(shutdown)
+		done()
+	}
+	cancel()
+	s.wg.Wait()
+	if err == http.ErrServerClosed {
+		err = nil
+	}
+	return err
+}
+
+func (s *APIServer) handle(ctx context.Context, w http.ResponseWriter, r *http.Request) {
+	if s.token != "" {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			apiError(w, http.StatusUnauthorized, "missing or wrong bearer token")
+			return
+		}
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "scans" || len(parts) > 3 || (len(parts) == 3 && parts[2] != "results") {
+		apiError(w, http.StatusNotFound, "not found")
+		return
+	}
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		var params struct {
+			Target string `json:"target"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&params); err != nil || params.Target == "" {
+			apiError(w, http.StatusBadRequest, `the body must be a JSON object with a "target"`)
+			return
+		}
+		job, err := s.submit(ctx, params.Target)
+		if err != nil {
+			apiError(w, http.StatusConflict, err.Error())
+			return
+		}
+		apiJSON(w, http.StatusAccepted, job)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		apiJSON(w, http.StatusOK, s.list())
+	case len(parts) == 1:
+		apiError(w, http.StatusMethodNotAllowed, "use GET or POST")
+	default:
+		s.mu.Lock()
+		job, ok := s.jobs[parts[1]]
+		s.mu.Unlock()
+		if !ok {
+			apiError(w, http.StatusNotFound, fmt.Sprintf("no scan with id %q", parts[1]))
+			return
+		}
+		switch {
+		case len(parts) == 3 && r.Method == http.MethodGet:
+			result := s.result(job)
+			if result == nil {
+				apiError(w, http.StatusConflict, fmt.Sprintf("scan %s has no results, it's %s", job.ID, s.view(job).Status))
+				return
+			}
+			apiJSON(w, http.StatusOK, result)
+		case len(parts) == 2 && r.Method == http.MethodGet:
+			apiJSON(w, http.StatusOK, s.view(job))
+		case len(parts) == 2 && r.Method == http.MethodDelete:
+			s.mu.Lock()
+			if job.Status == JobQueued || job.Status == JobRunning {
+				job.cancel()
+			}
+			s.mu.Unlock()
+			apiJSON(w, http.StatusOK, s.view(job))
+		default:
+			apiError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+	}
+}
+
+// submit queues the scan of a target, a target can't be scanned twice at the same time
+func (s *APIServer) submit(ctx context.Context, target string) (ScanJob, error) {
+	s.mu.Lock()
+	for _, id := range s.ids {
+		if job := s.jobs[id]; job.Target == target && (job.Status == JobQueued || job.Status == JobRunning) {
+			s.mu.Unlock()
+			return ScanJob{}, fmt.Errorf("%s is already being scanned by scan %s", target, id)
+		}
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	job := &ScanJob{ID: strconv.Itoa(len(s.ids) + 1), Target: target, Status: JobQueued, Submitted: time.Now(), cancel: cancel}
+	s.jobs[job.ID] = job
+	s.ids = append(s.ids, job.ID)
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.run(ctx, job)
+	return s.view(job), nil
+}
+
+// run waits for a slot, scans the target of a job and saves its results
+func (s *APIServer) run(ctx context.Context, job *ScanJob) {
+	defer s.wg.Done()
+	defer job.cancel()
+	select {
+	case s.slots <- struct{}{}:
+		defer func() { <-s.slots }()
+	case <-ctx.Done():
+		s.finish(ctx, job, nil, nil)
+		return
+	}
+	s.mu.Lock()
+	start := time.Now()
+	job.Status, job.Start = JobRunning, &start
+	s.mu.Unlock()
+
+	result, err := s.scanner.Run(ctx, job.Target)
+	if err == nil && s.store != nil {
+		if saveErr := result.Save(context.Background(), s.store, job.ID); saveErr != nil {
+			logger.WithTarget(job.Target).Errorf("Could not save the results of scan %s: %v", job.ID, saveErr)
+		}
+	}
+	s.finish(ctx, job, result, err)
+	// The job keeps the result, the scan and what it cached can go
+	s.scanner.evict(job.Target)
+}
+
+// finish records how the scan of a job ended, the context of a job is only cancelled before that when it's deleted or the server stops
+func (s *APIServer) finish(ctx context.Context, job *ScanJob, result *Result, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	end := time.Now()
+	job.End = &end
+	job.result = result
+	if err != nil {
+		job.Error = err.Error()
+	}
+	switch {
+	case ctx.Err() != nil:
+		job.Status = JobCancelled
+	case err != nil:
+		job.Status = JobFailed
+	default:
+		job.Status = JobDone
+	}
+}
+
+// result returns the result of a job, what its scan found so far while it's running
+func (s *APIServer) result(job *ScanJob) *Result {
+	s.mu.Lock()
+	status, result := job.Status, job.result
+	s.mu.Unlock()
+	if status == JobRunning {
+		return s.scanner.Result(job.Target)
+	}
+	return result
+}
+
+// view copies a job with the counts of its scan so far
+func (s *APIServer) view(job *ScanJob) ScanJob {
+	result := s.result(job)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	copied := *job
+	if result != nil {
+		copied.Summary = result.Summary
+	}
+	return copied
+}
+
+func (s *APIServer) list() []ScanJob {
+	s.mu.Lock()
+	jobs := make([]*ScanJob, len(s.ids))
+	for i, id := range s.ids {
+		jobs[i] = s.jobs[id]
+	}
+	s.mu.Unlock()
+	list := make([]ScanJob, len(jobs))
+	for i, job := range jobs {
+		list[i] = s.view(job)
+	}
+	return list
+}
+
+func apiJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func apiError(w http.ResponseWriter, status int, message string) {
+	apiJSON(w, status, map[string]string{"error": message})
+}
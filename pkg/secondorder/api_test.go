@@ -0,0 +1,188 @@
+package secondorder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apiClient sends requests to the API of a test server
+type apiClient struct {
+	t      *testing.T
+	server *httptest.Server
+	token  string
+}
+
+// do sends a request and decodes the JSON of the response into v, if it isn't nil
+func (c *apiClient) do(method, path, body string, v interface{}) int {
+	c.t.Helper()
+	req, _ := http.NewRequest(method, c.server.URL+path, strings.NewReader(body))
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		c.t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer res.Body.Close()
+	if v != nil {
+		if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+			c.t.Fatalf("%s %s: the response isn't JSON: %v", method, path, err)
+		}
+	}
+	return res.StatusCode
+}
+
+// waitFor polls a job until it has a status
+func (c *apiClient) waitFor(id, status string) ScanJob {
+	c.t.Helper()
+	var job ScanJob
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if c.do(http.MethodGet, "/scans/"+id, "", &job); job.Status == status {
+			return job
+		}
+	}
+	c.t.Fatalf("scan %s is %s, want %s", id, job.Status, status)
+	return job
+}
+
+func TestAPIServer(t *testing.T) {
+	// The slow page holds its scan until the test ends
+	release := make(chan struct{})
+	defer close(release)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><body><a href="/about">About</a></body></html>`)
+	}))
+	defer site.Close()
+
+	sc, err := NewScanner(Config{Options: Options{Depth: 2}})
+	if err != nil {
+		t.Fatalf("NewScanner: %v", err)
+	}
+	defer sc.Close()
+	dir := t.TempDir()
+	store, err := NewFileStore(dir, "")
+	if err != nil {
+		t.Fatalf("NewFileStore: %v", err)
+	}
+	s := NewAPIServer(sc, store, 1, "secret")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.handle(ctx, w, r)
+	}))
+	defer server.Close()
+	api := &apiClient{t: t, server: server, token: "secret"}
+
+	if status := (&apiClient{t: t, server: server, token: "wrong"}).do(http.MethodGet, "/scans", "", nil); status != http.StatusUnauthorized {
+		t.Errorf("a request with the wrong token got %d", status)
+	}
+	if status := api.do(http.MethodPost, "/scans", `{"url": "https://example.com/"}`, nil); status != http.StatusBadRequest {
+		t.Errorf("a scan without a target got %d", status)
+	}
+
+	// The first scan takes the only slot, the second one waits for it
+	var slow, fast ScanJob
+	if status := api.do(http.MethodPost, "/scans", fmt.Sprintf(`{"target": %q}`, site.URL+"/slow"), &slow); status != http.StatusAccepted || slow.ID != "1" {
+		t.Fatalf("POST /scans = %d %+v", status, slow)
+	}
+	if status := api.do(http.MethodPost, "/scans", fmt.Sprintf(`{"target": %q}`, site.URL+"/slow"), nil); status != http.StatusConflict {
+		t.Errorf("a target that's being scanned was submitted again with %d", status)
+	}
+	api.waitFor("1", JobRunning)
+	if status := api.do(http.MethodPost, "/scans", fmt.Sprintf(`{"target": %q}`, site.URL+"/"), &fast); status != http.StatusAccepted || fast.ID != "2" {
+		t.Fatalf("POST /scans = %d %+v", status, fast)
+	}
+	if job := api.waitFor("2", JobQueued); job.Start != nil {
+		t.Error("a queued scan has a start")
+	}
+	if status := api.do(http.MethodGet, "/scans/2/results", "", nil); status != http.StatusConflict {
+		t.Errorf("the results of a queued scan got %d", status)
+	}
+
+	var list []ScanJob
+	if api.do(http.MethodGet, "/scans", "", &list); len(list) != 2 || list[0].ID != "1" || list[1].ID != "2" {
+		t.Errorf("GET /scans = %+v, want the scans in the order they were submitted", list)
+	}
+
+	// Cancelling the first scan lets the second one run
+	var deleted ScanJob
+	if status := api.do(http.MethodDelete, "/scans/1", "", &deleted); status != http.StatusOK {
+		t.Errorf("DELETE /scans/1 = %d", status)
+	}
+	api.waitFor("1", JobCancelled)
+	done := api.waitFor("2", JobDone)
+	if done.Start == nil || done.End == nil || done.Summary == nil || done.Summary.Pages != 2 {
+		t.Errorf("GET /scans/2 = %+v, want the counts of a finished scan", done)
+	}
+	var result Result
+	if status := api.do(http.MethodGet, "/scans/2/results", "", &result); status != http.StatusOK || len(result.Pages) != 2 {
+		t.Errorf("GET /scans/2/results = %d with %d pages", status, len(result.Pages))
+	}
+	if saved, _ := filepath.Glob(filepath.Join(dir, "2", "*")); len(saved) == 0 {
+		entries, _ := os.ReadDir(dir)
+		t.Errorf("the results of scan 2 weren't saved under its id, the store has %v", entries)
+	}
+
+	// The job keeps the result of a finished scan, the scanner doesn't
+	for deadline := time.Now().Add(5 * time.Second); sc.Result(site.URL+"/") != nil; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("a finished scan wasn't evicted from the scanner")
+		}
+	}
+	if status := api.do(http.MethodGet, "/scans/2/results", "", &result); status != http.StatusOK || len(result.Pages) != 2 {
+		t.Errorf("GET /scans/2/results = %d with %d pages after the scan was evicted", status, len(result.Pages))
+	}
+
+	// A finished target can be scanned again
+	if status := api.do(http.MethodPost, "/scans", fmt.Sprintf(`{"target": %q}`, site.URL+"/"), nil); status != http.StatusAccepted {
+		t.Errorf("a target that was scanned before was submitted again with %d", status)
+	}
+	api.waitFor("3", JobDone)
+
+	tests := []struct {
+		method, path string
+		status       int
+	}{
+		{http.MethodGet, "/scans/9", http.StatusNotFound},
+		{http.MethodGet, "/scans/2/evidence", http.StatusNotFound},
+		{http.MethodGet, "/jobs", http.StatusNotFound},
+		{http.MethodPut, "/scans", http.StatusMethodNotAllowed},
+		{http.MethodPost, "/scans/2", http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		if status := api.do(tt.method, tt.path, "", nil); status != tt.status {
+			t.Errorf("%s %s = %d, want %d", tt.method, tt.path, status, tt.status)
+		}
+	}
+}
+
+func TestAPIServerWithoutToken(t *testing.T) {
+	sc, err := NewScanner(Config{})
+	if err != nil {
+		t.Fatalf("NewScanner: %v", err)
+	}
+	defer sc.Close()
+	s := NewAPIServer(sc, nil, 1, "")
+	w := httptest.NewRecorder()
+	s.handle(context.Background(), w, httptest.NewRequest(http.MethodGet, "/scans", nil))
+	if body, _ := ioutil.ReadAll(w.Body); w.Code != http.StatusOK || strings.TrimSpace(string(body)) != "[]" {
+		t.Errorf("GET /scans = %d %s, want an empty list", w.Code, body)
+	}
+}
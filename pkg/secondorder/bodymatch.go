@@ -0,0 +1,105 @@
+package secondorder
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Matches of each LogBodyRegex rule kept per page, a regex matching every line of a page shouldn't fill the results
+const maxBodyMatches = 50
+
+// BodyMatch is a match of a LogBodyRegex rule in the HTML of a page, saved in body-matches.json
+type BodyMatch struct {
+	Match string `json:"match"`
+	// Groups captured by the regex, named groups under their name and the others under their number
+	Groups map[string]string `json:"groups,omitempty"`
+}
+
+type bodyRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// compileBodyRules compiles the LogBodyRegex rules, sorted by name
+func compileBodyRules(patterns map[string]string) ([]bodyRule, error) {
+	var rules []bodyRule
+	for name, pattern := range patterns {
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid body regex %q: %v", name, err)
+		}
+		rules = append(rules, bodyRule{name: name, pattern: compiled})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].name < rules[j].name })
+	return rules, nil
+}
+
+// matchBody logs the matches of every LogBodyRegex rule in the body of a page
+func (s *scan) matchBody(page string, body []byte) {
+	for _, rule := range s.config.bodyRules {
+		names := rule.pattern.SubexpNames()
+		for _, submatches := range rule.pattern.FindAllSubmatch(body, maxBodyMatches) {
+			match := BodyMatch{Match: string(submatches[0])}
+			for i, group := range submatches[1:] {
+				if group == nil {
+					continue
+				}
+				if match.Groups == nil {
+					match.Groups = make(map[string]string)
+				}
+				name := names[i+1]
+				if name == "" {
+					name = strconv.Itoa(i + 1)
+				}
+				match.Groups[name] = string(group)
+			}
+			s.bodyMatches.add(page, rule.name, match)
+		}
+	}
+}
+
+// bodyMatches are the matches of the LogBodyRegex rules, keyed by page URL and then by rule
+type bodyMatches struct {
+	sync.Mutex
+	content map[string]map[string][]BodyMatch
+}
+
+func newBodyMatches() *bodyMatches {
+	return &bodyMatches{content: make(map[string]map[string][]BodyMatch)}
+}
+
+func (b *bodyMatches) add(page, rule string, match BodyMatch) {
+	b.Lock()
+	defer b.Unlock()
+	if _, ok := b.content[page]; !ok {
+		b.content[page] = make(map[string][]BodyMatch)
+	}
+	b.content[page][rule] = append(b.content[page][rule], match)
+}
+
+func (b *bodyMatches) copy() map[string]map[string][]BodyMatch {
+	b.Lock()
+	defer b.Unlock()
+	content := make(map[string]map[string][]BodyMatch, len(b.content))
+	for page, rules := range b.content {
+		content[page] = make(map[string][]BodyMatch, len(rules))
+		for rule, matches := range rules {
+			content[page][rule] = append([]BodyMatch(nil), matches...)
+		}
+	}
+	return content
+}
+
+// restore adds the matches saved by an interrupted run
+func (b *bodyMatches) restore(content map[string]map[string][]BodyMatch) {
+	for page, rules := range content {
+		for rule, matches := range rules {
+			for _, match := range matches {
+				b.add(page, rule, match)
+			}
+		}
+	}
+}
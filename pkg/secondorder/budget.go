@@ -0,0 +1,116 @@
+package secondorder
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// errBudgetSpent is returned for the verification requests to a third party that already got its MaxPerThirdParty requests
+// the URLs that aren't checked because of it aren't reported
+var errBudgetSpent = errors.New("the verification budget of the third party is spent")
+
+// ThirdPartyRequests is how many verification requests the run sent to a third-party organization
+type ThirdPartyRequests struct {
+	// Registrable domain of the hosts, like cloudfront.net for d111111abcdef8.cloudfront.net
+	Organization string `json:"organization"`
+	Requests     int    `json:"requests"`
+	// Requests that weren't sent because the budget of the organization was spent
+	Skipped int `json:"skipped"`
+}
+
+// thirdPartyBudget counts the verification requests to each organization across all targets, and caps them
+// the organizations of the targets themselves aren't capped
+type thirdPartyBudget struct {
+	// 0 for no limit
+	limit int
+
+	mu       sync.Mutex
+	requests map[string]*ThirdPartyRequests
+	targets  map[string]bool
+}
+
+func newThirdPartyBudget(limit int) *thirdPartyBudget {
+	return &thirdPartyBudget{limit: limit, requests: make(map[string]*ThirdPartyRequests), targets: make(map[string]bool)}
+}
+
+// organization returns the registrable domain of a host, or the host itself for IP addresses and unknown suffixes
+func organization(host string) string {
+	if net.ParseIP(host) != nil {
+		return host
+	}
+	if org, err := publicsuffix.EffectiveTLDPlusOne(host); err == nil {
+		return org
+	}
+	return host
+}
+
+// addTarget exempts the organization of a target from the budget
+func (b *thirdPartyBudget) addTarget(host string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.targets[organization(host)] = true
+}
+
+// take counts a request to host, and reports whether it can be sent
+func (b *thirdPartyBudget) take(host string) bool {
+	org := organization(host)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.targets[org] {
+		return true
+	}
+	r, ok := b.requests[org]
+	if !ok {
+		r = &ThirdPartyRequests{Organization: org}
+		b.requests[org] = r
+	}
+	if b.limit > 0 && r.Requests >= b.limit {
+		r.Skipped++
+		return false
+	}
+	r.Requests++
+	return true
+}
+
+// all returns the requests to every third party, the most requested first
+func (b *thirdPartyBudget) all() []ThirdPartyRequests {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	requests := make([]ThirdPartyRequests, 0, len(b.requests))
+	for _, r := range b.requests {
+		requests = append(requests, *r)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		if requests[i].Requests != requests[j].Requests {
+			return requests[i].Requests > requests[j].Requests
+		}
+		return requests[i].Organization < requests[j].Organization
+	})
+	return requests
+}
+
+// budgetTransport only sends the requests the budget of their organization allows
+type budgetTransport struct {
+	transport http.RoundTripper
+	budget    *thirdPartyBudget
+}
+
+func (t *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !t.budget.take(req.URL.Hostname()) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, errBudgetSpent
+	}
+	return t.transport.RoundTrip(req)
+}
+
+// ThirdPartyRequests returns how many verification requests were sent to each third-party organization so far
+func (sc *Scanner) ThirdPartyRequests() []ThirdPartyRequests {
+	return sc.budget.all()
+}
@@ -0,0 +1,168 @@
+package secondorder
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// cmsPattern recognizes assets of a CMS extension from their path
+// the first submatch of the pattern is the extension's name (slug)
+type cmsPattern struct {
+	CMS     string
+	Kind    string
+	Pattern *regexp.Regexp
+	// Assets matching Exclude are ignored even if they match Pattern
+	Exclude *regexp.Regexp
+	// Registry returns whether the extension still exists in the vendor's update registry
+	Registry func(sc *Scanner, ctx context.Context, slug string) (bool, error)
+}
+
+var cmsPatterns = []cmsPattern{
+	{CMS: "WordPress", Kind: "plugin", Pattern: regexp.MustCompile(`/wp-content/plugins/([\w.-]+)/`), Registry: (*Scanner).wordpressPluginExists},
+	{CMS: "WordPress", Kind: "theme", Pattern: regexp.MustCompile(`/wp-content/themes/([\w.-]+)/`), Registry: (*Scanner).wordpressThemeExists},
+	{CMS: "Drupal", Kind: "module", Pattern: regexp.MustCompile(`/modules/(?:contrib/|custom/)?([\w-]+)/`), Exclude: regexp.MustCompile(`/core/modules/`), Registry: (*Scanner).drupalProjectExists},
+	{CMS: "Drupal", Kind: "library", Pattern: regexp.MustCompile(`/(?:sites/[\w.-]+/)?libraries/([\w.-]+)/`)},
+}
+
+// Reason of the CMS findings of assets hosted on dead domains
+const cmsDeadAsset = "asset is hosted on a domain that is dead or returns 404"
+
+// CMSFinding is a CMS extension reference that failed one of the checks
+type CMSFinding struct {
+	Page   string `json:"page"`
+	CMS    string `json:"cms"`
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Asset  string `json:"asset"`
+	Reason string `json:"reason"`
+}
+
+type cmsFindings struct {
+	sync.Mutex
+	findings []CMSFinding
+	// Assets and extensions that were already checked in this scan
+	checked map[string]bool
+}
+
+func newCMSFindings() *cmsFindings {
+	return &cmsFindings{checked: make(map[string]bool)}
+}
+
+func (s *scan) addCMSFinding(finding CMSFinding) {
+	s.cms.Lock()
+	s.cms.findings = append(s.cms.findings, finding)
+	s.cms.Unlock()
+	s.report(Finding{Type: FindingCMS, Page: finding.Page, Resource: finding.Asset, Detail: finding.Reason})
+}
+
+// firstCheck reports whether key is being checked for the first time in this scan
+func (f *cmsFindings) firstCheck(key string) bool {
+	f.Lock()
+	defer f.Unlock()
+	if f.checked[key] {
+		return false
+	}
+	f.checked[key] = true
+	return true
+}
+
+// restore adds CMS findings saved by an interrupted run, their checks aren't run again
+func (f *cmsFindings) restore(findings []CMSFinding) {
+	f.Lock()
+	defer f.Unlock()
+	for _, finding := range findings {
+		f.findings = append(f.findings, finding)
+		if finding.Reason == cmsDeadAsset {
+			f.checked[finding.Asset] = true
+		} else {
+			f.checked[finding.CMS+"/"+finding.Kind+"/"+finding.Name] = true
+		}
+	}
+}
+
+func (f *cmsFindings) list() []CMSFinding {
+	f.Lock()
+	defer f.Unlock()
+	list := append(make([]CMSFinding, 0, len(f.findings)), f.findings...)
+	sort.Slice(list, func(i, j int) bool { return list[i].Page+list[i].Asset < list[j].Page+list[j].Asset })
+	return list
+}
+
+// checkCMSAsset checks an asset referenced from a page against the CMS patterns
+// assets hosted on other domains are checked for being dead, and extensions are looked up in their vendor's registry
+// since unregistered plugin slugs can be claimed by anyone and pushed to the site as an "update"
+func (s *scan) checkCMSAsset(page, asset string) {
+	for _, p := range cmsPatterns {
+		m := p.Pattern.FindStringSubmatch(asset)
+		if m == nil || (p.Exclude != nil && p.Exclude.MatchString(asset)) {
+			continue
+		}
+		finding := CMSFinding{Page: page, CMS: p.CMS, Kind: p.Kind, Name: m[1], Asset: asset}
+
+		if !s.checkOrigin(asset, s.target) && isValidURL(asset) && s.cms.firstCheck(asset) && s.isDangling(s.ctx, asset) {
+			finding.Reason = cmsDeadAsset
+			s.addCMSFinding(finding)
+		}
+		if p.Registry != nil && s.cms.firstCheck(p.CMS+"/"+p.Kind+"/"+m[1]) {
+			exists, err := p.Registry(s.Scanner, s.ctx, m[1])
+			if err == nil && !exists {
+				finding.Reason = fmt.Sprintf("%s %s is not in the official %s registry", p.CMS, p.Kind, p.CMS)
+				s.addCMSFinding(finding)
+			}
+		}
+		return
+	}
+}
+
+func (sc *Scanner) wordpressPluginExists(ctx context.Context, slug string) (bool, error) {
+	status, body, err := sc.registryLookup(ctx, "https://api.wordpress.org/plugins/info/1.0/"+url.PathEscape(slug)+".json")
+	if err != nil {
+		return false, err
+	}
+	return status == http.StatusOK && !strings.Contains(body, `"error"`) && strings.TrimSpace(body) != "null", nil
+}
+
+func (sc *Scanner) wordpressThemeExists(ctx context.Context, slug string) (bool, error) {
+	status, body, err := sc.registryLookup(ctx, "https://api.wordpress.org/themes/info/1.1/?action=theme_information&request[slug]="+url.QueryEscape(slug))
+	if err != nil {
+		return false, err
+	}
+	return status == http.StatusOK && !strings.Contains(body, `"error"`) && strings.TrimSpace(body) != "false", nil
+}
+
+func (sc *Scanner) drupalProjectExists(ctx context.Context, slug string) (bool, error) {
+	status, body, err := sc.registryLookup(ctx, "https://updates.drupal.org/release-history/"+url.PathEscape(slug)+"/current")
+	if err != nil {
+		return false, err
+	}
+	return status == http.StatusOK && !strings.Contains(body, "No release history"), nil
+}
+
+// registryLookup fetches a registry API endpoint, server errors are returned as errors so they aren't mistaken for missing extensions
+func (sc *Scanner) registryLookup(ctx context.Context, u string) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	sc.addHeaders(req)
+	res, err := sc.verifyClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode >= 500 {
+		return res.StatusCode, "", fmt.Errorf("registry returned status %d", res.StatusCode)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return 0, "", err
+	}
+	return res.StatusCode, string(body), nil
+}
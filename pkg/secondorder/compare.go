@@ -0,0 +1,84 @@
+package secondorder
+
+import (
+	"net/url"
+	"sort"
+)
+
+// EnvironmentDiff lists what was found in only one of two environments of the same site (e.g. staging and production)
+type EnvironmentDiff struct {
+	Target        string              `json:"target"`
+	Compare       string              `json:"compare"`
+	OnlyInTarget  map[string][]string `json:"only_in_target"`
+	OnlyInCompare map[string][]string `json:"only_in_compare"`
+}
+
+// Compare diffs the pages and logged resources of two results
+// URLs on each environment's own host are reduced to their path and query so the same page matches across environments
+func Compare(a, b *Result) EnvironmentDiff {
+	setA, setB := a.resourceSet(), b.resourceSet()
+	return EnvironmentDiff{
+		Target:        a.Target,
+		Compare:       b.Target,
+		OnlyInTarget:  subtractSets(setA, setB),
+		OnlyInCompare: subtractSets(setB, setA),
+	}
+}
+
+// resourceSet returns every page path and logged resource of a result, keyed by where it was found
+func (r *Result) resourceSet() map[string]map[string]bool {
+	set := make(map[string]map[string]bool)
+	add := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if set[key] == nil {
+			set[key] = make(map[string]bool)
+		}
+		set[key][r.normalizeOwnURL(value)] = true
+	}
+
+	for _, p := range r.Pages {
+		add("pages", p.URL)
+	}
+	for _, content := range []map[string]map[string][]string{r.Attributes, r.Inline} {
+		for _, queries := range content {
+			for query, values := range queries {
+				for _, value := range values {
+					add(query, value)
+				}
+			}
+		}
+	}
+	return set
+}
+
+// normalizeOwnURL strips the scheme and host from URLs that point to the target's own host
+func (r *Result) normalizeOwnURL(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || !u.IsAbs() {
+		return value
+	}
+	base, err := url.Parse(r.Target)
+	if err != nil || u.Hostname() != base.Hostname() {
+		return value
+	}
+	u.Scheme, u.Host, u.User = "", "", nil
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	return u.String()
+}
+
+func subtractSets(a, b map[string]map[string]bool) map[string][]string {
+	diff := make(map[string][]string)
+	for key, values := range a {
+		for value := range values {
+			if !b[key][value] {
+				diff[key] = append(diff[key], value)
+			}
+		}
+		sort.Strings(diff[key])
+	}
+	return diff
+}
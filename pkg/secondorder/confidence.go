@@ -0,0 +1,124 @@
+package secondorder
+
+import (
+	"context"
+	"math/rand"
+	"strings"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Weights of the signals the confidence score of a takeover candidate adds up, the score is capped between 0 and 100
+const (
+	// The service answered the host with the page it serves for names nobody claimed
+	scoreBodyFingerprint = 40
+	// The host is on a service that's vulnerable when its names don't resolve, and it doesn't
+	scoreNXDOMAINFingerprint = 45
+	// The unclaimed page came with an error status, a claimed name that happens to mention the text answers 200
+	scoreErrorStatus = 15
+	// The host is a custom domain pointing to the service through a CNAME, the record someone forgot to remove
+	scoreCNAME = 15
+	// The registrable domain of a name of the CNAME chain doesn't resolve, so it can be registered by anyone
+	scoreUnregistered = 30
+	// A random name next to the host is answered the same way: the match may come from a wildcard record
+	// rather than from a record created for the host
+	scoreWildcard = -25
+)
+
+// Signals of the confidence score
+const (
+	SignalBodyFingerprint = "body-fingerprint"
+	SignalNXDOMAIN        = "nxdomain"
+	SignalErrorStatus     = "error-status"
+	SignalCNAME           = "cname"
+	SignalUnregistered    = "unregistered-domain"
+	SignalWildcard        = "wildcard"
+)
+
+// Lowest scores of the high and medium confidence levels
+const (
+	highConfidence   = 70
+	mediumConfidence = 40
+)
+
+// ConfidenceLevel names a confidence score: high from 70, medium from 40, low below
+func ConfidenceLevel(score int) string {
+	switch {
+	case score >= highConfidence:
+		return "high"
+	case score >= mediumConfidence:
+		return "medium"
+	}
+	return "low"
+}
+
+// scoreTakeover sets the confidence score of a candidate from the signals of its fingerprint, response and DNS records
+// status is the status code of the root page of the host, 0 if it wasn't requested
+func (sc *Scanner) scoreTakeover(ctx context.Context, candidate *TakeoverCandidate, fp takeoverFingerprint, status int) {
+	score := 0
+	add := func(signal string, weight int) {
+		score += weight
+		candidate.Signals = append(candidate.Signals, signal)
+	}
+
+	if fp.NXDOMAIN {
+		add(SignalNXDOMAIN, scoreNXDOMAINFingerprint)
+	} else {
+		add(SignalBodyFingerprint, scoreBodyFingerprint)
+		if status >= 400 {
+			add(SignalErrorStatus, scoreErrorStatus)
+		}
+	}
+	custom := !fp.matchesName(candidate.Host, nil)
+	if custom && len(candidate.CNAMEs) > 0 {
+		add(SignalCNAME, scoreCNAME)
+	}
+	for _, name := range candidate.CNAMEs {
+		if sc.isUnregistered(ctx, name) {
+			add(SignalUnregistered, scoreUnregistered)
+			break
+		}
+	}
+	if custom && sc.isWildcard(ctx, candidate.Host, fp) {
+		add(SignalWildcard, scoreWildcard)
+	}
+
+	switch {
+	case score > 100:
+		score = 100
+	case score < 0:
+		score = 0
+	}
+	candidate.Confidence, candidate.ConfidenceLevel = score, ConfidenceLevel(score)
+}
+
+// isUnregistered reports whether the registrable domain of a name doesn't exist
+func (sc *Scanner) isUnregistered(ctx context.Context, name string) bool {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(strings.TrimSuffix(name, "."))
+	if err != nil {
+		return false
+	}
+	_, err = sc.resolver.LookupChain(ctx, domain)
+	return isNXDOMAIN(err)
+}
+
+// isWildcard reports whether a random name next to host points to the same service, which is what a wildcard record looks like
+func (sc *Scanner) isWildcard(ctx context.Context, host string, fp takeoverFingerprint) bool {
+	i := strings.Index(host, ".")
+	if i == -1 {
+		return false
+	}
+	parent := host[i+1:]
+	// Public suffixes have no records of the target
+	if _, err := publicsuffix.EffectiveTLDPlusOne(parent); err != nil {
+		return false
+	}
+	const letters = "abcdefghijklmnopqrstuvwxyz0123456789"
+	label := make([]byte, 12)
+	for i := range label {
+		label[i] = letters[rand.Intn(len(letters))]
+	}
+	probe := string(label) + "." + parent
+	chain, err := sc.resolver.LookupChain(ctx, probe)
+	return err == nil && fp.matchesName(probe, chain)
+}
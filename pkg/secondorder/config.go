@@ -0,0 +1,501 @@
+package secondorder
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds all the data passed from the config file
+// the target isn't part of it so we don't have to edit the configuration file every time we run the tool
+type Config struct {
+	// Rules logging attributes, and rules logging the URLs in attributes that don't return 200
+	LogQueries       Queries
+	LogNon200Queries Queries
+	// Tags or CSS selectors of the elements whose content is logged
+	LogInline []string
+	// Regexes the HTML of every crawled page is searched for, keyed by rule name
+	LogBodyRegex map[string]string
+	// Headers sent with every request, the ones of Options.Headers override them
+	Headers map[string]string
+	// Rules that change how URLs found by LogNon200Queries are verified
+	VerificationRules []VerificationRule
+	// Status codes the URLs of LogNon200Queries are never reported with, whatever verified them
+	ExcludeStatus []int
+	// Named sets of credentials that verification rules can use
+	CredentialContexts map[string]*CredentialContext
+	// Outbound webhook that receives batches of findings
+	Webhook *Webhook
+	// Slack and Discord channels every finding is posted to
+	Slack   *Notifier
+	Discord *Notifier
+	// Authorization the scan runs under
+	Engagement Engagement
+	// Network path of all requests, and overrides for crawling specific targets
+	Egress       Egress
+	TargetEgress []TargetEgress
+	// Secret patterns scripts are searched for with -secrets, keyed by name
+	// a rule with the name of a default rule replaces it, and an empty pattern disables it
+	SecretRules map[string]string
+	// Hosts left out of the results with -filter-noise, on top of the default ones, their subdomains are left out as well
+	NoiseHosts []string
+	// Rules that rewrite the links found in pages and the pages the crawl starts from before they're crawled, applied in order
+	Rewrites []RewriteRule
+	// Request that logs in to each target before it's crawled
+	Login *Login
+	// Hosts that are the target's own rather than third parties: OriginDomain (default) or OriginHost
+	Origin string
+
+	secretRules []secretRule
+	bodyRules   []bodyRule
+	noiseHosts  []string
+
+	// Settings that aren't read from the configuration file, the CLI sets them with flags
+	Options Options `json:"-"`
+}
+
+// Options are the settings of a scan that the CLI exposes as flags
+type Options struct {
+	// Depth to crawl, 0 for no limit
+	Depth int
+	// Number of concurrent requests per host, 0 for no limit
+	Threads int
+	// Time each crawling thread waits after a request before sending the next one
+	Delay time.Duration
+	// Maximum random time added to Delay, so the requests don't come at a fixed interval
+	RandomDelay time.Duration
+	// Maximum number of concurrent requests across all targets (default 50)
+	MaxThreads int
+	// Maximum number of concurrent requests to each IP address across all hosts and targets, 0 for no limit
+	MaxPerIP int
+	// Maximum number of verification requests to each third-party organization (registrable domain) across all targets, 0 for no limit
+	MaxPerThirdParty int
+	// Hosts the crawl follows links to: ScopeSubdomains (default), ScopeStrict or ScopeFile
+	Scope string
+	// Host patterns of ScopeFile, like app.example.com or *.example.com, the ones starting with ! are denied
+	ScopeHosts []string
+	// Number of values of each query parameter of a path to crawl, links that only bring more values are skipped, 0 for no limit
+	QuerySamples int
+	// Number of pages of each target to request, the links found afterwards are skipped, 0 for no limit
+	MaxURLs int
+	// Number of pages crawled and of findings after which the scan of a target is stopped, 0 for no limit
+	// unlike MaxURLs, the resources that are still being checked are given up
+	MaxPages    int
+	MaxFindings int
+	// Number of concurrent DNS lookups (default 20)
+	DNSThreads int
+	// Nameserver every lookup is sent to, like 1.1.1.1:53, or the URL of a DNS over HTTPS server, empty for the system's
+	Resolver string
+	// Number of resources of each target verified at the same time, apart from the threads crawling its pages (default 50)
+	// the verification requests take the same slots as the crawl, so they count towards MaxThreads and MaxPerIP
+	VerifyThreads int
+	// Number of resources of each target waiting for verification or DNS resolution before the pages that find more
+	// wait for room (default 1000)
+	QueueSize int
+	// Time to wait for each response, 0 for the defaults (10 seconds for pages, 5 seconds for verification requests)
+	Timeout time.Duration
+	// Number of times a request that fails or responds with one of RetryStatus is sent again, 0 for no retries
+	Retries int
+	// Time to wait before the first retry, doubled for each of the next ones (default 1 second)
+	RetryBackoff time.Duration
+	// Status codes that are retried (default 502, 503 and 504)
+	RetryStatus []int
+	// Accept untrusted SSL/TLS certificates
+	Insecure bool
+	// Verify the URLs pages reference even on private, loopback, link-local and cloud metadata addresses
+	// they're refused by default, so a page can't make the scanner probe the network it runs in
+	AllowInternal bool
+	// Only send GET and HEAD requests: the scanner refuses to start with features that send others (Login with
+	// another method, GraphQL), refuses any other request in its transports, and rendered pages can't send them either
+	Safe bool
+	// Headers sent with every request
+	Headers map[string]string
+	// Cookies sent with the requests to the targets and their subdomains, keyed by name
+	Cookies map[string]string
+	// Check CMS plugin, theme and library references for dead hosts and unregistered names
+	CMSChecks bool
+	// Parse PWA manifests and browserconfig.xml files and check the URLs in them
+	Manifests bool
+	// Check the URLs in JSON data scripts and the state single page apps assign to window
+	InlineJSON bool
+	// Search inline and external scripts for secrets
+	Secrets bool
+	// Record the inline scripts of every page, and report the ones that differ across pages or from InlineBaseline
+	InlineScripts bool
+	// Inline scripts of a previous run, their changes are reported
+	InlineBaseline []InlineScript
+	// Extract the URLs, paths, fetch and XHR calls and routes of inline and external scripts
+	JSEndpoints bool
+	// Download every external script, save it in the scripts directory and analyze it like inline scripts
+	SaveScripts bool
+	// External scripts of a previous run, the ones that changed since then and the ones its pages didn't load are reported
+	ScriptBaseline []*Script
+	// Analyze the Swagger/OpenAPI specs the target references
+	OpenAPI bool
+	// Send introspection queries to the GraphQL endpoints of the target and save their schemas
+	GraphQL bool
+	// Compare the third-party domains of crawled pages with their Wayback Machine snapshots from this many months ago
+	WaybackMonths int
+	// Where to get more pages to start the crawl of a target from: SeedWayback, or empty for the target alone
+	Seed string
+	// Maximum number of pages of Seed each target starts from (default 1000, -1 for no limit)
+	SeedLimit int
+	// Pages each target starts from as well, like the ones of a Burp Suite site map read with LoadSeedFile, the ones out of its scope are left out
+	SeedURLs []string
+	// Only analyze the target's pages of SeedURLs and Seed, without requesting the target or following the links of the pages
+	SeedOnly bool
+	// Compare the hosts of external resources with the fingerprints of unclaimed hosting services
+	Takeover bool
+	// Skip HTTP verification and only resolve referenced hosts
+	DNSOnly bool
+	// Resolve the hosts of external links as well, and report the ones that are dangling
+	CheckLinks bool
+	// Don't crawl the pages robots.txt disallows, and wait for the crawl delay it sets between two requests to a host
+	RespectRobots bool
+	// Leave well-managed third parties (the default noise hosts and NoiseHosts) out of the results
+	FilterNoise bool
+	// Render pages in a headless Chrome before scraping them, for single page apps that build their DOM at runtime
+	Render bool
+	// Directory of rule files adding secret rules and takeover fingerprints, reloaded when it changes
+	RulesDir string
+	// Search the downloaded scripts again when the rules are reloaded
+	RecheckRules bool
+	// File the crawl state of every target is saved to while they're running, empty to not save it
+	StateFile string
+	// Continue the crawls saved in StateFile by an interrupted run
+	Resume bool
+	// redis:// URL of the server the frontier of every target is kept in, so instances on different machines crawl it together
+	// and push their findings to it, empty to crawl alone
+	Redis string
+	// Name of the distributed crawl, the instances with the same Redis and RedisRun crawl the targets together (default "default")
+	RedisRun string
+	// Run targets again and again: each run replaces the previous one of its target, whose findings are only sent to the
+	// sinks again if they changed, and the result lists what appeared, changed or went away since
+	Monitor bool
+	// Algorithm used to compress output files ("gzip", "zstd" or empty for none)
+	Compression string
+	// Where in-scope links are printed as they're found, nil to not print them
+	LinkOutput io.Writer
+}
+
+// LoadConfig reads a configuration file, in YAML if its extension is .yaml or .yml and in JSON otherwise
+func LoadConfig(location string) (Config, error) {
+	content, err := readConfigDocument(location)
+	if err != nil {
+		return Config{}, err
+	}
+	return configFromValue(content)
+}
+
+// readConfigDocument decodes a configuration file without building the configuration
+func readConfigDocument(location string) (interface{}, error) {
+	f, err := os.Open(location)
+	if err != nil {
+		return nil, fmt.Errorf("could not open Configuration file: %v", err)
+	}
+	defer f.Close()
+	switch strings.ToLower(filepath.Ext(location)) {
+	case ".yaml", ".yml":
+		return decodeYAMLDocument(f)
+	}
+	return decodeJSONDocument(f)
+}
+
+// ParseConfig reads a configuration passed as a value instead of a file, like an environment variable
+// either the JSON itself or its base64 encoding, which survives the quoting of container and job specs
+func ParseConfig(value string) (Config, error) {
+	value = strings.TrimSpace(value)
+	if !strings.HasPrefix(value, "{") {
+		decoded, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			if decoded, err = base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "=")); err != nil {
+				return Config{}, fmt.Errorf("could not decode Configuration: it's neither JSON nor base64")
+			}
+		}
+		value = string(decoded)
+	}
+	return decodeConfig(strings.NewReader(value))
+}
+
+func decodeConfig(r io.Reader) (Config, error) {
+	content, err := decodeJSONDocument(r)
+	if err != nil {
+		return Config{}, err
+	}
+	return configFromValue(content)
+}
+
+func decodeJSONDocument(r io.Reader) (interface{}, error) {
+	var content interface{}
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+	if err := decoder.Decode(&content); err != nil {
+		return nil, fmt.Errorf("could not decode Configuration file: %v", err)
+	}
+	return content, nil
+}
+
+// decodeYAMLDocument reads a configuration written in YAML, with the same fields as the JSON one
+func decodeYAMLDocument(r io.Reader) (interface{}, error) {
+	var content interface{}
+	if err := yaml.NewDecoder(r).Decode(&content); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("could not decode Configuration file: %v", err)
+	}
+	return jsonValue(content), nil
+}
+
+// jsonValue converts the mappings of a decoded YAML document, whose keys can be numbers, to JSON objects
+func jsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, child := range v {
+			v[key] = jsonValue(child)
+		}
+	case map[interface{}]interface{}:
+		object := make(map[string]interface{}, len(v))
+		for key, child := range v {
+			object[fmt.Sprint(key)] = jsonValue(child)
+		}
+		return object
+	case []interface{}:
+		for i := range v {
+			v[i] = jsonValue(v[i])
+		}
+	}
+	return value
+}
+
+// configFromValue builds a configuration from its decoded document
+func configFromValue(content interface{}) (Config, error) {
+	content, err := expandEnv(content, "")
+	if err != nil {
+		return Config{}, err
+	}
+	expanded, err := json.Marshal(content)
+	if err != nil {
+		return Config{}, err
+	}
+	config := Config{}
+	if err := json.Unmarshal(expanded, &config); err != nil {
+		return Config{}, fmt.Errorf("could not decode Configuration file: %v", err)
+	}
+	if err := config.compile(); err != nil {
+		return Config{}, err
+	}
+	return config, nil
+}
+
+// envReference is a ${VAR} or ${VAR:-default} reference to an environment variable in a string of the configuration
+var envReference = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(?::-([^}]*))?\}`)
+
+// expandEnv replaces the environment variable references in the strings of a decoded configuration, so secrets like tokens
+// don't have to be written in it; a reference to a variable that isn't set and has no default is an error
+// the replacements of rewrite rules are left as they are, ${name} refers to a group of their pattern there
+func expandEnv(value interface{}, key string) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		var missing string
+		expanded := envReference.ReplaceAllStringFunc(v, func(ref string) string {
+			m := envReference.FindStringSubmatch(ref)
+			if value, ok := os.LookupEnv(m[1]); ok {
+				return value
+			}
+			if strings.Contains(ref, ":-") {
+				return m[2]
+			}
+			missing = m[1]
+			return ref
+		})
+		if missing != "" {
+			return nil, fmt.Errorf("the configuration references the environment variable %s, which isn't set", missing)
+		}
+		return expanded, nil
+	case []interface{}:
+		// The elements of a list are under the key of the list
+		for i := range v {
+			var err error
+			if v[i], err = expandEnv(v[i], key); err != nil {
+				return nil, err
+			}
+		}
+	case map[string]interface{}:
+		for name := range v {
+			if strings.EqualFold(key, "Rewrites") && strings.EqualFold(name, "Replacement") {
+				continue
+			}
+			var err error
+			if v[name], err = expandEnv(v[name], name); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
+
+// Overrides are fields of the configuration set at runtime, like with the flags of the CLI, on top of the configuration file
+type Overrides struct {
+	// Tags and attributes added to the queries of the configuration as rules named tag[attribute]
+	LogQueries       map[string]string
+	LogNon200Queries map[string]string
+	// Added to the tags and hosts of the configuration
+	LogInline  []string
+	NoiseHosts []string
+	// Replaces the status codes of the configuration, unless it's nil
+	ExcludeStatus []int
+}
+
+// Override applies overrides to the configuration and validates it again
+func (config *Config) Override(o Overrides) error {
+	config.LogQueries = config.LogQueries.add(queriesFromTags(o.LogQueries))
+	config.LogNon200Queries = config.LogNon200Queries.add(queriesFromTags(o.LogNon200Queries))
+	for _, tag := range o.LogInline {
+		if !contains(config.LogInline, tag) {
+			config.LogInline = append(config.LogInline, tag)
+		}
+	}
+	config.NoiseHosts = append(append([]string(nil), config.NoiseHosts...), o.NoiseHosts...)
+	if o.ExcludeStatus != nil {
+		config.ExcludeStatus = o.ExcludeStatus
+	}
+	return config.compile()
+}
+
+// compile validates the configuration and compiles its patterns
+func (config *Config) compile() error {
+	var err error
+	if config.LogQueries, err = config.LogQueries.compile("LogQueries"); err != nil {
+		return err
+	}
+	if config.LogNon200Queries, err = config.LogNon200Queries.compile("LogNon200Queries"); err != nil {
+		return err
+	}
+	for _, tag := range config.LogInline {
+		if err := validateSelector(tag); err != nil {
+			return fmt.Errorf("LogInline: %v", err)
+		}
+	}
+	// The rules are compiled into copies so the caller's configuration isn't modified
+	config.VerificationRules = append([]VerificationRule(nil), config.VerificationRules...)
+	for i := range config.VerificationRules {
+		if err := config.VerificationRules[i].compile(config.CredentialContexts); err != nil {
+			return err
+		}
+	}
+	if err := config.Engagement.validate(); err != nil {
+		return err
+	}
+	config.TargetEgress = append([]TargetEgress(nil), config.TargetEgress...)
+	for i, rule := range config.TargetEgress {
+		if config.TargetEgress[i].pattern, err = regexp.Compile(rule.Pattern); err != nil {
+			return fmt.Errorf("invalid target egress pattern %q: %v", rule.Pattern, err)
+		}
+	}
+	if config.Rewrites, err = compileRewrites(config.Rewrites); err != nil {
+		return err
+	}
+	if config.Login != nil {
+		// Compiled into a copy so the caller's configuration isn't modified
+		login := *config.Login
+		if err := login.compile(); err != nil {
+			return err
+		}
+		config.Login = &login
+	}
+	config.noiseHosts = append(append([]string(nil), defaultNoiseHosts...), config.NoiseHosts...)
+	if config.secretRules, err = compileSecretRules(config.SecretRules); err != nil {
+		return err
+	}
+	if config.bodyRules, err = compileBodyRules(config.LogBodyRegex); err != nil {
+		return err
+	}
+	if err := validateOrigin(config.Origin); err != nil {
+		return err
+	}
+	if err := validateScope(config.Options); err != nil {
+		return err
+	}
+	if err := validateSeed(config.Options); err != nil {
+		return err
+	}
+	return ValidateCompression(config.Options.Compression)
+}
+
+func createQuerySelector(tag, attribute string) string {
+	return fmt.Sprintf("%s[%s]", tag, attribute)
+}
+
+// a[href] -> a, href
+func unpackQuerySelector(q string) (string, string) {
+	parts := strings.Split(q, "[")
+	tag := parts[0]
+	attribute := strings.Trim(parts[1], "]")
+
+	return tag, attribute
+}
+
+func getHostname(rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	return u.Hostname(), nil
+}
+
+// Origins, the hosts that are the target's own rather than third parties
+const (
+	// The hosts of the registrable domain of the target, like www.example.co.uk and api.example.co.uk
+	OriginDomain = "domain"
+	// Only the host of the target
+	OriginHost = "host"
+)
+
+func validateOrigin(origin string) error {
+	switch origin {
+	case "", OriginDomain, OriginHost:
+		return nil
+	}
+	return fmt.Errorf("unknown origin %q (supported: %s, %s)", origin, OriginDomain, OriginHost)
+}
+
+// checkOrigin reports whether a link is on the same origin as base, the links that aren't point to third parties
+func (sc *Scanner) checkOrigin(link, base string) bool {
+	linkurl, err := url.Parse(link)
+	if err != nil {
+		return false
+	}
+	linkhost := strings.ToLower(linkurl.Hostname())
+
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return false
+	}
+	basehost := strings.ToLower(baseURL.Hostname())
+
+	if sc.config.Origin == OriginHost {
+		return linkhost == basehost
+	}
+	// check the registrable domain not the subdomain, IP addresses are only the same origin as themselves
+	// checkOrigin ("https://docs.google.com", "https://mail.google.com") => true
+	// checkOrigin ("https://target.co.uk", "https://other.co.uk") => false
+	return organization(linkhost) == organization(basehost)
+}
+
+func isValidURL(s string) bool {
+	u, err := url.ParseRequestURI(s)
+	if err != nil {
+		return false
+	}
+	if u.IsAbs() {
+		return true
+	}
+	return false
+}
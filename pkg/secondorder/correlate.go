@@ -0,0 +1,81 @@
+package secondorder
+
+import (
+	"net/url"
+	"sort"
+)
+
+// SharedHost is a dangling external host referenced by more than one target
+// one expired CDN domain used by many sites is worth more than many domains used once
+type SharedHost struct {
+	Host    string   `json:"host"`
+	Targets []string `json:"targets"`
+	// The dangling resources on the host, and the types of findings they were reported in
+	Resources []string `json:"resources"`
+	Types     []string `json:"types"`
+}
+
+// Correlate groups the findings of several targets by the host of their resource, and returns the hosts found on more than one target
+// the hosts shared by the most targets come first
+func Correlate(results []*Result) []SharedHost {
+	type group struct {
+		targets, resources, types map[string]bool
+	}
+	groups := make(map[string]*group)
+	for _, r := range results {
+		for _, f := range r.Findings {
+			switch f.Type {
+			case FindingNon200, FindingDangling, FindingDanglingLink, FindingTakeover, FindingCMS:
+			default:
+				continue
+			}
+			host := findingHost(f.Resource)
+			if host == "" {
+				continue
+			}
+			g, ok := groups[host]
+			if !ok {
+				g = &group{targets: make(map[string]bool), resources: make(map[string]bool), types: make(map[string]bool)}
+				groups[host] = g
+			}
+			g.targets[r.Target] = true
+			g.resources[f.Resource] = true
+			g.types[f.Type] = true
+		}
+	}
+
+	shared := []SharedHost{}
+	for host, g := range groups {
+		if len(g.targets) < 2 {
+			continue
+		}
+		shared = append(shared, SharedHost{Host: host, Targets: sortedKeys(g.targets), Resources: sortedKeys(g.resources), Types: sortedKeys(g.types)})
+	}
+	sort.Slice(shared, func(i, j int) bool {
+		if len(shared[i].Targets) != len(shared[j].Targets) {
+			return len(shared[i].Targets) > len(shared[j].Targets)
+		}
+		return shared[i].Host < shared[j].Host
+	})
+	return shared
+}
+
+// findingHost returns the host of a finding's resource, which is either a URL or a bare host
+func findingHost(resource string) string {
+	if u, err := url.Parse(resource); err == nil && u.Hostname() != "" {
+		return u.Hostname()
+	}
+	if u, err := url.Parse("//" + resource); err == nil {
+		return u.Hostname()
+	}
+	return ""
+}
+
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,123 @@
+package secondorder
+
+import (
+	"errors"
+	"mime"
+	"sort"
+	"sync"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Reasons links weren't crawled
+const (
+	SkipDepth       = "depth"
+	SkipScope       = "scope"
+	SkipRegex       = "regex"
+	SkipRobots      = "robots"
+	SkipContentType = "content-type"
+	SkipBudget      = "budget"
+	SkipMaxURLs     = "max-urls"
+)
+
+// Budgets that stop a crawl before it's finished
+const (
+	StopMaxPages    = "max-pages"
+	StopMaxFindings = "max-findings"
+)
+
+// Coverage is what the crawl didn't look at and why, so a clean result can be told apart from a crawl that missed the interesting parts
+type Coverage struct {
+	// Number of URLs skipped for each reason
+	Counts map[string]int `json:"counts"`
+	// URLs skipped for each reason
+	Skipped map[string][]string `json:"skipped"`
+	// Budget that stopped the crawl before it was finished, empty if it wasn't stopped by one
+	Stopped string `json:"stopped,omitempty"`
+}
+
+type coverage struct {
+	sync.Mutex
+	skipped map[string]map[string]bool
+	stopped string
+}
+
+func newCoverage() *coverage {
+	return &coverage{skipped: make(map[string]map[string]bool)}
+}
+
+func (c *coverage) add(reason, u string) {
+	c.Lock()
+	defer c.Unlock()
+	if c.skipped[reason] == nil {
+		c.skipped[reason] = make(map[string]bool)
+	}
+	c.skipped[reason][u] = true
+}
+
+// stop records the budget that stopped the crawl, it returns false if the crawl was already stopped
+func (c *coverage) stop(budget string) bool {
+	c.Lock()
+	defer c.Unlock()
+	if c.stopped != "" {
+		return false
+	}
+	c.stopped = budget
+	return true
+}
+
+// restore adds the links an interrupted run skipped, the budget that stopped it isn't restored so resuming continues the crawl
+func (c *coverage) restore(copied *Coverage) {
+	if copied == nil {
+		return
+	}
+	for reason, urls := range copied.Skipped {
+		for _, u := range urls {
+			c.add(reason, u)
+		}
+	}
+}
+
+func (c *coverage) copy() *Coverage {
+	c.Lock()
+	defer c.Unlock()
+	copied := &Coverage{Counts: make(map[string]int), Skipped: make(map[string][]string), Stopped: c.stopped}
+	for reason, urls := range c.skipped {
+		list := make([]string, 0, len(urls))
+		for u := range urls {
+			list = append(list, u)
+		}
+		sort.Strings(list)
+		copied.Counts[reason] = len(list)
+		copied.Skipped[reason] = list
+	}
+	return copied
+}
+
+// skipReason returns why colly refused to visit a URL, or an empty string if it wasn't a limit of the crawl
+// a link that's both too deep and out of scope is out of scope
+func skipReason(scope *crawlScope, err error, u string) string {
+	switch {
+	case errors.Is(err, colly.ErrMaxDepth):
+		if !scope.allows(u) {
+			return SkipScope
+		}
+		return SkipDepth
+	case errors.Is(err, colly.ErrNoURLFiltersMatch), errors.Is(err, colly.ErrForbiddenDomain):
+		return SkipScope
+	case errors.Is(err, colly.ErrForbiddenURL):
+		if scope.denies(u) {
+			return SkipScope
+		}
+		return SkipRegex
+	case errors.Is(err, colly.ErrRobotsTxtBlocked):
+		return SkipRobots
+	}
+	return ""
+}
+
+// isHTML reports whether a response is a page whose links and resources are scraped
+func isHTML(r *colly.Response) bool {
+	mediaType, _, _ := mime.ParseMediaType(r.Headers.Get("Content-Type"))
+	return mediaType == "" || mediaType == "text/html" || mediaType == "application/xhtml+xml"
+}
@@ -0,0 +1,82 @@
+package secondorder
+
+import (
+	"encoding/csv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Types of the CSV rows that don't come from findings
+const (
+	csvLoggedQuery = "logged-query"
+	csvNon200      = "non-200"
+)
+
+var csvHeader = []string{"type", "page", "tag", "attribute", "resource", "status", "detail", "confidence", "rule"}
+
+// WriteCSV writes the logged queries, non-200 URLs and findings of the result to w as one flat CSV table
+// with a row per resource: type, page, tag, attribute, resource, status, detail, confidence and rule
+// tag and attribute are empty for findings that don't come from a tag, status for URLs that didn't respond,
+// confidence for findings that aren't takeover candidates, and rule for findings that don't come from a query rule
+func (r *Result) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	writer.Write(csvHeader)
+
+	r.writeCSVQueries(writer, csvLoggedQuery, r.Attributes)
+	r.writeCSVQueries(writer, csvNon200, r.Non200)
+	// Non-200 findings are already in the rows of the non-200 URLs
+	for _, f := range r.Findings {
+		if f.Type == FindingNon200 {
+			continue
+		}
+		confidence := ""
+		if f.Confidence > 0 {
+			confidence = strconv.Itoa(f.Confidence)
+		}
+		writer.Write([]string{f.Type, f.Page, "", "", f.Resource, "", f.Detail, confidence, ""})
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// writeCSVQueries writes a row per value of the results of a query, sorted by page and query
+func (r *Result) writeCSVQueries(writer *csv.Writer, rowType string, content map[string]map[string][]string) {
+	pages := make([]string, 0, len(content))
+	for page := range content {
+		pages = append(pages, page)
+	}
+	sort.Strings(pages)
+
+	for _, page := range pages {
+		queries := content[page]
+		names := make([]string, 0, len(queries))
+		for query := range queries {
+			names = append(names, query)
+		}
+		sort.Strings(names)
+
+		for _, query := range names {
+			tag, attribute := splitQuery(query)
+			for _, value := range queries[query] {
+				status := ""
+				if response := r.Responses[value]; response != nil && response.Status != 0 && rowType == csvNon200 {
+					status = strconv.Itoa(response.Status)
+				}
+				writer.Write([]string{rowType, page, tag, attribute, value, status, "", "", query})
+			}
+		}
+	}
+}
+
+// splitQuery is unpackQuerySelector for keys that aren't always tag[attribute], like the manifest and inline JSON ones
+func splitQuery(query string) (string, string) {
+	// The attribute is in the last brackets, the selector of a rule can have brackets of its own
+	i := strings.LastIndex(query, "[")
+	if i == -1 || !strings.HasSuffix(query, "]") {
+		return query, ""
+	}
+	return query[:i], query[i+1 : len(query)-1]
+}
@@ -0,0 +1,107 @@
+package secondorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// LoadFindings reads the findings saved in findings.json by a previous run, path is the file or the output directory
+// of the run, whose subdirectories are searched for the findings of every target
+func LoadFindings(path string) ([]Finding, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the findings: %v", err)
+	}
+	files := []string{path}
+	if info.IsDir() {
+		files = nil
+		err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && strings.HasPrefix(info.Name(), "findings.json") {
+				files = append(files, p)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(files) == 0 {
+			return nil, fmt.Errorf("%s has no findings.json, it isn't the output of a run or was saved by a version that didn't save its findings", path)
+		}
+	}
+	// The targets are in the same order in both runs
+	sort.Strings(files)
+
+	var findings []Finding
+	for _, file := range files {
+		f, err := OpenResultFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var content map[string][]Finding
+		err = json.NewDecoder(f).Decode(&content)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not decode the findings of %s: %v", file, err)
+		}
+		findings = append(findings, content["Findings"]...)
+	}
+	return findings, nil
+}
+
+// changeMarks start the lines of WriteChanges
+var changeMarks = map[string]string{ChangeNew: "+", ChangeChanged: "~", ChangeGone: "-"}
+
+// WriteChanges writes the changes between two runs as text, one per line like
+// + [dangling-domain] [high] cdn.example.net https://example.com/login
+// with + for new findings, ~ for changed ones followed by what changed, like (response 404 -> NXDOMAIN), and - for the ones that are gone
+func WriteChanges(w io.Writer, changes []FindingChange) error {
+	for _, c := range changes {
+		line := changeMarks[c.Change] + " " + findingLine(c.Finding)
+		if c.Previous != nil {
+			line += " (" + describeChange(*c.Previous, c.Finding) + ")"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// describeChange lists what differs between two versions of a finding
+func describeChange(before, after Finding) string {
+	var diffs []string
+	add := func(name, a, b string) {
+		if a != b {
+			diffs = append(diffs, fmt.Sprintf("%s %s -> %s", name, orNone(a), orNone(b)))
+		}
+	}
+	add("detail", before.Detail, after.Detail)
+	add("severity", before.Level(), after.Level())
+	add("confidence", strconv.Itoa(before.Confidence), strconv.Itoa(after.Confidence))
+	add("response", responseStatus(before.Response), responseStatus(after.Response))
+	return strings.Join(diffs, ", ")
+}
+
+// responseStatus is the status code of a response, or why there's none
+func responseStatus(r *Response) string {
+	switch {
+	case r == nil:
+		return ""
+	case r.Status != 0:
+		return strconv.Itoa(r.Status)
+	}
+	return r.Error
+}
+
+func orNone(value string) string {
+	if value == "" {
+		return "none"
+	}
+	return value
+}
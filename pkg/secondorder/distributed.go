@@ -0,0 +1,401 @@
+package secondorder
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Name of the distributed crawl when Options.RedisRun isn't set
+const defaultRedisRun = "default"
+
+// How long an instance with nothing to crawl waits before looking at the shared queue again
+const sharedPollInterval = 500 * time.Millisecond
+
+// An instance that didn't renew its lease for this long is considered dead, and the pages it popped are queued again
+const (
+	sharedLease         = 30 * time.Second
+	sharedLeaseInterval = sharedLease / 3
+)
+
+// sharedQueue queues a page that wasn't seen by any instance yet, and counts it as pending until it's crawled
+// the seen set makes it idempotent, running it twice queues the page once
+// KEYS: seen set, queue list, pending counter, ARGV: URL, queued page
+var sharedQueue = `if redis.call('SADD', KEYS[1], ARGV[1]) == 1 then
+	redis.call('INCR', KEYS[3])
+	redis.call('LPUSH', KEYS[2], ARGV[2])
+	return 1
+end
+return 0`
+
+// sharedFinish counts a popped page as crawled, once: a page that isn't in the processing list anymore isn't counted
+// KEYS: processing list, pending counter, ARGV: queued page
+var sharedFinish = `if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 1 then
+	redis.call('DECR', KEYS[2])
+	return 1
+end
+return 0`
+
+// sharedRelease moves a popped page back to the queue, once: a page that isn't in the processing list anymore isn't queued
+// KEYS: processing list, queue list, ARGV: queued page
+var sharedRelease = `if redis.call('LREM', KEYS[1], 1, ARGV[1]) == 1 then
+	redis.call('LPUSH', KEYS[2], ARGV[1])
+	return 1
+end
+return 0`
+
+// sharedRequeue moves the pages of a processing list back to the queue, they're still counted as pending
+// KEYS: processing list, queue list, instances set, ARGV: instance
+var sharedRequeue = `local moved = 0
+while redis.call('RPOPLPUSH', KEYS[1], KEYS[2]) do
+	moved = moved + 1
+end
+if ARGV[1] ~= '' then
+	redis.call('SREM', KEYS[3], ARGV[1])
+end
+return moved`
+
+// sharedFrontier is the frontier of a target that several instances crawl together, kept in Redis
+// every page a crawl finds is queued in Redis once, and is crawled by the instance that pops it
+// a popped page is moved to the processing list of the instance until it's crawled, and an instance whose lease
+// expired, because it crashed or lost Redis, gets its pages queued again by the others
+// the crawl is over when no page is queued or being crawled by any instance
+type sharedFrontier struct {
+	client *redisClient
+	prefix string
+	// id names the processing list and the lease of this instance
+	id string
+
+	mu sync.Mutex
+	// Pages popped by this instance that weren't requested yet, as they're stored in its processing list
+	claimed map[string]string
+	// Requests of this instance for the popped pages, until they're crawled
+	owned map[uint32]string
+	// Pages of the processing list this instance is crawling, the others were popped without getting the reply
+	popped map[string]bool
+	// A slot is taken by every page this instance is crawling, so it doesn't pop more than its threads can crawl
+	slots chan struct{}
+}
+
+func newSharedFrontier(client *redisClient, run, target string, threads int) *sharedFrontier {
+	if threads < 1 {
+		threads = 1
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &sharedFrontier{
+		client:  client,
+		prefix:  redisKeyPrefix(run, target),
+		id:      hex.EncodeToString(id),
+		claimed: make(map[string]string),
+		owned:   make(map[uint32]string),
+		popped:  make(map[string]bool),
+		slots:   make(chan struct{}, threads),
+	}
+}
+
+// redisKeyPrefix is the prefix of the keys of a target in a distributed crawl
+func redisKeyPrefix(run, target string) string {
+	if run == "" {
+		run = defaultRedisRun
+	}
+	return "second-order:" + run + ":" + target + ":"
+}
+
+func (f *sharedFrontier) processingKey(id string) string {
+	return f.prefix + "processing:" + id
+}
+
+func (f *sharedFrontier) leaseKey(id string) string {
+	return f.prefix + "lease:" + id
+}
+
+// push queues a page found by this instance, unless an instance already queued it
+func (f *sharedFrontier) push(u string, depth int) error {
+	page, _ := json.Marshal(QueuedPage{URL: u, Depth: depth})
+	_, err := f.client.doIdempotent("EVAL", sharedQueue, "3", f.prefix+"seen", f.prefix+"queue", f.prefix+"pending", u, string(page))
+	return err
+}
+
+// pop moves the next queued page to the processing list of this instance, ok is false when the queue is empty
+// entry is the page as it's stored in the list
+func (f *sharedFrontier) pop() (page QueuedPage, entry string, ok bool, err error) {
+	reply, err := f.client.do("RPOPLPUSH", f.prefix+"queue", f.processingKey(f.id))
+	if err != nil || reply == nil {
+		return page, "", false, err
+	}
+	entry, _ = reply.(string)
+	if err := json.Unmarshal([]byte(entry), &page); err != nil {
+		return page, "", false, err
+	}
+	return page, entry, true, nil
+}
+
+// idle reports whether no page is queued or being crawled by any instance
+func (f *sharedFrontier) idle() (bool, error) {
+	pending, err := redisInt(f.client.do("GET", f.prefix+"pending"))
+	return pending <= 0, err
+}
+
+// finish counts a popped page as crawled, and frees its slot
+// a page that can't be counted stays in the processing list, and is queued again by recover
+func (f *sharedFrontier) finish(entry string) {
+	if _, err := f.client.doIdempotent("EVAL", sharedFinish, "2", f.processingKey(f.id), f.prefix+"pending", entry); err != nil {
+		logger.Errorf("Could not update the shared frontier: %v", err)
+	}
+	f.mu.Lock()
+	delete(f.popped, entry)
+	f.mu.Unlock()
+	<-f.slots
+}
+
+// renew extends the lease of this instance, and registers it as one whose pages may need to be queued again
+func (f *sharedFrontier) renew() error {
+	if _, err := f.client.doIdempotent("SET", f.leaseKey(f.id), "1", "PX", strconv.FormatInt(sharedLease.Milliseconds(), 10)); err != nil {
+		return err
+	}
+	_, err := f.client.doIdempotent("SADD", f.prefix+"instances", f.id)
+	return err
+}
+
+// hold renews the lease of this instance until stop is closed
+func (f *sharedFrontier) hold(stop <-chan struct{}) {
+	ticker := time.NewTicker(sharedLeaseInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := f.renew(); err != nil {
+				logger.Errorf("Could not renew the lease of this instance in the shared frontier: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+// leave queues the pages this instance popped and didn't crawl again, like the ones of a cancelled crawl, and gives up its lease
+// pages that can't be queued again are reclaimed by the other instances once the lease expires
+func (f *sharedFrontier) leave() {
+	if _, err := f.client.doIdempotent("EVAL", sharedRequeue, "3", f.processingKey(f.id), f.prefix+"queue", f.prefix+"instances", f.id); err != nil {
+		logger.Errorf("Could not queue the pages this instance didn't crawl in the shared frontier: %v", err)
+		return
+	}
+	f.client.doIdempotent("DEL", f.leaseKey(f.id))
+}
+
+// recover queues the pages of the processing list of this instance again when they aren't being crawled,
+// like a page whose pop reply was lost
+func (f *sharedFrontier) recover() error {
+	reply, err := f.client.do("LRANGE", f.processingKey(f.id), "0", "-1")
+	if err != nil {
+		return err
+	}
+	entries, _ := reply.([]interface{})
+	for _, e := range entries {
+		entry, _ := e.(string)
+		f.mu.Lock()
+		popped := f.popped[entry]
+		f.mu.Unlock()
+		if popped {
+			continue
+		}
+		if _, err := f.client.doIdempotent("EVAL", sharedRelease, "2", f.processingKey(f.id), f.prefix+"queue", entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reclaim queues the pages of the instances whose lease expired again
+func (f *sharedFrontier) reclaim() error {
+	reply, err := f.client.do("SMEMBERS", f.prefix+"instances")
+	if err != nil {
+		return err
+	}
+	ids, _ := reply.([]interface{})
+	for _, i := range ids {
+		id, _ := i.(string)
+		if id == "" || id == f.id {
+			continue
+		}
+		alive, err := redisInt(f.client.do("EXISTS", f.leaseKey(id)))
+		if err != nil {
+			return err
+		}
+		if alive > 0 {
+			continue
+		}
+		moved, err := redisInt(f.client.doIdempotent("EVAL", sharedRequeue, "3", f.processingKey(id), f.prefix+"queue", f.prefix+"instances", id))
+		if err != nil {
+			return err
+		}
+		if moved > 0 {
+			logger.Infof("Queued again %d pages of an instance of the distributed crawl whose lease expired", moved)
+		}
+	}
+	return nil
+}
+
+// apply makes a collector crawl the pages it pops, and queue the others in Redis instead of requesting them
+func (f *sharedFrontier) apply(c *colly.Collector) {
+	// Pages are only crawled once across instances, Redis keeps track of the ones that were seen
+	c.AllowURLRevisit = true
+	// The links of a page are queued before it's counted as crawled, or another instance could see nothing pending and stop
+	// the popped pages are crawled concurrently by crawl instead
+	c.Async = false
+	c.OnRequest(func(r *colly.Request) {
+		u := r.URL.String()
+		f.mu.Lock()
+		entry, claimed := f.claimed[u]
+		if claimed {
+			delete(f.claimed, u)
+			f.owned[r.ID] = entry
+		}
+		f.mu.Unlock()
+		if claimed {
+			var page QueuedPage
+			json.Unmarshal([]byte(entry), &page)
+			r.Depth = page.Depth
+			return
+		}
+		r.Abort()
+		if err := f.push(u, r.Depth); err != nil {
+			logger.Errorf("Could not queue %s in the shared frontier: %v", u, err)
+		}
+	})
+	c.OnScraped(func(r *colly.Response) {
+		f.done(r.Request)
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		f.done(r.Request)
+	})
+}
+
+// owns reports whether a request is for a page this instance popped, the other requests are only queued
+func (f *sharedFrontier) owns(r *colly.Request) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, owned := f.owned[r.ID]
+	return owned
+}
+
+// done counts a request as crawled if it's one of the pages this instance popped
+func (f *sharedFrontier) done(r *colly.Request) {
+	f.mu.Lock()
+	entry, owned := f.owned[r.ID]
+	delete(f.owned, r.ID)
+	f.mu.Unlock()
+	if owned {
+		f.finish(entry)
+	}
+}
+
+// crawl queues the pages the crawl of the target starts from, and crawls the pages this instance pops
+// until no instance has anything left to crawl
+func (f *sharedFrontier) crawl(ctx context.Context, c *colly.Collector, target string, pages []string) {
+	if err := f.renew(); err != nil {
+		logger.WithTarget(target).Errorf("Could not join the shared frontier: %v", err)
+		return
+	}
+	stop := make(chan struct{})
+	go f.hold(stop)
+	defer f.leave()
+	defer close(stop)
+
+	for _, page := range pages {
+		// The pages are queued the way the collector writes their URL, so the instance that pops them recognizes them
+		if u, err := url.Parse(page); err == nil {
+			page = u.String()
+		}
+		if err := f.push(page, 1); err != nil {
+			logger.WithTarget(target).Errorf("Could not queue %s in the shared frontier: %v", page, err)
+			return
+		}
+	}
+	var visits sync.WaitGroup
+	defer visits.Wait()
+	for ctx.Err() == nil {
+		select {
+		case f.slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		page, entry, ok, err := f.pop()
+		if err != nil || !ok {
+			<-f.slots
+			if err != nil {
+				logger.WithTarget(target).Errorf("Could not read the shared frontier: %v", err)
+				// The page may have been popped without this instance getting it
+				if err := f.recover(); err != nil {
+					logger.WithTarget(target).Errorf("Could not read the shared frontier: %v", err)
+				}
+			} else if err := f.reclaim(); err != nil {
+				logger.WithTarget(target).Errorf("Could not reclaim the pages of the other instances: %v", err)
+			} else if idle, err := f.idle(); err == nil && idle {
+				return
+			}
+			select {
+			case <-time.After(sharedPollInterval):
+			case <-ctx.Done():
+			}
+			continue
+		}
+		f.mu.Lock()
+		f.claimed[page.URL] = entry
+		f.popped[entry] = true
+		f.mu.Unlock()
+		// The page was checked against the limits of the crawl by the instance that found it
+		visits.Add(1)
+		go func(u, entry string) {
+			defer visits.Done()
+			if err := c.Visit(u); err != nil {
+				f.mu.Lock()
+				delete(f.claimed, u)
+				f.mu.Unlock()
+				f.finish(entry)
+			}
+		}(page.URL, entry)
+	}
+}
+
+// redisSink pushes every finding to a Redis list of its target, so the findings of every instance of a distributed crawl are in one place
+type redisSink struct {
+	client *redisClient
+	run    string
+
+	sync.Mutex
+	err error
+}
+
+func (s *redisSink) Send(f Finding) {
+	content, err := json.Marshal(f)
+	if err == nil {
+		_, err = s.client.do("RPUSH", redisKeyPrefix(s.run, f.Target)+"findings", string(content))
+	}
+	if err != nil {
+		s.Lock()
+		if s.err == nil {
+			s.err = err
+		}
+		s.Unlock()
+	}
+}
+
+// Close reports the first push that failed, nothing is buffered
+func (s *redisSink) Close() error {
+	s.Lock()
+	defer s.Unlock()
+	if s.err != nil {
+		return fmt.Errorf("could not push findings to Redis: %v", s.err)
+	}
+	return nil
+}
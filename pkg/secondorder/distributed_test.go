@@ -0,0 +1,225 @@
+package secondorder
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// testRedisServers returns the URLs of the Redis servers the shared frontier is tested against: an in-memory one, and
+// the server of SECOND_ORDER_TEST_REDIS, like redis://localhost:6379/15, which runs the Lua scripts themselves
+func testRedisServers(t *testing.T) map[string]string {
+	servers := map[string]string{"fake": newFakeRedis(t, "").url()}
+	if u := os.Getenv("SECOND_ORDER_TEST_REDIS"); u != "" {
+		servers["redis"] = u
+	}
+	return servers
+}
+
+// testRedisRun names a distributed crawl that no other test uses, so the tests don't share keys on a real server
+func testRedisRun() string {
+	id := make([]byte, 4)
+	rand.Read(id)
+	return "test-" + hex.EncodeToString(id)
+}
+
+func TestSharedFrontier(t *testing.T) {
+	for name, u := range testRedisServers(t) {
+		t.Run(name, func(t *testing.T) {
+			client, err := newRedisClient(u)
+			if err != nil {
+				t.Fatalf("newRedisClient: %v", err)
+			}
+			testSharedFrontier(t, client, testRedisRun())
+		})
+	}
+}
+
+func testSharedFrontier(t *testing.T, client *redisClient, run string) {
+	a := newSharedFrontier(client, run, "https://example.com/", 2)
+	b := newSharedFrontier(client, run, "https://example.com/", 2)
+	pending := func() int64 {
+		t.Helper()
+		n, err := redisInt(client.do("GET", a.prefix+"pending"))
+		if err != nil {
+			t.Fatalf("GET pending: %v", err)
+		}
+		return n
+	}
+	pop := func(f *sharedFrontier) (string, string) {
+		t.Helper()
+		page, entry, ok, err := f.pop()
+		if err != nil || !ok {
+			t.Fatalf("pop() = %v, %v, want a page", ok, err)
+		}
+		f.slots <- struct{}{}
+		f.popped[entry] = true
+		return page.URL, entry
+	}
+	for _, f := range []*sharedFrontier{a, b} {
+		if err := f.renew(); err != nil {
+			t.Fatalf("renew: %v", err)
+		}
+	}
+
+	// A page is queued once, whoever finds it
+	a.push("https://example.com/a", 1)
+	b.push("https://example.com/a", 2)
+	if n := pending(); n != 1 {
+		t.Errorf("%d pages pending after the same page was pushed twice", n)
+	}
+	if idle, err := a.idle(); err != nil || idle {
+		t.Errorf("idle() = %v, %v with a page queued", idle, err)
+	}
+
+	// A popped page is pending until it's crawled, and no other instance gets it
+	page, entry := pop(b)
+	if page != "https://example.com/a" {
+		t.Errorf("popped %s", page)
+	}
+	if _, _, ok, _ := a.pop(); ok {
+		t.Error("a page was popped twice")
+	}
+	if idle, _ := a.idle(); idle {
+		t.Error("the crawl is idle while an instance crawls a page")
+	}
+	b.finish(entry)
+	if idle, err := a.idle(); err != nil || !idle {
+		t.Errorf("idle() = %v, %v once the only page was crawled", idle, err)
+	}
+	// A page is counted once, even if it's finished twice
+	b.slots <- struct{}{}
+	b.finish(entry)
+	if n := pending(); n != 0 {
+		t.Errorf("%d pages pending after a page was finished twice", n)
+	}
+	// And it isn't queued again once it was crawled
+	a.push("https://example.com/a", 1)
+	if n := pending(); n != 0 {
+		t.Errorf("a page that was crawled was queued again")
+	}
+
+	// The pages of an instance whose lease expired are queued again by the others
+	a.push("https://example.com/b", 1)
+	_, entry = pop(a)
+	if err := b.reclaim(); err != nil {
+		t.Fatalf("reclaim: %v", err)
+	}
+	if _, _, ok, _ := b.pop(); ok {
+		t.Fatal("the page of an instance that's alive was reclaimed")
+	}
+	client.do("DEL", a.leaseKey(a.id))
+	if err := b.reclaim(); err != nil {
+		t.Fatalf("reclaim: %v", err)
+	}
+	if page, _ := pop(b); page != "https://example.com/b" {
+		t.Errorf("popped %s, want the page of the dead instance", page)
+	}
+	if members, _ := client.do("SMEMBERS", a.prefix+"instances"); len(members.([]interface{})) != 1 {
+		t.Errorf("instances = %v, want the dead instance forgotten", members)
+	}
+	// The dead instance can't count the page anymore, the one that reclaimed it does
+	a.finish(entry)
+	if n := pending(); n != 1 {
+		t.Errorf("%d pages pending after a dead instance finished a page it lost, want 1", n)
+	}
+	b.finish(entry)
+	if n := pending(); n != 0 {
+		t.Errorf("%d pages pending after the page was crawled", n)
+	}
+
+	// A page whose pop reply was lost is queued again
+	b.push("https://example.com/c", 1)
+	if _, _, ok, _ := b.pop(); !ok {
+		t.Fatal("pop() found nothing")
+	}
+	if err := b.recover(); err != nil {
+		t.Fatalf("recover: %v", err)
+	}
+	page, _ = pop(b)
+	if page != "https://example.com/c" {
+		t.Errorf("popped %s, want the page that was recovered", page)
+	}
+
+	// An instance that leaves queues what it didn't crawl again
+	b.leave()
+	if n, _ := redisInt(client.do("LLEN", b.prefix+"queue")); n != 1 {
+		t.Errorf("%d pages queued after an instance left with one it didn't crawl", n)
+	}
+	if n := pending(); n != 1 {
+		t.Errorf("%d pages pending after an instance left, want the page still pending", n)
+	}
+	if alive, _ := redisInt(client.do("EXISTS", b.leaseKey(b.id))); alive != 0 {
+		t.Error("an instance that left still has its lease")
+	}
+}
+
+func TestDistributedCrawl(t *testing.T) {
+	var mu sync.Mutex
+	requests := make(map[string]int)
+	site := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests[r.URL.Path]++
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, `<html><body>`)
+		for i := 0; i < 3; i++ {
+			fmt.Fprintf(w, `<a href="/%d">page</a>`, i)
+		}
+		fmt.Fprint(w, `</body></html>`)
+	}))
+	defer site.Close()
+
+	for name, u := range testRedisServers(t) {
+		t.Run(name, func(t *testing.T) {
+			mu.Lock()
+			requests = make(map[string]int)
+			mu.Unlock()
+			run := testRedisRun()
+			results := make([]*Result, 2)
+			var wg sync.WaitGroup
+			for i := range results {
+				sc, err := NewScanner(Config{Options: Options{Depth: 3, Threads: 2, Redis: u, RedisRun: run}})
+				if err != nil {
+					t.Fatalf("NewScanner: %v", err)
+				}
+				defer sc.Close()
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					results[i], _ = sc.Run(context.Background(), site.URL+"/")
+				}(i)
+			}
+			wg.Wait()
+
+			mu.Lock()
+			defer mu.Unlock()
+			for path, n := range requests {
+				if n != 1 {
+					t.Errorf("%s was requested %d times", path, n)
+				}
+			}
+			var pages []string
+			for _, result := range results {
+				if result == nil {
+					t.Fatal("a scan has no result")
+				}
+				for _, page := range result.Pages {
+					pages = append(pages, page.URL)
+				}
+			}
+			sort.Strings(pages)
+			want := []string{site.URL + "/", site.URL + "/0", site.URL + "/1", site.URL + "/2"}
+			if fmt.Sprint(pages) != fmt.Sprint(want) {
+				t.Errorf("the instances crawled %q, want %q", pages, want)
+			}
+		})
+	}
+}
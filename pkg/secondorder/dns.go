@@ -0,0 +1,121 @@
+package secondorder
+
+import (
+	"context"
+	"net/url"
+	"sort"
+	"sync"
+)
+
+// DanglingDomain is an external host referenced by the target that doesn't resolve
+type DanglingDomain struct {
+	Host   string   `json:"host"`
+	Status string   `json:"status"`
+	CNAMEs []string `json:"cnames,omitempty"`
+	Pages  []string `json:"pages"`
+}
+
+type danglingDomains struct {
+	sync.Mutex
+	domains map[string]*DanglingDomain
+	// Hosts that were already resolved in this scan, dangling or not
+	checked map[string]bool
+	// Pages of the hosts being resolved, they're kept with the host if it's dangling
+	pending map[string][]string
+}
+
+func newDanglingDomains() *danglingDomains {
+	return &danglingDomains{domains: make(map[string]*DanglingDomain), checked: make(map[string]bool), pending: make(map[string][]string)}
+}
+
+// checkDomain resolves the host of an external resource and records it if it's dangling
+// a host that was already checked, or is being resolved, is only linked to the new page
+func (s *scan) checkDomain(page, resource string) {
+	host, ok := s.thirdPartyHost(resource)
+	if !ok {
+		return
+	}
+
+	s.dangling.Lock()
+	if d, ok := s.dangling.domains[host]; ok && !contains(d.Pages, page) {
+		d.Pages = append(d.Pages, page)
+	}
+	if pages, ok := s.dangling.pending[host]; ok && !contains(pages, page) {
+		s.dangling.pending[host] = append(pages, page)
+	}
+	checked := s.dangling.checked[host]
+	if !checked {
+		s.dangling.checked[host] = true
+		s.dangling.pending[host] = []string{page}
+	}
+	s.dangling.Unlock()
+	if checked {
+		return
+	}
+
+	status, chain := s.resolveStatus(s.ctx, host)
+	s.dangling.Lock()
+	pages := s.dangling.pending[host]
+	delete(s.dangling.pending, host)
+	if status != "" {
+		s.dangling.domains[host] = &DanglingDomain{Host: host, Status: status, CNAMEs: chain, Pages: pages}
+	}
+	s.dangling.Unlock()
+	if status != "" {
+		s.report(Finding{Type: FindingDangling, Page: page, Resource: host, Detail: status})
+	}
+}
+
+// resolveStatus returns why a host is dangling (NXDOMAIN, a CNAME to a name that doesn't exist, or SERVFAIL), or an empty string if it resolves
+func (sc *Scanner) resolveStatus(ctx context.Context, host string) (string, []string) {
+	chain, err := sc.resolver.LookupChain(ctx, host)
+	switch {
+	case isNXDOMAIN(err) && len(chain) > 0:
+		return "dangling CNAME", chain
+	case isNXDOMAIN(err):
+		return "NXDOMAIN", nil
+	case isSERVFAIL(err):
+		return "SERVFAIL", chain
+	}
+	return "", nil
+}
+
+// isDNSDangling is the -dns-only replacement for HTTP verification
+func (sc *Scanner) isDNSDangling(ctx context.Context, u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil || parsed.Hostname() == "" {
+		return false
+	}
+	status, _ := sc.resolveStatus(ctx, parsed.Hostname())
+	if status == "" {
+		return false
+	}
+	sc.responses.Store(u, &Response{Error: status})
+	return true
+}
+
+// restore adds dangling domains saved by an interrupted run, their hosts aren't resolved again
+func (d *danglingDomains) restore(domains []*DanglingDomain) {
+	d.Lock()
+	defer d.Unlock()
+	for _, domain := range domains {
+		d.domains[domain.Host] = domain
+		d.checked[domain.Host] = true
+	}
+}
+
+// list returns copies of the dangling domains sorted by host
+func (d *danglingDomains) list() []*DanglingDomain {
+	d.Lock()
+	defer d.Unlock()
+	list := make([]*DanglingDomain, 0, len(d.domains))
+	for _, domain := range d.domains {
+		c := *domain
+		c.CNAMEs = append([]string(nil), domain.CNAMEs...)
+		c.Pages = append([]string(nil), domain.Pages...)
+		sort.Strings(c.Pages)
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Host < list[j].Host })
+	return list
+}
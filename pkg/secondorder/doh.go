@@ -0,0 +1,122 @@
+package secondorder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// parseResolver reads the Resolver option: the address of a nameserver, with port 53 by default, or the URL of a
+// DNS over HTTPS server
+func parseResolver(value string) (nameserver string, doh *dohClient, err error) {
+	if strings.Contains(value, "://") {
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return "", nil, fmt.Errorf("invalid resolver %q: DNS over HTTPS needs an https:// URL", value)
+		}
+		return "", &dohClient{url: value, client: &http.Client{Timeout: 5 * time.Second}}, nil
+	}
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		host, port = strings.Trim(value, "[]"), "53"
+	}
+	if net.ParseIP(host) == nil {
+		return "", nil, fmt.Errorf("invalid resolver %q: use an IP address like 1.1.1.1:53 or a DNS over HTTPS URL", value)
+	}
+	return net.JoinHostPort(host, port), nil, nil
+}
+
+// dohClient sends DNS queries to a DNS over HTTPS server (RFC 8484)
+type dohClient struct {
+	url    string
+	client *http.Client
+}
+
+// exchange sends a packed query and returns the packed response
+func (d *dohClient) exchange(ctx context.Context, packet []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(packet))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/dns-message")
+	req.Header.Set("Accept", "application/dns-message")
+	res, err := d.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS over HTTPS server responded with %d", res.StatusCode)
+	}
+	return ioutil.ReadAll(io.LimitReader(res.Body, 65535))
+}
+
+// dial returns a connection the standard library resolver can send its queries through, so every lookup goes to the server
+func (d *dohClient) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	return &dohConn{ctx: ctx, doh: d}, nil
+}
+
+// dohConn is a DNS over TCP connection whose queries are sent over HTTPS
+// the resolver writes length-prefixed queries to it, and reads the length-prefixed responses back
+type dohConn struct {
+	ctx      context.Context
+	doh      *dohClient
+	deadline time.Time
+	written  bytes.Buffer
+	read     bytes.Buffer
+}
+
+func (c *dohConn) Write(b []byte) (int, error) {
+	ctx := c.ctx
+	if !c.deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, c.deadline)
+		defer cancel()
+	}
+	c.written.Write(b)
+	for c.written.Len() >= 2 {
+		pending := c.written.Bytes()
+		n := int(pending[0])<<8 | int(pending[1])
+		if len(pending) < 2+n {
+			break
+		}
+		query := append([]byte(nil), pending[2:2+n]...)
+		c.written.Next(2 + n)
+
+		response, err := c.doh.exchange(ctx, query)
+		if err != nil {
+			return 0, err
+		}
+		c.read.Write([]byte{byte(len(response) >> 8), byte(len(response))})
+		c.read.Write(response)
+	}
+	return len(b), nil
+}
+
+func (c *dohConn) Read(b []byte) (int, error) {
+	if c.read.Len() == 0 {
+		return 0, errors.New("no DNS over HTTPS response to read")
+	}
+	return c.read.Read(b)
+}
+
+func (c *dohConn) Close() error                       { return nil }
+func (c *dohConn) LocalAddr() net.Addr                { return dohAddr(c.doh.url) }
+func (c *dohConn) RemoteAddr() net.Addr               { return dohAddr(c.doh.url) }
+func (c *dohConn) SetDeadline(t time.Time) error      { c.deadline = t; return nil }
+func (c *dohConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *dohConn) SetWriteDeadline(t time.Time) error { c.deadline = t; return nil }
+
+// dohAddr is the address of a DNS over HTTPS server, its URL
+type dohAddr string
+
+func (a dohAddr) Network() string { return "https" }
+func (a dohAddr) String() string  { return string(a) }
@@ -0,0 +1,136 @@
+package secondorder
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"time"
+)
+
+// Egress selects the network path requests leave through
+// it's needed when different scopes are only reachable through different VPNs or tunnels from the same machine
+type Egress struct {
+	// Name of the local network interface to bind to (e.g. "tun0")
+	Interface string
+	// Local IP address to bind to, takes precedence over Interface
+	LocalAddress string
+	// socks5:// (or http://) URL of a proxy to tunnel the requests through
+	Proxy string
+}
+
+// TargetEgress is the egress used to crawl the targets that match Pattern
+type TargetEgress struct {
+	Pattern string
+	Egress
+
+	pattern *regexp.Regexp
+}
+
+// egressFor returns the egress of the first TargetEgress rule that matches the target, or the default one
+func (config Config) egressFor(target string) Egress {
+	for _, rule := range config.TargetEgress {
+		if rule.pattern.MatchString(target) {
+			return rule.Egress
+		}
+	}
+	return config.Egress
+}
+
+// transport builds an HTTP transport that leaves through the egress
+func (e Egress) transport(skipTLSVerify bool, resolver *cachingResolver) (*http.Transport, error) {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+
+	localIP, err := e.localIP()
+	if err != nil {
+		return nil, err
+	}
+	if localIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: localIP}
+	}
+
+	// Connections to the hosts a crawl is done with are closed once they've been idle for a while
+	t := &http.Transport{
+		DialContext:     resolver.DialContextWith(dialer),
+		TLSClientConfig: &tls.Config{InsecureSkipVerify: skipTLSVerify},
+		IdleConnTimeout: 90 * time.Second,
+	}
+	proxyURL, err := e.proxyURL()
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL != nil {
+		// The proxy resolves the target's hostname, so hosts only visible inside the tunnel work
+		t.Proxy = http.ProxyURL(proxyURL)
+	}
+	return t, nil
+}
+
+// validate checks the interface, address and proxy of the egress without building a transport
+func (e Egress) validate() error {
+	if _, err := e.localIP(); err != nil {
+		return err
+	}
+	_, err := e.proxyURL()
+	return err
+}
+
+// proxyURL parses the proxy of the egress, nil if there's none
+func (e Egress) proxyURL() (*url.URL, error) {
+	if e.Proxy == "" {
+		return nil, nil
+	}
+	proxyURL, err := url.Parse(e.Proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy %q: %v", e.Proxy, err)
+	}
+	switch proxyURL.Scheme {
+	case "socks5", "http", "https":
+	default:
+		return nil, fmt.Errorf("invalid proxy %q: the scheme must be socks5, http or https", e.Proxy)
+	}
+	return proxyURL, nil
+}
+
+// localIP returns the address to bind to, or nil to let the OS choose
+func (e Egress) localIP() (net.IP, error) {
+	if e.LocalAddress != "" {
+		ip := net.ParseIP(e.LocalAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid local address %q", e.LocalAddress)
+		}
+		return ip, nil
+	}
+	if e.Interface == "" {
+		return nil, nil
+	}
+
+	iface, err := net.InterfaceByName(e.Interface)
+	if err != nil {
+		return nil, fmt.Errorf("invalid interface %q: %v", e.Interface, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("invalid interface %q: %v", e.Interface, err)
+	}
+	// Prefer IPv4 since most scopes are still IPv4-only
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipnet.IP.To4() != nil {
+			return ipnet.IP, nil
+		}
+		if ipv6 == nil {
+			ipv6 = ipnet.IP
+		}
+	}
+	if ipv6 == nil {
+		return nil, fmt.Errorf("interface %q has no IP address", e.Interface)
+	}
+	return ipv6, nil
+}
@@ -0,0 +1,89 @@
+package secondorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Engagement records the authorization a scan runs under
+// it's embedded in the run metadata and sent with every request so the people on the other end know who's scanning and why
+type Engagement struct {
+	// Reference to the authorization (program URL, contract or ticket number)
+	Authorization string
+	// Email address or URL to contact the person running the scan
+	Contact string
+	// Maximum number of requests per second allowed by the engagement, 0 for no limit
+	MaxRequestsPerSecond float64
+}
+
+func (e Engagement) isSet() bool {
+	return e != Engagement{}
+}
+
+// validate checks that an engagement that's set has everything needed to scan responsibly
+func (e Engagement) validate() error {
+	if !e.isSet() {
+		return nil
+	}
+	if strings.TrimSpace(e.Authorization) == "" {
+		return errors.New("engagement: Authorization is required")
+	}
+	if strings.TrimSpace(e.Contact) == "" {
+		return errors.New("engagement: Contact is required")
+	}
+	if _, err := mail.ParseAddress(e.Contact); err != nil {
+		if u, err := url.Parse(e.Contact); err != nil || !u.IsAbs() {
+			return fmt.Errorf("engagement: Contact %q is neither an email address nor a URL", e.Contact)
+		}
+	}
+	if e.MaxRequestsPerSecond < 0 {
+		return errors.New("engagement: MaxRequestsPerSecond can't be negative")
+	}
+	return nil
+}
+
+// headers returns the identification headers sent with every request
+func (e Engagement) headers() map[string]string {
+	if !e.isSet() {
+		return nil
+	}
+	h := map[string]string{
+		"X-Scanner": fmt.Sprintf("second-order (authorization: %s)", e.Authorization),
+	}
+	if _, err := mail.ParseAddress(e.Contact); err == nil {
+		h["From"] = e.Contact
+	} else {
+		h["X-Scanner"] += fmt.Sprintf(" (contact: %s)", e.Contact)
+	}
+	return h
+}
+
+// rateLimiter spaces out requests so they don't exceed a number per second
+type rateLimiter struct {
+	ticker *time.Ticker
+}
+
+func newRateLimiter(perSecond float64) *rateLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &rateLimiter{ticker: time.NewTicker(time.Duration(float64(time.Second) / perSecond))}
+}
+
+// wait blocks until the next request is allowed, a nil limiter never blocks
+func (l *rateLimiter) wait(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case <-l.ticker.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,206 @@
+package secondorder
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event types
+const (
+	EventTargetStarted    = "target-started"
+	EventTargetFinished   = "target-finished"
+	EventPageCrawled      = "page-crawled"
+	EventResourceFound    = "resource-found"
+	EventFindingConfirmed = "finding-confirmed"
+)
+
+// Event is something that happened while a target was crawled
+// only the field of its type is set
+type Event struct {
+	Type   string    `json:"type"`
+	Target string    `json:"target"`
+	Time   time.Time `json:"time"`
+	// Page as it was first recorded from its response, for EventPageCrawled
+	Page *Page `json:"page,omitempty"`
+	// URL a page loads, for EventResourceFound
+	Resource *Resource `json:"resource,omitempty"`
+	// Finding of EventFindingConfirmed
+	Finding *Finding `json:"finding,omitempty"`
+}
+
+// Resource is a URL a crawled page loads, and the tag-attribute query it was found with
+type Resource struct {
+	Page  string `json:"page"`
+	Query string `json:"query"`
+	URL   string `json:"url"`
+}
+
+// EventSink receives the events of every target a Scanner runs, in the order they happened
+// writers, notifiers and exporters are sinks, so they don't need hooks of their own in the crawl
+type EventSink interface {
+	Handle(Event)
+	// Close delivers whatever is still buffered
+	Close() error
+}
+
+// findingSink passes the confirmed findings to a FindingSink
+type findingSink struct {
+	FindingSink
+}
+
+func (s findingSink) Handle(e Event) {
+	if e.Type == EventFindingConfirmed {
+		s.Send(*e.Finding)
+	}
+}
+
+// eventBus delivers the events of the scans to the sinks through a channel
+// so a slow sink holds back the other sinks, but not the crawl until the channel fills up
+type eventBus struct {
+	sinks  []EventSink
+	events chan Event
+	done   chan struct{}
+	start  sync.Once
+	stop   sync.Once
+}
+
+// add registers a sink, it must be called before the first event is published
+func (b *eventBus) add(sink EventSink) {
+	b.sinks = append(b.sinks, sink)
+}
+
+// publish queues an event for the sinks, events aren't even built when there are no sinks to receive them
+func (b *eventBus) publish(e Event) {
+	if len(b.sinks) == 0 {
+		return
+	}
+	b.start.Do(func() {
+		b.events = make(chan Event, 256)
+		b.done = make(chan struct{})
+		go b.loop()
+	})
+	// The bus was closed before anything was published
+	if b.events == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	b.events <- e
+}
+
+func (b *eventBus) loop() {
+	defer close(b.done)
+	for e := range b.events {
+		for _, sink := range b.sinks {
+			sink.Handle(e)
+		}
+	}
+}
+
+// close waits until every queued event is delivered and closes the sinks, no event can be published after it
+func (b *eventBus) close() error {
+	// An event published from now on would start a loop that's never stopped
+	b.start.Do(func() {})
+	b.stop.Do(func() {
+		if b.events != nil {
+			close(b.events)
+			<-b.done
+		}
+	})
+
+	var errs []string
+	for _, sink := range b.sinks {
+		if err := sink.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("could not deliver findings: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Number of findings a sink that delivers them over the network holds while its endpoint is slow or down,
+// the findings found past it are dropped and counted
+const maxQueuedFindings = 10000
+
+// findingQueue holds the findings of a sink until its loop delivers them, without ever making Send wait:
+// a webhook that's down would otherwise fill the bus, and then hold back every page of the crawl that finds something
+type findingQueue struct {
+	// ready is signaled when findings are added or the queue is closed
+	ready chan struct{}
+
+	mu       sync.Mutex
+	findings []Finding
+	closed   bool
+	dropped  int
+}
+
+func newFindingQueue() *findingQueue {
+	return &findingQueue{ready: make(chan struct{}, 1)}
+}
+
+// push adds a finding, or drops it when the queue is full
+func (q *findingQueue) push(f Finding) {
+	q.mu.Lock()
+	if len(q.findings) >= maxQueuedFindings {
+		q.dropped++
+	} else {
+		q.findings = append(q.findings, f)
+	}
+	q.mu.Unlock()
+	q.signal()
+}
+
+// close lets the loop deliver the findings left and stop
+func (q *findingQueue) close() {
+	q.mu.Lock()
+	q.closed = true
+	q.mu.Unlock()
+	q.signal()
+}
+
+func (q *findingQueue) signal() {
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take removes up to max queued findings, done is true once the queue is closed and nothing is left
+func (q *findingQueue) take(max int) (findings []Finding, done bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	n := len(q.findings)
+	if max > 0 && n > max {
+		n = max
+	}
+	findings = append([]Finding(nil), q.findings[:n]...)
+	q.findings = q.findings[n:]
+	return findings, q.closed && len(q.findings) == 0
+}
+
+// closing reports whether the queue was closed, the findings left are the last ones
+func (q *findingQueue) closing() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.closed
+}
+
+// drop gives up the queued findings, they're counted with the ones dropped when the queue was full
+func (q *findingQueue) drop() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.dropped += len(q.findings)
+	q.findings = nil
+}
+
+// droppedCount returns the number of findings that were dropped
+func (q *findingQueue) droppedCount() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
@@ -0,0 +1,98 @@
+package secondorder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindingQueue(t *testing.T) {
+	finding := func(page string) Finding { return Finding{Type: FindingNon200, Page: page} }
+	tests := []struct {
+		name string
+		run  func(q *findingQueue)
+		// Calls of take, with their max, and what they return
+		takes   []int
+		want    [][]Finding
+		done    []bool
+		dropped int
+	}{
+		{
+			name:  "empty",
+			run:   func(q *findingQueue) {},
+			takes: []int{0},
+			want:  [][]Finding{{}},
+			done:  []bool{false},
+		},
+		{
+			name:  "in order",
+			run:   func(q *findingQueue) { q.push(finding("a")); q.push(finding("b")); q.push(finding("c")) },
+			takes: []int{2, 0},
+			want:  [][]Finding{{finding("a"), finding("b")}, {finding("c")}},
+			done:  []bool{false, false},
+		},
+		{
+			name:  "done once closed and empty",
+			run:   func(q *findingQueue) { q.push(finding("a")); q.push(finding("b")); q.close() },
+			takes: []int{1, 1, 1},
+			want:  [][]Finding{{finding("a")}, {finding("b")}, {}},
+			done:  []bool{false, true, true},
+		},
+		{
+			name:    "dropped",
+			run:     func(q *findingQueue) { q.push(finding("a")); q.push(finding("b")); q.drop(); q.push(finding("c")) },
+			takes:   []int{0},
+			want:    [][]Finding{{finding("c")}},
+			done:    []bool{false},
+			dropped: 2,
+		},
+		{
+			name: "full",
+			run: func(q *findingQueue) {
+				for i := 0; i < maxQueuedFindings+3; i++ {
+					q.push(finding("a"))
+				}
+			},
+			dropped: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newFindingQueue()
+			tt.run(q)
+			for i, max := range tt.takes {
+				findings, done := q.take(max)
+				if len(findings) == 0 && len(tt.want[i]) == 0 {
+					findings = tt.want[i]
+				}
+				if !reflect.DeepEqual(findings, tt.want[i]) || done != tt.done[i] {
+					t.Errorf("take(%d) = %v, %v, want %v, %v", max, findings, done, tt.want[i], tt.done[i])
+				}
+			}
+			if got := q.droppedCount(); got != tt.dropped {
+				t.Errorf("droppedCount() = %d, want %d", got, tt.dropped)
+			}
+		})
+	}
+}
+
+func TestFindingQueueSignals(t *testing.T) {
+	q := newFindingQueue()
+	// Pushing never waits for the loop, however many findings it didn't take yet
+	for i := 0; i < 3; i++ {
+		q.push(Finding{})
+	}
+	select {
+	case <-q.ready:
+	default:
+		t.Fatal("push didn't signal the queue")
+	}
+	q.close()
+	select {
+	case <-q.ready:
+	default:
+		t.Fatal("close didn't signal the queue")
+	}
+	if !q.closing() {
+		t.Error("closing() = false after close")
+	}
+}
@@ -0,0 +1,145 @@
+package secondorder
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/PuerkitoBio/goquery"
+	"golang.org/x/net/html"
+)
+
+// Bytes of the outer HTML of an element kept as evidence, a frame or a script with inline content can be huge
+const maxEvidenceLength = 300
+
+// Evidence is where on a page a logged resource is referenced, so it can be found without searching the source
+type Evidence struct {
+	// Outer HTML of the element, truncated
+	Element string `json:"element"`
+	// CSS path of the element, like html > body > div#app > script:nth-of-type(2)
+	Path string `json:"path"`
+}
+
+// newEvidence returns the evidence of the first element of a selection
+func newEvidence(selection *goquery.Selection) *Evidence {
+	if selection.Length() == 0 {
+		return nil
+	}
+	element, _ := goquery.OuterHtml(selection.First())
+	return &Evidence{Element: truncateEvidence(element), Path: cssPath(selection.Get(0))}
+}
+
+// truncateEvidence cuts an element to maxEvidenceLength bytes without splitting a character
+func truncateEvidence(element string) string {
+	if len(element) <= maxEvidenceLength {
+		return element
+	}
+	cut := maxEvidenceLength
+	for cut > 0 && !utf8.RuneStart(element[cut]) {
+		cut--
+	}
+	return element[:cut] + "…"
+}
+
+// cssPath returns a selector that matches an element, from the root of the document or from its closest ancestor with an id
+// siblings with the same tag are told apart with :nth-of-type
+func cssPath(node *html.Node) string {
+	var steps []string
+	for ; node != nil && node.Type == html.ElementNode; node = node.Parent {
+		if id := nodeAttr(node, "id"); id != "" {
+			steps = append(steps, node.Data+"#"+id)
+			break
+		}
+		step := node.Data
+		index, count := 0, 0
+		for sibling := firstSibling(node); sibling != nil; sibling = sibling.NextSibling {
+			if sibling.Type == html.ElementNode && sibling.Data == node.Data {
+				count++
+				if sibling == node {
+					index = count
+				}
+			}
+		}
+		if count > 1 {
+			step += fmt.Sprintf(":nth-of-type(%d)", index)
+		}
+		steps = append(steps, step)
+	}
+	for i, j := 0, len(steps)-1; i < j; i, j = i+1, j-1 {
+		steps[i], steps[j] = steps[j], steps[i]
+	}
+	return strings.Join(steps, " > ")
+}
+
+func firstSibling(node *html.Node) *html.Node {
+	if node.Parent != nil {
+		return node.Parent.FirstChild
+	}
+	for node.PrevSibling != nil {
+		node = node.PrevSibling
+	}
+	return node
+}
+
+func nodeAttr(node *html.Node, name string) string {
+	for _, a := range node.Attr {
+		if a.Namespace == "" && a.Key == name {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// evidenceStore keeps the evidence of the logged resources, keyed by page URL and then by resource
+// a resource referenced several times on a page keeps the evidence of its first reference
+type evidenceStore struct {
+	sync.Mutex
+	content map[string]map[string]*Evidence
+}
+
+func newEvidenceStore() *evidenceStore {
+	return &evidenceStore{content: make(map[string]map[string]*Evidence)}
+}
+
+func (e *evidenceStore) add(page, resource string, evidence *Evidence) {
+	if evidence == nil {
+		return
+	}
+	e.Lock()
+	defer e.Unlock()
+	if _, ok := e.content[page]; !ok {
+		e.content[page] = make(map[string]*Evidence)
+	}
+	if _, ok := e.content[page][resource]; !ok {
+		e.content[page][resource] = evidence
+	}
+}
+
+func (e *evidenceStore) get(page, resource string) *Evidence {
+	e.Lock()
+	defer e.Unlock()
+	return e.content[page][resource]
+}
+
+func (e *evidenceStore) copy() map[string]map[string]*Evidence {
+	e.Lock()
+	defer e.Unlock()
+	content := make(map[string]map[string]*Evidence, len(e.content))
+	for page, resources := range e.content {
+		content[page] = make(map[string]*Evidence, len(resources))
+		for resource, evidence := range resources {
+			content[page][resource] = evidence
+		}
+	}
+	return content
+}
+
+// restore adds the evidence saved by an interrupted run
+func (e *evidenceStore) restore(content map[string]map[string]*Evidence) {
+	for page, resources := range content {
+		for resource, evidence := range resources {
+			e.add(page, resource, evidence)
+		}
+	}
+}
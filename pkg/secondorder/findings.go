@@ -0,0 +1,97 @@
+package secondorder
+
+import (
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Finding types
+const (
+	FindingNon200             = "non-200"
+	FindingDangling           = "dangling-domain"
+	FindingDanglingLink       = "dangling-link"
+	FindingCMS                = "cms"
+	FindingGraphQL            = "graphql-introspection"
+	FindingTakeover           = "takeover-candidate"
+	FindingSecret             = "secret"
+	FindingInlineScriptChange = "inline-script-change"
+	FindingScriptChange       = "script-change"
+)
+
+// Finding is a structured result, sent to the finding sinks as soon as it's found
+type Finding struct {
+	Type     string `json:"type"`
+	Target   string `json:"target"`
+	Page     string `json:"page"`
+	Resource string `json:"resource,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	// Confidence score of takeover candidates, from 0 to 100
+	Confidence int `json:"confidence,omitempty"`
+	// Name and severity of the query rule that found the resource, the severity overrides the one of the type
+	Rule     string `json:"rule,omitempty"`
+	Severity string `json:"severity,omitempty"`
+	// How a non-200 resource responded, and where on the page it's referenced
+	Response *Response `json:"response,omitempty"`
+	Evidence *Evidence `json:"evidence,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// AtLeast reports whether a finding is at least as severe as a severity (info, low, medium, high or critical)
+// the severity of a finding is the one of its query rule, or the one of its type
+func (f Finding) AtLeast(severity string) bool {
+	min, err := strconv.ParseFloat(severityLevels[severity].severity, 64)
+	if err != nil {
+		return false
+	}
+	score, _ := strconv.ParseFloat(findingSeverity(f), 64)
+	return score >= min
+}
+
+// Level returns the name of the severity of a finding: info, low, medium, high or critical
+func (f Finding) Level() string {
+	level := SeverityInfo
+	for _, severity := range []string{SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical} {
+		if f.AtLeast(severity) {
+			level = severity
+		}
+	}
+	return level
+}
+
+// FindingSink receives findings while the scan is running
+type FindingSink interface {
+	Send(Finding)
+	// Close delivers whatever is still buffered
+	Close() error
+}
+
+// findingList keeps the findings of a scan for its result
+type findingList struct {
+	sync.Mutex
+	findings []Finding
+}
+
+func (l *findingList) count() int {
+	l.Lock()
+	defer l.Unlock()
+	return len(l.findings)
+}
+
+// report records a finding of the scan and publishes it to the sinks
+func (s *scan) report(f Finding) {
+	f.Target = s.target
+	if f.Time.IsZero() {
+		f.Time = time.Now()
+	}
+	s.findings.Lock()
+	s.findings.findings = append(s.findings.findings, f)
+	found := len(s.findings.findings)
+	s.findings.Unlock()
+	if !s.reported(f) {
+		s.events.publish(Event{Type: EventFindingConfirmed, Target: s.target, Time: f.Time, Finding: &f})
+	}
+	if max := s.config.Options.MaxFindings; max > 0 && found >= max {
+		s.exhaust(StopMaxFindings)
+	}
+}
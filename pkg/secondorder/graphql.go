@@ -0,0 +1,137 @@
+package secondorder
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// graphQLEndpoint matches the paths GraphQL APIs are usually served from
+var graphQLEndpoint = regexp.MustCompile(`(?i)/(?:graphql|gql|graphiql)/?$`)
+var graphQLInlineReference = regexp.MustCompile(`(?i)["'` + "`" + `]((?:https?:)?/[^"'` + "`" + `\s]*/(?:graphql|gql))/?["'` + "`" + `]`)
+
+// introspectionQuery asks for everything needed to rebuild the schema: the root types, and the fields, arguments and values of every type
+const introspectionQuery = `query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types { ...FullType }
+    directives { name description locations args { ...InputValue } }
+  }
+}
+fragment FullType on __Type {
+  kind name description
+  fields(includeDeprecated: true) { name description args { ...InputValue } type { ...TypeRef } isDeprecated deprecationReason }
+  inputFields { ...InputValue }
+  interfaces { ...TypeRef }
+  enumValues(includeDeprecated: true) { name description isDeprecated deprecationReason }
+  possibleTypes { ...TypeRef }
+}
+fragment InputValue on __InputValue { name description type { ...TypeRef } defaultValue }
+fragment TypeRef on __Type { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name ofType { kind name } } } } } } }`
+
+// GraphQLEndpoint is a GraphQL endpoint found during the crawl, saved in graphql.json
+type GraphQLEndpoint struct {
+	URL  string `json:"url"`
+	Page string `json:"page"`
+	// Whether the endpoint answers introspection queries without credentials beyond the scan's headers
+	Introspection bool `json:"introspection"`
+	// Number of types in the schema
+	Types  int             `json:"types,omitempty"`
+	Schema json.RawMessage `json:"schema,omitempty"`
+}
+
+type graphQLEndpoints struct {
+	sync.Mutex
+	endpoints []GraphQLEndpoint
+}
+
+func (g *graphQLEndpoints) add(endpoint GraphQLEndpoint) {
+	g.Lock()
+	defer g.Unlock()
+	g.endpoints = append(g.endpoints, endpoint)
+}
+
+// restore adds endpoints introspected by an interrupted run
+func (g *graphQLEndpoints) restore(endpoints []GraphQLEndpoint) {
+	g.Lock()
+	defer g.Unlock()
+	g.endpoints = append(g.endpoints, endpoints...)
+}
+
+// list returns the endpoints sorted by URL
+func (g *graphQLEndpoints) list() []GraphQLEndpoint {
+	g.Lock()
+	defer g.Unlock()
+	list := append(make([]GraphQLEndpoint, 0, len(g.endpoints)), g.endpoints...)
+	sort.Slice(list, func(i, j int) bool { return list[i].URL < list[j].URL })
+	return list
+}
+
+// checkGraphQLEndpoint sends an introspection query to an in-scope GraphQL endpoint, the first time it's seen
+func (s *scan) checkGraphQLEndpoint(page, endpoint string) {
+	endpoint = strings.SplitN(endpoint, "?", 2)[0]
+	if endpoint == "" || !s.checkOrigin(endpoint, s.target) || !s.documents.first(endpoint) {
+		return
+	}
+	schema, ok := s.introspect(s.ctx, endpoint)
+	if !ok {
+		return
+	}
+
+	found := GraphQLEndpoint{URL: endpoint, Page: page}
+	if schema != nil {
+		var parsed struct {
+			Types []json.RawMessage `json:"types"`
+		}
+		json.Unmarshal(schema, &parsed)
+		found.Introspection, found.Types, found.Schema = true, len(parsed.Types), schema
+		s.report(Finding{Type: FindingGraphQL, Page: page, Resource: endpoint, Detail: "introspection is enabled"})
+	}
+	s.graphQL.add(found)
+}
+
+// introspect sends the introspection query to a URL
+// it returns false if the URL isn't a GraphQL endpoint, and a nil schema if it is one but introspection is disabled
+func (sc *Scanner) introspect(ctx context.Context, endpoint string) (json.RawMessage, bool) {
+	body, _ := json.Marshal(map[string]string{"query": introspectionQuery})
+	req, err := http.NewRequestWithContext(ctx, "POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, false
+	}
+	sc.addHeaders(req)
+	req.Header.Set("Content-Type", "application/json")
+
+	res, err := sc.verifyClient.Do(req)
+	if err != nil {
+		return nil, false
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(res.Body, 20<<20))
+	if err != nil {
+		return nil, false
+	}
+
+	// A GraphQL server answers with data, errors, or both, even when it rejects the query
+	var response struct {
+		Data *struct {
+			Schema json.RawMessage `json:"__schema"`
+		} `json:"data"`
+		Errors []json.RawMessage `json:"errors"`
+	}
+	if err := json.Unmarshal(data, &response); err != nil || (response.Data == nil && response.Errors == nil) {
+		return nil, false
+	}
+	if response.Data == nil || len(response.Data.Schema) == 0 || string(response.Data.Schema) == "null" {
+		return nil, true
+	}
+	return response.Data.Schema, true
+}
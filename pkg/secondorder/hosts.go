@@ -0,0 +1,74 @@
+package secondorder
+
+import (
+	"net/url"
+	"sort"
+)
+
+// HostSummary is what was found on one host of the target, saved in hosts.json
+// large scopes span many subdomains, and are easier to triage host by host
+type HostSummary struct {
+	Host  string `json:"host"`
+	Pages int    `json:"pages"`
+	// Number of crawled pages with each status code
+	Statuses map[int]int `json:"statuses"`
+	// Third-party hosts the pages of the host load resources from
+	ExternalHosts []string `json:"external_hosts"`
+	// Number of findings on the pages of the host, by type
+	Findings map[string]int `json:"findings"`
+}
+
+// summarizeHosts groups the pages, third parties and findings of a scan by the host of their page
+func (s *scan) summarizeHosts(pages []*Page, findings []Finding) []HostSummary {
+	type group struct {
+		summary  HostSummary
+		external map[string]bool
+	}
+	groups := make(map[string]*group)
+	groupOf := func(page string) *group {
+		u, err := url.Parse(page)
+		if err != nil || u.Hostname() == "" {
+			return nil
+		}
+		g, ok := groups[u.Hostname()]
+		if !ok {
+			g = &group{
+				summary:  HostSummary{Host: u.Hostname(), Statuses: make(map[int]int), Findings: make(map[string]int)},
+				external: make(map[string]bool),
+			}
+			groups[u.Hostname()] = g
+		}
+		return g
+	}
+
+	for _, page := range pages {
+		// Endpoints of API specs weren't crawled
+		if page.Source != "" {
+			continue
+		}
+		if g := groupOf(page.URL); g != nil {
+			g.summary.Pages++
+			g.summary.Statuses[page.StatusCode]++
+		}
+	}
+	for page, hosts := range s.thirdParties.byPage() {
+		if g := groupOf(page); g != nil {
+			for _, host := range hosts {
+				g.external[host] = true
+			}
+		}
+	}
+	for _, f := range findings {
+		if g := groupOf(f.Page); g != nil {
+			g.summary.Findings[f.Type]++
+		}
+	}
+
+	summaries := make([]HostSummary, 0, len(groups))
+	for _, g := range groups {
+		g.summary.ExternalHosts = sortedKeys(g.external)
+		summaries = append(summaries, g.summary)
+	}
+	sort.Slice(summaries, func(i, j int) bool { return summaries[i].Host < summaries[j].Host })
+	return summaries
+}
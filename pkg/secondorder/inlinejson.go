@@ -0,0 +1,107 @@
+package secondorder
+
+import (
+	"encoding/json"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// inlineJSONScripts are the script tags that carry data instead of code
+const inlineJSONScripts = `script[type="application/json"], script[type="application/ld+json"]`
+
+// inlineStateAssignment matches the state server-rendered single page apps assign to window, like window.__INITIAL_STATE__ = {...}
+// the value is either a JSON literal or a JSON.parse() of a string
+var inlineStateAssignment = regexp.MustCompile(`window(?:\.|\[["'])(__[A-Za-z0-9_]+__)(?:["']\])?\s*=\s*(JSON\.parse\(\s*)?`)
+
+// logInlineJSON runs the URLs of a JSON blob through resource logging and dangling checks
+func (s *scan) logInlineJSON(page string, refs map[string][]string) {
+	// Protocol-relative URLs get the scheme of the page
+	for _, values := range refs {
+		for i, value := range values {
+			values[i] = resolveReference(page, value)
+		}
+	}
+	s.logDocumentReferences(page, refs)
+}
+
+// inlineJSONReferences returns the URLs in a JSON blob, keyed by the path of the field they were found in
+func inlineJSONReferences(name string, blob []byte) map[string][]string {
+	var value interface{}
+	if err := json.Unmarshal(blob, &value); err != nil {
+		return nil
+	}
+	found := make(map[string][]string)
+	walkJSONURLs(value, name, found)
+	return found
+}
+
+// inlineStateReferences returns the URLs in the state a script assigns to window
+func inlineStateReferences(script string) map[string][]string {
+	found := make(map[string][]string)
+	for _, match := range inlineStateAssignment.FindAllStringSubmatchIndex(script, -1) {
+		name := script[match[2]:match[3]]
+		rest := strings.NewReader(script[match[1]:])
+		var value interface{}
+		if match[4] != -1 {
+			// JSON.parse("...") holds the state in a double-quoted string, which is JSON as well
+			var encoded string
+			if err := json.NewDecoder(rest).Decode(&encoded); err != nil {
+				continue
+			}
+			if err := json.Unmarshal([]byte(encoded), &value); err != nil {
+				continue
+			}
+		} else if err := json.NewDecoder(rest).Decode(&value); err != nil {
+			// Object literals that aren't valid JSON (unquoted keys, functions...) are skipped
+			continue
+		}
+		walkJSONURLs(value, "window."+name, found)
+	}
+	return found
+}
+
+// walkJSONURLs adds the absolute URLs in a JSON value to found, under the path of their field
+// array indexes are left out of the path so every element of a list is logged under the same key
+func walkJSONURLs(value interface{}, path string, found map[string][]string) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			walkJSONURLs(v[key], path+"."+key, found)
+		}
+	case []interface{}:
+		for _, element := range v {
+			walkJSONURLs(element, path+"[]", found)
+		}
+	case string:
+		if isInlineJSONURL(v) {
+			key := "inline-json[" + path + "]"
+			if !contains(found[key], v) {
+				found[key] = append(found[key], v)
+			}
+		}
+	}
+}
+
+// isInlineJSONURL reports whether a string of a JSON blob is an absolute web URL, relative paths are too ambiguous to check
+func isInlineJSONURL(s string) bool {
+	lower := strings.ToLower(s)
+	if strings.HasPrefix(lower, "//") {
+		return isValidURL("http:" + s)
+	}
+	return (strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")) && isValidURL(s)
+}
+
+// inlineJSONName names a data script after its id, or its position on the page if it has none
+func inlineJSONName(id string, index int) string {
+	if id != "" {
+		return "#" + id
+	}
+	return "script" + strconv.Itoa(index)
+}
@@ -0,0 +1,233 @@
+package secondorder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// An inline script is reported when it differs from the version of the other pages that have it
+// and at least this many pages, and more than half of them, have that version
+const minInlineScriptPages = 2
+
+// How much of each side of a change the detail of a finding shows
+const inlineDiffContext = 120
+
+var (
+	// Literals are left out of the shape of a script, so the versions of a script that only differ in their values have the same shape
+	scriptStringLiteral = regexp.MustCompile("\"(?:[^\"\\\\\\n]|\\\\.)*\"|'(?:[^'\\\\\\n]|\\\\.)*'|`(?:[^`\\\\]|\\\\.)*`")
+	scriptNumberLiteral = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+	scriptWhitespace    = regexp.MustCompile(`\s+`)
+)
+
+// InlineScript is an inline script of a crawled page, saved in inline-scripts.json
+type InlineScript struct {
+	Page   string `json:"page"`
+	SHA256 string `json:"sha256"`
+	// Hash of the script without its string and number literals, the versions of a script that only differ in their values share it
+	Shape   string `json:"shape"`
+	Size    int    `json:"size"`
+	Content string `json:"content"`
+}
+
+type inlineScripts struct {
+	sync.Mutex
+	scripts []InlineScript
+	// Scripts already recorded, keyed by page and hash
+	seen map[string]bool
+	// Scripts of the baseline run, keyed by page and then by shape
+	baseline map[string]map[string]InlineScript
+}
+
+func newInlineScripts(baseline []InlineScript) *inlineScripts {
+	scripts := &inlineScripts{seen: make(map[string]bool), baseline: make(map[string]map[string]InlineScript)}
+	for _, script := range baseline {
+		if scripts.baseline[script.Page] == nil {
+			scripts.baseline[script.Page] = make(map[string]InlineScript)
+		}
+		scripts.baseline[script.Page][script.Shape] = script
+	}
+	return scripts
+}
+
+// isJavaScript reports whether a script element without src holds code rather than data like JSON
+func isJavaScript(scriptType string) bool {
+	switch strings.ToLower(strings.TrimSpace(scriptType)) {
+	case "", "text/javascript", "application/javascript", "module":
+		return true
+	}
+	return false
+}
+
+func newInlineScript(page, content string) InlineScript {
+	sum := sha256.Sum256([]byte(content))
+	skeleton := scriptStringLiteral.ReplaceAllString(content, `""`)
+	skeleton = scriptNumberLiteral.ReplaceAllString(skeleton, "0")
+	skeleton = scriptWhitespace.ReplaceAllString(strings.TrimSpace(skeleton), " ")
+	shape := sha256.Sum256([]byte(skeleton))
+	return InlineScript{
+		Page:    page,
+		SHA256:  hex.EncodeToString(sum[:]),
+		Shape:   hex.EncodeToString(shape[:]),
+		Size:    len(content),
+		Content: content,
+	}
+}
+
+// addInlineScript records an inline script of a page, and reports it if it changed since the baseline run
+// a page of the baseline that has a script the baseline didn't have is reported as well
+func (s *scan) addInlineScript(page, content string) {
+	if strings.TrimSpace(content) == "" {
+		return
+	}
+	script := newInlineScript(page, content)
+	st := s.inlineScripts
+	st.Lock()
+	key := page + " " + script.SHA256
+	if st.seen[key] {
+		st.Unlock()
+		return
+	}
+	st.seen[key] = true
+	st.scripts = append(st.scripts, script)
+	before, pageInBaseline := st.baseline[page]
+	previous, ok := before[script.Shape]
+	st.Unlock()
+
+	switch {
+	case ok && previous.SHA256 != script.SHA256:
+		s.report(Finding{Type: FindingInlineScriptChange, Page: page, Resource: "inline:" + script.SHA256,
+			Detail: "changed since the baseline: " + diffSnippet(previous.Content, script.Content)})
+	case !ok && pageInBaseline:
+		s.report(Finding{Type: FindingInlineScriptChange, Page: page, Resource: "inline:" + script.SHA256,
+			Detail: "added since the baseline: " + truncate(script.Content, 2*inlineDiffContext)})
+	}
+}
+
+// diffInlineScripts reports the pages whose version of a script differs from the one most pages with the script have
+// scripts that are different on every page, like the ones with a nonce or the data of the page, aren't reported
+func (s *scan) diffInlineScripts() {
+	st := s.inlineScripts
+	st.Lock()
+	byShape := make(map[string][]InlineScript)
+	for _, script := range st.scripts {
+		byShape[script.Shape] = append(byShape[script.Shape], script)
+	}
+	st.Unlock()
+
+	shapes := make([]string, 0, len(byShape))
+	for shape := range byShape {
+		shapes = append(shapes, shape)
+	}
+	sort.Strings(shapes)
+	for _, shape := range shapes {
+		versions := byShape[shape]
+		count := make(map[string]int)
+		for _, script := range versions {
+			count[script.SHA256]++
+		}
+		if len(count) < 2 {
+			continue
+		}
+		var common InlineScript
+		for _, script := range versions {
+			if count[script.SHA256] > count[common.SHA256] {
+				common = script
+			}
+		}
+		if count[common.SHA256] < minInlineScriptPages || 2*count[common.SHA256] <= len(versions) {
+			continue
+		}
+		for _, script := range versions {
+			if script.SHA256 != common.SHA256 {
+				s.report(Finding{Type: FindingInlineScriptChange, Page: script.Page, Resource: "inline:" + script.SHA256,
+					Detail: fmt.Sprintf("differs from the version of %d other pages: %s", count[common.SHA256], diffSnippet(common.Content, script.Content))})
+			}
+		}
+	}
+}
+
+func (st *inlineScripts) list() []InlineScript {
+	st.Lock()
+	defer st.Unlock()
+	list := append([]InlineScript{}, st.scripts...)
+	sort.SliceStable(list, func(i, j int) bool { return list[i].Page < list[j].Page })
+	return list
+}
+
+// restore adds the inline scripts saved by an interrupted run
+func (st *inlineScripts) restore(scripts []InlineScript) {
+	st.Lock()
+	defer st.Unlock()
+	for _, script := range scripts {
+		if key := script.Page + " " + script.SHA256; !st.seen[key] {
+			st.seen[key] = true
+			st.scripts = append(st.scripts, script)
+		}
+	}
+}
+
+// diffSnippet shows what changed between two versions of a script: the part between their common prefix and suffix
+func diffSnippet(old, new string) string {
+	prefix := 0
+	for prefix < len(old) && prefix < len(new) && old[prefix] == new[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(old)-prefix && suffix < len(new)-prefix && old[len(old)-1-suffix] == new[len(new)-1-suffix] {
+		suffix++
+	}
+	return fmt.Sprintf("-%q +%q", truncate(old[prefix:len(old)-suffix], inlineDiffContext), truncate(new[prefix:len(new)-suffix], inlineDiffContext))
+}
+
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n] + "..."
+}
+
+// LoadInlineScripts reads the inline scripts saved by a previous run, from its inline-scripts.json
+// or from every inline-scripts.json under its output directory
+func LoadInlineScripts(path string) ([]InlineScript, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the inline script baseline: %v", err)
+	}
+	files := []string{path}
+	if info.IsDir() {
+		files = nil
+		err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && strings.HasPrefix(info.Name(), "inline-scripts.json") {
+				files = append(files, p)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var scripts []InlineScript
+	for _, file := range files {
+		f, err := OpenResultFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var content map[string][]InlineScript
+		err = json.NewDecoder(f).Decode(&content)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not decode the inline scripts of %s: %v", file, err)
+		}
+		scripts = append(scripts, content["InlineScripts"]...)
+	}
+	return scripts, nil
+}
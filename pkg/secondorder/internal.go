@@ -0,0 +1,109 @@
+package secondorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// errInternalAddress is returned for the verification requests to hosts on internal addresses, unless AllowInternal is set
+// a page can reference any URL, so it would otherwise make the scanner probe the network it runs in
+// the URLs that aren't checked because of it aren't reported
+var errInternalAddress = errors.New("refusing to request an internal address")
+
+// Cloud metadata services that aren't on a link-local address
+var metadataIPs = []net.IP{
+	net.ParseIP("100.100.100.200"), // Alibaba Cloud
+	net.ParseIP("fd00:ec2::254"),   // AWS over IPv6
+}
+
+// isInternalIP reports whether an IP address is private (RFC 1918 and IPv6 unique local), loopback, link-local
+// (including the 169.254.169.254 metadata service), unspecified or a metadata service
+func isInternalIP(ip net.IP) bool {
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, metadata := range metadataIPs {
+		if ip.Equal(metadata) {
+			return true
+		}
+	}
+	return false
+}
+
+// unsent reports whether a verification request was refused before it was sent, the URL is neither dead nor alive
+func unsent(err error) bool {
+	return errors.Is(err, errBudgetSpent) || errors.Is(err, errInternalAddress)
+}
+
+// internalGuard refuses the requests to hosts that resolve to internal addresses, apart from the hosts of the targets
+// redirects go through it as well, so a URL can't redirect the scanner to an internal address either
+type internalGuard struct {
+	transport http.RoundTripper
+	resolver  *cachingResolver
+
+	mu      sync.Mutex
+	targets map[string]bool
+}
+
+func newInternalGuard(transport http.RoundTripper, resolver *cachingResolver) *internalGuard {
+	return &internalGuard{transport: transport, resolver: resolver, targets: make(map[string]bool)}
+}
+
+// addTarget exempts the host of a target, the scan was pointed at it on purpose
+func (g *internalGuard) addTarget(host string) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.targets[strings.ToLower(host)] = true
+}
+
+func (g *internalGuard) isTarget(host string) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.targets[host]
+}
+
+// internalIP returns the internal address a host resolves to, nil if it has none
+// a host that doesn't resolve isn't refused, the request fails with the DNS error the checks look for
+func (g *internalGuard) internalIP(ctx context.Context, host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		if isInternalIP(ip) {
+			return ip
+		}
+		return nil
+	}
+	// The transports dial through the same cache, so the request goes to the addresses checked here
+	addrs, err := g.resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil
+	}
+	for _, addr := range addrs {
+		if ip := net.ParseIP(addr); ip != nil && isInternalIP(ip) {
+			return ip
+		}
+	}
+	return nil
+}
+
+func (g *internalGuard) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := strings.ToLower(req.URL.Hostname())
+	if !g.isTarget(host) {
+		if ip := g.internalIP(req.Context(), host); ip != nil {
+			if req.Body != nil {
+				req.Body.Close()
+			}
+			if host == ip.String() {
+				return nil, fmt.Errorf("%w: %s", errInternalAddress, host)
+			}
+			return nil, fmt.Errorf("%w: %s resolves to %s", errInternalAddress, host, ip)
+		}
+	}
+	return g.transport.RoundTrip(req)
+}
@@ -0,0 +1,146 @@
+package secondorder
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// roundTripFunc is a transport that answers requests with a function
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// respond returns a response with a status code to a request
+func respond(req *http.Request, status int) *http.Response {
+	return &http.Response{StatusCode: status, Header: make(http.Header), Body: ioutil.NopCloser(strings.NewReader("")), Request: req}
+}
+
+// testResolver returns a resolver that answers the lookups of hosts from its cache, a host without addresses doesn't exist
+func testResolver(t *testing.T, hosts map[string][]string) *cachingResolver {
+	t.Helper()
+	r, err := newCachingResolver(1, "")
+	if err != nil {
+		t.Fatalf("newCachingResolver: %v", err)
+	}
+	cacheLookups(r, hosts)
+	return r
+}
+
+// cacheLookups puts the addresses of hosts in the cache of a resolver, a host without addresses doesn't exist
+// the hosts have no CNAME chain
+func cacheLookups(r *cachingResolver, hosts map[string][]string) {
+	r.Lock()
+	defer r.Unlock()
+	for host, addrs := range hosts {
+		entry := &dnsEntry{done: make(chan struct{}), values: addrs, expires: time.Now().Add(time.Hour)}
+		chain := &dnsEntry{done: entry.done, expires: entry.expires}
+		if len(addrs) == 0 {
+			entry.values, entry.err = nil, &net.DNSError{Err: "no such host", Name: host, IsNotFound: true}
+			chain.err = entry.err
+		}
+		close(entry.done)
+		r.hosts[host], r.chains[host] = entry, chain
+	}
+}
+
+func TestIsInternalIP(t *testing.T) {
+	tests := []struct {
+		ip       string
+		internal bool
+	}{
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"172.32.0.1", false},
+		{"192.168.1.1", true},
+		{"127.0.0.1", true},
+		{"169.254.169.254", true},
+		{"0.0.0.0", true},
+		{"100.100.100.200", true},
+		{"::1", true},
+		{"fd00::1", true},
+		{"fe80::1", true},
+		{"fd00:ec2::254", true},
+		{"93.184.216.34", false},
+		{"2606:2800:220:1::", false},
+	}
+	for _, tt := range tests {
+		if got := isInternalIP(net.ParseIP(tt.ip)); got != tt.internal {
+			t.Errorf("isInternalIP(%s) = %v, want %v", tt.ip, got, tt.internal)
+		}
+	}
+}
+
+func TestInternalGuard(t *testing.T) {
+	resolver := testResolver(t, map[string][]string{
+		"public.example.com":   {"93.184.216.34"},
+		"intranet.example.com": {"93.184.216.34", "10.0.0.5"},
+		"app.example.com":      {"10.0.0.8"},
+		"gone.example.com":     nil,
+	})
+	var sent []string
+	guard := newInternalGuard(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent = append(sent, req.URL.String())
+		return respond(req, http.StatusOK), nil
+	}), resolver)
+	guard.addTarget("APP.example.com")
+
+	tests := []struct {
+		url     string
+		refused bool
+	}{
+		{"https://public.example.com/app.js", false},
+		{"http://127.0.0.1:9/gone.js", true},
+		{"http://[::1]/", true},
+		{"http://169.254.169.254/latest/meta-data/", true},
+		{"http://10.0.0.1/", true},
+		// One internal address is enough
+		{"https://intranet.example.com/", true},
+		// The target was scanned on purpose, wherever it is
+		{"https://app.example.com/", false},
+		// The request fails with the DNS error the checks look for
+		{"https://gone.example.com/", false},
+	}
+	for _, tt := range tests {
+		sent = nil
+		req, _ := http.NewRequest(http.MethodGet, tt.url, nil)
+		_, err := guard.RoundTrip(req)
+		if refused := errors.Is(err, errInternalAddress); refused != tt.refused {
+			t.Errorf("%s: refused = %v, want %v (error %v)", tt.url, refused, tt.refused, err)
+		}
+		if tt.refused && (len(sent) > 0 || !unsent(err)) {
+			t.Errorf("%s was sent", tt.url)
+		}
+	}
+}
+
+func TestInternalGuardRedirects(t *testing.T) {
+	// The server is on a loopback address, so it's the target of the scan
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://internal.example.com/", http.StatusFound)
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+
+	guard := newInternalGuard(http.DefaultTransport, testResolver(t, map[string][]string{"internal.example.com": {"192.168.0.10"}}))
+	guard.addTarget(u.Hostname())
+	client := &http.Client{Transport: guard}
+	req, _ := http.NewRequestWithContext(context.Background(), http.MethodGet, server.URL, nil)
+	res, err := client.Do(req)
+	if err == nil {
+		res.Body.Close()
+		t.Fatal("the redirect to an internal address was followed")
+	}
+	if !unsent(err) {
+		t.Errorf("error = %v, want the internal address error", err)
+	}
+}
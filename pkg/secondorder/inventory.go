@@ -0,0 +1,133 @@
+package secondorder
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Page is an entry of the page inventory, one for every crawled page
+type Page struct {
+	URL           string   `json:"url"`
+	StatusCode    int      `json:"status"`
+	Title         string   `json:"title,omitempty"`
+	Server        string   `json:"server,omitempty"`
+	PoweredBy     string   `json:"x_powered_by,omitempty"`
+	ContentLength int      `json:"content_length"`
+	Technologies  []string `json:"technologies,omitempty"`
+	// Spec an endpoint was found in, for API endpoints that weren't crawled
+	Source string `json:"source,omitempty"`
+}
+
+type inventory struct {
+	sync.Mutex
+	pages map[string]*Page
+}
+
+func newInventory() *inventory {
+	return &inventory{pages: make(map[string]*Page)}
+}
+
+// addResponse records a crawled page from its response and returns a copy of it
+func (inv *inventory) addResponse(r *colly.Response) *Page {
+	if r == nil || r.Request == nil {
+		return nil
+	}
+	page := &Page{
+		URL:           r.Request.URL.String(),
+		StatusCode:    r.StatusCode,
+		Server:        r.Headers.Get("Server"),
+		PoweredBy:     r.Headers.Get("X-Powered-By"),
+		ContentLength: len(r.Body),
+		Technologies:  fingerprintResponse(r.Headers),
+	}
+	inv.Lock()
+	defer inv.Unlock()
+	inv.pages[page.URL] = page
+	copied := *page
+	return &copied
+}
+
+// addPage records a crawled page in the inventory and publishes it to the event sinks
+func (s *scan) addPage(r *colly.Response) {
+	if page := s.pages.addResponse(r); page != nil {
+		s.events.publish(Event{Type: EventPageCrawled, Target: s.target, Page: page})
+		if max := s.config.Options.MaxPages; max > 0 && atomic.AddInt64(&s.crawled, 1) >= int64(max) {
+			s.exhaust(StopMaxPages)
+		}
+	}
+}
+
+// addEndpoint records an API endpoint documented in a spec, unless it was already crawled
+func (inv *inventory) addEndpoint(u, source string) {
+	inv.Lock()
+	defer inv.Unlock()
+	if _, ok := inv.pages[u]; !ok {
+		inv.pages[u] = &Page{URL: u, Source: source}
+	}
+}
+
+// setTitle records the title of a page that was already added from its response
+func (inv *inventory) setTitle(u, title string) {
+	inv.Lock()
+	defer inv.Unlock()
+	if p, ok := inv.pages[u]; ok && p.Title == "" {
+		p.Title = strings.TrimSpace(title)
+	}
+}
+
+// addTechnologies records technologies recognized in the content of a page
+func (inv *inventory) addTechnologies(u string, names []string) {
+	inv.Lock()
+	defer inv.Unlock()
+	p, ok := inv.pages[u]
+	if !ok {
+		return
+	}
+	for _, name := range names {
+		if !contains(p.Technologies, name) {
+			p.Technologies = append(p.Technologies, name)
+		}
+	}
+}
+
+// list returns copies of the pages sorted by URL
+func (inv *inventory) count() int {
+	inv.Lock()
+	defer inv.Unlock()
+	return len(inv.pages)
+}
+
+func (inv *inventory) list() []*Page {
+	inv.Lock()
+	defer inv.Unlock()
+	pages := make([]*Page, 0, len(inv.pages))
+	for _, p := range inv.pages {
+		c := *p
+		c.Technologies = append([]string(nil), p.Technologies...)
+		pages = append(pages, &c)
+	}
+	sort.Slice(pages, func(i, j int) bool { return pages[i].URL < pages[j].URL })
+	return pages
+}
+
+// restore adds pages saved by an interrupted run
+func (inv *inventory) restore(pages []*Page) {
+	inv.Lock()
+	defer inv.Unlock()
+	for _, p := range pages {
+		inv.pages[p.URL] = p
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,132 @@
+package secondorder
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Kinds of endpoints found in scripts, from the most specific to the least
+const (
+	EndpointFetch = "fetch"
+	EndpointXHR   = "xhr"
+	EndpointRoute = "route"
+	EndpointURL   = "url"
+	EndpointPath  = "path"
+)
+
+// jsEndpointPatterns find endpoints by how scripts use them, the first group is the endpoint
+var jsEndpointPatterns = []struct {
+	kind    string
+	pattern *regexp.Regexp
+}{
+	{EndpointFetch, regexp.MustCompile(`\b(?:fetch|axios(?:\.(?:get|post|put|patch|delete|head|request))?|\$\.(?:ajax|get|post|getJSON))\(\s*["'` + "`" + `]([^"'` + "`" + `\s]+)["'` + "`" + `]`)},
+	{EndpointXHR, regexp.MustCompile(`(?i)\.open\(\s*["'](?:GET|POST|PUT|PATCH|DELETE|HEAD|OPTIONS)["']\s*,\s*["'` + "`" + `]([^"'` + "`" + `\s]+)["'` + "`" + `]`)},
+	{EndpointRoute, regexp.MustCompile(`\bpath\s*:\s*["'` + "`" + `](/[^"'` + "`" + `\s]*)["'` + "`" + `]`)},
+}
+
+// jsEndpointLiteral finds URL-like string literals, the way LinkFinder does:
+// absolute and protocol-relative URLs, absolute paths, and relative paths to files with a known extension
+var jsEndpointLiteral = regexp.MustCompile(`["'` + "`" + `]((?:https?:)?//[^"'` + "`" + `\s<>]+|/[A-Za-z0-9_\-.~/:@%]+(?:\?[^"'` + "`" + `\s<>]*)?|[A-Za-z0-9_\-.]+/[A-Za-z0-9_\-./]*\.(?:php|asp|aspx|jsp|json|action|html|js|txt|xml)(?:\?[^"'` + "`" + `\s<>]*)?)["'` + "`" + `]`)
+
+// JSEndpoint is an endpoint found in a script, saved in js-endpoints.json grouped by the script it was found in
+type JSEndpoint struct {
+	Endpoint string `json:"endpoint"`
+	// How the script uses it: fetch, xhr, route, or url and path for plain literals
+	Kind string `json:"kind"`
+}
+
+type jsEndpoints struct {
+	sync.Mutex
+	// Script -> endpoint -> kind
+	content map[string]map[string]string
+}
+
+func newJSEndpoints() *jsEndpoints {
+	return &jsEndpoints{content: make(map[string]map[string]string)}
+}
+
+func (j *jsEndpoints) add(script string, found map[string]string) {
+	if len(found) == 0 {
+		return
+	}
+	j.Lock()
+	defer j.Unlock()
+	if j.content[script] == nil {
+		j.content[script] = make(map[string]string)
+	}
+	for endpoint, kind := range found {
+		if _, ok := j.content[script][endpoint]; !ok {
+			j.content[script][endpoint] = kind
+		}
+	}
+}
+
+// restore adds endpoints found by an interrupted run
+func (j *jsEndpoints) restore(content map[string][]JSEndpoint) {
+	for script, endpoints := range content {
+		found := make(map[string]string, len(endpoints))
+		for _, endpoint := range endpoints {
+			found[endpoint.Endpoint] = endpoint.Kind
+		}
+		j.add(script, found)
+	}
+}
+
+// copy returns the endpoints of every script sorted by endpoint
+func (j *jsEndpoints) copy() map[string][]JSEndpoint {
+	j.Lock()
+	defer j.Unlock()
+	copied := make(map[string][]JSEndpoint, len(j.content))
+	for script, endpoints := range j.content {
+		list := make([]JSEndpoint, 0, len(endpoints))
+		for endpoint, kind := range endpoints {
+			list = append(list, JSEndpoint{Endpoint: endpoint, Kind: kind})
+		}
+		sort.Slice(list, func(a, b int) bool { return list[a].Endpoint < list[b].Endpoint })
+		copied[script] = list
+	}
+	return copied
+}
+
+// extractJSEndpoints returns the endpoints in a script and their kind
+// an endpoint found by a specific pattern isn't reported again as a plain literal
+func extractJSEndpoints(content []byte) map[string]string {
+	found := make(map[string]string)
+	for _, p := range jsEndpointPatterns {
+		for _, match := range p.pattern.FindAllSubmatch(content, -1) {
+			if endpoint := string(match[1]); isJSEndpoint(endpoint) {
+				if _, ok := found[endpoint]; !ok {
+					found[endpoint] = p.kind
+				}
+			}
+		}
+	}
+	for _, match := range jsEndpointLiteral.FindAllSubmatch(content, -1) {
+		endpoint := string(match[1])
+		if _, ok := found[endpoint]; ok || !isJSEndpoint(endpoint) {
+			continue
+		}
+		if strings.HasPrefix(endpoint, "//") || strings.Contains(endpoint, "://") {
+			found[endpoint] = EndpointURL
+		} else {
+			found[endpoint] = EndpointPath
+		}
+	}
+	return found
+}
+
+// isJSEndpoint leaves out what the patterns catch that can't be requested, like a bare slash or a comment
+func isJSEndpoint(endpoint string) bool {
+	switch {
+	case len(endpoint) < 2, endpoint == "//", strings.HasPrefix(endpoint, "/*"):
+		return false
+	}
+	return true
+}
+
+// logJSEndpoints extracts the endpoints of a script, inline scripts are grouped under "inline:" and the page they're in
+func (s *scan) logJSEndpoints(script string, content []byte) {
+	s.jsEndpoints.add(script, extractJSEndpoints(content))
+}
@@ -0,0 +1,35 @@
+package secondorder
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// jsonlSink writes every finding as a line of JSON as soon as it's found, so an interrupted scan still leaves its findings behind
+type jsonlSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	err     error
+}
+
+// NewJSONLSink returns a sink that writes findings to w, one JSON object per line
+// w isn't closed by the sink
+func NewJSONLSink(w io.Writer) FindingSink {
+	return &jsonlSink{encoder: json.NewEncoder(w)}
+}
+
+func (s *jsonlSink) Send(f Finding) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.encoder.Encode(f); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// Close reports the first write that failed, nothing is buffered
+func (s *jsonlSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
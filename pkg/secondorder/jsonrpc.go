@@ -0,0 +1,272 @@
+package secondorder
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// JSON-RPC error codes
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcScanError      = -32000
+)
+
+// JSONRPCServer lets other programs drive a Scanner as a subprocess, with JSON-RPC 2.0 messages, one per line
+// the methods are:
+//   - scan {"target": URL}: crawls a target, the response is its Result once the crawl is done
+//   - cancel {"target": URL}: stops the crawl of a target, its scan request is answered with what was found until then
+//   - status: the targets being crawled
+//   - results: the results of every target crawled so far
+//   - shutdown: cancels the running crawls and stops reading requests
+//
+// every event of the scans is sent as an "event" notification as it happens
+type JSONRPCServer struct {
+	scanner *Scanner
+
+	mu      sync.Mutex
+	encoder *json.Encoder
+	running map[string]context.CancelFunc
+	// Closed when the last event of a target was sent, so its events always come before the response
+	finished map[string]chan struct{}
+	wg       sync.WaitGroup
+}
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcNotification struct {
+	JSONRPC string      `json:"jsonrpc"`
+	Method  string      `json:"method"`
+	Params  interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type rpcTargetParams struct {
+	Target string `json:"target"`
+}
+
+// NewJSONRPCServer returns a server that writes responses and notifications to w
+// it registers itself as an event sink of the scanner, so it must be created before the first Run
+func NewJSONRPCServer(sc *Scanner, w io.Writer) *JSONRPCServer {
+	s := &JSONRPCServer{
+		scanner:  sc,
+		encoder:  json.NewEncoder(w),
+		running:  make(map[string]context.CancelFunc),
+		finished: make(map[string]chan struct{}),
+	}
+	sc.AddEventSink(s)
+	return s
+}
+
+// Serve answers the requests read from r until it ends, a shutdown request is received or ctx is cancelled
+// and waits for the running crawls to be answered before returning
+func (s *JSONRPCServer) Serve(ctx context.Context, r io.Reader) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	lines := make(chan []byte)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+		for scanner.Scan() {
+			line := append([]byte(nil), scanner.Bytes()...)
+			select {
+			case lines <- line:
+			case <-ctx.Done():
+				return
+			}
+		}
+		readErr <- scanner.Err()
+	}()
+
+	var err error
+loop:
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				err = <-readErr
+				break loop
+			}
+			if len(line) == 0 {
+				continue
+			}
+			if s.handle(ctx, line) {
+				break loop
+			}
+		case <-ctx.Done():
+			break loop
+		}
+	}
+	if ctx.Err() != nil {
+		s.cancelAll()
+	}
+	s.wg.Wait()
+	return err
+}
+
+// handle answers a request, and reports whether it was a shutdown
+func (s *JSONRPCServer) handle(ctx context.Context, line []byte) bool {
+	var req rpcRequest
+	if err := json.Unmarshal(line, &req); err != nil {
+		s.reply(nil, nil, &rpcError{Code: rpcParseError, Message: err.Error()})
+		return false
+	}
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		s.reply(req.ID, nil, &rpcError{Code: rpcInvalidRequest, Message: "not a JSON-RPC 2.0 request"})
+		return false
+	}
+
+	switch req.Method {
+	case "scan":
+		var params rpcTargetParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || params.Target == "" {
+			s.reply(req.ID, nil, &rpcError{Code: rpcInvalidParams, Message: "scan needs a target"})
+			return false
+		}
+		s.scan(ctx, req.ID, params.Target)
+	case "cancel":
+		var params rpcTargetParams
+		if err := json.Unmarshal(req.Params, &params); err != nil || params.Target == "" {
+			s.reply(req.ID, nil, &rpcError{Code: rpcInvalidParams, Message: "cancel needs a target"})
+			return false
+		}
+		s.mu.Lock()
+		cancel, ok := s.running[params.Target]
+		s.mu.Unlock()
+		if ok {
+			cancel()
+		}
+		s.reply(req.ID, map[string]bool{"cancelled": ok}, nil)
+	case "status":
+		s.mu.Lock()
+		running := make([]string, 0, len(s.running))
+		for target := range s.running {
+			running = append(running, target)
+		}
+		s.mu.Unlock()
+		sort.Strings(running)
+		s.reply(req.ID, map[string][]string{"running": running}, nil)
+	case "results":
+		s.reply(req.ID, s.scanner.Results(), nil)
+	case "shutdown":
+		s.cancelAll()
+		s.wg.Wait()
+		s.reply(req.ID, map[string]bool{"shutdown": true}, nil)
+		return true
+	default:
+		s.reply(req.ID, nil, &rpcError{Code: rpcMethodNotFound, Message: fmt.Sprintf("unknown method %q", req.Method)})
+	}
+	return false
+}
+
+// scan crawls a target in the background, and answers the request when it's done
+func (s *JSONRPCServer) scan(ctx context.Context, id json.RawMessage, target string) {
+	s.mu.Lock()
+	if _, ok := s.running[target]; ok {
+		s.mu.Unlock()
+		s.reply(id, nil, &rpcError{Code: rpcInvalidParams, Message: fmt.Sprintf("%s is already being crawled", target)})
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s.running[target] = cancel
+	finished := make(chan struct{})
+	s.finished[target] = finished
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		result, err := s.scanner.Run(ctx, target)
+		cancel()
+		if err != nil {
+			s.mu.Lock()
+			delete(s.running, target)
+			delete(s.finished, target)
+			s.mu.Unlock()
+			s.reply(id, nil, &rpcError{Code: rpcScanError, Message: err.Error()})
+			return
+		}
+		<-finished
+		s.mu.Lock()
+		delete(s.running, target)
+		s.mu.Unlock()
+		s.reply(id, result, nil)
+	}()
+}
+
+func (s *JSONRPCServer) cancelAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, cancel := range s.running {
+		cancel()
+	}
+}
+
+// reply answers a request, notifications (requests without an id) aren't answered unless they're invalid
+func (s *JSONRPCServer) reply(id json.RawMessage, result interface{}, err *rpcError) {
+	if id == nil && err == nil {
+		return
+	}
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	res := rpcResponse{JSONRPC: "2.0", ID: id, Error: err}
+	if err == nil {
+		content, marshalErr := json.Marshal(result)
+		if marshalErr != nil {
+			res.Error = &rpcError{Code: rpcScanError, Message: marshalErr.Error()}
+		}
+		res.Result = content
+	}
+	s.write(res)
+}
+
+func (s *JSONRPCServer) write(v interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.encoder.Encode(v)
+}
+
+// Handle sends an event as a notification
+func (s *JSONRPCServer) Handle(e Event) {
+	s.write(rpcNotification{JSONRPC: "2.0", Method: "event", Params: e})
+	if e.Type == EventTargetFinished {
+		s.mu.Lock()
+		if finished, ok := s.finished[e.Target]; ok {
+			close(finished)
+			delete(s.finished, e.Target)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// Close has nothing to deliver, every message is written as soon as it's sent
+func (s *JSONRPCServer) Close() error {
+	return nil
+}
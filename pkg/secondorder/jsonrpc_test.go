@@ -0,0 +1,82 @@
+package secondorder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestJSONRPCServerFraming(t *testing.T) {
+	sc, err := NewScanner(Config{})
+	if err != nil {
+		t.Fatalf("NewScanner: %v", err)
+	}
+	defer sc.Close()
+	var out bytes.Buffer
+	server := NewJSONRPCServer(sc, &out)
+
+	tests := []struct {
+		name    string
+		request string
+		// Expected response, nil when the request isn't answered
+		id     string
+		code   int
+		result string
+	}{
+		{name: "invalid JSON", request: `{"jsonrpc": "2.0", "id": 1,`, id: "null", code: rpcParseError},
+		{name: "not JSON-RPC 2.0", request: `{"jsonrpc": "1.0", "id": 2, "method": "status"}`, id: "2", code: rpcInvalidRequest},
+		{name: "no method", request: `{"jsonrpc": "2.0", "id": 3}`, id: "3", code: rpcInvalidRequest},
+		{name: "unknown method", request: `{"jsonrpc": "2.0", "id": 4, "method": "crawl"}`, id: "4", code: rpcMethodNotFound},
+		{name: "scan without a target", request: `{"jsonrpc": "2.0", "id": 5, "method": "scan", "params": {}}`, id: "5", code: rpcInvalidParams},
+		{name: "cancel without params", request: `{"jsonrpc": "2.0", "id": 6, "method": "cancel"}`, id: "6", code: rpcInvalidParams},
+		{name: "cancel of a target that isn't crawled", request: `{"jsonrpc": "2.0", "id": "seven", "method": "cancel", "params": {"target": "https://example.com/"}}`, id: `"seven"`, result: `{"cancelled":false}`},
+		{name: "notification", request: `{"jsonrpc": "2.0", "method": "status"}`},
+		{name: "empty line", request: ``},
+		{name: "status", request: `{"jsonrpc": "2.0", "id": 8, "method": "status"}`, id: "8", result: `{"running":[]}`},
+		{name: "results", request: `{"jsonrpc": "2.0", "id": 9, "method": "results"}`, id: "9", result: `[]`},
+		{name: "shutdown", request: `{"jsonrpc": "2.0", "id": 10, "method": "shutdown"}`, id: "10", result: `{"shutdown":true}`},
+		// The requests after a shutdown aren't read
+		{name: "after the shutdown", request: `{"jsonrpc": "2.0", "id": 11, "method": "status"}`},
+	}
+	var requests []string
+	for _, tt := range tests {
+		requests = append(requests, tt.request)
+	}
+	if err := server.Serve(context.Background(), strings.NewReader(strings.Join(requests, "\n")+"\n")); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+
+	lines := bufio.NewScanner(&out)
+	for _, tt := range tests {
+		if tt.id == "" {
+			continue
+		}
+		if !lines.Scan() {
+			t.Fatalf("%s: no response", tt.name)
+		}
+		var res struct {
+			JSONRPC string          `json:"jsonrpc"`
+			ID      json.RawMessage `json:"id"`
+			Result  json.RawMessage `json:"result"`
+			Error   *rpcError       `json:"error"`
+		}
+		if err := json.Unmarshal(lines.Bytes(), &res); err != nil {
+			t.Fatalf("%s: response %q isn't JSON: %v", tt.name, lines.Text(), err)
+		}
+		if res.JSONRPC != "2.0" || string(res.ID) != tt.id {
+			t.Errorf("%s: response %s doesn't answer request %s", tt.name, lines.Text(), tt.id)
+		}
+		switch {
+		case tt.code != 0 && (res.Error == nil || res.Error.Code != tt.code):
+			t.Errorf("%s: response %s, want error %d", tt.name, lines.Text(), tt.code)
+		case tt.code == 0 && (res.Error != nil || string(res.Result) != tt.result):
+			t.Errorf("%s: response %s, want result %s", tt.name, lines.Text(), tt.result)
+		}
+	}
+	if lines.Scan() {
+		t.Errorf("unexpected message %s", lines.Text())
+	}
+}
@@ -0,0 +1,103 @@
+package secondorder
+
+import (
+	"sort"
+	"sync"
+)
+
+// DanglingLink is an external host the target links to that doesn't resolve
+// whoever registers it gets the visitors and the reputation of the target's links
+type DanglingLink struct {
+	Host   string   `json:"host"`
+	Status string   `json:"status"`
+	CNAMEs []string `json:"cnames,omitempty"`
+	// Links to the host, and the pages they were found on
+	Links []string `json:"links"`
+	Pages []string `json:"pages"`
+}
+
+type danglingLinks struct {
+	sync.Mutex
+	links map[string]*DanglingLink
+	// Hosts that were already resolved in this scan, dangling or not
+	checked map[string]bool
+	// Links and pages of the hosts being resolved, they're kept if the host is dangling
+	pending map[string]*DanglingLink
+}
+
+func newDanglingLinks() *danglingLinks {
+	return &danglingLinks{links: make(map[string]*DanglingLink), checked: make(map[string]bool), pending: make(map[string]*DanglingLink)}
+}
+
+// checkLink resolves the host of an external link and records it if it's dangling
+// a host that was already checked, or is being resolved, only gets the new link and page
+func (s *scan) checkLink(page, link string) {
+	host, ok := s.thirdPartyHost(link)
+	if !ok {
+		return
+	}
+
+	s.danglingLinks.Lock()
+	d, ok := s.danglingLinks.links[host]
+	if !ok {
+		d, ok = s.danglingLinks.pending[host]
+	}
+	if ok {
+		if !contains(d.Links, link) {
+			d.Links = append(d.Links, link)
+		}
+		if !contains(d.Pages, page) {
+			d.Pages = append(d.Pages, page)
+		}
+	}
+	checked := s.danglingLinks.checked[host]
+	if !checked {
+		s.danglingLinks.checked[host] = true
+		s.danglingLinks.pending[host] = &DanglingLink{Host: host, Links: []string{link}, Pages: []string{page}}
+	}
+	s.danglingLinks.Unlock()
+	if checked {
+		return
+	}
+
+	status, chain := s.resolveStatus(s.ctx, host)
+	s.danglingLinks.Lock()
+	d = s.danglingLinks.pending[host]
+	delete(s.danglingLinks.pending, host)
+	if status != "" {
+		d.Status, d.CNAMEs = status, chain
+		s.danglingLinks.links[host] = d
+	}
+	s.danglingLinks.Unlock()
+	if status != "" {
+		s.report(Finding{Type: FindingDanglingLink, Page: page, Resource: link, Detail: status})
+	}
+}
+
+// restore adds dangling links saved by an interrupted run, their hosts aren't resolved again
+func (d *danglingLinks) restore(links []*DanglingLink) {
+	d.Lock()
+	defer d.Unlock()
+	for _, link := range links {
+		d.links[link.Host] = link
+		d.checked[link.Host] = true
+	}
+}
+
+// list returns copies of the dangling links sorted by host
+func (d *danglingLinks) list() []*DanglingLink {
+	d.Lock()
+	defer d.Unlock()
+	list := make([]*DanglingLink, 0, len(d.links))
+	for _, link := range d.links {
+		c := *link
+		c.CNAMEs = append([]string(nil), link.CNAMEs...)
+		c.Links = append([]string(nil), link.Links...)
+		c.Pages = append([]string(nil), link.Pages...)
+		sort.Strings(c.Links)
+		sort.Strings(c.Pages)
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Host < list[j].Host })
+	return list
+}
@@ -0,0 +1,107 @@
+package secondorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogLevel is how verbose a Logger is, the messages below its level are dropped
+type LogLevel int
+
+// Log levels, from the most verbose
+const (
+	// Every page crawled and every request that failed
+	LogDebug LogLevel = iota
+	// What the run is doing: rate limiting, reloaded rules, stopped scans
+	LogInfo
+	// Problems the run works around
+	LogWarn
+	// Targets that couldn't be scanned and results that couldn't be saved
+	LogError
+)
+
+var logLevelNames = map[LogLevel]string{
+	LogDebug: "debug",
+	LogInfo:  "info",
+	LogWarn:  "warn",
+	LogError: "error",
+}
+
+// Prefixes of the messages in text format
+var logLevelPrefixes = map[LogLevel]string{
+	LogDebug: "[DBG]",
+	LogInfo:  "[INF]",
+	LogWarn:  "[WRN]",
+	LogError: "[ERR]",
+}
+
+// Logger writes the diagnostics of a run, away from the results and findings
+// as text for people, or as JSON lines for the programs that run second-order
+type Logger struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	level  LogLevel
+	json   bool
+	target string
+}
+
+// logEntry is a message in JSON format
+type logEntry struct {
+	Time    time.Time `json:"time"`
+	Level   string    `json:"level"`
+	Target  string    `json:"target,omitempty"`
+	Message string    `json:"msg"`
+}
+
+// NewLogger returns a logger that writes the messages of level and above to out, one per line
+// out isn't closed by the logger
+func NewLogger(out io.Writer, level LogLevel, jsonFormat bool) *Logger {
+	return &Logger{mu: &sync.Mutex{}, out: out, level: level, json: jsonFormat}
+}
+
+// logger is where the scans log to, stderr at info level until SetLogger is called
+var logger = NewLogger(os.Stderr, LogInfo, false)
+
+// SetLogger sets the logger of the scans, it should be called before they're started
+func SetLogger(l *Logger) {
+	logger = l
+}
+
+// WithTarget returns a logger that adds the target to its messages, writing to the same output
+func (l *Logger) WithTarget(target string) *Logger {
+	copied := *l
+	copied.target = target
+	return &copied
+}
+
+// Enabled reports whether the messages of a level are written
+func (l *Logger) Enabled(level LogLevel) bool {
+	return level >= l.level
+}
+
+func (l *Logger) Debugf(format string, v ...interface{}) { l.logf(LogDebug, format, v...) }
+func (l *Logger) Infof(format string, v ...interface{})  { l.logf(LogInfo, format, v...) }
+func (l *Logger) Warnf(format string, v ...interface{})  { l.logf(LogWarn, format, v...) }
+func (l *Logger) Errorf(format string, v ...interface{}) { l.logf(LogError, format, v...) }
+
+func (l *Logger) logf(level LogLevel, format string, v ...interface{}) {
+	if !l.Enabled(level) {
+		return
+	}
+	message := fmt.Sprintf(format, v...)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.json {
+		line, _ := json.Marshal(logEntry{Time: time.Now(), Level: logLevelNames[level], Target: l.target, Message: message})
+		l.out.Write(append(line, '\n'))
+		return
+	}
+	if l.target != "" {
+		message = l.target + ": " + message
+	}
+	fmt.Fprintf(l.out, "%s %s\n", logLevelPrefixes[level], message)
+}
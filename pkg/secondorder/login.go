@@ -0,0 +1,193 @@
+package secondorder
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Login is a request sent before crawling each target, so the crawl and the verification requests run with the session it opens
+type Login struct {
+	// URL of the login endpoint, absolute or relative to the target
+	URL string
+	// Method of the request (default POST)
+	Method string
+	// Fields of the form, sent URL encoded
+	Form map[string]string
+	// Raw body sent instead of Form, like a JSON document
+	Body string
+	// Content type of Body (default application/json)
+	ContentType string
+	// Headers sent with the login request only
+	Headers map[string]string
+	// Hidden field of the login page holding a CSRF token, the page is requested first and the field is sent with the form
+	CSRFField string
+	// The login succeeded if the final response, after redirects, has this status code
+	SuccessStatus int
+	// The login succeeded if the body of the final response matches this regular expression
+	SuccessPattern string
+	// The login succeeded if the target set this cookie
+	// without any success indicator, the login succeeded if the final response isn't an error
+	SuccessCookie string
+	// URLs matching this regular expression aren't crawled, so the crawl doesn't end the session
+	Logout string
+
+	successPattern *regexp.Regexp
+	logout         *regexp.Regexp
+}
+
+// compile validates the login step and compiles its regular expressions
+func (l *Login) compile() error {
+	var err error
+	if l.URL == "" {
+		return fmt.Errorf("login: URL is required")
+	}
+	if l.Body != "" && len(l.Form) > 0 {
+		return fmt.Errorf("login: Form and Body can't both be set")
+	}
+	if l.SuccessPattern != "" {
+		if l.successPattern, err = regexp.Compile(l.SuccessPattern); err != nil {
+			return fmt.Errorf("login: invalid success pattern %q: %v", l.SuccessPattern, err)
+		}
+	}
+	if l.Logout != "" {
+		if l.logout, err = regexp.Compile(l.Logout); err != nil {
+			return fmt.Errorf("login: invalid logout pattern %q: %v", l.Logout, err)
+		}
+	}
+	return nil
+}
+
+// login sends the login request of the configuration to the target through its transport
+// the cookies of the session are kept in the jar the crawl and the verification requests share
+func (s *scan) login(ctx context.Context, l *Login) error {
+	base, err := url.Parse(s.target)
+	if err != nil {
+		return err
+	}
+	endpoint, err := base.Parse(l.URL)
+	if err != nil {
+		return fmt.Errorf("login URL is invalid: %v", err)
+	}
+	client := &http.Client{Transport: s.transport, Jar: s.jar}
+
+	form := url.Values{}
+	for name, value := range l.Form {
+		form.Set(name, value)
+	}
+	if l.CSRFField != "" {
+		token, err := s.csrfToken(ctx, client, endpoint.String(), l.CSRFField)
+		if err != nil {
+			return fmt.Errorf("login to %s failed: %v", endpoint, err)
+		}
+		form.Set(l.CSRFField, token)
+	}
+
+	method := loginMethod(l)
+	body, contentType := form.Encode(), "application/x-www-form-urlencoded"
+	if l.Body != "" {
+		body, contentType = l.Body, l.ContentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+	}
+	var reader io.Reader
+	if method != http.MethodGet {
+		reader = strings.NewReader(body)
+	} else if len(form) > 0 {
+		endpoint.RawQuery = form.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint.String(), reader)
+	if err != nil {
+		return err
+	}
+	if reader != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+	s.addHeaders(req)
+	for name, value := range l.Headers {
+		req.Header.Set(name, value)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("login to %s failed: %v", endpoint, err)
+	}
+	defer res.Body.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(res.Body, 10*1024*1024))
+	if err != nil {
+		return fmt.Errorf("login to %s failed: %v", endpoint, err)
+	}
+
+	checked := false
+	if l.SuccessStatus != 0 {
+		checked = true
+		if res.StatusCode != l.SuccessStatus {
+			return fmt.Errorf("login to %s failed: got status %d instead of %d", endpoint, res.StatusCode, l.SuccessStatus)
+		}
+	}
+	if l.successPattern != nil {
+		checked = true
+		if !l.successPattern.Match(content) {
+			return fmt.Errorf("login to %s failed: the response doesn't match %q", endpoint, l.SuccessPattern)
+		}
+	}
+	if l.SuccessCookie != "" {
+		checked = true
+		if !hasCookie(s.jar.Cookies(base), l.SuccessCookie) {
+			return fmt.Errorf("login to %s failed: the %s cookie wasn't set", endpoint, l.SuccessCookie)
+		}
+	}
+	if !checked && res.StatusCode >= 400 {
+		return fmt.Errorf("login to %s failed: got status %d", endpoint, res.StatusCode)
+	}
+	return nil
+}
+
+// csrfToken requests the login page and returns the value of its CSRF field
+func (s *scan) csrfToken(ctx context.Context, client *http.Client, page, field string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, page, nil)
+	if err != nil {
+		return "", err
+	}
+	s.addHeaders(req)
+	res, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	doc, err := goquery.NewDocumentFromReader(io.LimitReader(res.Body, 10*1024*1024))
+	if err != nil {
+		return "", err
+	}
+	token, ok := doc.Find("input[name]").FilterFunction(func(_ int, input *goquery.Selection) bool {
+		return input.AttrOr("name", "") == field
+	}).First().Attr("value")
+	if !ok {
+		return "", fmt.Errorf("the login page has no %s field", field)
+	}
+	return token, nil
+}
+
+// loginMethod is the method of the login request, POST unless another one is set
+func loginMethod(l *Login) string {
+	if l.Method == "" {
+		return http.MethodPost
+	}
+	return strings.ToUpper(l.Method)
+}
+
+func hasCookie(cookies []*http.Cookie, name string) bool {
+	for _, cookie := range cookies {
+		if cookie.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,165 @@
+package secondorder
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+)
+
+// webManifest holds the URL-bearing fields of a PWA manifest
+type webManifest struct {
+	StartURL string `json:"start_url"`
+	Scope    string `json:"scope"`
+	Icons    []struct {
+		Src string `json:"src"`
+	} `json:"icons"`
+	Screenshots []struct {
+		Src string `json:"src"`
+	} `json:"screenshots"`
+	Shortcuts []struct {
+		URL string `json:"url"`
+	} `json:"shortcuts"`
+	RelatedApplications []struct {
+		URL string `json:"url"`
+	} `json:"related_applications"`
+}
+
+// fetchedOnce remembers the documents that were already fetched in a scan
+type fetchedOnce struct {
+	sync.Mutex
+	seen map[string]bool
+}
+
+func (f *fetchedOnce) first(u string) bool {
+	f.Lock()
+	defer f.Unlock()
+	if f.seen == nil {
+		f.seen = make(map[string]bool)
+	}
+	if f.seen[u] {
+		return false
+	}
+	f.seen[u] = true
+	return true
+}
+
+// logDocumentReferences runs URLs found in a manifest or browserconfig through resource logging and dangling checks
+func (s *scan) logDocumentReferences(page string, refs map[string][]string) {
+	for key, values := range refs {
+		for _, value := range values {
+			if s.isNoise(value) {
+				continue
+			}
+			s.loggedQueries.add(page, key, value)
+			if isValidURL(value) && s.isDangling(s.ctx, value) {
+				s.loggedNon200Queries.add(page, key, value)
+				s.report(Finding{Type: FindingNon200, Page: page, Resource: value, Detail: key, Response: s.response(value)})
+			}
+		}
+	}
+}
+
+// parseManifest fetches a PWA manifest and returns its URLs, keyed by the field they were found in
+func (sc *Scanner) parseManifest(ctx context.Context, manifestURL string) map[string][]string {
+	body, err := sc.fetchDocument(ctx, manifestURL)
+	if err != nil {
+		return nil
+	}
+	defer body.Close()
+
+	var m webManifest
+	if err := json.NewDecoder(body).Decode(&m); err != nil {
+		return nil
+	}
+
+	found := make(map[string][]string)
+	add := func(field, ref string) {
+		if ref != "" {
+			found["manifest["+field+"]"] = append(found["manifest["+field+"]"], resolveReference(manifestURL, ref))
+		}
+	}
+	add("start_url", m.StartURL)
+	add("scope", m.Scope)
+	for _, icon := range m.Icons {
+		add("icons", icon.Src)
+	}
+	for _, screenshot := range m.Screenshots {
+		add("screenshots", screenshot.Src)
+	}
+	for _, shortcut := range m.Shortcuts {
+		add("shortcuts", shortcut.URL)
+	}
+	for _, app := range m.RelatedApplications {
+		add("related_applications", app.URL)
+	}
+	return found
+}
+
+// parseBrowserconfig fetches a browserconfig.xml file and returns the URLs of its tiles, badges and notifications
+func (sc *Scanner) parseBrowserconfig(ctx context.Context, configURL string) map[string][]string {
+	body, err := sc.fetchDocument(ctx, configURL)
+	if err != nil {
+		return nil
+	}
+	defer body.Close()
+
+	found := make(map[string][]string)
+	decoder := xml.NewDecoder(body)
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			break
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		for _, attr := range element.Attr {
+			if attr.Name.Local == "src" && attr.Value != "" {
+				key := "browserconfig[" + element.Name.Local + "]"
+				found[key] = append(found[key], resolveReference(configURL, attr.Value))
+			}
+		}
+	}
+	return found
+}
+
+// fetchDocument requests a document referenced by a page, sending the user's headers
+func (sc *Scanner) fetchDocument(ctx context.Context, u string) (io.ReadCloser, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	sc.addHeaders(req)
+	res, err := sc.verifyClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK {
+		io.Copy(ioutil.Discard, res.Body)
+		res.Body.Close()
+		return nil, fmt.Errorf("%s returned status %d", u, res.StatusCode)
+	}
+	return res.Body, nil
+}
+
+// resolveReference resolves a possibly relative reference against the URL of the document it was found in
+func resolveReference(base, ref string) string {
+	ref = strings.TrimSpace(ref)
+	b, err := url.Parse(base)
+	if err != nil {
+		return ref
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return ref
+	}
+	return b.ResolveReference(r).String()
+}
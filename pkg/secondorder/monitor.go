@@ -0,0 +1,93 @@
+package secondorder
+
+// Changes of a finding between two runs of a target
+const (
+	ChangeNew     = "new"
+	ChangeChanged = "changed"
+	ChangeGone    = "gone"
+)
+
+// FindingChange is a finding that appeared, changed or went away since the previous run of its target
+type FindingChange struct {
+	Change  string  `json:"change"`
+	Finding Finding `json:"finding"`
+	// The finding as the previous run found it, for changed findings
+	Previous *Finding `json:"previous,omitempty"`
+}
+
+// findingKey identifies a finding across runs: what was found, where, and by which rule
+// the detail of a secret finding is part of it, it tells apart the secrets a rule matches in the same script
+func findingKey(f Finding) string {
+	key := f.Type + "\x00" + f.Target + "\x00" + f.Page + "\x00" + f.Resource + "\x00" + f.Rule
+	if f.Type == FindingSecret {
+		key += "\x00" + f.Detail
+	}
+	return key
+}
+
+// findingChanged reports whether two findings with the same key differ in what was found about them,
+// like a resource that went from 404 to NXDOMAIN or a takeover candidate whose confidence went up
+func findingChanged(before, after Finding) bool {
+	return before.Detail != after.Detail || before.Level() != after.Level() || before.Confidence != after.Confidence ||
+		responseStatus(before.Response) != responseStatus(after.Response)
+}
+
+// findingIndex keys findings by findingKey, the first one wins when several have the same key
+func findingIndex(findings []Finding) map[string]Finding {
+	index := make(map[string]Finding, len(findings))
+	for _, f := range findings {
+		if _, ok := index[findingKey(f)]; !ok {
+			index[findingKey(f)] = f
+		}
+	}
+	return index
+}
+
+// DiffFindings lists the findings of after that aren't in before or changed since, in the order they were found,
+// followed by the findings of before that aren't in after anymore
+func DiffFindings(before, after []Finding) []FindingChange {
+	changes := []FindingChange{}
+	previous := findingIndex(before)
+	seen := make(map[string]bool)
+	for _, f := range after {
+		key := findingKey(f)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		old, ok := previous[key]
+		if !ok {
+			changes = append(changes, FindingChange{Change: ChangeNew, Finding: f})
+		} else if findingChanged(old, f) {
+			changes = append(changes, FindingChange{Change: ChangeChanged, Finding: f, Previous: &old})
+		}
+	}
+	for _, f := range before {
+		key := findingKey(f)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		changes = append(changes, FindingChange{Change: ChangeGone, Finding: f})
+	}
+	return changes
+}
+
+// CountChanges counts the changes of each kind
+func CountChanges(changes []FindingChange) map[string]int {
+	counts := map[string]int{ChangeNew: 0, ChangeChanged: 0, ChangeGone: 0}
+	for _, c := range changes {
+		counts[c.Change]++
+	}
+	return counts
+}
+
+// reported reports whether the previous run of the target already found a finding as it is now
+// while monitoring, those findings aren't sent to the sinks again
+func (s *scan) reported(f Finding) bool {
+	if s.previous == nil {
+		return false
+	}
+	before, ok := s.previous[findingKey(f)]
+	return ok && !findingChanged(before, f)
+}
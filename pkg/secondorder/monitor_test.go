@@ -0,0 +1,89 @@
+package secondorder
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindingKey(t *testing.T) {
+	base := Finding{Type: FindingNon200, Target: "https://example.com/", Page: "https://example.com/", Resource: "https://cdn.example.com/app.js", Rule: "scripts"}
+	tests := []struct {
+		name  string
+		other func(f Finding) Finding
+		same  bool
+	}{
+		{"detail", func(f Finding) Finding { f.Detail = "404"; return f }, true},
+		{"severity", func(f Finding) Finding { f.Severity = SeverityHigh; return f }, true},
+		{"response", func(f Finding) Finding { f.Response = &Response{Status: 404}; return f }, true},
+		{"type", func(f Finding) Finding { f.Type = FindingDangling; return f }, false},
+		{"target", func(f Finding) Finding { f.Target = "https://example.org/"; return f }, false},
+		{"page", func(f Finding) Finding { f.Page = "https://example.com/about"; return f }, false},
+		{"resource", func(f Finding) Finding { f.Resource = "https://cdn.example.com/other.js"; return f }, false},
+		{"rule", func(f Finding) Finding { f.Rule = "styles"; return f }, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if same := findingKey(base) == findingKey(tt.other(base)); same != tt.same {
+				t.Errorf("same key = %v, want %v", same, tt.same)
+			}
+		})
+	}
+
+	t.Run("secrets are told apart by their detail", func(t *testing.T) {
+		secret := Finding{Type: FindingSecret, Target: "https://example.com/", Page: "https://example.com/", Resource: "https://example.com/app.js", Rule: "aws-access-key-id"}
+		a, b := secret, secret
+		a.Detail, b.Detail = "aws-access-key-id sha256 000000000000", "aws-access-key-id sha256 111111111111"
+		if findingKey(a) == findingKey(b) {
+			t.Error("two secrets of a rule in the same script have the same key")
+		}
+	})
+}
+
+func TestDiffFindings(t *testing.T) {
+	finding := func(resource, detail string) Finding {
+		return Finding{Type: FindingNon200, Target: "https://example.com/", Page: "https://example.com/", Resource: resource, Detail: detail}
+	}
+	kept := finding("https://a.example.net/a.js", "404")
+	changedBefore, changedAfter := finding("https://b.example.net/b.js", "404"), finding("https://b.example.net/b.js", "NXDOMAIN")
+	gone := finding("https://c.example.net/c.js", "404")
+	added := finding("https://d.example.net/d.js", "404")
+
+	tests := []struct {
+		name          string
+		before, after []Finding
+		want          []FindingChange
+	}{
+		{"nothing", nil, nil, []FindingChange{}},
+		{"same findings", []Finding{kept}, []Finding{kept}, []FindingChange{}},
+		{"first run", nil, []Finding{kept, added}, []FindingChange{{Change: ChangeNew, Finding: kept}, {Change: ChangeNew, Finding: added}}},
+		{"everything gone", []Finding{kept}, nil, []FindingChange{{Change: ChangeGone, Finding: kept}}},
+		{
+			"new, changed and gone",
+			[]Finding{kept, changedBefore, gone},
+			[]Finding{added, kept, changedAfter},
+			[]FindingChange{
+				{Change: ChangeNew, Finding: added},
+				{Change: ChangeChanged, Finding: changedAfter, Previous: &changedBefore},
+				{Change: ChangeGone, Finding: gone},
+			},
+		},
+		{
+			"a response that changed",
+			[]Finding{func() Finding { f := kept; f.Response = &Response{Status: 404}; return f }()},
+			[]Finding{func() Finding { f := kept; f.Response = &Response{Error: "NXDOMAIN"}; return f }()},
+			[]FindingChange{{
+				Change:   ChangeChanged,
+				Finding:  func() Finding { f := kept; f.Response = &Response{Error: "NXDOMAIN"}; return f }(),
+				Previous: func() *Finding { f := kept; f.Response = &Response{Status: 404}; return &f }(),
+			}},
+		},
+		{"duplicates are counted once", []Finding{gone, gone}, []Finding{added, added}, []FindingChange{{Change: ChangeNew, Finding: added}, {Change: ChangeGone, Finding: gone}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DiffFindings(tt.before, tt.after); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("DiffFindings() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,66 @@
+package secondorder
+
+import (
+	"net/url"
+	"strings"
+)
+
+// defaultNoiseHosts are ubiquitous, well-managed third parties, they and their subdomains are left out of the results with -filter-noise
+var defaultNoiseHosts = []string{
+	"googleapis.com",
+	"gstatic.com",
+	"google.com",
+	"google-analytics.com",
+	"googletagmanager.com",
+	"googlesyndication.com",
+	"googleadservices.com",
+	"doubleclick.net",
+	"youtube.com",
+	"ytimg.com",
+	"cloudflareinsights.com",
+	"cdnjs.cloudflare.com",
+	"facebook.net",
+	"facebook.com",
+	"twitter.com",
+	"twimg.com",
+	"linkedin.com",
+	"licdn.com",
+	"bing.com",
+	"jsdelivr.net",
+	"unpkg.com",
+	"jquery.com",
+	"bootstrapcdn.com",
+	"fontawesome.com",
+	"typekit.net",
+	"hotjar.com",
+	"recaptcha.net",
+	"hcaptcha.com",
+	"stripe.com",
+	"paypal.com",
+	"gravatar.com",
+	"wp.com",
+}
+
+// isNoiseHost reports whether a host is one of the noise hosts or a subdomain of one
+func isNoiseHost(host string, noise []string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, n := range noise {
+		n = strings.TrimPrefix(strings.ToLower(n), ".")
+		if host == n || strings.HasSuffix(host, "."+n) {
+			return true
+		}
+	}
+	return false
+}
+
+// isNoise reports whether a URL is on a noise host, which is never the case without -filter-noise
+func (s *scan) isNoise(resource string) bool {
+	if !s.config.Options.FilterNoise {
+		return false
+	}
+	u, err := url.Parse(resource)
+	if err != nil || u.Hostname() == "" {
+		return false
+	}
+	return isNoiseHost(u.Hostname(), s.config.noiseHosts)
+}
@@ -0,0 +1,314 @@
+package secondorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Notifier posts a message to a Slack or Discord channel for every finding, through an incoming webhook of the channel
+type Notifier struct {
+	// Incoming webhook URL, like https://hooks.slack.com/services/... or https://discord.com/api/webhooks/...
+	URL string
+	// Finding types that are posted, like takeover-candidate and secret (default every type)
+	Types []string
+}
+
+// Chat services a Notifier can post to
+const (
+	notifySlack   = "slack"
+	notifyDiscord = "discord"
+)
+
+// Number of times a message is posted again when the service is rate limiting or failing, and the longest wait before it
+const (
+	notifyRetries = 5
+	notifyMaxWait = 30 * time.Second
+)
+
+// Longest messages the services take, in characters, Discord rejects longer ones
+const (
+	slackMessageLimit   = 4000
+	discordMessageLimit = 2000
+)
+
+func (config Notifier) validate(service string) error {
+	if config.URL == "" {
+		return fmt.Errorf("%s: URL is required", service)
+	}
+	if u, err := url.Parse(config.URL); err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("%s: URL %q isn't an https URL", service, config.URL)
+	}
+	if err := checkFindingTypes(config.Types); err != nil {
+		return fmt.Errorf("%s: %v", service, err)
+	}
+	return nil
+}
+
+// checkFindingTypes checks the names of finding types
+func checkFindingTypes(types []string) error {
+	for _, t := range types {
+		if _, ok := sarifRules[t]; !ok {
+			return fmt.Errorf("unknown finding type %q", t)
+		}
+	}
+	return nil
+}
+
+// notifierSink posts a message for every finding, one at a time so the channel gets them in order
+// and the rate limits of the service (about one message per second) are waited out
+// once a message couldn't be posted, the next ones are only tried once until one goes through
+type notifierSink struct {
+	service  string
+	config   Notifier
+	types    map[string]bool
+	client   *http.Client
+	findings *findingQueue
+	done     chan struct{}
+
+	sync.Mutex
+	failed  int
+	lastErr error
+	down    bool
+}
+
+func newNotifierSink(service string, config Notifier) (*notifierSink, error) {
+	if err := config.validate(service); err != nil {
+		return nil, err
+	}
+	n := &notifierSink{
+		service:  service,
+		config:   config,
+		types:    make(map[string]bool),
+		client:   &http.Client{Timeout: 30 * time.Second},
+		findings: newFindingQueue(),
+		done:     make(chan struct{}),
+	}
+	for _, t := range config.Types {
+		n.types[t] = true
+	}
+	go n.loop()
+	return n, nil
+}
+
+func (n *notifierSink) Send(f Finding) {
+	if len(n.types) > 0 && !n.types[f.Type] {
+		return
+	}
+	n.findings.push(f)
+}
+
+func (n *notifierSink) Close() error {
+	n.findings.close()
+	<-n.done
+	n.Lock()
+	defer n.Unlock()
+	var problems []string
+	if n.failed > 0 {
+		problems = append(problems, fmt.Sprintf("%d findings couldn't be posted, last error: %v", n.failed, n.lastErr))
+	}
+	if dropped := n.findings.droppedCount(); dropped > 0 {
+		problems = append(problems, fmt.Sprintf("%d findings were dropped while %s was down or too slow to keep up", dropped, n.service))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %s", n.service, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (n *notifierSink) loop() {
+	defer close(n.done)
+	for range n.findings.ready {
+		for {
+			findings, done := n.findings.take(1)
+			for _, f := range findings {
+				err := n.deliver(f)
+				n.Lock()
+				if err != nil {
+					n.failed++
+					n.lastErr = err
+				}
+				n.down = err != nil
+				n.Unlock()
+			}
+			if done {
+				return
+			}
+			if len(findings) == 0 {
+				break
+			}
+			// The run is over, posting to a service that's down would only hold its end
+			if n.isDown() && n.findings.closing() {
+				n.findings.drop()
+			}
+		}
+	}
+}
+
+// isDown reports whether the last message couldn't be posted
+func (n *notifierSink) isDown() bool {
+	n.Lock()
+	defer n.Unlock()
+	return n.down
+}
+
+// deliver posts the message of a finding, retrying on network errors, 429 and 5xx responses
+func (n *notifierSink) deliver(f Finding) error {
+	var message interface{}
+	if n.service == notifySlack {
+		message = slackMessage(f)
+	} else {
+		message = discordMessage(f)
+	}
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	retries := notifyRetries
+	if n.isDown() {
+		retries = 0
+	}
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		wait, err := n.post(body)
+		if wait == 0 || attempt >= retries {
+			return err
+		}
+		if wait < 0 {
+			wait = backoff
+			backoff *= 2
+		}
+		if wait > notifyMaxWait {
+			wait = notifyMaxWait
+		}
+		time.Sleep(wait)
+	}
+}
+
+// post sends a message once, and returns how long to wait before posting it again if it's worth retrying
+// -1 when the service didn't say
+func (n *notifierSink) post(body []byte) (time.Duration, error) {
+	res, err := n.client.Post(n.config.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+		err := fmt.Errorf("%s returned status %d", n.service, res.StatusCode)
+		if wait := retryAfter(res.Header.Get("Retry-After")); wait > 0 {
+			return wait, err
+		}
+		return -1, err
+	}
+	if res.StatusCode >= 300 {
+		return 0, fmt.Errorf("%s rejected the message with status %d", n.service, res.StatusCode)
+	}
+	return 0, nil
+}
+
+// notificationLines are the lines of the message of a finding after its title, as label and value
+func notificationLines(f Finding) [][2]string {
+	var lines [][2]string
+	add := func(label, value string) {
+		if value != "" {
+			lines = append(lines, [2]string{label, value})
+		}
+	}
+	add("Target", f.Target)
+	add("Resource", f.Resource)
+	add("Page", f.Page)
+	add("Detail", f.Detail)
+	if f.Evidence != nil {
+		add("Element", f.Evidence.Element)
+	}
+	return lines
+}
+
+// fitMessage formats the title and lines of a message, shortening the longest values until it fits in limit characters,
+// then the title, and leaving the last lines out if it still doesn't fit
+// the formatted text is never cut, so its markup (code blocks, escapes) stays whole
+func fitMessage(title string, lines [][2]string, limit int, format func(string, [][2]string) string) string {
+	text := format(title, lines)
+	for excess := utf8.RuneCountInString(text) - limit; excess > 0; excess = utf8.RuneCountInString(text) - limit {
+		longest := -1
+		for i, line := range lines {
+			if longest < 0 || utf8.RuneCountInString(line[1]) > utf8.RuneCountInString(lines[longest][1]) {
+				longest = i
+			}
+		}
+		switch {
+		case longest >= 0 && utf8.RuneCountInString(lines[longest][1]) > 1:
+			lines[longest][1] = shorten(lines[longest][1], excess)
+		case utf8.RuneCountInString(title) > 1:
+			title = shorten(title, excess)
+		case len(lines) > 0:
+			lines = lines[:len(lines)-1]
+		default:
+			// Nothing left to take out
+			return text
+		}
+		text = format(title, lines)
+	}
+	return text
+}
+
+// shorten takes excess characters off the end of a value, and one more for the ellipsis that replaces them
+func shorten(value string, excess int) string {
+	runes := []rune(value)
+	keep := len(runes) - excess - 1
+	if keep < 0 {
+		keep = 0
+	}
+	return string(runes[:keep]) + "…"
+}
+
+// slackMessage formats a finding in Slack's mrkdwn, where &, < and > have to be escaped
+func slackMessage(f Finding) map[string]string {
+	escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace
+	title := fmt.Sprintf("[%s] %s", f.Level(), f.Type)
+	text := fitMessage(title, notificationLines(f), slackMessageLimit, func(title string, lines [][2]string) string {
+		text := fmt.Sprintf("*%s*", escape(title))
+		for _, line := range lines {
+			if line[0] == "Element" {
+				text += fmt.Sprintf("\n%s: ```%s```", line[0], escape(line[1]))
+			} else {
+				text += fmt.Sprintf("\n%s: %s", line[0], escape(line[1]))
+			}
+		}
+		return text
+	})
+	return map[string]string{"text": text}
+}
+
+// discordMessage formats a finding in Discord's markdown
+// mentions are disabled, so a page can't make the message ping @everyone
+func discordMessage(f Finding) map[string]interface{} {
+	title := fmt.Sprintf("[%s] %s", f.Level(), f.Type)
+	text := fitMessage(title, notificationLines(f), discordMessageLimit, func(title string, lines [][2]string) string {
+		text := fmt.Sprintf("**%s**", title)
+		for _, line := range lines {
+			if line[0] == "Element" {
+				text += fmt.Sprintf("\n%s: ```html\n%s```", line[0], strings.ReplaceAll(line[1], "```", "'''"))
+			} else if strings.HasPrefix(line[1], "http://") || strings.HasPrefix(line[1], "https://") {
+				// Links in angle brackets aren't embedded, a message would otherwise preview every URL in it
+				text += fmt.Sprintf("\n%s: <%s>", line[0], line[1])
+			} else {
+				text += fmt.Sprintf("\n%s: %s", line[0], line[1])
+			}
+		}
+		return text
+	})
+	return map[string]interface{}{
+		"content":          text,
+		"allowed_mentions": map[string][]string{"parse": {}},
+	}
+}
@@ -0,0 +1,71 @@
+package secondorder
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFitMessage(t *testing.T) {
+	format := func(title string, lines [][2]string) string {
+		text := title
+		for _, line := range lines {
+			text += "\n" + line[0] + ": " + line[1]
+		}
+		return text
+	}
+	tests := []struct {
+		name  string
+		title string
+		lines [][2]string
+		limit int
+		// The message, or its length when want is empty
+		want   string
+		length int
+	}{
+		{name: "fits", title: "T", lines: [][2]string{{"Page", "https://example.com/"}}, limit: 100, want: "T\nPage: https://example.com/"},
+		{name: "exactly the limit", title: "T", lines: [][2]string{{"A", "xyz"}}, limit: 8, want: "T\nA: xyz"},
+		{name: "longest value is shortened", title: "T", lines: [][2]string{{"A", "short"}, {"B", strings.Repeat("b", 50)}}, limit: 30, want: "T\nA: short\nB: " + strings.Repeat("b", 15) + "…"},
+		{name: "several values are shortened", title: "T", lines: [][2]string{{"A", strings.Repeat("a", 40)}, {"B", strings.Repeat("b", 40)}}, limit: 20, length: 20},
+		{name: "runes are counted", title: "T", lines: [][2]string{{"A", strings.Repeat("é", 50)}}, limit: 10, want: "T\nA: éééé…"},
+		{name: "title longer than the limit", title: strings.Repeat("t", 20), lines: [][2]string{{"A", "x"}}, limit: 10, want: "tttt…\nA: x"},
+		{name: "title shortened after the values", title: strings.Repeat("t", 20), lines: [][2]string{{"A", strings.Repeat("a", 20)}, {"B", "y"}}, limit: 16, want: "ttttt…\nA: …\nB: y"},
+		{name: "no lines", title: strings.Repeat("t", 20), limit: 10, want: "ttttttttt…"},
+		{name: "last lines left out", title: "T", lines: [][2]string{{"A", "x"}, {"B", "y"}, {"C", "z"}}, limit: 8, want: "T\nA: x"},
+		{name: "markup longer than the limit", title: "T", limit: 0, want: "T"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := fitMessage(tt.title, tt.lines, tt.limit, format)
+			if tt.want != "" && got != tt.want {
+				t.Errorf("fitMessage() = %q, want %q", got, tt.want)
+			}
+			if tt.want == "" && utf8.RuneCountInString(got) != tt.length {
+				t.Errorf("fitMessage() = %q, %d characters, want %d", got, utf8.RuneCountInString(got), tt.length)
+			}
+		})
+	}
+}
+
+func TestNotificationMessagesFitTheLimits(t *testing.T) {
+	long := "https://example.com/?" + strings.Repeat("a=<b>&", 2000)
+	f := Finding{Type: FindingNon200, Target: "https://example.com/", Page: long, Resource: long, Detail: "404", Evidence: &Evidence{Element: long}}
+	text := slackMessage(f)["text"]
+	if utf8.RuneCountInString(text) > slackMessageLimit {
+		t.Errorf("Slack message is %d characters, the limit is %d", utf8.RuneCountInString(text), slackMessageLimit)
+	}
+	if strings.Count(text, "```")%2 != 0 {
+		t.Error("Slack message has an unclosed code block")
+	}
+	// Every & is the start of an escape, none of them was cut
+	if escapes := strings.Count(text, "&amp;") + strings.Count(text, "&lt;") + strings.Count(text, "&gt;"); escapes != strings.Count(text, "&") {
+		t.Error("Slack message has a cut escape")
+	}
+	text, _ = discordMessage(f)["content"].(string)
+	if text == "" || utf8.RuneCountInString(text) > discordMessageLimit {
+		t.Errorf("Discord message is %d characters, the limit is %d", utf8.RuneCountInString(text), discordMessageLimit)
+	}
+	if strings.Count(text, "```")%2 != 0 {
+		t.Error("Discord message has an unclosed code block")
+	}
+}
@@ -0,0 +1,179 @@
+package secondorder
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// openAPIReference matches the URLs of Swagger/OpenAPI specs, in attributes and in the config of Swagger UI pages
+var openAPIReference = regexp.MustCompile(`(?i)(?:swagger|openapi|api-docs)[\w.-]*(?:\.json|\.ya?ml)$|/v[23]/api-docs$`)
+var openAPIInlineReference = regexp.MustCompile(`(?i)["']([^"'\s]*(?:swagger|openapi|api-docs)[\w.-]*\.(?:json|ya?ml))["']`)
+
+// openAPIMethods are the operations of a path item, other keys (parameters, summary...) aren't endpoints
+var openAPIMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+
+// openAPIDocument holds the parts of a Swagger 2 or OpenAPI 3 spec that point to servers and endpoints
+// YAML is a superset of JSON, so the same decoder reads both formats
+type openAPIDocument struct {
+	Swagger  string   `yaml:"swagger"`
+	OpenAPI  string   `yaml:"openapi"`
+	Host     string   `yaml:"host"`
+	BasePath string   `yaml:"basePath"`
+	Schemes  []string `yaml:"schemes"`
+	Servers  []struct {
+		URL       string `yaml:"url"`
+		Variables map[string]struct {
+			Default string `yaml:"default"`
+		} `yaml:"variables"`
+	} `yaml:"servers"`
+	Paths map[string]map[string]interface{} `yaml:"paths"`
+}
+
+// OpenAPISpec is a spec found during the crawl, saved in openapi.json
+type OpenAPISpec struct {
+	URL       string   `json:"url"`
+	Page      string   `json:"page"`
+	Version   string   `json:"version"`
+	Servers   []string `json:"servers"`
+	Endpoints []string `json:"endpoints"`
+}
+
+type openAPISpecs struct {
+	sync.Mutex
+	specs []OpenAPISpec
+}
+
+func (o *openAPISpecs) add(spec OpenAPISpec) {
+	o.Lock()
+	defer o.Unlock()
+	o.specs = append(o.specs, spec)
+}
+
+// checkOpenAPIReference analyzes the spec a page links to, the first time it's seen
+func (s *scan) checkOpenAPIReference(page, ref string) {
+	if ref == "" || !s.documents.first(ref) {
+		return
+	}
+	spec, ok := s.parseOpenAPI(s.ctx, ref)
+	if !ok {
+		return
+	}
+	spec.Page = page
+	s.openAPI.add(spec)
+
+	// External servers are where the API lives, a dead one can be claimed
+	var external []string
+	for _, server := range spec.Servers {
+		if _, ok := s.thirdPartyHost(server); ok {
+			external = append(external, server)
+		}
+	}
+	if len(external) > 0 {
+		s.logDocumentReferences(page, map[string][]string{"openapi[servers]": external})
+	}
+
+	for _, endpoint := range spec.Endpoints {
+		if s.checkOrigin(endpoint, s.target) {
+			s.pages.addEndpoint(endpoint, spec.URL)
+		}
+	}
+}
+
+// parseOpenAPI fetches a spec and returns its server URLs and the endpoints they serve
+func (sc *Scanner) parseOpenAPI(ctx context.Context, specURL string) (OpenAPISpec, bool) {
+	body, err := sc.fetchDocument(ctx, specURL)
+	if err != nil {
+		return OpenAPISpec{}, false
+	}
+	defer body.Close()
+	data, err := ioutil.ReadAll(io.LimitReader(body, 10<<20))
+	if err != nil {
+		return OpenAPISpec{}, false
+	}
+
+	var doc openAPIDocument
+	if err := yaml.Unmarshal(data, &doc); err != nil || (doc.Swagger == "" && doc.OpenAPI == "") {
+		return OpenAPISpec{}, false
+	}
+
+	spec := OpenAPISpec{URL: specURL, Version: doc.OpenAPI, Servers: doc.servers(specURL)}
+	if spec.Version == "" {
+		spec.Version = doc.Swagger
+	}
+	for path, item := range doc.Paths {
+		if !hasOperation(item) {
+			continue
+		}
+		for _, server := range spec.Servers {
+			spec.Endpoints = append(spec.Endpoints, strings.TrimSuffix(server, "/")+"/"+strings.TrimPrefix(path, "/"))
+		}
+	}
+	sort.Strings(spec.Endpoints)
+	return spec, true
+}
+
+// servers returns the absolute base URLs of the API
+// a spec without servers is served from the host it was found on
+func (doc openAPIDocument) servers(specURL string) []string {
+	var servers []string
+	if doc.Swagger != "" {
+		schemes := doc.Schemes
+		if len(schemes) == 0 {
+			if u, err := url.Parse(specURL); err == nil {
+				schemes = []string{u.Scheme}
+			}
+		}
+		if doc.Host == "" {
+			return []string{resolveReference(specURL, "/"+strings.TrimPrefix(doc.BasePath, "/"))}
+		}
+		for _, scheme := range schemes {
+			servers = append(servers, scheme+"://"+doc.Host+doc.BasePath)
+		}
+		return servers
+	}
+
+	for _, server := range doc.Servers {
+		u := server.URL
+		for name, variable := range server.Variables {
+			u = strings.ReplaceAll(u, "{"+name+"}", variable.Default)
+		}
+		servers = append(servers, resolveReference(specURL, u))
+	}
+	if len(servers) == 0 {
+		servers = []string{resolveReference(specURL, "/")}
+	}
+	return servers
+}
+
+func hasOperation(item map[string]interface{}) bool {
+	for _, method := range openAPIMethods {
+		if _, ok := item[method]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// restore adds specs analyzed by an interrupted run
+func (o *openAPISpecs) restore(specs []OpenAPISpec) {
+	o.Lock()
+	defer o.Unlock()
+	o.specs = append(o.specs, specs...)
+}
+
+// list returns the specs sorted by URL
+func (o *openAPISpecs) list() []OpenAPISpec {
+	o.Lock()
+	defer o.Unlock()
+	list := append(make([]OpenAPISpec, 0, len(o.specs)), o.specs...)
+	sort.Slice(list, func(i, j int) bool { return list[i].URL < list[j].URL })
+	return list
+}
@@ -0,0 +1,126 @@
+package secondorder
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var compressionExtensions = map[string]string{
+	"gzip": ".gz",
+	"zstd": ".zst",
+}
+
+// ValidateCompression checks the algorithm used for output files ("gzip", "zstd" or empty for none)
+func ValidateCompression(name string) error {
+	if _, ok := compressionExtensions[name]; name != "" && !ok {
+		return fmt.Errorf("unknown compression %q (supported: gzip, zstd)", name)
+	}
+	return nil
+}
+
+// createResultFile creates an output file, compressing its content on the fly if compression is enabled
+// the extension of the compression algorithm is added to the path
+func createResultFile(path, compression string) (io.WriteCloser, error) {
+	f, err := os.Create(path + compressionExtensions[compression])
+	if err != nil {
+		return nil, err
+	}
+
+	switch compression {
+	case "gzip":
+		return &compressedFile{Writer: gzip.NewWriter(f), file: f}, nil
+	case "zstd":
+		zw, err := zstd.NewWriter(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &compressedFile{Writer: zw, file: f}, nil
+	}
+	return f, nil
+}
+
+// WriteJSON marshals v and saves it to path, compressed with the given algorithm
+func WriteJSON(path string, v interface{}, compression string) error {
+	JSON, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("could not marshal the JSON object: %v", err)
+	}
+	f, err := createResultFile(path, compression)
+	if err != nil {
+		return fmt.Errorf("couldn't create output file: %v", err)
+	}
+	if _, err := f.Write(JSON); err != nil {
+		f.Close()
+		return fmt.Errorf("couldn't write resources to JSON: %v", err)
+	}
+	return f.Close()
+}
+
+// openResultFile opens an output file for reading and decompresses it based on its extension
+// if the path doesn't exist, the compressed versions of it are tried as well
+func OpenResultFile(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		for _, ext := range compressionExtensions {
+			if f, err = os.Open(path + ext); err == nil {
+				path += ext
+				break
+			}
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasSuffix(path, ".gz"):
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &decompressedFile{Reader: zr, closer: zr, file: f}, nil
+	case strings.HasSuffix(path, ".zst"):
+		zr, err := zstd.NewReader(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &decompressedFile{Reader: zr, closer: zr.IOReadCloser(), file: f}, nil
+	}
+	return f, nil
+}
+
+// compressedFile flushes the compressor before closing the underlying file
+type compressedFile struct {
+	io.Writer
+	file *os.File
+}
+
+func (c *compressedFile) Close() error {
+	if closer, ok := c.Writer.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			c.file.Close()
+			return err
+		}
+	}
+	return c.file.Close()
+}
+
+type decompressedFile struct {
+	io.Reader
+	closer io.Closer
+	file   *os.File
+}
+
+func (d *decompressedFile) Close() error {
+	d.closer.Close()
+	return d.file.Close()
+}
@@ -0,0 +1,132 @@
+package secondorder
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// pipeline runs the checks of the resources found in pages (HTTP verification, DNS lookups and fingerprinting)
+// in stages that work concurrently with the crawl, instead of inside the handlers of the pages that found them
+// each stage has its own workers and a bounded queue: VerifyThreads workers verify resources over HTTP, DNSThreads resolve hosts
+// a stage holds QueueSize jobs before the pages that submit more wait for room, so a page with thousands of links
+// slows its crawling thread down instead of filling the memory
+type pipeline struct {
+	ctx    context.Context
+	verify *stage
+	dns    *stage
+	// Counts the queued jobs
+	stats *scanStats
+
+	mu sync.Mutex
+	// Jobs of each page that aren't done yet, only kept when the crawl state is saved, nil otherwise
+	pages map[string]*pageJobs
+	// Callbacks waiting for the jobs of their page
+	waiting sync.WaitGroup
+}
+
+type pageJobs struct {
+	sync.WaitGroup
+	// A job was dropped because the scan was cancelled, so the page wasn't fully checked
+	dropped bool
+}
+
+// stage is a queue of jobs of one kind and the workers that run them
+type stage struct {
+	jobs    chan func()
+	workers sync.WaitGroup
+}
+
+// newPipeline starts the stages of a scan, trackPages keeps the jobs of each page for afterPage
+func newPipeline(ctx context.Context, options Options, trackPages bool, stats *scanStats) *pipeline {
+	p := &pipeline{
+		ctx:    ctx,
+		verify: newStage(options.VerifyThreads, options.QueueSize),
+		dns:    newStage(options.DNSThreads, options.QueueSize),
+		stats:  stats,
+	}
+	if trackPages {
+		p.pages = make(map[string]*pageJobs)
+	}
+	return p
+}
+
+func newStage(workers, queue int) *stage {
+	if workers < 1 {
+		workers = 1
+	}
+	if queue < 0 {
+		queue = 0
+	}
+	st := &stage{jobs: make(chan func(), queue)}
+	st.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer st.workers.Done()
+			for job := range st.jobs {
+				job()
+			}
+		}()
+	}
+	return st
+}
+
+// submit queues a job of a page on a stage, it blocks while the queue of the stage is full
+// jobs that haven't started when the scan is cancelled are dropped
+func (p *pipeline) submit(st *stage, page string, job func()) {
+	jobs := &pageJobs{}
+	if p.pages != nil {
+		p.mu.Lock()
+		if existing, ok := p.pages[page]; ok {
+			jobs = existing
+		} else {
+			p.pages[page] = jobs
+		}
+		p.mu.Unlock()
+	}
+	jobs.Add(1)
+
+	atomic.AddInt64(&p.stats.queued, 1)
+	st.jobs <- func() {
+		atomic.AddInt64(&p.stats.queued, -1)
+		defer jobs.Done()
+		if p.ctx.Err() != nil {
+			p.mu.Lock()
+			jobs.dropped = true
+			p.mu.Unlock()
+			return
+		}
+		job()
+	}
+}
+
+// afterPage calls done once every job of a page finished, with whether all of them ran
+// it's called when the page was scraped, so no more jobs are submitted for it
+func (p *pipeline) afterPage(page string, done func(complete bool)) {
+	p.mu.Lock()
+	jobs, ok := p.pages[page]
+	delete(p.pages, page)
+	p.mu.Unlock()
+	if !ok {
+		done(true)
+		return
+	}
+	p.waiting.Add(1)
+	go func() {
+		defer p.waiting.Done()
+		jobs.Wait()
+		p.mu.Lock()
+		complete := !jobs.dropped
+		p.mu.Unlock()
+		done(complete)
+	}()
+}
+
+// close waits for the queued jobs and the callbacks waiting for them, the pipeline can't be used afterwards
+func (p *pipeline) close() {
+	for _, st := range []*stage{p.verify, p.dns} {
+		close(st.jobs)
+		st.workers.Wait()
+	}
+	p.waiting.Wait()
+}
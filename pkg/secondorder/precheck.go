@@ -0,0 +1,87 @@
+package secondorder
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sync"
+)
+
+// parkedPage recognizes the pages domain parking services and registrars serve on unused domains
+var parkedPage = regexp.MustCompile(`(?i)this domain (?:name )?(?:is|may be) for sale|buy this domain|domain (?:is )?parked|parked (?:free|domain)|sedoparking|parkingcrew|bodis\.com|domain has expired|godaddy\.com/park|dan\.com/buy-domain|hugedomains\.com`)
+
+// SeedHealth is the result of probing a target before crawling it
+type SeedHealth struct {
+	Target     string `json:"target"`
+	Status     string `json:"status"`
+	StatusCode int    `json:"status_code,omitempty"`
+	FinalURL   string `json:"final_url,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Seed statuses, only healthy seeds are crawled
+const (
+	SeedHealthy     = "healthy"
+	SeedUnreachable = "unreachable"
+	SeedOffScope    = "off-scope redirect"
+	SeedParked      = "parked"
+)
+
+// Precheck probes the targets, parallel at a time, and returns their health in the same order
+// the targets that weren't probed when ctx is cancelled are unreachable
+func (sc *Scanner) Precheck(ctx context.Context, targets []string, parallel int) []SeedHealth {
+	if parallel < 1 {
+		parallel = 1
+	}
+	health := make([]SeedHealth, len(targets))
+	var wg sync.WaitGroup
+	slots := make(chan struct{}, parallel)
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			health[i] = sc.ProbeSeed(ctx, target)
+			<-slots
+		}(i, target)
+	}
+	wg.Wait()
+	return health
+}
+
+// ProbeSeed requests a target following redirects, and checks whether it's alive, stays in scope, and isn't a parked page
+func (sc *Scanner) ProbeSeed(ctx context.Context, target string) SeedHealth {
+	h := SeedHealth{Target: target}
+	if hostname, err := getHostname(target); err == nil {
+		sc.internal.addTarget(hostname)
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		h.Status, h.Error = SeedUnreachable, err.Error()
+		return h
+	}
+	sc.addHeaders(req)
+
+	res, err := sc.verifyClient.Do(req)
+	if err != nil {
+		h.Status, h.Error = SeedUnreachable, err.Error()
+		return h
+	}
+	defer res.Body.Close()
+	h.StatusCode = res.StatusCode
+	h.FinalURL = res.Request.URL.String()
+
+	body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+	scope, err := newCrawlScope(sc.config.Options, target)
+	switch {
+	case err != nil || !scope.allows(h.FinalURL):
+		h.Status = SeedOffScope
+	case parkedPage.Match(body):
+		h.Status = SeedParked
+	default:
+		h.Status = SeedHealthy
+	}
+	return h
+}
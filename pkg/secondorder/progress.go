@@ -0,0 +1,64 @@
+package secondorder
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+)
+
+// progressSink writes the progress of the scans as JSON lines, for wrappers that show progress or enforce their own timeouts
+// resource events are left out, there are too many of them to be useful as progress
+type progressSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+	// Pages crawled and findings of every target so far
+	counts map[string]*progressCounts
+	err    error
+}
+
+type progressCounts struct {
+	Pages    int `json:"pages"`
+	Findings int `json:"findings"`
+}
+
+// progressLine is an event with the counts of its target so far
+type progressLine struct {
+	Event
+	progressCounts
+}
+
+// NewProgressSink returns a sink that writes every event but the resources pages load to w, one JSON object per line,
+// with the number of pages crawled and findings of its target so far
+// w isn't closed by the sink
+func NewProgressSink(w io.Writer) EventSink {
+	return &progressSink{encoder: json.NewEncoder(w), counts: make(map[string]*progressCounts)}
+}
+
+func (s *progressSink) Handle(e Event) {
+	if e.Type == EventResourceFound {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	counts, ok := s.counts[e.Target]
+	if !ok {
+		counts = &progressCounts{}
+		s.counts[e.Target] = counts
+	}
+	switch e.Type {
+	case EventPageCrawled:
+		counts.Pages++
+	case EventFindingConfirmed:
+		counts.Findings++
+	}
+	if err := s.encoder.Encode(progressLine{e, *counts}); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// Close reports the first write that failed, nothing is buffered
+func (s *progressSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
@@ -0,0 +1,165 @@
+package secondorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/andybalholm/cascadia"
+)
+
+// Severities of query rules, from the least to the most severe
+const (
+	SeverityInfo     = "info"
+	SeverityLow      = "low"
+	SeverityMedium   = "medium"
+	SeverityHigh     = "high"
+	SeverityCritical = "critical"
+)
+
+// SARIF levels and security severities of the query rule severities
+var severityLevels = map[string]sarifRule{
+	SeverityInfo:     {level: "note", severity: "1.0"},
+	SeverityLow:      {level: "note", severity: "3.0"},
+	SeverityMedium:   {level: "warning", severity: "5.0"},
+	SeverityHigh:     {level: "error", severity: "7.5"},
+	SeverityCritical: {level: "error", severity: "9.5"},
+}
+
+// ValidateSeverity checks the name of a severity
+func ValidateSeverity(severity string) error {
+	if _, ok := severityLevels[severity]; !ok {
+		return fmt.Errorf("unknown severity %q, use info, low, medium, high or critical", severity)
+	}
+	return nil
+}
+
+// QueryRule logs an attribute of the elements matching a selector
+type QueryRule struct {
+	// Name of the rule, the results and findings of the rule are keyed by it (default selector[attribute])
+	Name string
+	// Tag or CSS selector of the elements, like script or link[rel=stylesheet]
+	Selector string
+	// Attribute to log, like src
+	Attribute string
+	// Severity of the findings of the rule: info, low, medium, high or critical (default the severity of their type)
+	Severity string
+	// What the findings of the rule mean, sent as their detail
+	Description string
+}
+
+// name is the default name of the rule, selector[attribute]
+func (r QueryRule) name() string {
+	return createQuerySelector(r.Selector, r.Attribute)
+}
+
+// validate checks that the rule has what it needs to match elements, and a known severity
+func (r QueryRule) validate() error {
+	if strings.TrimSpace(r.Selector) == "" {
+		return fmt.Errorf("query rule %q: Selector is required", r.Name)
+	}
+	if strings.TrimSpace(r.Attribute) == "" {
+		return fmt.Errorf("query rule %q: Attribute is required", r.Name)
+	}
+	if err := validateSelector(r.Selector); err != nil {
+		return fmt.Errorf("query rule %q: %v", r.Name, err)
+	}
+	if _, ok := severityLevels[r.Severity]; r.Severity != "" && !ok {
+		return fmt.Errorf("query rule %q: unknown severity %q, use info, low, medium, high or critical", r.Name, r.Severity)
+	}
+	return nil
+}
+
+// Queries are the rules of LogQueries or LogNon200Queries
+// in the configuration file they're either a list of rules, or an object of tags and the attribute to log for each of them
+type Queries []QueryRule
+
+func (q *Queries) UnmarshalJSON(data []byte) error {
+	var tags map[string]string
+	if err := json.Unmarshal(data, &tags); err == nil {
+		*q = queriesFromTags(tags)
+		return nil
+	}
+	var rules []QueryRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fmt.Errorf("queries must be a list of rules or an object of tags and attributes: %v", err)
+	}
+	*q = rules
+	return nil
+}
+
+// queriesFromTags converts an object of tags and attributes to rules named selector[attribute], sorted by tag
+func queriesFromTags(tags map[string]string) Queries {
+	if tags == nil {
+		return nil
+	}
+	q := make(Queries, 0, len(tags))
+	for tag, attribute := range tags {
+		q = append(q, QueryRule{Selector: tag, Attribute: attribute})
+	}
+	sort.Slice(q, func(i, j int) bool { return q[i].Selector < q[j].Selector })
+	return q.withNames()
+}
+
+// withNames returns a copy of the rules where the ones without a name are named selector[attribute]
+// and severities are lowercase
+func (q Queries) withNames() Queries {
+	if q == nil {
+		return nil
+	}
+	named := make(Queries, len(q))
+	for i, rule := range q {
+		if rule.Name == "" {
+			rule.Name = rule.name()
+		}
+		rule.Severity = strings.ToLower(rule.Severity)
+		named[i] = rule
+	}
+	return named
+}
+
+// compile names the rules and validates them, the results of two rules with the same name would be mixed up
+func (q Queries) compile(field string) (Queries, error) {
+	q = q.withNames()
+	names := make(map[string]bool)
+	for _, rule := range q {
+		if err := rule.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %v", field, err)
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("%s: two rules are named %q", field, rule.Name)
+		}
+		names[rule.Name] = true
+	}
+	return q, nil
+}
+
+// add returns the rules with the ones of other added, replacing the rules with the same name
+func (q Queries) add(other Queries) Queries {
+	if len(other) == 0 {
+		return q
+	}
+	merged := append(Queries{}, q.withNames()...)
+	for _, rule := range other.withNames() {
+		replaced := false
+		for i := range merged {
+			if merged[i].Name == rule.Name {
+				merged[i], replaced = rule, true
+			}
+		}
+		if !replaced {
+			merged = append(merged, rule)
+		}
+	}
+	return merged
+}
+
+// validateSelector checks that a CSS selector parses, pages are searched with an invalid one without an error
+// and it silently matches nothing
+func validateSelector(selector string) error {
+	if _, err := cascadia.Compile(selector); err != nil {
+		return fmt.Errorf("invalid selector %q: %v", selector, err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package secondorder
+
+import (
+	"net/url"
+	"sync"
+)
+
+// querySampler treats the URLs of a path that only differ in parameter values as the same page
+// once enough values of their parameters were sampled, to keep faceted search and calendars from exploding the crawl
+type querySampler struct {
+	limit int
+
+	mu sync.Mutex
+	// Values seen of every parameter, keyed by host and path and then by parameter name
+	values map[string]map[string]map[string]bool
+}
+
+func newQuerySampler(limit int) *querySampler {
+	return &querySampler{limit: limit, values: make(map[string]map[string]map[string]bool)}
+}
+
+// sample reports whether a URL is worth crawling: it has no query, or at least one of its parameters has a value
+// that wasn't seen yet while fewer than limit values of that parameter were sampled on its path
+func (q *querySampler) sample(rawURL string) bool {
+	if q.limit < 1 {
+		return true
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.RawQuery == "" {
+		return true
+	}
+	query := u.Query()
+	if len(query) == 0 {
+		return true
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	path := u.Host + u.EscapedPath()
+	params, ok := q.values[path]
+	if !ok {
+		params = make(map[string]map[string]bool)
+		q.values[path] = params
+	}
+	fresh := false
+	for name, values := range query {
+		seen, ok := params[name]
+		if !ok {
+			seen = make(map[string]bool)
+			params[name] = seen
+		}
+		for _, value := range values {
+			if !seen[value] && len(seen) < q.limit {
+				seen[value] = true
+				fresh = true
+			}
+		}
+	}
+	return fresh
+}
@@ -0,0 +1,59 @@
+package secondorder
+
+import "testing"
+
+func TestQuerySamplerSample(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit int
+		urls  []string
+		want  []bool
+	}{
+		{
+			name:  "no limit",
+			limit: 0,
+			urls:  []string{"https://example.com/s?a=1", "https://example.com/s?a=2", "https://example.com/s?a=1"},
+			want:  []bool{true, true, true},
+		},
+		{
+			name:  "no query",
+			limit: 1,
+			urls:  []string{"https://example.com/s", "https://example.com/s", "https://example.com/s?"},
+			want:  []bool{true, true, true},
+		},
+		{
+			name:  "values of a parameter",
+			limit: 2,
+			urls:  []string{"https://example.com/s?a=1", "https://example.com/s?a=2", "https://example.com/s?a=3", "https://example.com/s?a=1"},
+			want:  []bool{true, true, false, false},
+		},
+		{
+			name:  "a new parameter is sampled",
+			limit: 1,
+			urls:  []string{"https://example.com/s?a=1", "https://example.com/s?a=2", "https://example.com/s?a=2&b=1"},
+			want:  []bool{true, false, true},
+		},
+		{
+			name:  "paths and hosts are sampled apart",
+			limit: 1,
+			urls:  []string{"https://example.com/s?a=1", "https://example.com/t?a=2", "https://www.example.com/s?a=2", "https://example.com/s?a=2"},
+			want:  []bool{true, true, true, false},
+		},
+		{
+			name:  "parameter order",
+			limit: 1,
+			urls:  []string{"https://example.com/s?a=1&b=1", "https://example.com/s?b=1&a=1"},
+			want:  []bool{true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newQuerySampler(tt.limit)
+			for i, u := range tt.urls {
+				if got := q.sample(u); got != tt.want[i] {
+					t.Errorf("sample(%q) = %v, want %v", u, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
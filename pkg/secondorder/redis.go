@@ -0,0 +1,209 @@
+package secondorder
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// redisClient sends commands to a Redis server over a single connection, in the RESP protocol
+// the connection is opened again after a network error
+type redisClient struct {
+	address  string
+	tls      bool
+	username string
+	password string
+	db       int
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// redisError is an error reply of the server
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// newRedisClient connects to the server of a redis:// or rediss:// (TLS) URL, like redis://:password@host:6379/0
+func newRedisClient(rawURL string) (*redisClient, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "redis" && u.Scheme != "rediss") || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid Redis URL %q, use redis://[:password@]host[:port][/db]", rawURL)
+	}
+	c := &redisClient{address: u.Host, tls: u.Scheme == "rediss"}
+	if u.Port() == "" {
+		c.address = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		c.username = u.User.Username()
+		c.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if c.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid Redis database %q", db)
+		}
+	}
+	if _, err := c.do("PING"); err != nil {
+		return nil, fmt.Errorf("could not connect to Redis: %v", err)
+	}
+	return c, nil
+}
+
+// connect opens the connection, logs in and selects the database
+func (c *redisClient) connect() error {
+	dialer := &net.Dialer{Timeout: 10 * time.Second}
+	var conn net.Conn
+	var err error
+	if c.tls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", c.address, nil)
+	} else {
+		conn, err = dialer.Dial("tcp", c.address)
+	}
+	if err != nil {
+		return err
+	}
+	c.conn, c.reader = conn, bufio.NewReader(conn)
+	var setup [][]string
+	if c.password != "" && c.username != "" {
+		setup = append(setup, []string{"AUTH", c.username, c.password})
+	} else if c.password != "" {
+		setup = append(setup, []string{"AUTH", c.password})
+	}
+	if c.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.db)})
+	}
+	for _, args := range setup {
+		if _, err := c.roundTrip(args); err != nil {
+			c.close()
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *redisClient) close() {
+	if c.conn != nil {
+		c.conn.Close()
+		c.conn, c.reader = nil, nil
+	}
+}
+
+// redisReads are the commands that are sent again after a network error, they don't change anything
+var redisReads = map[string]bool{"PING": true, "GET": true, "EXISTS": true, "LLEN": true, "LRANGE": true, "SMEMBERS": true}
+
+// do sends a command and returns its reply: a string, an int64, nil, or a []interface{} of replies
+// a command that changes data isn't sent again when its reply is lost, it may have been applied
+func (c *redisClient) do(args ...string) (interface{}, error) {
+	return c.send(redisReads[strings.ToUpper(args[0])], args)
+}
+
+// doIdempotent is do for commands that leave the data the same when they're applied twice, like SET or the scripts
+// guarded by a set, they're sent again after a network error like reads
+func (c *redisClient) doIdempotent(args ...string) (interface{}, error) {
+	return c.send(true, args)
+}
+
+func (c *redisClient) send(retry bool, args []string) (interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// The command is sent again on a new connection if the previous one broke
+	for attempt := 0; ; attempt++ {
+		if c.conn == nil {
+			if err := c.connect(); err != nil {
+				return nil, err
+			}
+		}
+		reply, err := c.roundTrip(args)
+		var replyErr redisError
+		if err == nil || errors.As(err, &replyErr) || attempt > 0 || !retry {
+			return reply, err
+		}
+		c.close()
+	}
+}
+
+func (c *redisClient) roundTrip(args []string) (interface{}, error) {
+	var command strings.Builder
+	fmt.Fprintf(&command, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&command, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	c.conn.SetDeadline(time.Now().Add(30 * time.Second))
+	if _, err := io.WriteString(c.conn, command.String()); err != nil {
+		c.close()
+		return nil, err
+	}
+	reply, err := readRedisReply(c.reader)
+	var replyErr redisError
+	if err != nil && !errors.As(err, &replyErr) {
+		c.close()
+	}
+	return reply, err
+}
+
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		content := make([]byte, n+2)
+		if _, err := io.ReadFull(r, content); err != nil {
+			return nil, err
+		}
+		return string(content[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		replies := make([]interface{}, n)
+		for i := range replies {
+			if replies[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return replies, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// redisInt reads an integer reply, a missing key counts as 0
+func redisInt(reply interface{}, err error) (int64, error) {
+	if err != nil {
+		return 0, err
+	}
+	switch v := reply.(type) {
+	case int64:
+		return v, nil
+	case string:
+		return strconv.ParseInt(v, 10, 64)
+	case nil:
+		return 0, nil
+	}
+	return 0, fmt.Errorf("redis: unexpected reply %v", reply)
+}
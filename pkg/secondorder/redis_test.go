@@ -0,0 +1,357 @@
+package secondorder
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeRedis is a Redis server that keeps its data in memory, it knows the commands second-order sends
+// the scripts of the shared frontier are run by Go functions that do what their Lua does, the Lua itself is only run
+// by the tests of the shared frontier against the server of SECOND_ORDER_TEST_REDIS
+type fakeRedis struct {
+	listener net.Listener
+	password string
+
+	mu      sync.Mutex
+	strings map[string]string
+	lists   map[string][]string
+	sets    map[string]map[string]bool
+	// Commands received, in order
+	commands [][]string
+	// The next command with a name in drop is applied, then the connection is closed instead of answering it
+	drop map[string]bool
+}
+
+func newFakeRedis(t *testing.T, password string) *fakeRedis {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not start the Redis server: %v", err)
+	}
+	f := &fakeRedis{
+		listener: listener,
+		password: password,
+		strings:  make(map[string]string),
+		lists:    make(map[string][]string),
+		sets:     make(map[string]map[string]bool),
+		drop:     make(map[string]bool),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { listener.Close() })
+	return f
+}
+
+// url is the URL the clients connect to the server with
+func (f *fakeRedis) url() string {
+	return "redis://" + f.listener.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+	authenticated := f.password == ""
+	for {
+		request, err := readRedisReply(reader)
+		if err != nil {
+			return
+		}
+		parts, _ := request.([]interface{})
+		args := make([]string, len(parts))
+		for i, part := range parts {
+			args[i], _ = part.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+		name := strings.ToUpper(args[0])
+
+		f.mu.Lock()
+		f.commands = append(f.commands, args)
+		var reply interface{}
+		switch {
+		case name == "AUTH":
+			if authenticated = args[len(args)-1] == f.password; authenticated {
+				reply = "OK"
+			} else {
+				reply = redisError("WRONGPASS invalid username-password pair")
+			}
+		case !authenticated:
+			reply = redisError("NOAUTH Authentication required.")
+		default:
+			reply = f.exec(name, args[1:])
+		}
+		dropped := f.drop[name]
+		delete(f.drop, name)
+		f.mu.Unlock()
+		if dropped {
+			return
+		}
+		writeRedisReply(conn, reply)
+	}
+}
+
+// dropNext closes the connection instead of answering the next command with a name
+func (f *fakeRedis) dropNext(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.drop[name] = true
+}
+
+// received returns the commands the server got with a name
+func (f *fakeRedis) received(name string) [][]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var commands [][]string
+	for _, args := range f.commands {
+		if strings.EqualFold(args[0], name) {
+			commands = append(commands, args)
+		}
+	}
+	return commands
+}
+
+func writeRedisReply(w io.Writer, reply interface{}) {
+	switch v := reply.(type) {
+	case nil:
+		io.WriteString(w, "$-1\r\n")
+	case string:
+		fmt.Fprintf(w, "$%d\r\n%s\r\n", len(v), v)
+	case int64:
+		fmt.Fprintf(w, ":%d\r\n", v)
+	case redisError:
+		fmt.Fprintf(w, "-%s\r\n", string(v))
+	case []string:
+		fmt.Fprintf(w, "*%d\r\n", len(v))
+		for _, s := range v {
+			writeRedisReply(w, s)
+		}
+	}
+}
+
+// exec applies a command to the data, the lock is held
+func (f *fakeRedis) exec(name string, args []string) interface{} {
+	switch name {
+	case "PING":
+		return "PONG"
+	case "SELECT", "SET":
+		if name == "SET" {
+			f.strings[args[0]] = args[1]
+		}
+		return "OK"
+	case "GET":
+		if v, ok := f.strings[args[0]]; ok {
+			return v
+		}
+		return nil
+	case "INCR", "DECR":
+		n, _ := strconv.ParseInt(f.strings[args[0]], 10, 64)
+		if name == "INCR" {
+			n++
+		} else {
+			n--
+		}
+		f.strings[args[0]] = strconv.FormatInt(n, 10)
+		return n
+	case "EXISTS", "DEL":
+		var count int64
+		for _, key := range args {
+			_, isString := f.strings[key]
+			_, isList := f.lists[key]
+			_, isSet := f.sets[key]
+			if isString || isList || isSet {
+				count++
+			}
+			if name == "DEL" {
+				delete(f.strings, key)
+				delete(f.lists, key)
+				delete(f.sets, key)
+			}
+		}
+		return count
+	case "LPUSH":
+		f.lists[args[0]] = append([]string{args[1]}, f.lists[args[0]]...)
+		return int64(len(f.lists[args[0]]))
+	case "RPUSH":
+		f.lists[args[0]] = append(f.lists[args[0]], args[1])
+		return int64(len(f.lists[args[0]]))
+	case "RPOPLPUSH":
+		return f.rpoplpush(args[0], args[1])
+	case "LREM":
+		return f.lrem(args[0], args[2])
+	case "LLEN":
+		return int64(len(f.lists[args[0]]))
+	case "LRANGE":
+		return append([]string{}, f.lists[args[0]]...)
+	case "SADD", "SREM":
+		set := f.sets[args[0]]
+		if set == nil {
+			set = make(map[string]bool)
+			f.sets[args[0]] = set
+		}
+		if set[args[1]] == (name == "SADD") {
+			return int64(0)
+		}
+		if name == "SADD" {
+			set[args[1]] = true
+		} else {
+			delete(set, args[1])
+		}
+		return int64(1)
+	case "SMEMBERS":
+		var members []string
+		for member := range f.sets[args[0]] {
+			members = append(members, member)
+		}
+		return members
+	case "EVAL":
+		n, _ := strconv.Atoi(args[1])
+		return f.eval(args[0], args[2:2+n], args[2+n:])
+	}
+	return redisError("ERR unknown command '" + name + "'")
+}
+
+func (f *fakeRedis) rpoplpush(source, destination string) interface{} {
+	list := f.lists[source]
+	if len(list) == 0 {
+		return nil
+	}
+	value := list[len(list)-1]
+	f.lists[source] = list[:len(list)-1]
+	f.exec("LPUSH", []string{destination, value})
+	return value
+}
+
+// lrem removes the first occurrence of a value from a list
+func (f *fakeRedis) lrem(key, value string) int64 {
+	list := f.lists[key]
+	for i, v := range list {
+		if v == value {
+			f.lists[key] = append(list[:i:i], list[i+1:]...)
+			return 1
+		}
+	}
+	return 0
+}
+
+// eval runs the scripts of the shared frontier
+func (f *fakeRedis) eval(script string, keys, argv []string) interface{} {
+	switch script {
+	case sharedQueue:
+		if f.exec("SADD", []string{keys[0], argv[0]}) == int64(1) {
+			f.exec("INCR", []string{keys[2]})
+			f.exec("LPUSH", []string{keys[1], argv[1]})
+			return int64(1)
+		}
+		return int64(0)
+	case sharedFinish:
+		if f.lrem(keys[0], argv[0]) == 1 {
+			f.exec("DECR", []string{keys[1]})
+			return int64(1)
+		}
+		return int64(0)
+	case sharedRelease:
+		if f.lrem(keys[0], argv[0]) == 1 {
+			f.exec("LPUSH", []string{keys[1], argv[0]})
+			return int64(1)
+		}
+		return int64(0)
+	case sharedRequeue:
+		var moved int64
+		for f.rpoplpush(keys[0], keys[1]) != nil {
+			moved++
+		}
+		if argv[0] != "" {
+			f.exec("SREM", []string{keys[2], argv[0]})
+		}
+		return moved
+	}
+	return redisError("ERR unknown script")
+}
+
+func TestNewRedisClient(t *testing.T) {
+	for _, u := range []string{"http://localhost:6379", "redis://", "redis://localhost/first"} {
+		if _, err := newRedisClient(u); err == nil {
+			t.Errorf("newRedisClient(%q) accepted an invalid URL", u)
+		}
+	}
+
+	server := newFakeRedis(t, "pw")
+	if _, err := newRedisClient("redis://:wrong@" + server.listener.Addr().String()); err == nil {
+		t.Error("the client connected with the wrong password")
+	}
+	if _, err := newRedisClient("redis://:pw@" + server.listener.Addr().String() + "/2"); err != nil {
+		t.Fatalf("newRedisClient: %v", err)
+	}
+	if selected := server.received("SELECT"); len(selected) != 1 || selected[0][1] != "2" {
+		t.Errorf("SELECT commands = %q, want the database of the URL selected", selected)
+	}
+}
+
+func TestRedisClient(t *testing.T) {
+	server := newFakeRedis(t, "")
+	client, err := newRedisClient(server.url())
+	if err != nil {
+		t.Fatalf("newRedisClient: %v", err)
+	}
+
+	client.do("SET", "key", "value")
+	client.do("RPUSH", "list", "a")
+	client.do("RPUSH", "list", "b")
+	tests := []struct {
+		args  []string
+		reply interface{}
+	}{
+		{[]string{"GET", "key"}, "value"},
+		{[]string{"GET", "missing"}, nil},
+		{[]string{"INCR", "counter"}, int64(1)},
+		{[]string{"LRANGE", "list", "0", "-1"}, []interface{}{"a", "b"}},
+	}
+	for _, tt := range tests {
+		if reply, err := client.do(tt.args...); err != nil || !reflect.DeepEqual(reply, tt.reply) {
+			t.Errorf("do(%q) = %#v, %v, want %#v", tt.args, reply, err, tt.reply)
+		}
+	}
+	if _, err := client.do("BOGUS"); err == nil || !strings.Contains(err.Error(), "unknown command") {
+		t.Errorf("do(BOGUS) = %v, want the error of the server", err)
+	}
+	if n, err := redisInt(client.do("GET", "missing")); err != nil || n != 0 {
+		t.Errorf("redisInt(a missing key) = %d, %v, want 0", n, err)
+	}
+
+	// A read whose reply is lost is sent again on a new connection
+	server.dropNext("GET")
+	if reply, err := client.do("GET", "key"); err != nil || reply != "value" {
+		t.Errorf("do(GET) = %v, %v after the connection broke, want the value", reply, err)
+	}
+	// An increment isn't, it was applied
+	server.dropNext("INCR")
+	if _, err := client.do("INCR", "counter"); err == nil {
+		t.Error("do(INCR) = nil after its reply was lost")
+	}
+	if n, _ := redisInt(client.do("GET", "counter")); n != 2 {
+		t.Errorf("counter = %d, want the increment applied once", n)
+	}
+	// Unless the command can be applied twice
+	server.dropNext("SET")
+	if _, err := client.doIdempotent("SET", "key", "other"); err != nil {
+		t.Errorf("doIdempotent(SET) = %v after the connection broke", err)
+	}
+	if sets := server.received("SET"); len(sets) != 3 {
+		t.Errorf("SET was sent %d times, want 3", len(sets))
+	}
+}
@@ -0,0 +1,135 @@
+package secondorder
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+const (
+	// How many pages are rendered at the same time, every one of them is a browser tab
+	renderTabs = 4
+	// How long a page gets to load and run its scripts
+	renderTimeout = 30 * time.Second
+)
+
+// renderer renders pages in a headless Chrome so the elements single page apps inject at runtime can be scraped
+type renderer struct {
+	browser context.Context
+	cancel  func()
+	headers network.Headers
+	tabs    chan struct{}
+	// Fail the requests of rendered pages that aren't GET or HEAD
+	safe bool
+	// rate spaces out the requests of rendered pages along with the other requests of the run
+	rate *rateLimiter
+}
+
+// newRenderer starts the browser, its requests leave through the proxy of egress if there's one
+func newRenderer(headers map[string]string, insecure, safe bool, rate *rateLimiter, egress Egress) (*renderer, error) {
+	allocatorOptions := append(chromedp.DefaultExecAllocatorOptions[:],
+		chromedp.Flag("ignore-certificate-errors", insecure),
+	)
+	if egress.Proxy != "" {
+		allocatorOptions = append(allocatorOptions, chromedp.ProxyServer(egress.Proxy))
+	}
+	allocator, cancelAllocator := chromedp.NewExecAllocator(context.Background(), allocatorOptions...)
+	browser, cancelBrowser := chromedp.NewContext(allocator)
+	cancel := func() {
+		cancelBrowser()
+		cancelAllocator()
+	}
+	// Running without actions starts the browser, so a missing Chrome is reported before the crawl starts
+	if err := chromedp.Run(browser); err != nil {
+		cancel()
+		return nil, fmt.Errorf("could not start the browser for -render: %v", err)
+	}
+
+	r := &renderer{browser: browser, cancel: cancel, headers: network.Headers{}, tabs: make(chan struct{}, renderTabs), safe: safe, rate: rate}
+	for name, value := range headers {
+		r.headers[name] = value
+	}
+	return r, nil
+}
+
+// render loads a page in a new tab and returns its DOM once the page's scripts ran
+// the cookies are set for the URL, so the page is rendered with the session of the crawl
+func (r *renderer) render(ctx context.Context, u string, cookies []*http.Cookie) (string, error) {
+	select {
+	case r.tabs <- struct{}{}:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	defer func() { <-r.tabs }()
+
+	tab, cancelTab := chromedp.NewContext(r.browser)
+	defer cancelTab()
+	tab, cancelTimeout := context.WithTimeout(tab, renderTimeout)
+	defer cancelTimeout()
+	// Abort the render when the scan is cancelled
+	go func() {
+		select {
+		case <-ctx.Done():
+			cancelTab()
+		case <-tab.Done():
+		}
+	}()
+
+	actions := []chromedp.Action{network.Enable(), network.SetExtraHTTPHeaders(r.headers)}
+	if r.safe || r.rate != nil {
+		actions = append(actions, interceptRequests(tab, r.safe, r.rate))
+	}
+	for _, cookie := range cookies {
+		actions = append(actions, network.SetCookie(cookie.Name, cookie.Value).WithURL(u))
+	}
+	var html string
+	err := chromedp.Run(tab, append(actions,
+		chromedp.Navigate(u),
+		chromedp.WaitReady("body", chromedp.ByQuery),
+		chromedp.OuterHTML("html", &html, chromedp.ByQuery),
+	)...)
+	return html, err
+}
+
+func (r *renderer) close() {
+	r.cancel()
+}
+
+// renderTransport replaces the body of HTML pages with their rendered DOM
+// the page is still requested through the normal transport first, so the status code, headers and limits stay the same
+// pages that can't be rendered keep their original body
+type renderTransport struct {
+	transport http.RoundTripper
+	renderer  *renderer
+}
+
+func (t *renderTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.transport.RoundTrip(req)
+	if err != nil || req.Method != "GET" || res.StatusCode != http.StatusOK || !strings.Contains(res.Header.Get("Content-Type"), "html") {
+		return res, err
+	}
+
+	original, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	body := original
+	if html, err := t.renderer.render(req.Context(), req.URL.String(), req.Cookies()); err == nil {
+		body = []byte(html)
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	res.ContentLength = int64(len(body))
+	res.Header.Set("Content-Length", strconv.Itoa(len(body)))
+	res.Header.Del("Content-Encoding")
+	return res, nil
+}
@@ -0,0 +1,100 @@
+package secondorder
+
+import (
+	"bytes"
+	"html/template"
+	"sort"
+)
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Second Order report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin: 1em 0 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; vertical-align: top; }
+td { word-break: break-all; }
+.error { color: #d62728; }
+.warning { color: #ff7f0e; }
+.note { color: #1f77b4; }
+pre { white-space: pre-wrap; margin: 4px 0 0; color: #555; }
+</style>
+</head>
+<body>
+<h1>Second Order report</h1>
+<p>{{len .Targets}} targets, {{.Total}} findings</p>
+<table>
+<tr><th>Type</th><th>Findings</th></tr>
+{{range .Counts}}<tr><td class="{{.Level}}">{{.Type}}</td><td>{{.Count}}</td></tr>
+{{end}}</table>
+{{range .Targets}}<h2>{{.Target}}</h2>
+{{if .Findings}}<table>
+<tr><th>Type</th><th>Page</th><th>Resource</th><th>Detail</th><th>Confidence</th></tr>
+{{range .Findings}}<tr><td class="{{.Level}}">{{.Type}}{{if .Severity}} ({{.Severity}}){{end}}</td><td>{{.Page}}</td><td>{{.Resource}}{{with .Evidence}}<pre title="{{.Path}}">{{.Element}}</pre>{{end}}</td><td>{{.Detail}}</td><td>{{if .Confidence}}{{.Confidence}}{{end}}</td></tr>
+{{end}}</table>
+{{else}}<p>No findings</p>
+{{end}}{{end}}</body>
+</html>
+`))
+
+type reportFinding struct {
+	Finding
+	// SARIF level of the type, which the report colors it with
+	Level string
+}
+
+type reportTarget struct {
+	Target   string
+	Findings []reportFinding
+}
+
+type reportCount struct {
+	Type  string
+	Level string
+	Count int
+}
+
+// HTMLReport renders the findings of every target as a single HTML page, for reading or sharing a run without other tools
+// the findings of a target are sorted by severity, the most severe first
+func HTMLReport(results []*Result) ([]byte, error) {
+	var targets []reportTarget
+	counts := make(map[string]int)
+	total := 0
+	for _, r := range results {
+		target := reportTarget{Target: r.Target}
+		for _, f := range r.Findings {
+			target.Findings = append(target.Findings, reportFinding{Finding: f, Level: findingLevel(f)})
+			counts[f.Type]++
+			total++
+		}
+		sort.SliceStable(target.Findings, func(i, j int) bool {
+			a, b := findingSeverity(target.Findings[i].Finding), findingSeverity(target.Findings[j].Finding)
+			if a != b {
+				return a > b
+			}
+			return target.Findings[i].Confidence > target.Findings[j].Confidence
+		})
+		targets = append(targets, target)
+	}
+
+	var list []reportCount
+	for t, count := range counts {
+		list = append(list, reportCount{Type: t, Level: sarifRules[t].level, Count: count})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Count != list[j].Count {
+			return list[i].Count > list[j].Count
+		}
+		return list[i].Type < list[j].Type
+	})
+
+	var report bytes.Buffer
+	err := reportTemplate.Execute(&report, struct {
+		Targets []reportTarget
+		Counts  []reportCount
+		Total   int
+	}{targets, list, total})
+	return report.Bytes(), err
+}
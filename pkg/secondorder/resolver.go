@@ -0,0 +1,366 @@
+package secondorder
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	// How long successful and failed (NXDOMAIN) lookups are kept in the cache
+	dnsPositiveTTL = 10 * time.Minute
+	dnsNegativeTTL = 2 * time.Minute
+)
+
+// dnsEntry is a cached lookup result. done is closed once the lookup has finished,
+// so concurrent callers asking for the same name wait for a single query instead of sending their own
+type dnsEntry struct {
+	done    chan struct{}
+	values  []string
+	err     error
+	expires time.Time
+}
+
+// cachingResolver is shared by everything that needs DNS during a run (crawling, scope checks and verification)
+// popular CDNs are referenced by almost every page, so without a cache the same names get resolved thousands of times
+type cachingResolver struct {
+	resolver *net.Resolver
+	workers  chan struct{}
+	// nameserver receives the raw queries that need more than the standard library exposes (CNAME chains and response codes)
+	nameserver string
+	// doh receives every query instead of the nameserver if the resolver is a DNS over HTTPS server
+	doh *dohClient
+
+	sync.Mutex
+	hosts  map[string]*dnsEntry
+	cnames map[string]*dnsEntry
+	chains map[string]*dnsEntry
+}
+
+// newCachingResolver creates a resolver that sends its queries to server, or to the system's nameservers if it's empty
+func newCachingResolver(workers int, server string) (*cachingResolver, error) {
+	if workers < 1 {
+		workers = 1
+	}
+	r := &cachingResolver{
+		resolver:   net.DefaultResolver,
+		workers:    make(chan struct{}, workers),
+		nameserver: systemNameserver(),
+		hosts:      make(map[string]*dnsEntry),
+		cnames:     make(map[string]*dnsEntry),
+		chains:     make(map[string]*dnsEntry),
+	}
+	if server == "" {
+		return r, nil
+	}
+	nameserver, doh, err := parseResolver(server)
+	if err != nil {
+		return nil, err
+	}
+	// The lookups of the standard library are sent to the server as well, whatever the system's configuration says
+	if doh != nil {
+		r.nameserver, r.doh = doh.url, doh
+		r.resolver = &net.Resolver{PreferGo: true, Dial: doh.dial}
+		return r, nil
+	}
+	r.nameserver = nameserver
+	r.resolver = &net.Resolver{PreferGo: true, Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+		var dialer net.Dialer
+		return dialer.DialContext(ctx, network, nameserver)
+	}}
+	return r, nil
+}
+
+// LookupHost returns the addresses of a host, using the cache when possible
+func (r *cachingResolver) LookupHost(ctx context.Context, host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []string{host}, nil
+	}
+	return r.lookup(ctx, r.hosts, host, func(ctx context.Context, name string) ([]string, error) {
+		addrs, err := r.resolver.LookupHost(ctx, name)
+		return addrs, r.named(err)
+	})
+}
+
+// LookupCNAME returns the canonical name of a host, using the cache when possible
+func (r *cachingResolver) LookupCNAME(ctx context.Context, host string) (string, error) {
+	values, err := r.lookup(ctx, r.cnames, host, func(ctx context.Context, name string) ([]string, error) {
+		cname, err := r.resolver.LookupCNAME(ctx, name)
+		if err != nil {
+			return nil, r.named(err)
+		}
+		return []string{cname}, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return values[0], nil
+}
+
+// named sets the server of a DNS error to the resolver the lookup was sent to
+// the standard library names the nameserver of the system's configuration even when its queries go elsewhere
+func (r *cachingResolver) named(err error) error {
+	var dnsErr *net.DNSError
+	if r.resolver != net.DefaultResolver && errors.As(err, &dnsErr) {
+		dnsErr.Server = r.nameserver
+	}
+	return err
+}
+
+// LookupChain returns the CNAME chain of a host, using the cache when possible
+// if the chain ends in a name that doesn't exist, the chain is returned along with an NXDOMAIN error,
+// which is how a dangling CNAME looks like
+func (r *cachingResolver) LookupChain(ctx context.Context, host string) ([]string, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return nil, nil
+	}
+	return r.lookup(ctx, r.chains, host, r.queryChain)
+}
+
+func (r *cachingResolver) queryChain(ctx context.Context, name string) ([]string, error) {
+	res, err := r.exchange(ctx, name, dnsmessage.TypeA)
+	if err != nil {
+		return nil, err
+	}
+
+	var chain []string
+	current := name + "."
+	for _, answer := range res.Answers {
+		cname, ok := answer.Body.(*dnsmessage.CNAMEResource)
+		if !ok || !strings.EqualFold(answer.Header.Name.String(), current) {
+			continue
+		}
+		current = cname.CNAME.String()
+		chain = append(chain, strings.TrimSuffix(current, "."))
+	}
+
+	switch res.RCode {
+	case dnsmessage.RCodeSuccess:
+		return chain, nil
+	case dnsmessage.RCodeNameError:
+		return chain, &net.DNSError{Err: "no such host", Name: name, Server: r.nameserver, IsNotFound: true}
+	case dnsmessage.RCodeServerFailure:
+		return chain, &net.DNSError{Err: "server misbehaving", Name: name, Server: r.nameserver, IsTemporary: true}
+	}
+	return chain, &net.DNSError{Err: res.RCode.String(), Name: name, Server: r.nameserver}
+}
+
+// exchange sends a single query to the nameserver, retrying over TCP if the UDP response was truncated
+func (r *cachingResolver) exchange(ctx context.Context, name string, qtype dnsmessage.Type) (*dnsmessage.Message, error) {
+	qname, err := dnsmessage.NewName(name + ".")
+	if err != nil {
+		return nil, err
+	}
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: uint16(rand.Intn(1 << 16)), RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	packet, err := query.Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+	}
+
+	var res *dnsmessage.Message
+	if r.doh != nil {
+		res, err = r.exchangeDoH(ctx, packet)
+	} else {
+		res, err = r.exchangeOver(ctx, "udp", packet)
+		if err == nil && res.Truncated {
+			res, err = r.exchangeOver(ctx, "tcp", packet)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res.ID != query.ID {
+		return nil, errors.New("DNS response ID doesn't match the query")
+	}
+	return res, nil
+}
+
+func (r *cachingResolver) exchangeOver(ctx context.Context, network string, packet []byte) (*dnsmessage.Message, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, r.nameserver)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// DNS over TCP prefixes messages with their length
+	if network == "tcp" {
+		packet = append([]byte{byte(len(packet) >> 8), byte(len(packet))}, packet...)
+	}
+	if _, err := conn.Write(packet); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 65535)
+	var n int
+	if network == "tcp" {
+		if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+			return nil, err
+		}
+		n = int(buf[0])<<8 | int(buf[1])
+		_, err = io.ReadFull(conn, buf[:n])
+	} else {
+		n, err = conn.Read(buf)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var res dnsmessage.Message
+	if err := res.Unpack(buf[:n]); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+func (r *cachingResolver) exchangeDoH(ctx context.Context, packet []byte) (*dnsmessage.Message, error) {
+	response, err := r.doh.exchange(ctx, packet)
+	if err != nil {
+		return nil, err
+	}
+	var res dnsmessage.Message
+	if err := res.Unpack(response); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// systemNameserver returns the first nameserver in /etc/resolv.conf
+func systemNameserver() string {
+	data, err := ioutil.ReadFile("/etc/resolv.conf")
+	if err == nil {
+		for _, line := range strings.Split(string(data), "\n") {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				return net.JoinHostPort(fields[1], "53")
+			}
+		}
+	}
+	return "127.0.0.1:53"
+}
+
+func (r *cachingResolver) lookup(ctx context.Context, cache map[string]*dnsEntry, name string, query func(context.Context, string) ([]string, error)) ([]string, error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	r.Lock()
+	entry, ok := cache[name]
+	if ok {
+		select {
+		case <-entry.done:
+			if time.Now().After(entry.expires) {
+				ok = false
+			}
+		default:
+			// A lookup for this name is already in flight
+		}
+	}
+	if !ok {
+		entry = &dnsEntry{done: make(chan struct{})}
+		cache[name] = entry
+	}
+	r.Unlock()
+
+	if ok {
+		select {
+		case <-entry.done:
+			return entry.values, entry.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	r.workers <- struct{}{}
+	entry.values, entry.err = query(ctx, name)
+	<-r.workers
+
+	switch {
+	case entry.err == nil:
+		entry.expires = time.Now().Add(dnsPositiveTTL)
+	case isNXDOMAIN(entry.err):
+		entry.expires = time.Now().Add(dnsNegativeTTL)
+	default:
+		// Timeouts and other temporary errors shouldn't be remembered
+		entry.expires = time.Now()
+	}
+	close(entry.done)
+
+	return entry.values, entry.err
+}
+
+// prune forgets the lookups whose answer expired, they'd be sent again anyway
+func (r *cachingResolver) prune() {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	for _, cache := range []map[string]*dnsEntry{r.hosts, r.cnames, r.chains} {
+		for name, entry := range cache {
+			select {
+			case <-entry.done:
+				if now.After(entry.expires) {
+					delete(cache, name)
+				}
+			default:
+			}
+		}
+	}
+}
+
+// DialContext resolves the address through the cache before dialing, so it can be plugged into an http.Transport
+func (r *cachingResolver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return r.DialContextWith(&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second})(ctx, network, address)
+}
+
+// DialContextWith is DialContext with a custom dialer, e.g. one bound to a local address
+func (r *cachingResolver) DialContextWith(dialer *net.Dialer) func(context.Context, string, string) (net.Conn, error) {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		addrs, err := r.LookupHost(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+
+		var conn net.Conn
+		for _, addr := range addrs {
+			conn, err = dialer.DialContext(ctx, network, net.JoinHostPort(addr, port))
+			if err == nil {
+				return conn, nil
+			}
+		}
+		return nil, err
+	}
+}
+
+// isNXDOMAIN reports whether err means that the name doesn't exist at all
+func isNXDOMAIN(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// isSERVFAIL reports whether the nameserver failed to answer, which is what a zone delegated to nameservers that are gone looks like
+func isSERVFAIL(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.Err == "server misbehaving"
+}
@@ -0,0 +1,246 @@
+package secondorder
+
+import (
+	"context"
+	"path"
+)
+
+// Result is what was found on a target
+// it's a copy, so it's safe to read while the scan is still running
+// the results of features that weren't enabled are nil
+type Result struct {
+	Target string
+	// Results of LogQueries, LogNon200Queries, LogInline and LogBodyRegex, keyed by page URL and then by query or rule
+	Attributes map[string]map[string][]string
+	Non200     map[string]map[string][]string
+	// How the non-200 URLs responded, keyed by URL, saved with them in non-200-url-attributes.json
+	Responses map[string]*Response
+	// Where on the page the resources of LogQueries and LogNon200Queries are referenced, keyed by page URL and then by resource
+	Evidence        map[string]map[string]*Evidence
+	Inline          map[string]map[string][]string
+	BodyMatches     map[string]map[string][]BodyMatch
+	Pages           []*Page
+	CMS             []CMSFinding
+	DanglingDomains []*DanglingDomain
+	DanglingLinks   []*DanglingLink
+	WaybackDiff     map[string]WaybackDiff
+	OpenAPI         []OpenAPISpec
+	GraphQL         []GraphQLEndpoint
+	Takeovers       []*TakeoverCandidate
+	Secrets         []Secret
+	Scripts         []*Script
+	InlineScripts   []InlineScript
+	// Endpoints found in scripts, keyed by script URL, or "inline:" and the page URL for inline scripts
+	JSEndpoints map[string][]JSEndpoint
+	// Third-party hosts referenced by the crawled pages, it isn't saved by Write
+	ThirdParties []string
+	// Pages, third parties and findings of every crawled host
+	Hosts []HostSummary
+	// Links that weren't crawled, and why
+	Coverage *Coverage
+	// Every finding, in the order they were found
+	Findings []Finding
+	// What the scan did, saved in summary.json
+	Summary *ScanSummary
+	// Findings that appeared, changed or went away since the previous run of the target, nil unless monitoring
+	Changes []FindingChange
+
+	compression string
+	// Content of the scripts, keyed by hash
+	scriptContent map[string][]byte
+}
+
+// result takes a snapshot of what the scan found so far
+func (s *scan) result() *Result {
+	options := s.config.Options
+	r := &Result{
+		Target:      s.target,
+		Pages:       s.pages.list(),
+		compression: options.Compression,
+	}
+	if s.config.LogQueries != nil || options.Manifests || options.OpenAPI || options.InlineJSON {
+		r.Attributes = s.loggedQueries.copy()
+	}
+	if s.config.LogNon200Queries != nil || options.Manifests || options.OpenAPI || options.InlineJSON {
+		r.Non200 = s.loggedNon200Queries.copy()
+		r.Responses = make(map[string]*Response)
+		for _, queries := range r.Non200 {
+			for _, values := range queries {
+				for _, value := range values {
+					if response := s.response(value); response != nil {
+						r.Responses[value] = response
+					}
+				}
+			}
+		}
+	}
+	if r.Attributes != nil || r.Non200 != nil {
+		r.Evidence = s.evidence.copy()
+	}
+	if s.config.LogInline != nil {
+		r.Inline = s.loggedInline.copy()
+	}
+	if s.config.LogBodyRegex != nil {
+		r.BodyMatches = s.bodyMatches.copy()
+	}
+	if options.CMSChecks {
+		r.CMS = s.cms.list()
+	}
+	r.DanglingDomains = s.dangling.list()
+	if options.CheckLinks {
+		r.DanglingLinks = s.danglingLinks.list()
+	}
+	r.ThirdParties = s.thirdParties.all()
+	if options.WaybackMonths > 0 {
+		r.WaybackDiff = s.waybackDiffs.copy()
+	}
+	if options.OpenAPI {
+		r.OpenAPI = s.openAPI.list()
+	}
+	if options.GraphQL {
+		r.GraphQL = s.graphQL.list()
+	}
+	if options.Takeover {
+		r.Takeovers = s.takeovers.list()
+	}
+	if options.Secrets {
+		r.Secrets = s.secrets.list()
+	}
+	if options.JSEndpoints {
+		r.JSEndpoints = s.jsEndpoints.copy()
+	}
+	if options.SaveScripts {
+		r.Scripts, r.scriptContent = s.scripts.list()
+	}
+	if options.InlineScripts {
+		r.InlineScripts = s.inlineScripts.list()
+	}
+	s.findings.Lock()
+	r.Findings = append([]Finding(nil), s.findings.findings...)
+	s.findings.Unlock()
+	r.Hosts = s.summarizeHosts(r.Pages, r.Findings)
+	r.Coverage = s.coverage.copy()
+	r.Summary = s.summary(r.Pages, r.Findings, r.Coverage)
+	if s.previous != nil {
+		r.Changes = DiffFindings(s.previousFindings, r.Findings)
+	}
+	return r
+}
+
+// VerifiedURL is a non-200 URL and how it responded, saved in non-200-url-attributes.json
+type VerifiedURL struct {
+	URL string `json:"url"`
+	*Response
+	Evidence *Evidence `json:"evidence,omitempty"`
+}
+
+// verifiedURLs pairs the non-200 URLs with their responses, keyed by the class of the response, then by page URL
+// and then by query
+func (r *Result) verifiedURLs() map[string]map[string]map[string][]VerifiedURL {
+	content := make(map[string]map[string]map[string][]VerifiedURL)
+	for page, queries := range r.Non200 {
+		for query, values := range queries {
+			for _, value := range values {
+				response := r.Responses[value]
+				class := response.class()
+				if content[class] == nil {
+					content[class] = make(map[string]map[string][]VerifiedURL)
+				}
+				if content[class][page] == nil {
+					content[class][page] = make(map[string][]VerifiedURL)
+				}
+				content[class][page][query] = append(content[class][page][query], VerifiedURL{URL: value, Response: response, Evidence: r.Evidence[page][value]})
+			}
+		}
+	}
+	return content
+}
+
+// Write saves the result in dir, one JSON file per kind of result
+func (r *Result) Write(dir string) error {
+	store, err := NewFileStore(dir, r.compression)
+	if err != nil {
+		return err
+	}
+	return r.Save(context.Background(), store, "")
+}
+
+// Save saves the result in a store under prefix, one JSON document per kind of result
+// every document is attempted even if one fails, the first error is returned
+// the documents that weren't saved yet when ctx is cancelled aren't saved
+func (r *Result) Save(ctx context.Context, store ResultStore, prefix string) error {
+	files := map[string]interface{}{
+		"pages.json":    map[string][]*Page{"Pages": r.Pages},
+		"hosts.json":    map[string][]HostSummary{"Hosts": r.Hosts},
+		"coverage.json": map[string]*Coverage{"Coverage": r.Coverage},
+		"findings.json": map[string][]Finding{"Findings": r.Findings},
+	}
+	if r.Summary != nil {
+		files["summary.json"] = map[string]*ScanSummary{"Summary": r.Summary}
+	}
+	if r.Changes != nil {
+		files["changes.json"] = map[string][]FindingChange{"Changes": r.Changes}
+	}
+	if r.Attributes != nil {
+		files["attributes.json"] = map[string]map[string]map[string][]string{"LogQueries": r.Attributes}
+	}
+	if r.Evidence != nil {
+		files["evidence.json"] = map[string]map[string]map[string]*Evidence{"Evidence": r.Evidence}
+	}
+	if r.Inline != nil {
+		files["inline.json"] = map[string]map[string]map[string][]string{"LogInline": r.Inline}
+	}
+	if r.BodyMatches != nil {
+		files["body-matches.json"] = map[string]map[string]map[string][]BodyMatch{"LogBodyRegex": r.BodyMatches}
+	}
+	if r.Non200 != nil {
+		files["non-200-url-attributes.json"] = map[string]map[string]map[string]map[string][]VerifiedURL{"LogNon200Queries": r.verifiedURLs()}
+	}
+	if r.WaybackDiff != nil {
+		files["wayback-diff.json"] = map[string]map[string]WaybackDiff{"WaybackDiff": r.WaybackDiff}
+	}
+	if r.DanglingDomains != nil {
+		files["dangling-domains.json"] = map[string][]*DanglingDomain{"DanglingDomains": r.DanglingDomains}
+	}
+	if r.DanglingLinks != nil {
+		files["dangling-links.json"] = map[string][]*DanglingLink{"DanglingLinks": r.DanglingLinks}
+	}
+	if r.OpenAPI != nil {
+		files["openapi.json"] = map[string][]OpenAPISpec{"OpenAPI": r.OpenAPI}
+	}
+	if r.GraphQL != nil {
+		files["graphql.json"] = map[string][]GraphQLEndpoint{"GraphQL": r.GraphQL}
+	}
+	if r.Takeovers != nil {
+		files["takeover-candidates.json"] = map[string][]*TakeoverCandidate{"TakeoverCandidates": r.Takeovers}
+	}
+	if r.Secrets != nil {
+		files["secrets.json"] = map[string][]Secret{"Secrets": r.Secrets}
+	}
+	if r.CMS != nil {
+		files["cms.json"] = map[string][]CMSFinding{"CMS": r.CMS}
+	}
+	if r.JSEndpoints != nil {
+		files["js-endpoints.json"] = map[string]map[string][]JSEndpoint{"JSEndpoints": r.JSEndpoints}
+	}
+	if r.Scripts != nil {
+		files["scripts.json"] = map[string][]*Script{"Scripts": r.Scripts}
+	}
+	if r.InlineScripts != nil {
+		files["inline-scripts.json"] = map[string][]InlineScript{"InlineScripts": r.InlineScripts}
+	}
+
+	var first error
+	for name, content := range files {
+		if err := store.WriteJSON(ctx, path.Join(prefix, name), content); err != nil && first == nil {
+			first = err
+		}
+	}
+	// Scripts are named after their hash
+	for hash, content := range r.scriptContent {
+		if err := store.WriteFile(ctx, path.Join(prefix, "scripts", hash+".js"), content); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
@@ -0,0 +1,245 @@
+package secondorder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Verdicts of a retest
+const (
+	RetestVulnerable = "still-vulnerable"
+	RetestFixed      = "fixed"
+	// The resource wasn't checked, like a URL on an internal address without AllowInternal, it may still be vulnerable
+	RetestSkipped = "skipped"
+)
+
+// RetestResult is the new verdict on a resource a previous run reported
+type RetestResult struct {
+	Type     string   `json:"type"`
+	Resource string   `json:"resource"`
+	Pages    []string `json:"pages"`
+	Status   string   `json:"status"`
+	// What the check found: the status code or error of the URL, the DNS status or the takeover service, or why it wasn't checked
+	Detail string `json:"detail,omitempty"`
+}
+
+// LoadRetestInput reads the findings of a previous run that can be retested from one of its files:
+// non-200-url-attributes.json, dangling-domains.json, dangling-links.json, takeover-candidates.json,
+// or findings streamed with -format jsonl, one per line
+func LoadRetestInput(path string) ([]Finding, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the retest input: %v", err)
+	}
+
+	var file struct {
+		LogNon200Queries   map[string]json.RawMessage
+		DanglingDomains    []*DanglingDomain
+		DanglingLinks      []*DanglingLink
+		TakeoverCandidates []*TakeoverCandidate
+	}
+	// A JSON lines file only decodes as an object when it has a single finding
+	err = json.Unmarshal(content, &file)
+	if err != nil || (file.LogNon200Queries == nil && file.DanglingDomains == nil && file.DanglingLinks == nil && file.TakeoverCandidates == nil) {
+		return loadFindingLines(path, content)
+	}
+
+	var findings []Finding
+	for key, raw := range file.LogNon200Queries {
+		// The URLs are grouped by the class of their response, except in the files of older versions
+		pages := make(map[string]map[string][]non200Entry)
+		var queries map[string][]non200Entry
+		if err := json.Unmarshal(raw, &queries); err == nil {
+			pages[key] = queries
+		} else if err := json.Unmarshal(raw, &pages); err != nil {
+			return nil, fmt.Errorf("%s: invalid LogNon200Queries: %v", path, err)
+		}
+		for page, queries := range pages {
+			for query, values := range queries {
+				for _, value := range values {
+					findings = append(findings, Finding{Type: FindingNon200, Page: page, Resource: string(value), Rule: query})
+				}
+			}
+		}
+	}
+	for _, d := range file.DanglingDomains {
+		for _, page := range d.Pages {
+			findings = append(findings, Finding{Type: FindingDangling, Page: page, Resource: d.Host, Detail: d.Status})
+		}
+	}
+	for _, d := range file.DanglingLinks {
+		for _, link := range d.Links {
+			for _, page := range d.Pages {
+				findings = append(findings, Finding{Type: FindingDanglingLink, Page: page, Resource: link, Detail: d.Status})
+			}
+		}
+	}
+	for _, c := range file.TakeoverCandidates {
+		for _, page := range c.Pages {
+			findings = append(findings, Finding{Type: FindingTakeover, Page: page, Resource: c.Host, Detail: c.Service})
+		}
+	}
+	return findings, nil
+}
+
+// non200Entry is a URL of non-200-url-attributes.json, an object with its response or a bare string
+// in the files of older versions
+type non200Entry string
+
+func (e *non200Entry) UnmarshalJSON(data []byte) error {
+	var u string
+	if err := json.Unmarshal(data, &u); err == nil {
+		*e = non200Entry(u)
+		return nil
+	}
+	var verified VerifiedURL
+	if err := json.Unmarshal(data, &verified); err != nil {
+		return err
+	}
+	*e = non200Entry(verified.URL)
+	return nil
+}
+
+// loadFindingLines reads findings saved as JSON lines
+func loadFindingLines(path string, content []byte) ([]Finding, error) {
+	var findings []Finding
+	lines := bufio.NewScanner(bytes.NewReader(content))
+	lines.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	for n := 1; lines.Scan(); n++ {
+		line := bytes.TrimSpace(lines.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var f Finding
+		if err := json.Unmarshal(line, &f); err != nil || f.Type == "" {
+			return nil, fmt.Errorf("%s isn't a file of findings that can be retested (line %d)", path, n)
+		}
+		findings = append(findings, f)
+	}
+	return findings, lines.Err()
+}
+
+// Retest verifies the resources of previous findings again, without crawling, and reports which ones are still vulnerable
+// non-200 URLs are requested with the verification rules, dangling domains and links are resolved, and takeover candidates
+// are fingerprinted again; findings of other types are left out
+// each resource is checked once, with the pages of all its findings, and at most parallel checks run at the same time
+// cancelling ctx stops the checks, and only the resources checked until then are returned
+func (sc *Scanner) Retest(ctx context.Context, findings []Finding, parallel int) []RetestResult {
+	var results []*RetestResult
+	byResource := make(map[string]*RetestResult)
+	for _, f := range findings {
+		switch f.Type {
+		case FindingNon200, FindingDangling, FindingDanglingLink, FindingTakeover:
+		default:
+			continue
+		}
+		key := f.Type + " " + f.Resource
+		r, ok := byResource[key]
+		if !ok {
+			r = &RetestResult{Type: f.Type, Resource: f.Resource}
+			byResource[key] = r
+			results = append(results, r)
+		}
+		if f.Page != "" && !contains(r.Pages, f.Page) {
+			r.Pages = append(r.Pages, f.Page)
+		}
+	}
+
+	if parallel < 1 {
+		parallel = 1
+	}
+	slots := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+checks:
+	for _, r := range results {
+		r := r
+		select {
+		case slots <- struct{}{}:
+		case <-ctx.Done():
+			break checks
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-slots }()
+			status, detail := sc.retest(ctx, r.Type, r.Resource)
+			// A check cut short says nothing about the resource
+			if ctx.Err() != nil {
+				return
+			}
+			r.Status, r.Detail = status, detail
+		}()
+	}
+	wg.Wait()
+
+	list := make([]RetestResult, 0, len(results))
+	for _, r := range results {
+		if r.Status == "" {
+			continue
+		}
+		sort.Strings(r.Pages)
+		list = append(list, *r)
+	}
+	// The resources that are still vulnerable first, then the ones that weren't checked
+	rank := map[string]int{RetestVulnerable: 0, RetestSkipped: 1, RetestFixed: 2}
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Status != list[j].Status {
+			return rank[list[i].Status] < rank[list[j].Status]
+		}
+		return list[i].Resource < list[j].Resource
+	})
+	return list
+}
+
+// retest checks a resource the way the crawl found it, and returns its status and why
+func (sc *Scanner) retest(ctx context.Context, findingType, resource string) (string, string) {
+	switch findingType {
+	case FindingNon200:
+		dangling := sc.isDangling(ctx, resource)
+		// A URL that wasn't requested is neither dead nor alive
+		if reason := sc.refusal(resource); reason != "" {
+			return RetestSkipped, reason
+		}
+		// The status code, or why the URL didn't respond, like NXDOMAIN or a timeout
+		var detail string
+		if response := sc.response(resource); response != nil {
+			detail = response.Error
+			if response.Status != 0 {
+				detail = strconv.Itoa(response.Status)
+			}
+		}
+		return verdictStatus(dangling), detail
+	case FindingDangling:
+		status, _ := sc.resolveStatus(ctx, resource)
+		return verdictStatus(status != ""), status
+	case FindingDanglingLink:
+		u, err := url.Parse(resource)
+		if err != nil || u.Hostname() == "" {
+			return RetestFixed, ""
+		}
+		status, _ := sc.resolveStatus(ctx, u.Hostname())
+		return verdictStatus(status != ""), status
+	case FindingTakeover:
+		if candidate := sc.fingerprintTakeover(ctx, resource); candidate != nil {
+			return RetestVulnerable, candidate.Service
+		}
+		return RetestFixed, ""
+	}
+	return RetestFixed, ""
+}
+
+// verdictStatus is the status of a resource that was checked
+func verdictStatus(vulnerable bool) string {
+	if vulnerable {
+		return RetestVulnerable
+	}
+	return RetestFixed
+}
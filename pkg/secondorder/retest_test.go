@@ -0,0 +1,131 @@
+package secondorder
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestRetest(t *testing.T) {
+	site := newVerifiedSite()
+	defer site.Close()
+	sc, err := NewScanner(Config{Options: Options{AllowInternal: true}})
+	if err != nil {
+		t.Fatalf("NewScanner: %v", err)
+	}
+	defer sc.Close()
+	cacheLookups(sc.resolver, map[string][]string{"alive.example.com": {"93.184.216.34"}, "gone.example.com": nil})
+
+	findings := []Finding{
+		{Type: FindingNon200, Page: "https://example.com/b", Resource: site.URL + "/missing.js"},
+		{Type: FindingNon200, Page: "https://example.com/a", Resource: site.URL + "/missing.js"},
+		{Type: FindingNon200, Page: "https://example.com/a", Resource: site.URL + "/alive.js"},
+		{Type: FindingDangling, Page: "https://example.com/a", Resource: "gone.example.com"},
+		{Type: FindingDanglingLink, Page: "https://example.com/a", Resource: "https://alive.example.com/"},
+		// Secrets can't be checked again
+		{Type: FindingSecret, Page: "https://example.com/a", Resource: site.URL + "/missing.js"},
+	}
+	got := sc.Retest(context.Background(), findings, 2)
+	want := []RetestResult{
+		{Type: FindingDangling, Resource: "gone.example.com", Pages: []string{"https://example.com/a"}, Status: RetestVulnerable, Detail: "NXDOMAIN"},
+		{Type: FindingNon200, Resource: site.URL + "/missing.js", Pages: []string{"https://example.com/a", "https://example.com/b"}, Status: RetestVulnerable, Detail: "404"},
+		{Type: FindingNon200, Resource: site.URL + "/alive.js", Pages: []string{"https://example.com/a"}, Status: RetestFixed, Detail: "200"},
+		{Type: FindingDanglingLink, Resource: "https://alive.example.com/", Pages: []string{"https://example.com/a"}, Status: RetestFixed},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Retest() = %+v, want %+v", got, want)
+	}
+	if sent := site.sent("/missing.js"); len(sent) != 1 {
+		t.Errorf("a resource of two findings was requested %d times", len(sent))
+	}
+}
+
+func TestRetestSkipsInternalAddresses(t *testing.T) {
+	site := newVerifiedSite()
+	defer site.Close()
+	sc, err := NewScanner(Config{})
+	if err != nil {
+		t.Fatalf("NewScanner: %v", err)
+	}
+	defer sc.Close()
+
+	got := sc.Retest(context.Background(), []Finding{{Type: FindingNon200, Resource: site.URL + "/missing.js"}}, 1)
+	if len(got) != 1 || got[0].Status != RetestSkipped || got[0].Detail == "" {
+		t.Errorf("Retest() = %+v, want the resource skipped with the reason", got)
+	}
+}
+
+func TestRetestCancelled(t *testing.T) {
+	sc, err := NewScanner(Config{})
+	if err != nil {
+		t.Fatalf("NewScanner: %v", err)
+	}
+	defer sc.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if got := sc.Retest(ctx, []Finding{{Type: FindingNon200, Resource: "https://cdn.example.com/app.js"}}, 1); len(got) != 0 {
+		t.Errorf("Retest() = %+v after the retest was cancelled", got)
+	}
+}
+
+func TestLoadRetestInput(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		want    []Finding
+	}{
+		{
+			"non-200 URLs",
+			`{"LogNon200Queries": {"4xx": {"https://example.com/": {"script[src]": [{"url": "https://cdn.example.com/app.js", "status": 404}]}}}}`,
+			[]Finding{{Type: FindingNon200, Page: "https://example.com/", Resource: "https://cdn.example.com/app.js", Rule: "script[src]"}},
+		},
+		{
+			"non-200 URLs of older versions",
+			`{"LogNon200Queries": {"https://example.com/": {"script[src]": ["https://cdn.example.com/app.js"]}}}`,
+			[]Finding{{Type: FindingNon200, Page: "https://example.com/", Resource: "https://cdn.example.com/app.js", Rule: "script[src]"}},
+		},
+		{
+			"dangling domains",
+			`{"DanglingDomains": [{"host": "gone.example.com", "status": "NXDOMAIN", "pages": ["https://example.com/a", "https://example.com/b"]}]}`,
+			[]Finding{
+				{Type: FindingDangling, Page: "https://example.com/a", Resource: "gone.example.com", Detail: "NXDOMAIN"},
+				{Type: FindingDangling, Page: "https://example.com/b", Resource: "gone.example.com", Detail: "NXDOMAIN"},
+			},
+		},
+		{
+			"JSON lines",
+			`{"type": "non-200", "page": "https://example.com/", "resource": "https://cdn.example.com/app.js"}` + "\n\n" +
+				`{"type": "secret", "page": "https://example.com/", "resource": "https://example.com/app.js"}`,
+			[]Finding{
+				{Type: FindingNon200, Page: "https://example.com/", Resource: "https://cdn.example.com/app.js"},
+				{Type: FindingSecret, Page: "https://example.com/", Resource: "https://example.com/app.js"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := LoadRetestInput(write("input.json", tt.content))
+			if err != nil {
+				t.Fatalf("LoadRetestInput: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("LoadRetestInput() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadRetestInput(write("summary.json", `{"pages": 3}`+"\n"+`[1, 2]`)); err == nil {
+		t.Error("a file that doesn't have findings was loaded")
+	}
+}
@@ -0,0 +1,93 @@
+package secondorder
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// Status codes retried when RetryStatus is empty, the ones load balancers and CDNs return when the origin is briefly unavailable
+var defaultRetryStatus = []int{502, 503, 504}
+
+// retryTransport sends a request again when it fails or its response has one of the retried status codes
+// waiting backoff, then twice as long, and so on between the attempts
+// a transient 503 would otherwise be reported as a dead resource, or leave a page out of the crawl
+type retryTransport struct {
+	transport http.RoundTripper
+	retries   int
+	backoff   time.Duration
+	status    map[int]bool
+}
+
+// newRetryTransport wraps a transport with the retry settings of the options, or returns it as is when retries are disabled
+func newRetryTransport(transport http.RoundTripper, options Options) http.RoundTripper {
+	if options.Retries < 1 {
+		return transport
+	}
+	statuses := options.RetryStatus
+	if len(statuses) == 0 {
+		statuses = defaultRetryStatus
+	}
+	t := &retryTransport{
+		transport: transport,
+		retries:   options.Retries,
+		backoff:   options.RetryBackoff,
+		status:    make(map[int]bool, len(statuses)),
+	}
+	if t.backoff <= 0 {
+		t.backoff = time.Second
+	}
+	for _, status := range statuses {
+		t.status[status] = true
+	}
+	return t
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	wait := t.backoff
+	for attempt := 0; ; attempt++ {
+		res, err := t.transport.RoundTrip(req)
+		if attempt == t.retries || !t.retryable(ctx, res, err) {
+			return res, err
+		}
+		// Request bodies that can't be read again are only sent once
+		if req.Body != nil && req.GetBody == nil {
+			return res, err
+		}
+		if res != nil {
+			io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+			res.Body.Close()
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		wait *= 2
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+	}
+}
+
+// retryable reports whether a response or error is worth another attempt
+// requests of a cancelled scan and hosts that don't exist aren't
+func (t *retryTransport) retryable(ctx context.Context, res *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		return !isNXDOMAIN(err)
+	}
+	return t.status[res.StatusCode]
+}
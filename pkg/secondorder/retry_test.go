@@ -0,0 +1,117 @@
+package secondorder
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryTransport(t *testing.T) {
+	refused := errors.New("connection refused")
+	nxdomain := &net.DNSError{Err: "no such host", Name: "gone.example.com", IsNotFound: true}
+	tests := []struct {
+		name    string
+		options Options
+		// What each attempt gets, a status code or an error
+		answers  []interface{}
+		attempts int
+		status   int
+		err      error
+	}{
+		{name: "transient 503", options: Options{Retries: 2}, answers: []interface{}{503, 503, 200}, attempts: 3, status: 200},
+		{name: "retries run out", options: Options{Retries: 2}, answers: []interface{}{502, 504, 503, 200}, attempts: 3, status: 503},
+		{name: "network error", options: Options{Retries: 2}, answers: []interface{}{refused, 200}, attempts: 2, status: 200},
+		{name: "404 isn't retried", options: Options{Retries: 2}, answers: []interface{}{404, 200}, attempts: 1, status: 404},
+		{name: "500 isn't retried by default", options: Options{Retries: 2}, answers: []interface{}{500, 200}, attempts: 1, status: 500},
+		{name: "retried status codes", options: Options{Retries: 2, RetryStatus: []int{500}}, answers: []interface{}{500, 503, 200}, attempts: 2, status: 503},
+		{name: "host that doesn't exist", options: Options{Retries: 2}, answers: []interface{}{nxdomain, 200}, attempts: 1, err: nxdomain},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			tt.options.RetryBackoff = time.Millisecond
+			transport := newRetryTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				answer := tt.answers[attempts]
+				attempts++
+				if err, ok := answer.(error); ok {
+					return nil, err
+				}
+				return respond(req, answer.(int)), nil
+			}), tt.options)
+			req, _ := http.NewRequest(http.MethodGet, "https://cdn.example.com/app.js", nil)
+			res, err := transport.RoundTrip(req)
+			if attempts != tt.attempts {
+				t.Errorf("%d attempts, want %d", attempts, tt.attempts)
+			}
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Errorf("error = %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if err != nil || res.StatusCode != tt.status {
+				t.Errorf("RoundTrip() = %v, %v, want status %d", res, err, tt.status)
+			}
+		})
+	}
+}
+
+func TestRetryTransportDisabled(t *testing.T) {
+	inner := roundTripFunc(func(req *http.Request) (*http.Response, error) { return respond(req, 503), nil })
+	if _, ok := newRetryTransport(inner, Options{}).(*retryTransport); ok {
+		t.Error("requests are retried with Retries set to 0")
+	}
+}
+
+func TestRetryTransportBodies(t *testing.T) {
+	var bodies []string
+	transport := newRetryTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := ioutil.ReadAll(req.Body)
+		bodies = append(bodies, string(body))
+		return respond(req, 503), nil
+	}), Options{Retries: 2, RetryBackoff: time.Millisecond})
+
+	// A body that can be read again is sent with every attempt
+	req, _ := http.NewRequest(http.MethodPost, "https://example.com/login", strings.NewReader("user=a"))
+	transport.RoundTrip(req)
+	if want := []string{"user=a", "user=a", "user=a"}; !reflect.DeepEqual(bodies, want) {
+		t.Errorf("bodies = %q, want %q", bodies, want)
+	}
+
+	// A body that can't is only sent once
+	bodies = nil
+	req, _ = http.NewRequest(http.MethodPost, "https://example.com/login", ioutil.NopCloser(strings.NewReader("user=a")))
+	req.GetBody = nil
+	transport.RoundTrip(req)
+	if len(bodies) != 1 {
+		t.Errorf("a body that can't be read again was sent %d times", len(bodies))
+	}
+}
+
+func TestRetryTransportCancelled(t *testing.T) {
+	transport := newRetryTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		return respond(req, 503), nil
+	}), Options{Retries: 5, RetryBackoff: time.Hour})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "https://cdn.example.com/app.js", nil)
+	done := make(chan error)
+	go func() {
+		_, err := transport.RoundTrip(req)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("error = %v, want the cancellation of the scan", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request of a cancelled scan is still waiting to be retried")
+	}
+}
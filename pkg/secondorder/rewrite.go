@@ -0,0 +1,37 @@
+package secondorder
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// RewriteRule replaces the matches of Pattern in the links found in pages, and in the pages the crawl starts from, before they're crawled
+// Replacement can refer to the groups of Pattern with $1, $2... or ${name}
+// it forces a locale, strips session IDs, or maps the production host to a staging one
+type RewriteRule struct {
+	Pattern     string
+	Replacement string
+
+	pattern *regexp.Regexp
+}
+
+// compileRewrites compiles rewrite rules into a copy, so the caller's configuration isn't modified
+func compileRewrites(rules []RewriteRule) ([]RewriteRule, error) {
+	compiled := append([]RewriteRule(nil), rules...)
+	for i, rule := range compiled {
+		pattern, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rewrite pattern %q: %v", rule.Pattern, err)
+		}
+		compiled[i].pattern = pattern
+	}
+	return compiled, nil
+}
+
+// rewrite applies every rewrite rule to a URL, in the order they're configured
+func (config *Config) rewrite(u string) string {
+	for _, rule := range config.Rewrites {
+		u = rule.pattern.ReplaceAllString(u, rule.Replacement)
+	}
+	return u
+}
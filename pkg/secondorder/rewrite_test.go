@@ -0,0 +1,50 @@
+package secondorder
+
+import "testing"
+
+func TestRewrite(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []RewriteRule
+		url   string
+		want  string
+	}{
+		{"no rules", nil, "https://example.com/a", "https://example.com/a"},
+		{"no match", []RewriteRule{{Pattern: `/fr/`, Replacement: "/en/"}}, "https://example.com/de/a", "https://example.com/de/a"},
+		{"locale", []RewriteRule{{Pattern: `/(?:fr|de)/`, Replacement: "/en/"}}, "https://example.com/fr/a", "https://example.com/en/a"},
+		{"session ID", []RewriteRule{{Pattern: `[?&]PHPSESSID=[^&]*`, Replacement: ""}}, "https://example.com/a?PHPSESSID=abc", "https://example.com/a"},
+		{"numbered group", []RewriteRule{{Pattern: `^https://www\.(example\.com)`, Replacement: "https://staging.$1"}}, "https://www.example.com/a", "https://staging.example.com/a"},
+		{"named group", []RewriteRule{{Pattern: `^https://(?P<host>[^/]+)/old/`, Replacement: "https://${host}/new/"}}, "https://example.com/old/a", "https://example.com/new/a"},
+		{"every match", []RewriteRule{{Pattern: `a`, Replacement: "b"}}, "https://example.com/aa", "https://exbmple.com/bb"},
+		{"in order", []RewriteRule{{Pattern: `/fr/`, Replacement: "/de/"}, {Pattern: `/de/`, Replacement: "/en/"}}, "https://example.com/fr/a", "https://example.com/en/a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := compileRewrites(tt.rules)
+			if err != nil {
+				t.Fatalf("compileRewrites: %v", err)
+			}
+			config := &Config{Rewrites: rules}
+			if got := config.rewrite(tt.url); got != tt.want {
+				t.Errorf("rewrite(%q) = %q, want %q", tt.url, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileRewrites(t *testing.T) {
+	rules := []RewriteRule{{Pattern: `/fr/`, Replacement: "/en/"}}
+	compiled, err := compileRewrites(rules)
+	if err != nil {
+		t.Fatalf("compileRewrites: %v", err)
+	}
+	if rules[0].pattern != nil {
+		t.Error("compileRewrites modified the rules it was given")
+	}
+	if compiled[0].pattern == nil {
+		t.Error("compileRewrites didn't compile the pattern")
+	}
+	if _, err := compileRewrites([]RewriteRule{{Pattern: `(`}}); err == nil {
+		t.Error("compileRewrites accepted an invalid pattern")
+	}
+}
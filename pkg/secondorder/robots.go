@@ -0,0 +1,125 @@
+package secondorder
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+	"github.com/temoto/robotstxt"
+)
+
+// Name the crawl goes by in robots.txt, the rules of its group apply, or the ones of * when there's none
+const robotsAgent = "second-order"
+
+// robotsRules are the robots.txt of the hosts a crawl requests pages from, each fetched once
+type robotsRules struct {
+	client *http.Client
+
+	mu    sync.Mutex
+	hosts map[string]*robotsHost
+}
+
+// robotsHost is the robots.txt of a host, and when the crawl delay it sets lets the next page of the host be requested
+type robotsHost struct {
+	once sync.Once
+	data *robotstxt.RobotsData
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newRobotsRules(transport http.RoundTripper) *robotsRules {
+	return &robotsRules{client: &http.Client{Transport: transport}, hosts: make(map[string]*robotsHost)}
+}
+
+// host returns the robots.txt of the host of u, fetching it the first time
+// a robots.txt that can't be fetched allows everything, like a missing one
+func (r *robotsRules) host(ctx context.Context, u *url.URL) *robotsHost {
+	key := u.Scheme + "://" + u.Host
+	r.mu.Lock()
+	h, ok := r.hosts[key]
+	if !ok {
+		h = &robotsHost{}
+		r.hosts[key] = h
+	}
+	r.mu.Unlock()
+
+	h.once.Do(func() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, key+"/robots.txt", nil)
+		if err != nil {
+			return
+		}
+		res, err := r.client.Do(req)
+		if err != nil {
+			logger.Debugf("Could not fetch %s/robots.txt, crawling the host without restrictions: %v", key, err)
+			return
+		}
+		defer res.Body.Close()
+		h.data, _ = robotstxt.FromResponse(res)
+	})
+	return h
+}
+
+// allows reports whether robots.txt lets the page be crawled
+func (h *robotsHost) allows(u *url.URL) bool {
+	if h.data == nil {
+		return true
+	}
+	path := u.EscapedPath()
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return h.data.TestAgent(path, robotsAgent)
+}
+
+// wait holds a request back until the crawl delay of the host passed since the previous one
+func (h *robotsHost) wait(ctx context.Context) {
+	if h.data == nil {
+		return
+	}
+	delay := h.data.FindGroup(robotsAgent).CrawlDelay
+	if delay <= 0 {
+		return
+	}
+	h.mu.Lock()
+	at := time.Now()
+	if h.next.After(at) {
+		at = h.next
+	}
+	h.next = at.Add(delay)
+	h.mu.Unlock()
+
+	select {
+	case <-time.After(time.Until(at)):
+	case <-ctx.Done():
+	}
+}
+
+// respectRobots keeps the crawl out of the pages robots.txt disallows, and spaces the requests to each host by its crawl delay
+// the disallowed pages are listed in the coverage
+func (s *scan) respectRobots(c *colly.Collector) {
+	c.OnRequest(func(r *colly.Request) {
+		// In a distributed crawl, the rules are applied by the instance that crawls the page
+		if s.shared != nil && !s.shared.owns(r) {
+			return
+		}
+		host := s.robots.host(s.ctx, r.URL)
+		if !host.allows(r.URL) {
+			s.coverage.add(SkipRobots, r.URL.String())
+			r.Abort()
+			if s.shared != nil {
+				s.shared.done(r)
+			}
+			return
+		}
+		host.wait(s.ctx)
+	})
+}
+
+// disallows reports whether a page is kept out of the crawl by robots.txt
+func (s *scan) disallows(u *url.URL) bool {
+	return s.robots != nil && !s.robots.host(s.ctx, u).allows(u)
+}
@@ -0,0 +1,189 @@
+package secondorder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How often the rules directory is checked for changes
+const rulesInterval = 10 * time.Second
+
+// RuleFile is a file of the rules directory, every .json file in it adds detection rules to the ones of the config file
+type RuleFile struct {
+	// Secret patterns, keyed by name, they override the default and config file rules of the same name
+	SecretRules map[string]string
+	// Fingerprints of unclaimed resources of hosting services, checked before the built-in ones
+	TakeoverFingerprints []TakeoverRule
+}
+
+// TakeoverRule is a takeover fingerprint of a rule file
+type TakeoverRule struct {
+	Service string
+	// Pattern of the hostnames of the service
+	CNAME string
+	// Pattern of the page the service serves for names that aren't claimed
+	Body string
+	// The service is vulnerable when its hostname doesn't resolve, instead of when its body matches
+	NXDOMAIN bool
+}
+
+// ruleSet is the detection rules the scans use, replaced as a whole when the rules directory changes
+type ruleSet struct {
+	secretRules          []secretRule
+	takeoverFingerprints []takeoverFingerprint
+}
+
+// rules returns the detection rules in use
+func (sc *Scanner) rules() *ruleSet {
+	sc.rulesMu.RLock()
+	defer sc.rulesMu.RUnlock()
+	return sc.ruleSet
+}
+
+// loadRules merges the rule files of a directory, in the order of their names, with the rules of the config file
+func loadRules(dir string, config Config) (*ruleSet, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+
+	secretPatterns := make(map[string]string)
+	for name, pattern := range config.SecretRules {
+		secretPatterns[name] = pattern
+	}
+	var fingerprints []takeoverFingerprint
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read rule file: %v", err)
+		}
+		var rules RuleFile
+		if err := json.Unmarshal(content, &rules); err != nil {
+			return nil, fmt.Errorf("could not decode rule file %s: %v", file, err)
+		}
+		for name, pattern := range rules.SecretRules {
+			secretPatterns[name] = pattern
+		}
+		for _, rule := range rules.TakeoverFingerprints {
+			fp, err := rule.compile()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", file, err)
+			}
+			fingerprints = append(fingerprints, fp)
+		}
+	}
+
+	set := &ruleSet{takeoverFingerprints: append(fingerprints, takeoverFingerprints...)}
+	if set.secretRules, err = compileSecretRules(secretPatterns); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+func (r TakeoverRule) compile() (takeoverFingerprint, error) {
+	fp := takeoverFingerprint{Service: r.Service, NXDOMAIN: r.NXDOMAIN}
+	var err error
+	if r.Service == "" || r.CNAME == "" {
+		return fp, fmt.Errorf("takeover fingerprint needs a Service and a CNAME pattern")
+	}
+	if fp.CNAME, err = regexp.Compile(r.CNAME); err != nil {
+		return fp, fmt.Errorf("takeover fingerprint %q: invalid CNAME pattern: %v", r.Service, err)
+	}
+	if !r.NXDOMAIN {
+		if fp.Body, err = regexp.Compile(r.Body); err != nil || r.Body == "" {
+			return fp, fmt.Errorf("takeover fingerprint %q: needs a valid Body pattern or NXDOMAIN", r.Service)
+		}
+	}
+	return fp, nil
+}
+
+// rulesVersion identifies the content of the rules directory by the names, sizes and modification times of its files
+func rulesVersion(dir string) string {
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	sort.Strings(files)
+	var version strings.Builder
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			fmt.Fprintf(&version, "%s:%d:%d;", file, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return version.String()
+}
+
+// watchRules reloads the rules when the rules directory changes, until stop is closed
+// pages crawled after a change use the new rules, and with RecheckRules the downloaded scripts are searched again
+// a directory with invalid rules is reported and the previous rules are kept
+func (sc *Scanner) watchRules(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	dir := sc.config.Options.RulesDir
+	version := rulesVersion(dir)
+	ticker := time.NewTicker(rulesInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+		current := rulesVersion(dir)
+		if current == version {
+			continue
+		}
+		version = current
+		set, err := loadRules(dir, sc.config)
+		if err != nil {
+			logger.Errorf("Could not reload the rules, the previous ones are kept: %v", err)
+			continue
+		}
+		sc.rulesMu.Lock()
+		sc.ruleSet = set
+		sc.rulesMu.Unlock()
+		logger.Infof("Reloaded the rules of %s", dir)
+
+		if sc.config.Options.RecheckRules {
+			sc.mu.Lock()
+			scans := append([]*scan(nil), sc.scans...)
+			sc.mu.Unlock()
+			for _, s := range scans {
+				s.recheckScripts()
+			}
+		}
+	}
+}
+
+// recheckScripts searches the downloaded scripts for secrets again, only new matches are reported
+func (s *scan) recheckScripts() {
+	if !s.config.Options.Secrets {
+		return
+	}
+	scripts, content := s.scripts.list()
+	for _, script := range scripts {
+		s.scanScript(script.Pages[0], script.URL, content[script.SHA256])
+	}
+}
+
+// rulesWatcher stops the watching of the rules directory
+type rulesWatcher struct {
+	once sync.Once
+	stop chan struct{}
+	done chan struct{}
+}
+
+func (w *rulesWatcher) close() {
+	if w == nil {
+		return
+	}
+	w.once.Do(func() {
+		close(w.stop)
+		<-w.done
+	})
+}
@@ -0,0 +1,91 @@
+package secondorder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// safeMethod reports whether a request method only reads, the only ones safe mode sends
+func safeMethod(method string) bool {
+	return method == "" || method == http.MethodGet || method == http.MethodHead
+}
+
+// activeFeatures lists the features of a configuration that send requests other than GET and HEAD to the targets
+// features that change state must be listed here, so safe mode refuses to run with them
+func activeFeatures(config Config) []string {
+	var features []string
+	if config.Login != nil && !safeMethod(loginMethod(config.Login)) {
+		features = append(features, "Login (sends a "+loginMethod(config.Login)+" request)")
+	}
+	if config.Options.GraphQL {
+		features = append(features, "GraphQL introspection (sends POST requests)")
+	}
+	return features
+}
+
+// checkSafe returns an error naming the active features of a configuration in safe mode
+func checkSafe(config Config) error {
+	if !config.Options.Safe {
+		return nil
+	}
+	if features := activeFeatures(config); len(features) > 0 {
+		return fmt.Errorf("safe mode only sends GET and HEAD requests, it can't be used with %s", strings.Join(features, ", "))
+	}
+	return nil
+}
+
+// guard wraps the transport requests leave through in a safeTransport in safe mode
+func (o Options) guard(transport http.RoundTripper) http.RoundTripper {
+	if !o.Safe {
+		return transport
+	}
+	return &safeTransport{transport: transport}
+}
+
+// safeTransport refuses the requests of any other method than GET and HEAD, whichever part of the scanner sends them
+// it wraps the transports requests leave through, so nothing reaches the network without going through it
+type safeTransport struct {
+	transport http.RoundTripper
+}
+
+func (t *safeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !safeMethod(req.Method) {
+		if req.Body != nil {
+			req.Body.Close()
+		}
+		return nil, fmt.Errorf("safe mode: refusing to send a %s request to %s", req.Method, req.URL.Redacted())
+	}
+	return t.transport.RoundTrip(req)
+}
+
+// interceptRequests holds back the requests of the page of a browser tab: with safe, the ones that aren't GET or HEAD fail
+// so the scripts of a rendered page can't submit forms or call endpoints that change state, and the others wait for
+// the rate of the engagement, like the requests of the crawl
+func interceptRequests(tab context.Context, safe bool, rate *rateLimiter) chromedp.Action {
+	chromedp.ListenTarget(tab, func(ev interface{}) {
+		paused, ok := ev.(*fetch.EventRequestPaused)
+		if !ok {
+			return
+		}
+		// Answering from the listener would block the events of the tab
+		go func() {
+			executor := cdp.WithExecutor(tab, chromedp.FromContext(tab).Target)
+			if safe && !safeMethod(paused.Request.Method) {
+				fetch.FailRequest(paused.RequestID, network.ErrorReasonBlockedByClient).Do(executor)
+				return
+			}
+			// The tab is closed when waiting is cut short
+			if rate.wait(tab) == nil {
+				fetch.ContinueRequest(paused.RequestID).Do(executor)
+			}
+		}()
+	})
+	return fetch.Enable()
+}
@@ -0,0 +1,162 @@
+package secondorder
+
+import "fmt"
+
+// sarifRule describes a type of finding as a SARIF rule
+type sarifRule struct {
+	name        string
+	description string
+	// SARIF level: error, warning or note
+	level string
+	// Severity from 0 to 10, used by GitHub code scanning to rank the alerts
+	severity string
+}
+
+var sarifRules = map[string]sarifRule{
+	FindingTakeover:           {"TakeoverCandidate", "An external resource is hosted on a service where its name can be claimed", "error", "9.0"},
+	FindingSecret:             {"SecretInScript", "A script contains what looks like a secret or an API key", "error", "8.0"},
+	FindingDangling:           {"DanglingDomain", "An external resource is loaded from a host that doesn't resolve", "warning", "7.0"},
+	FindingDanglingLink:       {"DanglingLink", "A link points to a host that doesn't resolve, whoever registers it gets the visitors of the link", "warning", "5.5"},
+	FindingScriptChange:       {"ScriptChange", "An external script changed since the baseline run, or a page loads a script it didn't load then", "warning", "7.0"},
+	FindingInlineScriptChange: {"InlineScriptChange", "An inline script changed since the baseline run, or differs from the version most pages with it have", "warning", "6.5"},
+	FindingCMS:                {"UnregisteredCMSAsset", "A CMS plugin, theme or library is loaded from a dead host or isn't in the official registry", "warning", "6.0"},
+	FindingNon200:             {"DeadResource", "A referenced URL doesn't respond or doesn't return a 200 status code", "warning", "5.0"},
+	FindingGraphQL:            {"GraphQLIntrospection", "A GraphQL endpoint answers introspection queries", "note", "4.0"},
+}
+
+// findingLevel is the SARIF level of a finding, the one of its query rule's severity or of its type
+func findingLevel(f Finding) string {
+	if level, ok := severityLevels[f.Severity]; ok {
+		return level.level
+	}
+	return sarifRules[f.Type].level
+}
+
+// findingSeverity is the severity from 0 to 10 of a finding, the one of its query rule or of its type
+func findingSeverity(f Finding) string {
+	if level, ok := severityLevels[f.Severity]; ok {
+		return level.severity
+	}
+	return sarifRules[f.Type].severity
+}
+
+// SARIFLog is a SARIF 2.1.0 log, which GitHub code scanning and other SARIF consumers accept
+type SARIFLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name           string               `json:"name"`
+			InformationURI string               `json:"informationUri"`
+			Rules          []sarifReportingRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifReportingRule struct {
+	ID                   string            `json:"id"`
+	Name                 string            `json:"name"`
+	ShortDescription     sarifMessage      `json:"shortDescription"`
+	DefaultConfiguration map[string]string `json:"defaultConfiguration"`
+	Properties           map[string]string `json:"properties"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID    string            `json:"ruleId"`
+	Level     string            `json:"level"`
+	Message   sarifMessage      `json:"message"`
+	Locations []sarifLocation   `json:"locations"`
+	Partial   map[string]string `json:"partialFingerprints"`
+	// Confidence score of takeover candidates, which SARIF consumers sort by
+	Rank float64 `json:"rank,omitempty"`
+	// Query rule that found the resource, and its severity
+	Properties map[string]string `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation struct {
+		ArtifactLocation struct {
+			URI string `json:"uri"`
+		} `json:"artifactLocation"`
+		// The element that references the resource
+		Region *sarifRegion `json:"region,omitempty"`
+	} `json:"physicalLocation"`
+}
+
+type sarifRegion struct {
+	Snippet sarifMessage `json:"snippet"`
+}
+
+// SARIF converts the findings of every target into a SARIF log, every type of finding is a rule
+// the location of a finding is the page it was found on
+func SARIF(results []*Result) SARIFLog {
+	run := sarifRun{Results: []sarifResult{}}
+	run.Tool.Driver.Name = "second-order"
+	run.Tool.Driver.InformationURI = "https://github.com/mhmdiaa/second-order"
+
+	used := make(map[string]bool)
+	for _, r := range results {
+		for _, f := range r.Findings {
+			rule, ok := sarifRules[f.Type]
+			if !ok {
+				continue
+			}
+			used[f.Type] = true
+			result := sarifResult{
+				RuleID:  f.Type,
+				Level:   findingLevel(f),
+				Message: sarifMessage{Text: fmt.Sprintf("%s: %s (%s)", rule.description, f.Resource, f.Detail)},
+				// Code scanning tracks alerts across uploads with the fingerprint
+				Partial: map[string]string{"resource/v1": f.Type + ":" + f.Page + ":" + f.Resource},
+				Rank:    float64(f.Confidence),
+			}
+			if f.Rule != "" {
+				result.Properties = map[string]string{"rule": f.Rule}
+				if f.Severity != "" {
+					result.Properties["security-severity"] = findingSeverity(f)
+				}
+			}
+			var location sarifLocation
+			location.PhysicalLocation.ArtifactLocation.URI = f.Page
+			if location.PhysicalLocation.ArtifactLocation.URI == "" {
+				location.PhysicalLocation.ArtifactLocation.URI = r.Target
+			}
+			if f.Evidence != nil {
+				location.PhysicalLocation.Region = &sarifRegion{Snippet: sarifMessage{Text: f.Evidence.Element}}
+				if result.Properties == nil {
+					result.Properties = make(map[string]string)
+				}
+				result.Properties["css-path"] = f.Evidence.Path
+			}
+			result.Locations = []sarifLocation{location}
+			run.Results = append(run.Results, result)
+		}
+	}
+
+	run.Tool.Driver.Rules = []sarifReportingRule{}
+	for _, t := range sortedKeys(used) {
+		rule := sarifRules[t]
+		run.Tool.Driver.Rules = append(run.Tool.Driver.Rules, sarifReportingRule{
+			ID:                   t,
+			Name:                 rule.name,
+			ShortDescription:     sarifMessage{Text: rule.description},
+			DefaultConfiguration: map[string]string{"level": rule.level},
+			Properties:           map[string]string{"security-severity": rule.severity},
+		})
+	}
+
+	return SARIFLog{
+		Schema:  "https://json.schemastore.org/sarif-2.1.0.json",
+		Version: "2.1.0",
+		Runs:    []sarifRun{run},
+	}
+}
@@ -0,0 +1,533 @@
+package secondorder
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// results stores the gathered info of one type, keyed by page URL and then by query
+type results struct {
+	sync.RWMutex
+	content map[string]map[string][]string
+}
+
+func newResults() *results {
+	return &results{content: make(map[string]map[string][]string)}
+}
+
+func (r *results) add(page, key, value string) {
+	r.Lock()
+	defer r.Unlock()
+	if _, ok := r.content[page]; !ok {
+		r.content[page] = make(map[string][]string)
+	}
+	r.content[page][key] = append(r.content[page][key], value)
+}
+
+// copy returns a deep copy of the results
+func (r *results) copy() map[string]map[string][]string {
+	r.RLock()
+	defer r.RUnlock()
+	content := make(map[string]map[string][]string, len(r.content))
+	for page, queries := range r.content {
+		content[page] = make(map[string][]string, len(queries))
+		for query, values := range queries {
+			content[page][query] = append([]string(nil), values...)
+		}
+	}
+	return content
+}
+
+// restore adds results saved by an interrupted run
+func (r *results) restore(content map[string]map[string][]string) {
+	for page, queries := range content {
+		for query, values := range queries {
+			for _, value := range values {
+				r.add(page, query, value)
+			}
+		}
+	}
+}
+
+// resourceQueries are the tag-attribute queries of resources a page loads from other URLs
+var resourceQueries = []string{"script[src]", "link[href]", "img[src]", "iframe[src]"}
+
+// scan holds the state of crawling a single target
+// the state shared with the other targets comes from the Scanner that runs it
+type scan struct {
+	*Scanner
+	target    string
+	transport http.RoundTripper
+	// ctx is cancelled when the scan is stopped, the checks of the resources it found are given up
+	ctx context.Context
+	// stop cancels ctx once a budget of the scan is exhausted
+	stop context.CancelFunc
+	// Pages crawled so far, for MaxPages
+	crawled int64
+	// When the scan started and ended, and the requests it sent, for its summary
+	start time.Time
+	end   atomic.Value
+	stats scanStats
+	// Run returned, whether the scan ended or failed, guarded by the mutex of the Scanner
+	finished bool
+
+	loggedQueries       *results
+	loggedNon200Queries *results
+	loggedInline        *results
+	bodyMatches         *bodyMatches
+	evidence            *evidenceStore
+	pages               *inventory
+	cms                 *cmsFindings
+	documents           fetchedOnce
+	thirdParties        *hostSet
+	waybackDiffs        waybackDiffs
+	dangling            *danglingDomains
+	danglingLinks       *danglingLinks
+	findings            *findingList
+	openAPI             *openAPISpecs
+	graphQL             *graphQLEndpoints
+	takeovers           *takeoverCandidates
+	secrets             *secretList
+	scripts             *scriptStore
+	inlineScripts       *inlineScripts
+	jsEndpoints         *jsEndpoints
+	querySamples        *querySampler
+	coverage            *coverage
+	// Hosts the crawl follows links to
+	scope *crawlScope
+	// frontier is nil unless the crawl state is saved
+	frontier *frontier
+	// shared is the frontier kept in Redis, nil unless the crawl is distributed
+	shared *sharedFrontier
+	// robots are the robots.txt rules the crawl follows, nil unless RespectRobots is set
+	robots *robotsRules
+	// Runs the checks of the resources found in pages while the crawl goes on
+	pipeline *pipeline
+	// Pages queued by the interrupted run, crawled after the target
+	requeued []string
+	// Findings of the previous run of the target, nil unless monitoring
+	previous         map[string]Finding
+	previousFindings []Finding
+}
+
+func newScan(sc *Scanner, target string) *scan {
+	return &scan{
+		Scanner:             sc,
+		target:              target,
+		start:               time.Now(),
+		loggedQueries:       newResults(),
+		loggedNon200Queries: newResults(),
+		loggedInline:        newResults(),
+		bodyMatches:         newBodyMatches(),
+		evidence:            newEvidenceStore(),
+		pages:               newInventory(),
+		cms:                 newCMSFindings(),
+		thirdParties:        newHostSet(),
+		dangling:            newDanglingDomains(),
+		danglingLinks:       newDanglingLinks(),
+		findings:            &findingList{},
+		openAPI:             &openAPISpecs{},
+		graphQL:             &graphQLEndpoints{},
+		takeovers:           newTakeoverCandidates(),
+		secrets:             newSecretList(),
+		scripts:             newScriptStore(sc.config.Options.ScriptBaseline),
+		inlineScripts:       newInlineScripts(sc.config.Options.InlineBaseline),
+		jsEndpoints:         newJSEndpoints(),
+		querySamples:        newQuerySampler(sc.config.Options.QuerySamples),
+		coverage:            newCoverage(),
+	}
+}
+
+func (s *scan) run(ctx context.Context) error {
+	scope, err := newCrawlScope(s.config.Options, s.target)
+	if err != nil {
+		return fmt.Errorf("target URL is invalid: %v", err)
+	}
+	if !scope.allows(s.target) {
+		return fmt.Errorf("target %s is out of scope", s.target)
+	}
+	s.scope = scope
+
+	// Instantiate default collector, seeded pages alone are crawled with SeedOnly
+	depth := s.config.Options.Depth
+	if s.config.Options.SeedOnly {
+		depth = 1
+	}
+	c := colly.NewCollector(
+		colly.MaxDepth(depth),
+		colly.Async(),
+	)
+	c.Limit(&colly.LimitRule{
+		DomainGlob:  "*",
+		Parallelism: s.config.Options.Threads,
+		Delay:       s.config.Options.Delay,
+		RandomDelay: s.config.Options.RandomDelay,
+	})
+
+	// Don't end the session the login opened
+	if s.config.Login != nil && s.config.Login.logout != nil {
+		c.DisallowedURLFilters = []*regexp.Regexp{s.config.Login.logout}
+	}
+	// Only follow the links to the hosts in scope
+	s.scope.apply(c)
+
+	// Add headers
+	c.OnRequest(func(r *colly.Request) {
+		// Set a random user agent for each request
+		rand.Seed(time.Now().Unix())
+		n := rand.Intn(len(userAgents))
+		r.Headers.Set("User-Agent", userAgents[n])
+		// Add other headers
+		for header, value := range s.headers {
+			r.Headers.Set(header, value)
+		}
+	})
+
+	if s.shared != nil {
+		s.shared.apply(c)
+	}
+	if s.config.Options.RespectRobots {
+		s.robots = newRobotsRules(s.transport)
+		s.respectRobots(c)
+	}
+	if s.config.Options.MaxURLs > 0 {
+		s.limitURLs(c)
+	}
+
+	c.WithTransport(s.transport)
+	// Session cookies the target sets persist across the requests of the crawl and the verification requests
+	c.SetCookieJar(s.jar)
+	// The transport applies the timeout to each attempt
+	c.SetRequestTimeout(0)
+	s.pipeline = newPipeline(ctx, s.config.Options, s.frontier != nil, &s.stats)
+	if s.frontier != nil {
+		s.track(ctx, c)
+	}
+
+	// Keep an inventory of every crawled page, including the ones that returned an error status
+	c.OnResponse(func(r *colly.Response) {
+		logger.WithTarget(s.target).Debugf("Crawled %s (%d)", r.Request.URL, r.StatusCode)
+		s.addPage(r)
+		// Only HTML pages are scraped for links and resources
+		if !isHTML(r) {
+			s.coverage.add(SkipContentType, r.Request.URL.String())
+		} else if len(s.config.bodyRules) > 0 {
+			s.matchBody(r.Request.URL.String(), r.Body)
+		}
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		logger.WithTarget(s.target).Debugf("Could not crawl %s: %v", r.Request.URL, err)
+		if r.StatusCode != 0 {
+			s.addPage(r)
+		}
+	})
+	c.OnHTML("title", func(e *colly.HTMLElement) {
+		s.pages.setTitle(e.Request.URL.String(), e.Text)
+	})
+	c.OnHTML("script[src]", func(e *colly.HTMLElement) {
+		s.pages.addTechnologies(e.Request.URL.String(), fingerprintScript(e.Attr("src")))
+	})
+	c.OnHTML("meta[name=generator]", func(e *colly.HTMLElement) {
+		s.pages.addTechnologies(e.Request.URL.String(), fingerprintGenerator(e.Attr("content")))
+	})
+
+	// On every a element which has href attribute call callback
+	c.OnHTML("a[href]", func(e *colly.HTMLElement) {
+		link := e.Attr("href")
+		if len(s.config.Rewrites) > 0 {
+			link = s.config.rewrite(e.Request.AbsoluteURL(link))
+		}
+		u := e.Request.AbsoluteURL(link)
+		visited, _ := c.HasVisited(u)
+		crawlable := s.scope.allows(u) && !visited
+		// Links that only differ from crawled ones in sampled parameter values are treated as the same page
+		// only links that would be crawled take samples, out-of-scope, visited and too deep ones don't
+		tooDeep := c.MaxDepth > 0 && e.Request.Depth >= c.MaxDepth
+		if crawlable && !tooDeep && !s.querySamples.sample(u) {
+			s.coverage.add(SkipBudget, u)
+			return
+		}
+		// Print link if it's in-scope and has not been visited
+		if s.config.Options.LinkOutput != nil && crawlable {
+			fmt.Fprintln(s.config.Options.LinkOutput, link)
+		}
+
+		// Visit link found on page on a new thread, and keep track of the ones the limits of the crawl leave out
+		if err := e.Request.Visit(link); err != nil {
+			if reason := skipReason(s.scope, err, u); reason != "" {
+				s.coverage.add(reason, u)
+			}
+		}
+	})
+
+	if s.config.Options.CMSChecks {
+		for _, query := range resourceQueries {
+			_, attr := unpackQuerySelector(query)
+			c.OnHTML(query, func(e *colly.HTMLElement) {
+				page, asset := e.Request.URL.String(), e.Request.AbsoluteURL(e.Attr(attr))
+				s.pipeline.submit(s.pipeline.verify, page, func() { s.checkCMSAsset(page, asset) })
+			})
+		}
+	}
+
+	// Resolve every external host the target loads resources from, and in -dns-only mode the hosts it links to as well
+	// unless they're checked as links
+	domainQueries := resourceQueries
+	if s.config.Options.DNSOnly && !s.config.Options.CheckLinks {
+		domainQueries = append(resourceQueries, "a[href]")
+	}
+	for _, query := range domainQueries {
+		_, attr := unpackQuerySelector(query)
+		c.OnHTML(query, func(e *colly.HTMLElement) {
+			page, resource := e.Request.URL.String(), e.Request.AbsoluteURL(e.Attr(attr))
+			s.pipeline.submit(s.pipeline.dns, page, func() { s.checkDomain(page, resource) })
+		})
+	}
+
+	if s.config.Options.CheckLinks {
+		c.OnHTML("a[href]", func(e *colly.HTMLElement) {
+			page, link := e.Request.URL.String(), e.Request.AbsoluteURL(e.Attr("href"))
+			s.pipeline.submit(s.pipeline.dns, page, func() { s.checkLink(page, link) })
+		})
+	}
+
+	// Fingerprint the hosts of external scripts, stylesheets and frames against services that can be claimed
+	if s.config.Options.Takeover {
+		for _, query := range []string{"script[src]", "link[href]", "iframe[src]"} {
+			_, attr := unpackQuerySelector(query)
+			c.OnHTML(query, func(e *colly.HTMLElement) {
+				page, resource := e.Request.URL.String(), e.Request.AbsoluteURL(e.Attr(attr))
+				s.pipeline.submit(s.pipeline.verify, page, func() { s.checkTakeover(page, resource) })
+			})
+		}
+	}
+
+	// Every resource a page loads is published to the event sinks
+	for _, query := range resourceQueries {
+		query := query
+		_, attr := unpackQuerySelector(query)
+		c.OnHTML(query, func(e *colly.HTMLElement) {
+			if value := e.Attr(attr); value != "" {
+				resource := &Resource{Page: e.Request.URL.String(), Query: query, URL: e.Request.AbsoluteURL(value)}
+				s.events.publish(Event{Type: EventResourceFound, Target: s.target, Resource: resource})
+			}
+		})
+	}
+
+	// The third-party hosts of every page are compared with the Wayback Machine, and counted in the history of runs
+	for _, query := range resourceQueries {
+		_, attr := unpackQuerySelector(query)
+		c.OnHTML(query, func(e *colly.HTMLElement) {
+			if host, ok := s.thirdPartyHost(e.Request.AbsoluteURL(e.Attr(attr))); ok {
+				s.thirdParties.add(e.Request.URL.String(), host)
+			}
+		})
+	}
+
+	if s.config.Options.Manifests {
+		c.OnHTML("link[rel=manifest]", func(e *colly.HTMLElement) {
+			if u := e.Request.AbsoluteURL(e.Attr("href")); u != "" && s.documents.first(u) {
+				s.logDocumentReferences(e.Request.URL.String(), s.parseManifest(s.ctx, u))
+			}
+		})
+		c.OnHTML("meta[name=msapplication-config]", func(e *colly.HTMLElement) {
+			if u := e.Request.AbsoluteURL(e.Attr("content")); u != "" && s.documents.first(u) {
+				s.logDocumentReferences(e.Request.URL.String(), s.parseBrowserconfig(s.ctx, u))
+			}
+		})
+		// browserconfig.xml is picked up from the root of the site even if no page links to it
+		if u := resolveReference(s.target, "/browserconfig.xml"); s.documents.first(u) {
+			s.logDocumentReferences(u, s.parseBrowserconfig(s.ctx, u))
+		}
+	}
+
+	if s.config.Options.OpenAPI {
+		for _, query := range append(resourceQueries, "a[href]") {
+			_, attr := unpackQuerySelector(query)
+			c.OnHTML(query, func(e *colly.HTMLElement) {
+				if u := e.Request.AbsoluteURL(e.Attr(attr)); openAPIReference.MatchString(strings.SplitN(u, "?", 2)[0]) {
+					s.checkOpenAPIReference(e.Request.URL.String(), u)
+				}
+			})
+		}
+		// Swagger UI pages load their spec from a URL in an inline script
+		c.OnHTML("script:not([src])", func(e *colly.HTMLElement) {
+			for _, match := range openAPIInlineReference.FindAllStringSubmatch(e.Text, -1) {
+				s.checkOpenAPIReference(e.Request.URL.String(), e.Request.AbsoluteURL(match[1]))
+			}
+		})
+	}
+
+	if s.config.Options.Secrets {
+		c.OnHTML("script:not([src])", func(e *colly.HTMLElement) {
+			s.scanScript(e.Request.URL.String(), "inline", []byte(e.Text))
+		})
+	}
+	if s.config.Options.InlineScripts {
+		c.OnHTML("script:not([src])", func(e *colly.HTMLElement) {
+			if isJavaScript(e.Attr("type")) {
+				s.addInlineScript(e.Request.URL.String(), e.Text)
+			}
+		})
+	}
+	if s.config.Options.JSEndpoints {
+		c.OnHTML("script:not([src])", func(e *colly.HTMLElement) {
+			s.logJSEndpoints("inline:"+e.Request.URL.String(), []byte(e.Text))
+		})
+	}
+	// External scripts are downloaded once, and get the same analysis as inline scripts
+	if s.config.Options.Secrets || s.config.Options.SaveScripts || s.config.Options.JSEndpoints {
+		c.OnHTML("script[src]", func(e *colly.HTMLElement) {
+			page, script := e.Request.URL.String(), e.Request.AbsoluteURL(e.Attr("src"))
+			s.pipeline.submit(s.pipeline.verify, page, func() { s.analyzeExternalScript(page, script) })
+		})
+	}
+
+	// Server-rendered single page apps embed their state, and the API hosts in it, as JSON
+	if s.config.Options.InlineJSON {
+		c.OnHTML(inlineJSONScripts, func(e *colly.HTMLElement) {
+			s.logInlineJSON(e.Request.URL.String(), inlineJSONReferences(inlineJSONName(e.Attr("id"), e.Index), []byte(e.Text)))
+		})
+		c.OnHTML("script:not([src])", func(e *colly.HTMLElement) {
+			s.logInlineJSON(e.Request.URL.String(), inlineStateReferences(e.Text))
+		})
+	}
+
+	if s.config.Options.GraphQL {
+		for _, query := range append(resourceQueries, "a[href]", "form[action]") {
+			_, attr := unpackQuerySelector(query)
+			c.OnHTML(query, func(e *colly.HTMLElement) {
+				if u := e.Request.AbsoluteURL(e.Attr(attr)); graphQLEndpoint.MatchString(strings.SplitN(u, "?", 2)[0]) {
+					s.checkGraphQLEndpoint(e.Request.URL.String(), u)
+				}
+			})
+		}
+		// Single page apps keep the API URL in their scripts
+		c.OnHTML("script:not([src])", func(e *colly.HTMLElement) {
+			for _, match := range graphQLInlineReference.FindAllStringSubmatch(e.Text, -1) {
+				s.checkGraphQLEndpoint(e.Request.URL.String(), e.Request.AbsoluteURL(match[1]))
+			}
+		})
+		// /graphql is tried at the root of the site even if no page links to it
+		s.checkGraphQLEndpoint(s.target, resolveReference(s.target, "/graphql"))
+	}
+
+	// Register a function that logs HTML attributes, the values are keyed by the name of the rule
+	// the selectors can be lists, so the elements without the attribute are left out here rather than in the selector
+	for _, rule := range s.config.LogQueries {
+		rule := rule
+		c.OnHTML(rule.Selector, func(e *colly.HTMLElement) {
+			value, ok := e.DOM.Attr(rule.Attribute)
+			if !ok || s.isNoise(value) {
+				return
+			}
+			s.loggedQueries.add(e.Request.URL.String(), rule.Name, value)
+			s.evidence.add(e.Request.URL.String(), value, newEvidence(e.DOM))
+		})
+	}
+
+	// Register a function that logs URLs from HTML attributes if they return a non-200 response code
+	for _, rule := range s.config.LogNon200Queries {
+		rule := rule
+		detail := rule.Description
+		if detail == "" {
+			detail = rule.Name
+		}
+		c.OnHTML(rule.Selector, func(e *colly.HTMLElement) {
+			page, value := e.Request.URL.String(), e.Attr(rule.Attribute)
+			if !isValidURL(value) || s.isNoise(value) {
+				return
+			}
+			evidence := newEvidence(e.DOM)
+			s.pipeline.submit(s.pipeline.verify, page, func() {
+				if s.isDangling(s.ctx, value) {
+					s.evidence.add(page, value, evidence)
+					s.loggedNon200Queries.add(page, rule.Name, value)
+					s.report(Finding{Type: FindingNon200, Page: page, Resource: value, Detail: detail, Rule: rule.Name, Severity: rule.Severity, Response: s.response(value), Evidence: s.evidence.get(page, value)})
+				}
+			})
+		})
+	}
+
+	for _, tag := range s.config.LogInline {
+		tag := tag
+		c.OnHTML(tag, func(e *colly.HTMLElement) {
+			s.loggedInline.add(e.Request.URL.String(), tag, e.Text)
+		})
+	}
+
+	// Start scraping, from the seeded pages as well, or from them alone with SeedOnly
+	pages := s.seeds(ctx)
+	if !s.config.Options.SeedOnly {
+		pages = append([]string{s.target}, pages...)
+	}
+	// The pages the crawl starts from are rewritten like the links found in pages, the ones queued by an interrupted run as well
+	pages = append(pages, s.requeued...)
+	for i, u := range pages {
+		pages[i] = s.config.rewrite(u)
+	}
+	if s.shared != nil {
+		s.shared.crawl(ctx, c, s.target, pages)
+	} else {
+		for _, u := range pages {
+			c.Visit(u)
+		}
+	}
+	// Wait until threads are finished, and then for the checks of the last pages
+	c.Wait()
+	s.pipeline.close()
+
+	// The snapshots aren't worth waiting for once the scan is cancelled
+	if s.config.Options.WaybackMonths > 0 && ctx.Err() == nil {
+		diffs := s.compareWithWayback(ctx)
+		s.waybackDiffs.Lock()
+		s.waybackDiffs.content = diffs
+		s.waybackDiffs.Unlock()
+	}
+
+	return nil
+}
+
+// exhaust stops the scan because a budget ran out, what was found until then is kept
+func (s *scan) exhaust(budget string) {
+	if s.coverage.stop(budget) {
+		s.stop()
+	}
+}
+
+// limitURLs stops a crawl that runs away, in calendars or faceted search, after MaxURLs pages were requested
+// the pages found afterwards aren't requested and are listed in the coverage
+func (s *scan) limitURLs(c *colly.Collector) {
+	var requested int64
+	c.OnRequest(func(r *colly.Request) {
+		u := r.URL.String()
+		// The pages crawled by the interrupted run aren't requested again
+		if s.frontier != nil && s.frontier.wasCrawled(u) {
+			return
+		}
+		// In a distributed crawl, the pages this instance doesn't crawl are only queued
+		if s.shared != nil && !s.shared.owns(r) {
+			return
+		}
+		// The pages robots.txt disallows aren't requested
+		if s.disallows(r.URL) {
+			return
+		}
+		if atomic.AddInt64(&requested, 1) > int64(s.config.Options.MaxURLs) {
+			s.coverage.add(SkipMaxURLs, u)
+			r.Abort()
+			if s.shared != nil {
+				s.shared.done(r)
+			}
+		}
+	})
+}
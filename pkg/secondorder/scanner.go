@@ -0,0 +1,494 @@
+package secondorder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/publicsuffix"
+)
+
+// Scanner crawls targets and checks what they reference
+// everything targets share (the DNS cache, the request limits and the finding sinks) lives here, so one Scanner can run several targets at the same time
+type Scanner struct {
+	config  Config
+	headers map[string]string
+
+	resolver *cachingResolver
+	// verifyClient is used to check whether URLs found in pages are still alive
+	verifyClient *http.Client
+	// internal refuses the verification requests to internal addresses, nil with AllowInternal
+	internal *internalGuard
+	// jar keeps the cookies the targets set and the ones given in Cookies, for the crawl and the verification requests
+	jar http.CookieJar
+	// requestSlots limits the concurrent requests of all targets
+	requestSlots chan struct{}
+	// requestRate limits the requests per second of all targets: the crawl, verification and the rendered pages
+	requestRate *rateLimiter
+	// throttle holds back the requests to the hosts that answer 429
+	throttle *throttle
+	// budget counts and caps the verification requests to each third party
+	budget *thirdPartyBudget
+	// ipSlots limits the concurrent requests to each server, nil unless MaxPerIP is set
+	ipSlots *ipSlots
+	// events of all targets go through the bus to the sinks
+	events eventBus
+	// renderer is the headless browser pages are rendered in, nil unless Render is set
+	renderer *renderer
+	// redis is the server of the distributed crawl, nil unless Redis is set
+	redis *redisClient
+	// resumed is the crawl state of the interrupted run, nil unless Resume is set
+	resumed *CrawlState
+	// Stop and wait for the periodic saving of the crawl state
+	stopState chan struct{}
+	stateDone chan struct{}
+	// Detection rules in use, replaced when the rules directory changes
+	rulesMu      sync.RWMutex
+	ruleSet      *ruleSet
+	rulesWatcher *rulesWatcher
+	// responses are how verified URLs responded, keyed by URL
+	responses sync.Map
+	// refused are why the verification requests of URLs weren't sent, keyed by URL
+	refused sync.Map
+	// verdicts are whether verified URLs are dead, keyed by URL
+	verdicts sync.Map
+
+	mu    sync.Mutex
+	scans []*scan
+	// Transports of the egresses the targets are crawled through, the runs through the same egress share their connections
+	transports map[Egress]*http.Transport
+	// Set by the API server: its finished scans are evicted, and every scan checks the resources checked before it started again
+	served bool
+}
+
+// NewScanner validates the configuration and sets up what the targets share
+func NewScanner(config Config) (*Scanner, error) {
+	if err := config.compile(); err != nil {
+		return nil, err
+	}
+	if err := checkSafe(config); err != nil {
+		return nil, err
+	}
+	if config.Options.MaxThreads == 0 {
+		config.Options.MaxThreads = 50
+	}
+	if config.Options.DNSThreads == 0 {
+		config.Options.DNSThreads = 20
+	}
+	if config.Options.VerifyThreads == 0 {
+		config.Options.VerifyThreads = 50
+	}
+	if config.Options.QueueSize == 0 {
+		config.Options.QueueSize = 1000
+	}
+
+	sc := &Scanner{
+		config:  config,
+		headers: make(map[string]string),
+	}
+	// The headers of the options override the ones of the configuration file
+	for name, value := range config.Headers {
+		sc.headers[name] = value
+	}
+	for name, value := range config.Options.Headers {
+		sc.headers[name] = value
+	}
+	// Identify the scan in every request, unless the user set the headers explicitly
+	for name, value := range config.Engagement.headers() {
+		if _, ok := sc.headers[name]; !ok {
+			sc.headers[name] = value
+		}
+	}
+
+	var err error
+	sc.resolver, err = newCachingResolver(config.Options.DNSThreads, config.Options.Resolver)
+	if err != nil {
+		return nil, err
+	}
+	verifyTransport, err := config.Egress.transport(false, sc.resolver)
+	if err != nil {
+		return nil, err
+	}
+	verifyTimeout := 5 * time.Second
+	if config.Options.Timeout > 0 {
+		verifyTimeout = config.Options.Timeout
+	}
+	sc.throttle = newThrottle()
+	sc.requestSlots = newRequestSlots(config.Options.MaxThreads)
+	sc.ipSlots = newIPSlots(config.Options.MaxPerIP, sc.resolver)
+	// The rate of the engagement applies to every request sent to the targets and to what their pages reference
+	sc.requestRate = newRateLimiter(config.Engagement.MaxRequestsPerSecond)
+	// The timeout applies to each attempt, the time spent waiting for retries, rate limits and slots doesn't count
+	sc.budget = newThirdPartyBudget(config.Options.MaxPerThirdParty)
+	// Cookies are scoped by registrable domain, so the session of a target isn't sent to the third parties it loads
+	sc.jar, err = cookiejar.New(&cookiejar.Options{PublicSuffixList: publicsuffix.List})
+	if err != nil {
+		return nil, err
+	}
+	// Verification and every other request about what pages reference take the slots and the rate of the crawl
+	verifyTimed := &timeoutTransport{transport: config.Options.guard(&countingTransport{transport: verifyTransport}), timeout: verifyTimeout}
+	var verify http.RoundTripper = &budgetTransport{
+		transport: newRetryTransport(&throttleTransport{
+			transport: newLimitedTransport(verifyTimed, sc.requestSlots, sc.requestRate, sc.ipSlots),
+			throttle:  sc.throttle,
+		}, config.Options),
+		budget: sc.budget,
+	}
+	// The URLs pages reference aren't requested on internal addresses, so a page can't make the scanner probe them
+	if !config.Options.AllowInternal {
+		sc.internal = newInternalGuard(verify, sc.resolver)
+		verify = sc.internal
+	}
+	sc.verifyClient = &http.Client{Jar: sc.jar, Transport: verify}
+
+	if config.Options.Render {
+		sc.renderer, err = newRenderer(sc.headers, config.Options.Insecure, config.Options.Safe, sc.requestRate, config.Egress)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sc.ruleSet = &ruleSet{secretRules: config.secretRules, takeoverFingerprints: takeoverFingerprints}
+	if config.Options.RulesDir != "" {
+		if sc.ruleSet, err = loadRules(config.Options.RulesDir, config); err != nil {
+			return nil, err
+		}
+		sc.rulesWatcher = &rulesWatcher{stop: make(chan struct{}), done: make(chan struct{})}
+		go sc.watchRules(sc.rulesWatcher.stop, sc.rulesWatcher.done)
+	}
+
+	// Every run of a monitored target starts from scratch
+	if config.Options.Monitor && (config.Options.StateFile != "" || config.Options.Redis != "") {
+		return nil, fmt.Errorf("monitored targets are crawled from scratch every time, the crawl state can't be saved or kept in Redis")
+	}
+	if config.Options.Redis != "" {
+		// The frontier is in Redis, there's nothing local to save or resume
+		if config.Options.StateFile != "" {
+			return nil, fmt.Errorf("a distributed crawl can't save its state, the frontier is kept in Redis")
+		}
+		if sc.redis, err = newRedisClient(config.Options.Redis); err != nil {
+			return nil, err
+		}
+		sc.AddSink(&redisSink{client: sc.redis, run: config.Options.RedisRun})
+	}
+	if config.Options.Resume {
+		if config.Options.StateFile == "" {
+			return nil, fmt.Errorf("resuming needs the state file of the interrupted run")
+		}
+		if sc.resumed, err = LoadCrawlState(config.Options.StateFile); err != nil {
+			return nil, err
+		}
+	}
+	if config.Options.StateFile != "" {
+		sc.stopState, sc.stateDone = make(chan struct{}), make(chan struct{})
+		go sc.saveStates(sc.stopState, sc.stateDone)
+	}
+
+	if config.Webhook != nil {
+		sink, err := newWebhookSink(*config.Webhook)
+		if err != nil {
+			return nil, err
+		}
+		sc.AddSink(sink)
+	}
+	for service, notifier := range map[string]*Notifier{notifySlack: config.Slack, notifyDiscord: config.Discord} {
+		if notifier == nil {
+			continue
+		}
+		sink, err := newNotifierSink(service, *notifier)
+		if err != nil {
+			return nil, err
+		}
+		sc.AddSink(sink)
+	}
+	return sc, nil
+}
+
+// AddSink sends every finding to sink as soon as it's found
+// it must be called before the first Run
+func (sc *Scanner) AddSink(sink FindingSink) {
+	sc.events.add(findingSink{sink})
+}
+
+// AddEventSink sends every event of the scans to sink: crawled pages, the resources they load and the findings
+// it must be called before the first Run
+func (sc *Scanner) AddEventSink(sink EventSink) {
+	sc.events.add(sink)
+}
+
+// Run crawls a target and returns what was found on it
+// cancelling ctx stops the crawl, and the results found until then are returned
+func (sc *Scanner) Run(ctx context.Context, target string) (*Result, error) {
+	transport, err := sc.transportFor(target)
+	if err != nil {
+		return nil, err
+	}
+	// The budgets of the target stop its scan without stopping the others
+	scanCtx, stop := context.WithCancel(ctx)
+	defer stop()
+	s := newScan(sc, target)
+	scanCtx = withScanStats(scanCtx, &s.stats)
+	s.ctx, s.stop = scanCtx, stop
+	if hostname, err := getHostname(target); err == nil {
+		sc.budget.addTarget(hostname)
+		sc.internal.addTarget(hostname)
+		sc.addCookies(target, hostname)
+	}
+	if sc.redis != nil {
+		s.shared = newSharedFrontier(sc.redis, sc.config.Options.RedisRun, target, sc.config.Options.Threads)
+	}
+	if sc.config.Options.StateFile != "" {
+		s.frontier = newFrontier()
+		if sc.resumed != nil && sc.resumed.Targets[target] != nil {
+			s.requeued = s.resume(sc.resumed.Targets[target])
+		}
+	}
+	// The transports of all targets share the same slots so the global request limit applies to the whole run
+	// retries and rate limited requests wait outside the slots, so a struggling server doesn't hold back the other targets
+	pageTimeout := 10 * time.Second
+	if sc.config.Options.Timeout > 0 {
+		pageTimeout = sc.config.Options.Timeout
+	}
+	timed := &timeoutTransport{transport: sc.config.Options.guard(&countingTransport{transport: transport}), timeout: pageTimeout}
+	var limited http.RoundTripper = newLimitedTransport(timed, sc.requestSlots, sc.requestRate, sc.ipSlots)
+	limited = &throttleTransport{transport: limited, throttle: sc.throttle}
+	if sc.renderer != nil {
+		limited = &renderTransport{transport: limited, renderer: sc.renderer}
+	}
+	s.transport = &contextTransport{ctx: scanCtx, transport: newRetryTransport(limited, sc.config.Options)}
+
+	sc.mu.Lock()
+	// While monitoring, the run replaces the previous one of the target, and is compared with it
+	if sc.config.Options.Monitor {
+		for i, previous := range sc.scans {
+			if previous.target == target {
+				previous.findings.Lock()
+				s.previousFindings = append([]Finding{}, previous.findings.findings...)
+				previous.findings.Unlock()
+				s.previous = findingIndex(s.previousFindings)
+				sc.scans = append(sc.scans[:i], sc.scans[i+1:]...)
+				break
+			}
+		}
+	}
+	sc.scans = append(sc.scans, s)
+	sc.mu.Unlock()
+	defer func() {
+		sc.mu.Lock()
+		s.finished = true
+		sc.mu.Unlock()
+	}()
+
+	sc.events.publish(Event{Type: EventTargetStarted, Target: target})
+	if sc.config.Login != nil {
+		if err := s.login(scanCtx, sc.config.Login); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.run(scanCtx); err != nil {
+		return nil, err
+	}
+	// Inline scripts can only be compared across pages once every page was crawled, or once a budget stopped the crawl
+	if sc.config.Options.InlineScripts && ctx.Err() == nil {
+		s.diffInlineScripts()
+	}
+	s.end.Store(time.Now())
+	sc.events.publish(Event{Type: EventTargetFinished, Target: target})
+	return s.result(), nil
+}
+
+// transportFor returns the transport of the egress a target is crawled through, it's built the first time the egress is used
+// so monitored and served targets don't leave a pool of connections behind with every run
+func (sc *Scanner) transportFor(target string) (*http.Transport, error) {
+	egress := sc.config.egressFor(target)
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	if transport, ok := sc.transports[egress]; ok {
+		return transport, nil
+	}
+	transport, err := egress.transport(sc.config.Options.Insecure, sc.resolver)
+	if err != nil {
+		return nil, err
+	}
+	if sc.transports == nil {
+		sc.transports = make(map[Egress]*http.Transport)
+	}
+	sc.transports[egress] = transport
+	return transport, nil
+}
+
+// evict forgets the finished scans of a target once their result is kept elsewhere, with the verdicts and the DNS lookups
+// the scans that are still running don't need: served scans only use the verdicts reached since they started
+func (sc *Scanner) evict(target string) {
+	sc.mu.Lock()
+	oldest := time.Now()
+	scans := make([]*scan, 0, len(sc.scans))
+	for _, s := range sc.scans {
+		if s.target == target && s.finished {
+			continue
+		}
+		scans = append(scans, s)
+		if s.start.Before(oldest) {
+			oldest = s.start
+		}
+	}
+	sc.scans = scans
+	sc.mu.Unlock()
+
+	sc.verdicts.Range(func(u, v interface{}) bool {
+		entry := v.(*verdict)
+		select {
+		case <-entry.done:
+			if entry.checked.Before(oldest) {
+				sc.verdicts.Delete(u)
+				sc.responses.Delete(u)
+				sc.refused.Delete(u)
+			}
+		default:
+		}
+		return true
+	})
+	sc.resolver.prune()
+}
+
+// Results returns what was found on every target that was run so far, including the ones that are still running
+func (sc *Scanner) Results() []*Result {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	list := make([]*Result, len(sc.scans))
+	for i, s := range sc.scans {
+		list[i] = s.result()
+	}
+	return list
+}
+
+// Result returns what was found on the last run of a target so far, nil if it wasn't run
+func (sc *Scanner) Result(target string) *Result {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	for i := len(sc.scans) - 1; i >= 0; i-- {
+		if sc.scans[i].target == target {
+			return sc.scans[i].result()
+		}
+	}
+	return nil
+}
+
+// Close delivers the findings the sinks still hold, saves the final crawl state and stops the browser, it's called once all targets are done
+func (sc *Scanner) Close() error {
+	if sc.renderer != nil {
+		sc.renderer.close()
+	}
+	sc.rulesWatcher.close()
+	sc.mu.Lock()
+	for _, transport := range sc.transports {
+		transport.CloseIdleConnections()
+	}
+	sc.mu.Unlock()
+	err := sc.events.close()
+	if sc.stopState != nil {
+		close(sc.stopState)
+		<-sc.stateDone
+		sc.stopState = nil
+		if stateErr := sc.saveState(); stateErr != nil && err == nil {
+			err = fmt.Errorf("could not save the crawl state: %v", stateErr)
+		}
+	}
+	return err
+}
+
+func (sc *Scanner) isNotFound(ctx context.Context, url string) bool {
+	return sc.isNotFoundWith(ctx, url, "", sc.addHeaders)
+}
+
+// isNotFoundWith is isNotFound with control over the method and the credentials of the request
+func (sc *Scanner) isNotFoundWith(ctx context.Context, u, method string, authenticate func(*http.Request)) bool {
+	// Golang's native HTTP client can't read URLs in this format: "//example.com"
+	if strings.HasPrefix(u, "//") {
+		return sc.isNotFoundWith(ctx, "http:"+u, method, authenticate)
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	// A domain that doesn't resolve is the best candidate there is, no need to send a request
+	if _, err := sc.resolver.LookupHost(ctx, parsed.Hostname()); isNXDOMAIN(err) {
+		sc.responses.Store(u, &Response{Error: "NXDOMAIN"})
+		return true
+	}
+
+	res, err := sc.send(ctx, u, method, authenticate)
+	// If it doesn't respond at all, it could be an unregistered domain
+	// unless it wasn't requested because the third party got enough requests
+	if err != nil {
+		return !unsent(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode == 404 {
+		return true
+	}
+	return false
+}
+
+// status returns the status code a verified URL responded with, or 0 if it didn't respond or wasn't requested
+func (sc *Scanner) status(url string) int {
+	if response := sc.response(url); response != nil {
+		return response.Status
+	}
+	return 0
+}
+
+// addCookies puts the cookies of the options in the jar for the target and its subdomains
+func (sc *Scanner) addCookies(target, hostname string) {
+	if len(sc.config.Options.Cookies) == 0 {
+		return
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return
+	}
+	cookies := make([]*http.Cookie, 0, len(sc.config.Options.Cookies))
+	for name, value := range sc.config.Options.Cookies {
+		cookies = append(cookies, &http.Cookie{Name: name, Value: value, Domain: hostname, Path: "/"})
+	}
+	sc.jar.SetCookies(u, cookies)
+}
+
+// addHeaders adds the headers of the scan to a request
+func (sc *Scanner) addHeaders(req *http.Request) {
+	for name, value := range sc.headers {
+		req.Header.Set(name, value)
+	}
+}
+
+var userAgents = []string{
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/108.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/108.0.0.0 Safari/537.36 Edg/108.0.1462.54",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:108.0) Gecko/20100101 Firefox/108.0",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/108.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.2 Safari/605.1.15",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/108.0.0.0 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_6) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.2 Safari/605.1.15",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/108.0.0.0 Safari/537.36 Edg/108.0.1462.46",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:108.0) Gecko/20100101 Firefox/108.0",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/92.0.4515.131 Safari/537.36 Edg/92.0.902.67",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/89.0.4389.82 Safari/537.36",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 11_0_0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/89.0.4389.82 Safari/537.36",
+	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/89.0.4389.82 Safari/537.36",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:87.0) Gecko/20100101 Firefox/87.0",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 11_0_0; rv:87.0) Gecko/20100101 Firefox/87.0",
+	"Mozilla/5.0 (X11; Linux x86_64; rv:87.0) Gecko/20100101 Firefox/87.0",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/86.0.4240.198 Safari/537.36 Edg/87.0.664.59",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/86.0.4240.198 Safari/537.36 Edg/87.0.664.56",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:85.0) Gecko/20100101 Firefox/85.0",
+	"Mozilla/5.0 (Macintosh; Intel Mac OS X 11_0_0; rv:85.0) Gecko/20100101 Firefox/85.0",
+	"Mozilla/5.0 (X11; Linux x86_64; rv:85.0) Gecko/20100101 Firefox/85.0",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.141 Safari/537.36 Edg/87.0.664.75",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.141 Safari/537.36 Edg/87.0.664.72",
+	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.141 Safari/537.36",
+}
@@ -0,0 +1,158 @@
+package secondorder
+
+import (
+	"bufio"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// Scopes of the crawl, the hosts of the pages it follows links to
+const (
+	// The host of the target and its subdomains
+	ScopeSubdomains = "subdomains"
+	// Only the host of the target
+	ScopeStrict = "strict"
+	// The hosts matching the allow patterns of ScopeHosts and none of its deny patterns
+	ScopeFile = "file"
+)
+
+// Host patterns are names like app.example.com, or *.example.com for the subdomains of a name
+var hostPatternChars = regexp.MustCompile(`^(\*\.)?[a-z0-9_-]+(\.[a-z0-9_-]+)*\.?$`)
+
+// crawlScope is the scope of the crawl of a target, as the URL filters colly checks every link against
+type crawlScope struct {
+	allow []*regexp.Regexp
+	deny  []*regexp.Regexp
+}
+
+// newCrawlScope builds the scope of a target from the Scope and ScopeHosts options
+func newCrawlScope(options Options, target string) (*crawlScope, error) {
+	hostname, err := getHostname(target)
+	if err != nil {
+		return nil, err
+	}
+	hostname = regexp.QuoteMeta(strings.ToLower(hostname))
+	switch options.Scope {
+	case "", ScopeSubdomains:
+		return &crawlScope{allow: []*regexp.Regexp{hostFilter(`([^/?#@]+\.)?` + hostname)}}, nil
+	case ScopeStrict:
+		return &crawlScope{allow: []*regexp.Regexp{hostFilter(hostname)}}, nil
+	}
+	scope := &crawlScope{}
+	for _, pattern := range options.ScopeHosts {
+		deny := strings.HasPrefix(pattern, "!")
+		filter, err := hostPattern(strings.TrimPrefix(pattern, "!"))
+		if err != nil {
+			return nil, err
+		}
+		if deny {
+			scope.deny = append(scope.deny, filter)
+		} else {
+			scope.allow = append(scope.allow, filter)
+		}
+	}
+	return scope, nil
+}
+
+// hostFilter matches the URLs of the hosts a pattern matches, on any scheme and port
+func hostFilter(host string) *regexp.Regexp {
+	return regexp.MustCompile(`(?i)^https?://` + host + `(:\d+)?([/?#]|$)`)
+}
+
+// hostPattern compiles a host pattern of ScopeHosts, a full URL stands for its host
+func hostPattern(pattern string) (*regexp.Regexp, error) {
+	host := strings.ToLower(strings.TrimSpace(pattern))
+	if strings.Contains(host, "://") {
+		u, err := url.Parse(host)
+		if err != nil {
+			return nil, fmt.Errorf("invalid scope pattern %q: %v", pattern, err)
+		}
+		host = u.Hostname()
+	}
+	if !hostPatternChars.MatchString(host) {
+		return nil, fmt.Errorf("invalid scope pattern %q: use a host like app.example.com, or *.example.com for its subdomains", pattern)
+	}
+	host = strings.TrimSuffix(host, ".")
+	if strings.HasPrefix(host, "*.") {
+		return hostFilter(`[^/?#@]+\.` + regexp.QuoteMeta(host[2:])), nil
+	}
+	return hostFilter(regexp.QuoteMeta(host)), nil
+}
+
+// allows reports whether a URL is in the scope
+func (s *crawlScope) allows(u string) bool {
+	return matchesAny(s.allow, u) && !s.denies(u)
+}
+
+// denies reports whether a URL matches a deny pattern
+func (s *crawlScope) denies(u string) bool {
+	return matchesAny(s.deny, u)
+}
+
+// apply restricts a collector to the scope, the deny patterns are added to its disallowed URL filters
+func (s *crawlScope) apply(c *colly.Collector) {
+	c.URLFilters = s.allow
+	c.DisallowedURLFilters = append(c.DisallowedURLFilters, s.deny...)
+}
+
+func matchesAny(filters []*regexp.Regexp, u string) bool {
+	for _, filter := range filters {
+		if filter.MatchString(u) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateScope checks the scope options, the file scope needs the hosts it allows
+func validateScope(options Options) error {
+	switch options.Scope {
+	case "", ScopeSubdomains, ScopeStrict:
+		if len(options.ScopeHosts) > 0 {
+			return fmt.Errorf("ScopeHosts are only used by the %s scope", ScopeFile)
+		}
+		return nil
+	case ScopeFile:
+		allowed := false
+		for _, pattern := range options.ScopeHosts {
+			if _, err := hostPattern(strings.TrimPrefix(pattern, "!")); err != nil {
+				return err
+			}
+			allowed = allowed || !strings.HasPrefix(pattern, "!")
+		}
+		if !allowed {
+			return fmt.Errorf("the %s scope needs at least one host to allow", ScopeFile)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown scope %q (supported: %s, %s, %s)", options.Scope, ScopeSubdomains, ScopeStrict, ScopeFile)
+}
+
+// LoadScope reads the host patterns of a scope file, one per line, skipping empty lines and # comments
+// patterns starting with ! deny the hosts they match, even the ones another pattern allows
+func LoadScope(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open scope file: %v", err)
+	}
+	defer f.Close()
+
+	var patterns []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read scope file: %v", err)
+	}
+	return patterns, nil
+}
@@ -0,0 +1,49 @@
+package secondorder
+
+import "testing"
+
+func TestCrawlScopeAllows(t *testing.T) {
+	tests := []struct {
+		name    string
+		options Options
+		target  string
+		url     string
+		allowed bool
+	}{
+		{"subdomains: target host", Options{}, "https://example.com/", "https://example.com/about", true},
+		{"subdomains: subdomain", Options{Scope: ScopeSubdomains}, "https://example.com/", "http://cdn.example.com:8080/app.js", true},
+		{"subdomains: other host", Options{}, "https://example.com/", "https://example.org/", false},
+		{"subdomains: suffix of another name", Options{}, "https://example.com/", "https://notexample.com/", false},
+		{"subdomains: host in the userinfo", Options{}, "https://example.com/", "https://example.com@evil.com/", false},
+		{"subdomains: host in the path", Options{}, "https://example.com/", "https://evil.com/example.com", false},
+		{"subdomains: case", Options{}, "https://Example.com/", "https://EXAMPLE.COM/", true},
+		{"strict: target host", Options{Scope: ScopeStrict}, "https://example.com/", "https://example.com/?q=1", true},
+		{"strict: subdomain", Options{Scope: ScopeStrict}, "https://example.com/", "https://www.example.com/", false},
+		{"strict: other scheme", Options{Scope: ScopeStrict}, "https://example.com/", "ftp://example.com/", false},
+		{"file: exact host", Options{Scope: ScopeFile, ScopeHosts: []string{"app.example.com"}}, "https://app.example.com/", "https://app.example.com/", true},
+		{"file: wildcard", Options{Scope: ScopeFile, ScopeHosts: []string{"*.example.com"}}, "https://app.example.com/", "https://a.b.example.com/", true},
+		{"file: wildcard leaves out the name itself", Options{Scope: ScopeFile, ScopeHosts: []string{"*.example.com"}}, "https://app.example.com/", "https://example.com/", false},
+		{"file: full URL stands for its host", Options{Scope: ScopeFile, ScopeHosts: []string{"https://app.example.com/login"}}, "https://app.example.com/", "http://app.example.com/other", true},
+		{"file: deny wins", Options{Scope: ScopeFile, ScopeHosts: []string{"*.example.com", "!admin.example.com"}}, "https://app.example.com/", "https://admin.example.com/", false},
+		{"file: no pattern", Options{Scope: ScopeFile}, "https://app.example.com/", "https://app.example.com/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scope, err := newCrawlScope(tt.options, tt.target)
+			if err != nil {
+				t.Fatalf("newCrawlScope: %v", err)
+			}
+			if got := scope.allows(tt.url); got != tt.allowed {
+				t.Errorf("allows(%q) = %v, want %v", tt.url, got, tt.allowed)
+			}
+		})
+	}
+}
+
+func TestHostPatternInvalid(t *testing.T) {
+	for _, pattern := range []string{"", "example.com/path", "*example.com", "exa mple.com", "*.*.example.com"} {
+		if _, err := hostPattern(pattern); err == nil {
+			t.Errorf("hostPattern(%q) returned no error", pattern)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package secondorder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Script is an external script loaded by the crawled pages, saved in scripts.json
+// its content is saved in scripts/<sha256>.js, scripts with the same content share the file
+type Script struct {
+	URL    string   `json:"url"`
+	SHA256 string   `json:"sha256"`
+	Size   int      `json:"size"`
+	Pages  []string `json:"pages"`
+}
+
+type scriptStore struct {
+	sync.Mutex
+	scripts map[string]*Script
+	// Content of the scripts, keyed by hash
+	content map[string][]byte
+	// Scripts of the baseline run keyed by URL, and the pages that loaded them
+	baseline      map[string]*Script
+	baselinePages map[string]bool
+}
+
+func newScriptStore(baseline []*Script) *scriptStore {
+	st := &scriptStore{
+		scripts:       make(map[string]*Script),
+		content:       make(map[string][]byte),
+		baseline:      make(map[string]*Script),
+		baselinePages: make(map[string]bool),
+	}
+	for _, script := range baseline {
+		st.baseline[script.URL] = script
+		for _, page := range script.Pages {
+			st.baselinePages[page] = true
+		}
+	}
+	return st
+}
+
+// addPage links a script to a page, it returns false if the script was already seen
+func (st *scriptStore) addPage(u, page string) bool {
+	st.Lock()
+	defer st.Unlock()
+	if script, ok := st.scripts[u]; ok {
+		if !contains(script.Pages, page) {
+			script.Pages = append(script.Pages, page)
+		}
+		return false
+	}
+	st.scripts[u] = &Script{URL: u, Pages: []string{page}}
+	return true
+}
+
+// setContent saves the content of a script, and returns it with its version of the baseline run, nil if the baseline didn't have it
+func (st *scriptStore) setContent(u string, content []byte) (Script, *Script) {
+	sum := sha256.Sum256(content)
+	hash := hex.EncodeToString(sum[:])
+	st.Lock()
+	defer st.Unlock()
+	st.scripts[u].SHA256, st.scripts[u].Size = hash, len(content)
+	st.content[hash] = content
+	return *st.scripts[u], st.baseline[u]
+}
+
+// compareScript reports a script whose content changed since the baseline run, and a script that a page of the baseline
+// didn't load then, like a skimmer injected into a checkout page or a compromised third-party library
+func (s *scan) compareScript(page string, script Script, before *Script) {
+	st := s.scripts
+	if len(st.baseline) == 0 {
+		return
+	}
+	kind := "first-party"
+	if !s.checkOrigin(script.URL, s.target) {
+		kind = "third-party"
+	}
+	switch {
+	case before != nil && before.SHA256 != script.SHA256:
+		s.report(Finding{Type: FindingScriptChange, Page: page, Resource: script.URL,
+			Detail: fmt.Sprintf("%s script changed since the baseline: sha256 %s, %d bytes (was sha256 %s, %d bytes)", kind, script.SHA256, script.Size, before.SHA256, before.Size)})
+	case before == nil && st.baselinePages[page]:
+		s.report(Finding{Type: FindingScriptChange, Page: page, Resource: script.URL,
+			Detail: fmt.Sprintf("%s script added since the baseline: sha256 %s, %d bytes", kind, script.SHA256, script.Size)})
+	}
+}
+
+// LoadScripts reads the external scripts saved by a previous run, from its scripts.json
+// or from every scripts.json under its output directory
+func LoadScripts(path string) ([]*Script, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the script baseline: %v", err)
+	}
+	files := []string{path}
+	if info.IsDir() {
+		files = nil
+		err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() && strings.HasPrefix(info.Name(), "scripts.json") {
+				files = append(files, p)
+			}
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var scripts []*Script
+	for _, file := range files {
+		f, err := OpenResultFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var content map[string][]*Script
+		err = json.NewDecoder(f).Decode(&content)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not decode the scripts of %s: %v", file, err)
+		}
+		scripts = append(scripts, content["Scripts"]...)
+	}
+	return scripts, nil
+}
+
+// restore adds scripts downloaded by an interrupted run and their content, keyed by hash, they aren't downloaded again
+func (st *scriptStore) restore(scripts []*Script, content map[string][]byte) {
+	st.Lock()
+	defer st.Unlock()
+	for _, script := range scripts {
+		st.scripts[script.URL] = script
+	}
+	for hash, c := range content {
+		st.content[hash] = c
+	}
+}
+
+// list returns copies of the scripts that were downloaded sorted by URL, and their content
+func (st *scriptStore) list() ([]*Script, map[string][]byte) {
+	st.Lock()
+	defer st.Unlock()
+	list := make([]*Script, 0, len(st.scripts))
+	for _, script := range st.scripts {
+		if script.SHA256 == "" {
+			continue
+		}
+		s := *script
+		s.Pages = append([]string(nil), script.Pages...)
+		sort.Strings(s.Pages)
+		list = append(list, &s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].URL < list[j].URL })
+	content := make(map[string][]byte, len(st.content))
+	for hash, c := range st.content {
+		content[hash] = c
+	}
+	return list, content
+}
+
+// analyzeExternalScript downloads a script the first time it's referenced, and runs the analyses inline scripts get on it
+func (s *scan) analyzeExternalScript(page, u string) {
+	if u == "" || s.isNoise(u) || !s.scripts.addPage(u, page) {
+		return
+	}
+	body, err := s.fetchDocument(s.ctx, u)
+	if err != nil {
+		return
+	}
+	defer body.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(body, 10<<20))
+	if err != nil {
+		return
+	}
+	if s.config.Options.SaveScripts {
+		script, before := s.scripts.setContent(u, content)
+		s.compareScript(page, script, before)
+	}
+	s.analyzeScript(page, u, content)
+}
+
+// analyzeScript searches the content of a script for secrets and the endpoints the enabled features look for
+// relative references are resolved against the page, which is where the script runs
+func (s *scan) analyzeScript(page, script string, content []byte) {
+	options := s.config.Options
+	if options.Secrets {
+		s.scanScript(page, script, content)
+	}
+	if options.JSEndpoints {
+		s.logJSEndpoints(script, content)
+	}
+	if options.OpenAPI {
+		for _, match := range openAPIInlineReference.FindAllSubmatch(content, -1) {
+			s.checkOpenAPIReference(page, resolveReference(page, string(match[1])))
+		}
+	}
+	if options.GraphQL {
+		for _, match := range graphQLInlineReference.FindAllSubmatch(content, -1) {
+			s.checkGraphQLEndpoint(page, resolveReference(page, string(match[1])))
+		}
+	}
+	if options.InlineJSON {
+		s.logInlineJSON(page, inlineStateReferences(string(content)))
+	}
+}
@@ -0,0 +1,145 @@
+package secondorder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// defaultSecretRules are the secret patterns scripts are searched for, the SecretRules of the config file override them by name
+var defaultSecretRules = map[string]string{
+	"aws-access-key-id": `\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`,
+	"google-api-key":    `\bAIza[0-9A-Za-z_\-]{35}\b`,
+	"jwt":               `\beyJ[A-Za-z0-9_-]{10,}\.eyJ[A-Za-z0-9_-]{10,}\.[A-Za-z0-9_-]{10,}`,
+	"slack-token":       `\bxox[abposr]-[0-9A-Za-z-]{10,}`,
+	"slack-webhook":     `https://hooks\.slack\.com/services/T[0-9A-Z]+/B[0-9A-Z]+/[0-9A-Za-z]+`,
+	"github-token":      `\bgh[pousr]_[0-9A-Za-z]{36}\b`,
+	"stripe-secret-key": `\b[rs]k_live_[0-9A-Za-z]{24,}`,
+	"private-key":       `-----BEGIN (?:RSA |EC |DSA |OPENSSH )?PRIVATE KEY-----`,
+}
+
+type secretRule struct {
+	name    string
+	pattern *regexp.Regexp
+}
+
+// compileSecretRules merges the rules of the config file with the default ones, an empty pattern disables a default rule
+func compileSecretRules(overrides map[string]string) ([]secretRule, error) {
+	patterns := make(map[string]string)
+	for name, pattern := range defaultSecretRules {
+		patterns[name] = pattern
+	}
+	for name, pattern := range overrides {
+		patterns[name] = pattern
+	}
+
+	var rules []secretRule
+	for name, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		compiled, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret rule %q: %v", name, err)
+		}
+		rules = append(rules, secretRule{name: name, pattern: compiled})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].name < rules[j].name })
+	return rules, nil
+}
+
+// Secret is a match of a secret rule in a script, saved in secrets.json
+type Secret struct {
+	Rule  string `json:"rule"`
+	Match string `json:"match"`
+	// Start of the SHA-256 of the match, which tells the finding of the match apart without disclosing it
+	Fingerprint string `json:"fingerprint"`
+	// The match and some of the script around it
+	Context string `json:"context"`
+	Page    string `json:"page"`
+	// URL of the script, or "inline" for a script in the page
+	Script string `json:"script"`
+}
+
+type secretList struct {
+	sync.Mutex
+	secrets []Secret
+	seen    map[string]bool
+}
+
+func newSecretList() *secretList {
+	return &secretList{seen: make(map[string]bool)}
+}
+
+// add records a secret, it returns false if the same match was already found in the same script
+func (l *secretList) add(secret Secret) bool {
+	l.Lock()
+	defer l.Unlock()
+	// The same inline script is usually on every page, so inline secrets are only recorded once per match
+	key := secret.key()
+	if l.seen[key] {
+		return false
+	}
+	l.seen[key] = true
+	l.secrets = append(l.secrets, secret)
+	return true
+}
+
+// restore adds secrets saved by an interrupted run, they aren't reported again when their script is scanned
+func (l *secretList) restore(secrets []Secret) {
+	l.Lock()
+	defer l.Unlock()
+	for _, secret := range secrets {
+		if key := secret.key(); !l.seen[key] {
+			l.seen[key] = true
+			l.secrets = append(l.secrets, secret)
+		}
+	}
+}
+
+func (s Secret) key() string {
+	return s.Rule + "\x00" + s.Match + "\x00" + s.Script
+}
+
+// list returns the secrets sorted by rule, and then by script
+func (l *secretList) list() []Secret {
+	l.Lock()
+	defer l.Unlock()
+	list := append(make([]Secret, 0, len(l.secrets)), l.secrets...)
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Rule != list[j].Rule {
+			return list[i].Rule < list[j].Rule
+		}
+		return list[i].Script < list[j].Script
+	})
+	return list
+}
+
+// scanScript searches a script for secrets and reports every new match
+func (s *scan) scanScript(page, script string, content []byte) {
+	for _, rule := range s.rules().secretRules {
+		for _, loc := range rule.pattern.FindAllIndex(content, -1) {
+			secret := Secret{
+				Rule:    rule.name,
+				Match:   string(content[loc[0]:loc[1]]),
+				Context: evidence(content, loc),
+				Page:    page,
+				Script:  script,
+			}
+			secret.Fingerprint = secretFingerprint(secret.Match)
+			if s.secrets.add(secret) {
+				s.report(Finding{Type: FindingSecret, Page: page, Resource: script, Rule: rule.name,
+					Detail: rule.name + " sha256 " + secret.Fingerprint})
+			}
+		}
+	}
+}
+
+// secretFingerprint identifies a secret in findings and notifications, which aren't meant to carry the secret itself
+func secretFingerprint(match string) string {
+	sum := sha256.Sum256([]byte(match))
+	return hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,200 @@
+package secondorder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Sources of the pages a crawl starts from, besides the target
+const (
+	// The URLs of the target the Wayback Machine archived
+	SeedWayback = "wayback"
+)
+
+// Number of URLs a target is seeded with when SeedLimit isn't set
+const defaultSeedLimit = 1000
+
+func validateSeed(options Options) error {
+	switch options.Seed {
+	case "", SeedWayback:
+		return nil
+	}
+	return fmt.Errorf("invalid seed %q, use %s", options.Seed, SeedWayback)
+}
+
+// seeds returns the pages of SeedURLs and Seed the crawl starts from besides the target, each once
+// the ones out of scope are left out, and a source that can't be read is logged and skipped
+func (s *scan) seeds(ctx context.Context) []string {
+	seen := map[string]bool{s.target: !s.config.Options.SeedOnly}
+	var seeds []string
+	add := func(found []string) int {
+		added := 0
+		for _, u := range found {
+			if !seen[u] && s.scope.allows(u) {
+				seen[u] = true
+				seeds = append(seeds, u)
+				added++
+			}
+		}
+		return added
+	}
+	if len(s.config.Options.SeedURLs) > 0 {
+		logger.WithTarget(s.target).Infof("Seeded the crawl with %d URLs of the seed file", add(s.config.Options.SeedURLs))
+	}
+	if s.config.Options.Seed == "" {
+		return seeds
+	}
+	limit := s.config.Options.SeedLimit
+	if limit == 0 {
+		limit = defaultSeedLimit
+	}
+	found, err := s.waybackURLs(ctx, limit)
+	if err != nil {
+		logger.WithTarget(s.target).Errorf("Could not get the archived URLs of the target from the Wayback Machine: %v", err)
+		return seeds
+	}
+	logger.WithTarget(s.target).Infof("Seeded the crawl with %d URLs archived by the Wayback Machine", add(found))
+	return seeds
+}
+
+// LoadSeedFile reads the URLs of a file of pages to seed the crawl with: a Burp Suite site map or proxy history
+// saved as XML, whose GET requests for HTML pages are taken, or a list of URLs, one per line, skipping empty lines
+// and # comments
+func LoadSeedFile(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read seed file: %v", err)
+	}
+	trimmed := bytes.TrimSpace(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf")))
+	if bytes.HasPrefix(trimmed, []byte("<")) {
+		urls, err := burpURLs(trimmed)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse the Burp Suite items of %s: %v", path, err)
+		}
+		return urls, nil
+	}
+
+	var urls []string
+	lines := bufio.NewScanner(bytes.NewReader(content))
+	for n := 1; lines.Scan(); n++ {
+		line := strings.TrimSpace(lines.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if !isValidURL(line) {
+			return nil, fmt.Errorf("%s: line %d isn't a URL: %q", path, n, line)
+		}
+		urls = append(urls, line)
+	}
+	if err := lines.Err(); err != nil {
+		return nil, fmt.Errorf("could not read seed file: %v", err)
+	}
+	return urls, nil
+}
+
+// burpURLs returns the URLs of the GET requests for HTML pages, or whose response wasn't saved, of Burp Suite items
+// saved with "Save selected items", like
+// <items burpVersion="..."><item><url><![CDATA[https://example.com/]]></url><method><![CDATA[GET]]></method><mimetype>HTML</mimetype>...</item></items>
+func burpURLs(content []byte) ([]string, error) {
+	var items struct {
+		XMLName xml.Name `xml:"items"`
+		Items   []struct {
+			URL      string `xml:"url"`
+			Method   string `xml:"method"`
+			MIMEType string `xml:"mimetype"`
+		} `xml:"item"`
+	}
+	if err := xml.Unmarshal(content, &items); err != nil {
+		return nil, err
+	}
+	var urls []string
+	for _, item := range items.Items {
+		u := strings.TrimSpace(item.URL)
+		method, mimeType := strings.TrimSpace(item.Method), strings.TrimSpace(item.MIMEType)
+		if !isValidURL(u) || (method != "" && !strings.EqualFold(method, http.MethodGet)) {
+			continue
+		}
+		if mimeType != "" && !strings.EqualFold(mimeType, "HTML") {
+			continue
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
+// SeedTargets returns the targets of seed URLs when none are given: the scheme and host of every URL, each once
+func SeedTargets(urls []string) []string {
+	var targets []string
+	seen := make(map[string]bool)
+	for _, raw := range urls {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		target := u.Scheme + "://" + u.Host + "/"
+		if !seen[target] {
+			seen[target] = true
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// waybackURLs returns the HTML pages of the target's host, and of its subdomains unless the scope is strict,
+// that the Wayback Machine archived with a 200 status, each once, at most limit of them (-1 for no limit)
+func (s *scan) waybackURLs(ctx context.Context, limit int) ([]string, error) {
+	hostname, err := getHostname(s.target)
+	if err != nil {
+		return nil, err
+	}
+	matchType := "domain"
+	if s.config.Options.Scope == ScopeStrict {
+		matchType = "host"
+	}
+	query := url.Values{
+		"url":       {hostname},
+		"matchType": {matchType},
+		"output":    {"json"},
+		"fl":        {"original"},
+		"collapse":  {"urlkey"},
+		"filter":    {"statuscode:200", "mimetype:text/html"},
+	}
+	if limit > 0 {
+		query.Set("limit", strconv.Itoa(limit))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "https://web.archive.org/cdx/search/cdx?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.verifyClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("wayback machine returned status %d", res.StatusCode)
+	}
+
+	// The first row is the header, the others hold the requested fields
+	var rows [][]string
+	if err := json.NewDecoder(res.Body).Decode(&rows); err != nil {
+		return nil, err
+	}
+	var urls []string
+	for i, row := range rows {
+		if i == 0 || len(row) == 0 || !isValidURL(row[0]) {
+			continue
+		}
+		urls = append(urls, row[0])
+	}
+	return urls, nil
+}
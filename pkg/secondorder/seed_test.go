@@ -0,0 +1,70 @@
+package secondorder
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoadSeedFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		err     bool
+	}{
+		{
+			name:    "URL list",
+			content: "# pages from the proxy\nhttps://example.com/a\n\n  https://example.com/b?q=1  \n",
+			want:    []string{"https://example.com/a", "https://example.com/b?q=1"},
+		},
+		{
+			name:    "byte order mark",
+			content: "\xef\xbb\xbf<items><item><url>https://example.com/</url></item></items>",
+			want:    []string{"https://example.com/"},
+		},
+		{name: "not a URL", content: "https://example.com/\n/relative\n", err: true},
+		{name: "empty", content: "\n# nothing\n"},
+		{name: "invalid XML", content: "<items><item>", err: true},
+		{
+			name: "Burp Suite items",
+			content: `<?xml version="1.0"?>
+<items burpVersion="2023.1">
+  <item><url><![CDATA[https://example.com/]]></url><method><![CDATA[GET]]></method><mimetype>HTML</mimetype></item>
+  <item><url><![CDATA[https://example.com/login]]></url><method><![CDATA[POST]]></method><mimetype>HTML</mimetype></item>
+  <item><url><![CDATA[https://example.com/app.js]]></url><method><![CDATA[GET]]></method><mimetype>script</mimetype></item>
+  <item><url><![CDATA[https://example.com/saved-without-response]]></url><method><![CDATA[get]]></method><mimetype></mimetype></item>
+  <item><url><![CDATA[not a url]]></url><method><![CDATA[GET]]></method><mimetype>HTML</mimetype></item>
+</items>`,
+			want: []string{"https://example.com/", "https://example.com/saved-without-response"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "seeds")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			urls, err := LoadSeedFile(path)
+			if (err != nil) != tt.err {
+				t.Fatalf("LoadSeedFile() error = %v, want an error: %v", err, tt.err)
+			}
+			if !reflect.DeepEqual(urls, tt.want) {
+				t.Errorf("LoadSeedFile() = %q, want %q", urls, tt.want)
+			}
+		})
+	}
+
+	if _, err := LoadSeedFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("LoadSeedFile() of a missing file returned no error")
+	}
+}
+
+func TestSeedTargets(t *testing.T) {
+	urls := []string{"https://example.com/a", "http://example.com/b", "https://example.com:8443/", "https://example.com/c", "not a url"}
+	want := []string{"https://example.com/", "http://example.com/", "https://example.com:8443/"}
+	if got := SeedTargets(urls); !reflect.DeepEqual(got, want) {
+		t.Errorf("SeedTargets() = %q, want %q", got, want)
+	}
+}
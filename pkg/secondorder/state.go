@@ -0,0 +1,257 @@
+package secondorder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly/v2"
+)
+
+// How often the crawl state is saved while targets are running
+const stateInterval = 30 * time.Second
+
+// CrawlState is what's needed to continue interrupted crawls, keyed by target
+type CrawlState struct {
+	Targets map[string]*TargetState
+}
+
+// TargetState is where the crawl of a target stopped
+type TargetState struct {
+	// Pages that were crawled and scraped
+	Visited []string
+	// Pages that were requested but not scraped yet, they're crawled again
+	Queued []QueuedPage
+	// What was found until then
+	Result *Result
+	// Content of the scripts of the result keyed by hash, and the third-party hosts of every page, which the result doesn't hold
+	ScriptContent map[string][]byte   `json:",omitempty"`
+	ThirdParties  map[string][]string `json:",omitempty"`
+}
+
+// QueuedPage is a page of the frontier, and the depth it was found at
+type QueuedPage struct {
+	URL   string
+	Depth int
+}
+
+// LoadCrawlState reads a state file saved by a previous run
+func LoadCrawlState(path string) (*CrawlState, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read the crawl state: %v", err)
+	}
+	var state CrawlState
+	if err := json.Unmarshal(content, &state); err != nil {
+		return nil, fmt.Errorf("could not decode the crawl state: %v", err)
+	}
+	return &state, nil
+}
+
+// WriteCrawlState saves a state file, replacing the previous one only once the new one is complete
+func WriteCrawlState(path string, state *CrawlState) error {
+	content, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// frontier tracks the pages of a scan that were requested and the ones that were scraped
+type frontier struct {
+	sync.Mutex
+	// Requests that weren't scraped yet, keyed by request ID
+	queued  map[uint32]QueuedPage
+	visited map[string]bool
+
+	// Pages crawled by the interrupted run, and the depths of the ones it queued
+	resumedVisited map[string]bool
+	resumedDepths  map[string]int
+}
+
+func newFrontier() *frontier {
+	return &frontier{queued: make(map[uint32]QueuedPage), visited: make(map[string]bool)}
+}
+
+// wasCrawled reports whether the interrupted run already crawled a page
+func (f *frontier) wasCrawled(u string) bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.resumedVisited[u]
+}
+
+// track registers the callbacks that keep the frontier of a collector
+// they're only registered when the state is saved or resumed
+func (s *scan) track(ctx context.Context, c *colly.Collector) {
+	f := s.frontier
+	c.OnRequest(func(r *colly.Request) {
+		u := r.URL.String()
+		f.Lock()
+		defer f.Unlock()
+		if f.resumedVisited[u] {
+			r.Abort()
+			return
+		}
+		// Pages queued by the interrupted run are crawled at the depth they were found at
+		if depth, ok := f.resumedDepths[u]; ok {
+			r.Depth = depth
+			delete(f.resumedDepths, u)
+		}
+		f.queued[r.ID] = QueuedPage{URL: u, Depth: r.Depth}
+	})
+	done := func(r *colly.Request) {
+		f.Lock()
+		defer f.Unlock()
+		if page, ok := f.queued[r.ID]; ok {
+			f.visited[page.URL] = true
+			delete(f.queued, r.ID)
+		}
+	}
+	c.OnScraped(func(r *colly.Response) {
+		// A page whose checks were dropped by the interruption is crawled again when resuming
+		s.pipeline.afterPage(r.Request.URL.String(), func(complete bool) {
+			if complete {
+				done(r.Request)
+			}
+		})
+	})
+	c.OnError(func(r *colly.Response, err error) {
+		// Requests cancelled by the interruption are crawled again when resuming
+		if ctx.Err() == nil {
+			done(r.Request)
+		}
+	})
+}
+
+// resume loads the state of the interrupted run into the scan, and returns the pages to crawl again
+func (s *scan) resume(state *TargetState) []string {
+	f := s.frontier
+	f.Lock()
+	f.resumedVisited = make(map[string]bool, len(state.Visited))
+	for _, u := range state.Visited {
+		f.resumedVisited[u] = true
+		f.visited[u] = true
+	}
+	f.resumedDepths = make(map[string]int, len(state.Queued))
+	queued := make([]string, 0, len(state.Queued))
+	for _, page := range state.Queued {
+		if _, ok := f.resumedDepths[page.URL]; !ok && !f.resumedVisited[page.URL] {
+			f.resumedDepths[page.URL] = page.Depth
+			queued = append(queued, page.URL)
+		}
+	}
+	f.Unlock()
+
+	if r := state.Result; r != nil {
+		s.loggedQueries.restore(r.Attributes)
+		s.loggedNon200Queries.restore(r.Non200)
+		for u, response := range r.Responses {
+			s.responses.Store(u, response)
+		}
+		s.loggedInline.restore(r.Inline)
+		s.bodyMatches.restore(r.BodyMatches)
+		s.evidence.restore(r.Evidence)
+		s.pages.restore(r.Pages)
+		s.dangling.restore(r.DanglingDomains)
+		s.danglingLinks.restore(r.DanglingLinks)
+		s.inlineScripts.restore(r.InlineScripts)
+		s.cms.restore(r.CMS)
+		s.takeovers.restore(r.Takeovers)
+		s.secrets.restore(r.Secrets)
+		s.scripts.restore(r.Scripts, state.ScriptContent)
+		s.jsEndpoints.restore(r.JSEndpoints)
+		s.coverage.restore(r.Coverage)
+		s.openAPI.restore(r.OpenAPI)
+		s.graphQL.restore(r.GraphQL)
+		// Specs and endpoints that were already analyzed aren't fetched again
+		for _, spec := range r.OpenAPI {
+			s.documents.first(spec.URL)
+		}
+		for _, endpoint := range r.GraphQL {
+			s.documents.first(endpoint.URL)
+		}
+		s.findings.Lock()
+		s.findings.findings = append(s.findings.findings, r.Findings...)
+		s.findings.Unlock()
+	}
+	s.thirdParties.restore(state.ThirdParties)
+	return queued
+}
+
+// state returns where the crawl of the scan is
+func (s *scan) state() *TargetState {
+	f := s.frontier
+	f.Lock()
+	state := &TargetState{Visited: make([]string, 0, len(f.visited))}
+	for u := range f.visited {
+		state.Visited = append(state.Visited, u)
+	}
+	for _, page := range f.queued {
+		state.Queued = append(state.Queued, page)
+	}
+	// Pages that were queued by the interrupted run but not requested yet
+	for u, depth := range f.resumedDepths {
+		state.Queued = append(state.Queued, QueuedPage{URL: u, Depth: depth})
+	}
+	f.Unlock()
+	sort.Strings(state.Visited)
+	sort.Slice(state.Queued, func(i, j int) bool { return state.Queued[i].URL < state.Queued[j].URL })
+	state.Result = s.result()
+	state.ScriptContent = state.Result.scriptContent
+	state.ThirdParties = s.thirdParties.byPage()
+	return state
+}
+
+// saveState saves the state of every target that was run so far
+func (sc *Scanner) saveState() error {
+	sc.mu.Lock()
+	scans := append([]*scan(nil), sc.scans...)
+	sc.mu.Unlock()
+
+	state := &CrawlState{Targets: make(map[string]*TargetState, len(scans))}
+	// Targets of the interrupted run that weren't run again keep their state
+	if sc.resumed != nil {
+		for target, t := range sc.resumed.Targets {
+			state.Targets[target] = t
+		}
+	}
+	for _, s := range scans {
+		state.Targets[s.target] = s.state()
+	}
+	return WriteCrawlState(sc.config.Options.StateFile, state)
+}
+
+// saveStates saves the state every stateInterval until stop is closed
+func (sc *Scanner) saveStates(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(stateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := sc.saveState(); err != nil {
+				logger.Errorf("Could not save the crawl state: %v", err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package secondorder
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestCrawlStateRoundTrip(t *testing.T) {
+	const target = "https://example.com/"
+	config := Config{Options: Options{
+		CMSChecks:   true,
+		OpenAPI:     true,
+		GraphQL:     true,
+		Takeover:    true,
+		Secrets:     true,
+		JSEndpoints: true,
+		SaveScripts: true,
+		CheckLinks:  true,
+	}}
+
+	s := newScan(&Scanner{config: config}, target)
+	s.frontier = newFrontier()
+	s.frontier.visited[target] = true
+	s.frontier.queued[1] = QueuedPage{URL: target + "deep", Depth: 3}
+	s.frontier.queued[2] = QueuedPage{URL: target, Depth: 1}
+	s.pages.restore([]*Page{{URL: target, StatusCode: 200}})
+	s.thirdParties.add(target, "cdn.example.net")
+	s.cms.restore([]CMSFinding{{Page: target, CMS: "WordPress", Kind: "plugin", Name: "gone", Asset: target + "wp-content/plugins/gone/a.js", Reason: "not in the registry"}})
+	s.takeovers.restore([]*TakeoverCandidate{{Host: "docs.example.com", Service: "GitHub Pages", Pages: []string{target}}})
+	s.secrets.add(Secret{Rule: "jwt", Match: "eyJ", Fingerprint: secretFingerprint("eyJ"), Page: target, Script: target + "app.js"})
+	s.scripts.addPage(target+"app.js", target)
+	s.scripts.setContent(target+"app.js", []byte("var a = 1"))
+	s.jsEndpoints.add(target+"app.js", map[string]string{"/api/users": EndpointFetch})
+	s.openAPI.add(OpenAPISpec{URL: target + "openapi.json", Page: target})
+	s.graphQL.add(GraphQLEndpoint{URL: target + "graphql", Page: target, Introspection: true})
+	s.coverage.add(SkipScope, "https://example.org/")
+	s.findings.findings = append(s.findings.findings, Finding{Type: FindingSecret, Target: target, Page: target, Resource: target + "app.js", Time: time.Unix(0, 0).UTC()})
+
+	path := filepath.Join(t.TempDir(), "state.json")
+	if err := WriteCrawlState(path, &CrawlState{Targets: map[string]*TargetState{target: s.state()}}); err != nil {
+		t.Fatalf("WriteCrawlState: %v", err)
+	}
+	state, err := LoadCrawlState(path)
+	if err != nil {
+		t.Fatalf("LoadCrawlState: %v", err)
+	}
+
+	resumed := newScan(&Scanner{config: config}, target)
+	resumed.frontier = newFrontier()
+	queued := resumed.resume(state.Targets[target])
+	if want := []string{target + "deep"}; !reflect.DeepEqual(queued, want) {
+		t.Errorf("queued pages = %v, want %v", queued, want)
+	}
+	if !resumed.frontier.wasCrawled(target) {
+		t.Errorf("%s isn't crawled after resuming", target)
+	}
+
+	before, after := s.result(), resumed.result()
+	for _, field := range []struct {
+		name          string
+		before, after interface{}
+	}{
+		{"Pages", before.Pages, after.Pages},
+		{"CMS", before.CMS, after.CMS},
+		{"Takeovers", before.Takeovers, after.Takeovers},
+		{"Secrets", before.Secrets, after.Secrets},
+		{"Scripts", before.Scripts, after.Scripts},
+		{"script content", before.scriptContent, after.scriptContent},
+		{"JSEndpoints", before.JSEndpoints, after.JSEndpoints},
+		{"OpenAPI", before.OpenAPI, after.OpenAPI},
+		{"GraphQL", before.GraphQL, after.GraphQL},
+		{"ThirdParties", before.ThirdParties, after.ThirdParties},
+		{"Coverage", before.Coverage, after.Coverage},
+		{"Findings", before.Findings, after.Findings},
+	} {
+		if !reflect.DeepEqual(field.before, field.after) {
+			t.Errorf("%s = %+v after resuming, want %+v", field.name, field.after, field.before)
+		}
+	}
+
+	// What was found isn't checked or reported again
+	if resumed.secrets.add(Secret{Rule: "jwt", Match: "eyJ", Page: target + "other", Script: target + "app.js"}) {
+		t.Error("a restored secret was added again")
+	}
+	if resumed.scripts.addPage(target+"app.js", target+"other") {
+		t.Error("a restored script would be downloaded again")
+	}
+	if resumed.documents.first(target + "openapi.json") {
+		t.Error("a restored OpenAPI spec would be fetched again")
+	}
+	if !resumed.takeovers.checked["docs.example.com"] {
+		t.Error("a restored takeover candidate would be fingerprinted again")
+	}
+}
+
+func TestWriteCrawlStateKeepsThePreviousFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "state.json")
+	first := &CrawlState{Targets: map[string]*TargetState{"https://example.com/": {Visited: []string{"https://example.com/"}}}}
+	if err := WriteCrawlState(path, first); err != nil {
+		t.Fatalf("WriteCrawlState: %v", err)
+	}
+	// A state that can't be encoded doesn't replace the saved one
+	broken := &CrawlState{Targets: map[string]*TargetState{"https://example.com/": {Result: &Result{Findings: []Finding{{Time: time.Date(10000, 1, 1, 0, 0, 0, 0, time.UTC)}}}}}}
+	if err := WriteCrawlState(path, broken); err == nil {
+		t.Fatal("WriteCrawlState saved a state that can't be encoded")
+	}
+	state, err := LoadCrawlState(path)
+	if err != nil {
+		t.Fatalf("LoadCrawlState: %v", err)
+	}
+	if !reflect.DeepEqual(state, first) {
+		t.Errorf("state = %+v, want %+v", state, first)
+	}
+	entries, _ := os.ReadDir(dir)
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"state.json"}) {
+		t.Errorf("files left in the directory: %v", names)
+	}
+}
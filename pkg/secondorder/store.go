@@ -0,0 +1,147 @@
+package secondorder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ResultStore saves what the scans find, every writer of results goes through it so the backend can be swapped
+// names are slash-separated paths relative to the root of the store, like "pages.json" or "example.com/scripts/<hash>.js"
+// saving a name again replaces what was saved under it, and nothing is saved once ctx is cancelled
+type ResultStore interface {
+	// WriteJSON saves v marshalled to JSON
+	WriteJSON(ctx context.Context, name string, v interface{}) error
+	// WriteFile saves raw content, like downloaded scripts and reports
+	WriteFile(ctx context.Context, name string, content []byte) error
+	Close() error
+}
+
+// OpenResultStore opens the store a location points to:
+// a directory, sqlite://<path>, postgres://<connection URL>, s3://<bucket>/<prefix> or - for stdout
+// compression only applies to directories
+func OpenResultStore(location, compression string) (ResultStore, error) {
+	if location == "-" {
+		return NewStreamStore(os.Stdout), nil
+	}
+	scheme := ""
+	if i := strings.Index(location, "://"); i != -1 {
+		scheme = location[:i]
+	}
+	switch scheme {
+	case "":
+		return NewFileStore(location, compression)
+	case "sqlite":
+		return newSQLStore("sqlite3", strings.TrimPrefix(location, "sqlite://"))
+	case "postgres", "postgresql":
+		return newSQLStore("postgres", location)
+	case "s3":
+		return newS3Store(strings.TrimPrefix(location, "s3://"))
+	}
+	return nil, fmt.Errorf("unknown result store %q, use a directory, sqlite://, postgres://, s3:// or -", location)
+}
+
+// fileStore saves results as files in a directory, the way they've always been saved
+type fileStore struct {
+	dir         string
+	compression string
+}
+
+// NewFileStore returns a store that saves results in dir, compressed with the given algorithm
+func NewFileStore(dir, compression string) (ResultStore, error) {
+	if err := ValidateCompression(compression); err != nil {
+		return nil, err
+	}
+	return &fileStore{dir: dir, compression: compression}, nil
+}
+
+func (s *fileStore) path(name string) (string, error) {
+	path := filepath.Join(s.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (s *fileStore) WriteJSON(ctx context.Context, name string, v interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	return WriteJSON(path, v, s.compression)
+}
+
+func (s *fileStore) WriteFile(ctx context.Context, name string, content []byte) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	path, err := s.path(name)
+	if err != nil {
+		return err
+	}
+	f, err := createResultFile(path, s.compression)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func (s *fileStore) Close() error {
+	return nil
+}
+
+// streamStore writes every result to a stream as a JSON object per line, for jobs that have no writable volume
+// JSON results are embedded as they are in "content", other files are base64-encoded in "data"
+type streamStore struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+// streamEntry is a line of a streamStore
+type streamEntry struct {
+	Name    string          `json:"name"`
+	Content json.RawMessage `json:"content,omitempty"`
+	Data    []byte          `json:"data,omitempty"`
+}
+
+// NewStreamStore returns a store that writes results to w, one JSON line per file
+func NewStreamStore(w io.Writer) ResultStore {
+	return &streamStore{encoder: json.NewEncoder(w)}
+}
+
+func (s *streamStore) WriteJSON(ctx context.Context, name string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return s.write(ctx, streamEntry{Name: name, Content: content})
+}
+
+func (s *streamStore) WriteFile(ctx context.Context, name string, content []byte) error {
+	return s.write(ctx, streamEntry{Name: name, Data: content})
+}
+
+func (s *streamStore) write(ctx context.Context, entry streamEntry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.encoder.Encode(entry)
+}
+
+func (s *streamStore) Close() error {
+	return nil
+}
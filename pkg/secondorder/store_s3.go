@@ -0,0 +1,187 @@
+package secondorder
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// s3Store saves results as objects in an S3 bucket, signed with AWS Signature Version 4
+// the credentials and region are read from the usual AWS environment variables,
+// and AWS_ENDPOINT_URL points it to S3-compatible servers (MinIO, R2...) with path-style URLs
+type s3Store struct {
+	bucket    string
+	prefix    string
+	region    string
+	accessKey string
+	secretKey string
+	token     string
+	// Endpoint of S3-compatible servers, empty for AWS
+	endpoint string
+	client   *http.Client
+}
+
+func newS3Store(location string) (*s3Store, error) {
+	parts := strings.SplitN(location, "/", 2)
+	s := &s3Store{
+		bucket:    parts[0],
+		region:    firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		accessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		token:     os.Getenv("AWS_SESSION_TOKEN"),
+		endpoint:  strings.TrimSuffix(os.Getenv("AWS_ENDPOINT_URL"), "/"),
+		client:    &http.Client{Timeout: 60 * time.Second},
+	}
+	if len(parts) == 2 {
+		s.prefix = strings.Trim(parts[1], "/")
+	}
+	if s.bucket == "" {
+		return nil, fmt.Errorf("s3 result store needs a bucket: s3://<bucket>/<prefix>")
+	}
+	if s.accessKey == "" || s.secretKey == "" {
+		return nil, fmt.Errorf("s3 result store needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	if s.region == "" {
+		s.region = "us-east-1"
+	}
+	return s, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if value := os.Getenv(name); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+func (s *s3Store) WriteJSON(ctx context.Context, name string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("couldn't write resources to JSON: %v", err)
+	}
+	return s.put(ctx, name, content, "application/json")
+}
+
+func (s *s3Store) WriteFile(ctx context.Context, name string, content []byte) error {
+	contentType := "application/octet-stream"
+	switch path.Ext(name) {
+	case ".js":
+		contentType = "application/javascript"
+	case ".html":
+		contentType = "text/html"
+	}
+	return s.put(ctx, name, content, contentType)
+}
+
+func (s *s3Store) Close() error {
+	return nil
+}
+
+// objectURL returns the URL of an object, virtual-hosted on AWS and path-style on other endpoints
+func (s *s3Store) objectURL(key string) string {
+	escaped := s3Escape(key)
+	if s.endpoint != "" {
+		return s.endpoint + "/" + s3Escape(s.bucket) + "/" + escaped
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, escaped)
+}
+
+func (s *s3Store) put(ctx context.Context, name string, content []byte, contentType string) error {
+	key := path.Join(s.prefix, name)
+	req, err := http.NewRequestWithContext(ctx, "PUT", s.objectURL(key), bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	s.sign(req, content, time.Now().UTC())
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return fmt.Errorf("s3 returned status %d for %s: %s", res.StatusCode, key, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sign adds the AWS Signature Version 4 headers to a request
+func (s *s3Store) sign(req *http.Request, payload []byte, now time.Time) {
+	payloadHash := sha256Hex(payload)
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.token)
+	}
+
+	signed := map[string]string{"host": req.URL.Host}
+	for name := range req.Header {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-amz-") {
+			signed[lower] = strings.TrimSpace(req.Header.Get(name))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for name := range signed {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var headers strings.Builder
+	for _, name := range names {
+		headers.WriteString(name + ":" + signed[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{req.Method, req.URL.EscapedPath(), req.URL.RawQuery, headers.String(), signedHeaders, payloadHash}, "\n")
+	scope := date + "/" + s.region + "/s3/aws4_request"
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{date, s.region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", s.accessKey, scope, signedHeaders, signature))
+}
+
+// s3Escape encodes an object key the way Signature Version 4 expects, everything but unreserved characters and slashes
+func s3Escape(key string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(key) {
+		switch {
+		case 'A' <= b && b <= 'Z', 'a' <= b && b <= 'z', '0' <= b && b <= '9', b == '-', b == '_', b == '.', b == '~', b == '/':
+			escaped.WriteByte(b)
+		default:
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,54 @@
+package secondorder
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	// Drivers of the SQL result stores
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqlStore saves results as rows of a table, one row per document, in SQLite or PostgreSQL
+type sqlStore struct {
+	db     *sql.DB
+	upsert string
+}
+
+func newSQLStore(driver, dataSource string) (*sqlStore, error) {
+	db, err := sql.Open(driver, dataSource)
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s result store: %v", driver, err)
+	}
+
+	blob, upsert := "BLOB", "INSERT INTO second_order_results (name, content, updated_at) VALUES (?, ?, ?) ON CONFLICT (name) DO UPDATE SET content = excluded.content, updated_at = excluded.updated_at"
+	if driver == "postgres" {
+		blob, upsert = "BYTEA", "INSERT INTO second_order_results (name, content, updated_at) VALUES ($1, $2, $3) ON CONFLICT (name) DO UPDATE SET content = excluded.content, updated_at = excluded.updated_at"
+	}
+	_, err = db.Exec("CREATE TABLE IF NOT EXISTS second_order_results (name TEXT PRIMARY KEY, content " + blob + " NOT NULL, updated_at TIMESTAMP NOT NULL)")
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not create the table of the %s result store: %v", driver, err)
+	}
+	return &sqlStore{db: db, upsert: upsert}, nil
+}
+
+func (s *sqlStore) WriteJSON(ctx context.Context, name string, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("couldn't write resources to JSON: %v", err)
+	}
+	return s.WriteFile(ctx, name, content)
+}
+
+func (s *sqlStore) WriteFile(ctx context.Context, name string, content []byte) error {
+	_, err := s.db.ExecContext(ctx, s.upsert, name, content, time.Now().UTC())
+	return err
+}
+
+func (s *sqlStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,136 @@
+package secondorder
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+)
+
+// Version of second-order saved in summary.json, set when building with
+// -ldflags "-X github.com/mhmdiaa/second-order/pkg/secondorder.Version=v3.0.0"
+// without it, the version of the module go install built is used
+var Version = ""
+
+func version() string {
+	if Version != "" {
+		return Version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "devel"
+}
+
+// ScanSummary is what the scan of a target did, saved in summary.json
+// so automation can tell a scan that found nothing apart from one that didn't get to look
+type ScanSummary struct {
+	Target  string    `json:"target"`
+	Version string    `json:"version"`
+	Start   time.Time `json:"start"`
+	// End is nil while the scan is running
+	End   *time.Time `json:"end,omitempty"`
+	Pages int        `json:"pages"`
+	// Requests sent to the targets and the URLs their pages reference, retries included
+	Requests int64 `json:"requests"`
+	// Requests that got no response: timeouts, refused connections, DNS and TLS errors
+	Errors int64 `json:"errors"`
+	// Number of findings of each type
+	Findings map[string]int `json:"findings"`
+	// Links the crawl left out, by reason, like in coverage.json
+	Skipped map[string]int `json:"skipped,omitempty"`
+	// Budget that stopped the scan, if one did
+	Stopped string `json:"stopped,omitempty"`
+}
+
+// scanStats counts the requests of a scan, it travels in the context of the scan so the verification requests
+// the scan leads to are counted as well, whichever transport sends them
+type scanStats struct {
+	requests int64
+	errors   int64
+	// Resources waiting in the pipeline to be verified or resolved
+	queued int64
+}
+
+type scanStatsKey struct{}
+
+func withScanStats(ctx context.Context, stats *scanStats) context.Context {
+	return context.WithValue(ctx, scanStatsKey{}, stats)
+}
+
+// countingTransport counts the requests it sends in the stats of the scan they're sent for
+type countingTransport struct {
+	transport http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.transport.RoundTrip(req)
+	if stats, ok := req.Context().Value(scanStatsKey{}).(*scanStats); ok {
+		atomic.AddInt64(&stats.requests, 1)
+		// Requests cut short by the end of the scan didn't fail
+		if err != nil && req.Context().Err() == nil {
+			atomic.AddInt64(&stats.errors, 1)
+		}
+	}
+	return res, err
+}
+
+// Stats is what the scans of a Scanner did so far, for progress displays that tell a slow scan from a stuck one
+type Stats struct {
+	// Targets started, and the ones of them that finished
+	Targets  int
+	Finished int
+	Pages    int
+	// Resources waiting to be verified or resolved
+	Queued   int64
+	Findings int
+	// Requests sent and the ones that got no response, like in summary.json
+	Requests int64
+	Errors   int64
+}
+
+// Stats adds up the counts of every target run so far, including the ones that are still running
+func (sc *Scanner) Stats() Stats {
+	sc.mu.Lock()
+	scans := append([]*scan(nil), sc.scans...)
+	sc.mu.Unlock()
+
+	var stats Stats
+	for _, s := range scans {
+		stats.Targets++
+		if _, ok := s.end.Load().(time.Time); ok {
+			stats.Finished++
+		}
+		stats.Pages += s.pages.count()
+		stats.Findings += s.findings.count()
+		stats.Queued += atomic.LoadInt64(&s.stats.queued)
+		stats.Requests += atomic.LoadInt64(&s.stats.requests)
+		stats.Errors += atomic.LoadInt64(&s.stats.errors)
+	}
+	return stats
+}
+
+// summary counts what the scan did so far
+func (s *scan) summary(pages []*Page, findings []Finding, coverage *Coverage) *ScanSummary {
+	summary := &ScanSummary{
+		Target:   s.target,
+		Version:  version(),
+		Start:    s.start,
+		Pages:    len(pages),
+		Requests: atomic.LoadInt64(&s.stats.requests),
+		Errors:   atomic.LoadInt64(&s.stats.errors),
+		Findings: make(map[string]int),
+	}
+	if end, ok := s.end.Load().(time.Time); ok {
+		summary.End = &end
+	}
+	for _, f := range findings {
+		summary.Findings[f.Type]++
+	}
+	if coverage != nil {
+		summary.Skipped = coverage.Counts
+		summary.Stopped = coverage.Stopped
+	}
+	return summary
+}
@@ -0,0 +1,222 @@
+package secondorder
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// takeoverFingerprint describes how an unclaimed resource of a hosting service looks like
+// a host is a candidate if it's on the service (itself or through its CNAME chain) and the response body matches,
+// or, for services that don't serve an error page, if the name doesn't resolve
+type takeoverFingerprint struct {
+	Service string
+	// Pattern of the hostnames of the service
+	CNAME *regexp.Regexp
+	// Pattern of the page the service serves for names that aren't claimed
+	Body *regexp.Regexp
+	// The service is vulnerable when its hostname doesn't resolve
+	NXDOMAIN bool
+}
+
+var takeoverFingerprints = []takeoverFingerprint{
+	{Service: "GitHub Pages", CNAME: regexp.MustCompile(`\.github\.io$`), Body: regexp.MustCompile(`There isn't a GitHub Pages site here`)},
+	{Service: "Heroku", CNAME: regexp.MustCompile(`\.(?:herokuapp|herokudns|herokussl)\.com$`), Body: regexp.MustCompile(`No such app|herokucdn\.com/error-pages/no-such-app\.html`)},
+	{Service: "AWS S3", CNAME: regexp.MustCompile(`\.s3(?:[.-][\w-]+)*\.amazonaws\.com$`), Body: regexp.MustCompile(`NoSuchBucket|The specified bucket does not exist`)},
+	{Service: "Google Cloud Storage", CNAME: regexp.MustCompile(`(?:^|\.)(?:c\.)?storage\.googleapis\.com$`), Body: regexp.MustCompile(`NoSuchBucket|The specified bucket does not exist`)},
+	{Service: "Azure", CNAME: regexp.MustCompile(`\.(?:azurewebsites\.net|cloudapp\.net|cloudapp\.azure\.com|trafficmanager\.net|blob\.core\.windows\.net|azureedge\.net|azure-api\.net|azurefd\.net|azurecontainer\.io|database\.windows\.net|azurehdinsight\.net|redis\.cache\.windows\.net|search\.windows\.net|servicebus\.windows\.net|visualstudio\.com)$`), NXDOMAIN: true},
+	{Service: "Fastly", CNAME: regexp.MustCompile(`\.fastly\.net$`), Body: regexp.MustCompile(`Fastly error: unknown domain`)},
+	{Service: "Shopify", CNAME: regexp.MustCompile(`\.myshopify\.com$`), Body: regexp.MustCompile(`Sorry, this shop is currently unavailable`)},
+	{Service: "Netlify", CNAME: regexp.MustCompile(`\.netlify\.(?:app|com)$`), Body: regexp.MustCompile(`Not Found - Request ID`)},
+	{Service: "Surge.sh", CNAME: regexp.MustCompile(`\.surge\.sh$`), Body: regexp.MustCompile(`project not found`)},
+	{Service: "Bitbucket", CNAME: regexp.MustCompile(`\.bitbucket\.io$`), Body: regexp.MustCompile(`Repository not found`)},
+	{Service: "Ghost", CNAME: regexp.MustCompile(`\.ghost\.io$`), Body: regexp.MustCompile(`The thing you were looking for is no longer here`)},
+	{Service: "Pantheon", CNAME: regexp.MustCompile(`\.pantheonsite\.io$`), Body: regexp.MustCompile(`The gods are wise, but do not know of the site which you seek`)},
+	{Service: "Tumblr", CNAME: regexp.MustCompile(`(?:^|\.)domains\.tumblr\.com$`), Body: regexp.MustCompile(`Whatever you were looking for doesn't currently exist at this address`)},
+	{Service: "Zendesk", CNAME: regexp.MustCompile(`\.zendesk\.com$`), Body: regexp.MustCompile(`Help Center Closed`)},
+	{Service: "Help Scout", CNAME: regexp.MustCompile(`\.helpscoutdocs\.com$`), Body: regexp.MustCompile(`No settings were found for this company`)},
+	{Service: "Unbounce", CNAME: regexp.MustCompile(`\.unbouncepages\.com$`), Body: regexp.MustCompile(`The requested URL was not found on this server`)},
+	{Service: "ReadMe", CNAME: regexp.MustCompile(`\.readme\.io$`), Body: regexp.MustCompile(`Project doesnt exist\.\.\. yet!`)},
+	{Service: "Agile CRM", CNAME: regexp.MustCompile(`\.agilecrm\.com$`), Body: regexp.MustCompile(`Sorry, this page is no longer available`)},
+	{Service: "Strikingly", CNAME: regexp.MustCompile(`\.strikinglydns\.com$`), Body: regexp.MustCompile(`But if you're looking to build your own website`)},
+	{Service: "Uberflip", CNAME: regexp.MustCompile(`\.uberflip\.com$`), Body: regexp.MustCompile(`The URL you've accessed does not provide a hub`)},
+	{Service: "WordPress.com", CNAME: regexp.MustCompile(`\.wordpress\.com$`), Body: regexp.MustCompile(`Do you want to register .*\.wordpress\.com`)},
+}
+
+// TakeoverCandidate is an external host that matches the fingerprint of an unclaimed resource of a hosting service
+type TakeoverCandidate struct {
+	Host    string   `json:"host"`
+	Service string   `json:"service"`
+	CNAMEs  []string `json:"cnames,omitempty"`
+	// What matched: the body pattern of the service, or NXDOMAIN
+	Fingerprint string `json:"fingerprint"`
+	// The part of the response that matched, or the resolution error
+	Evidence string `json:"evidence"`
+	// How likely the host can be claimed, from 0 to 100, its level (high, medium or low) and the signals the score adds up
+	Confidence      int      `json:"confidence"`
+	ConfidenceLevel string   `json:"confidence_level"`
+	Signals         []string `json:"signals"`
+	Pages           []string `json:"pages"`
+}
+
+type takeoverCandidates struct {
+	sync.Mutex
+	candidates map[string]*TakeoverCandidate
+	// Hosts that were already fingerprinted in this scan, candidates or not
+	checked map[string]bool
+	// Pages of the hosts being fingerprinted, they're kept with the host if it's a candidate
+	pending map[string][]string
+}
+
+func newTakeoverCandidates() *takeoverCandidates {
+	return &takeoverCandidates{candidates: make(map[string]*TakeoverCandidate), checked: make(map[string]bool), pending: make(map[string][]string)}
+}
+
+// restore adds takeover candidates saved by an interrupted run, their hosts aren't fingerprinted again
+func (t *takeoverCandidates) restore(candidates []*TakeoverCandidate) {
+	t.Lock()
+	defer t.Unlock()
+	for _, candidate := range candidates {
+		t.candidates[candidate.Host] = candidate
+		t.checked[candidate.Host] = true
+	}
+}
+
+// list returns copies of the candidates sorted by host
+func (t *takeoverCandidates) list() []*TakeoverCandidate {
+	t.Lock()
+	defer t.Unlock()
+	list := make([]*TakeoverCandidate, 0, len(t.candidates))
+	for _, candidate := range t.candidates {
+		c := *candidate
+		c.CNAMEs = append([]string(nil), candidate.CNAMEs...)
+		c.Signals = append([]string(nil), candidate.Signals...)
+		c.Pages = append([]string(nil), candidate.Pages...)
+		sort.Strings(c.Pages)
+		list = append(list, &c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Host < list[j].Host })
+	return list
+}
+
+// checkTakeover fingerprints the host of an external resource and records it if it looks claimable
+// a host that was already checked, or is being fingerprinted, is only linked to the new page
+func (s *scan) checkTakeover(page, resource string) {
+	host, ok := s.thirdPartyHost(resource)
+	if !ok {
+		return
+	}
+
+	s.takeovers.Lock()
+	if c, ok := s.takeovers.candidates[host]; ok && !contains(c.Pages, page) {
+		c.Pages = append(c.Pages, page)
+	}
+	if pages, ok := s.takeovers.pending[host]; ok && !contains(pages, page) {
+		s.takeovers.pending[host] = append(pages, page)
+	}
+	checked := s.takeovers.checked[host]
+	if !checked {
+		s.takeovers.checked[host] = true
+		s.takeovers.pending[host] = []string{page}
+	}
+	s.takeovers.Unlock()
+	if checked {
+		return
+	}
+
+	candidate := s.fingerprintTakeover(s.ctx, host)
+	s.takeovers.Lock()
+	pages := s.takeovers.pending[host]
+	delete(s.takeovers.pending, host)
+	if candidate != nil {
+		candidate.Pages = pages
+		s.takeovers.candidates[host] = candidate
+	}
+	s.takeovers.Unlock()
+	if candidate == nil {
+		return
+	}
+	s.report(Finding{Type: FindingTakeover, Page: page, Resource: host, Detail: candidate.Service, Confidence: candidate.Confidence})
+}
+
+// fingerprintTakeover resolves the CNAME chain of a host and compares it and the host's response with the fingerprints
+func (sc *Scanner) fingerprintTakeover(ctx context.Context, host string) *TakeoverCandidate {
+	chain, err := sc.resolver.LookupChain(ctx, host)
+	nxdomain := isNXDOMAIN(err)
+
+	var body []byte
+	status, fetched := 0, false
+	for _, fp := range sc.rules().takeoverFingerprints {
+		if !fp.matchesName(host, chain) {
+			continue
+		}
+		candidate := &TakeoverCandidate{Host: host, Service: fp.Service, CNAMEs: chain}
+		if fp.NXDOMAIN {
+			if nxdomain {
+				candidate.Fingerprint, candidate.Evidence = "NXDOMAIN", err.Error()
+				sc.scoreTakeover(ctx, candidate, fp, 0)
+				return candidate
+			}
+			continue
+		}
+		if nxdomain {
+			continue
+		}
+		if !fetched {
+			body, status = sc.fetchRoot(ctx, host)
+			fetched = true
+		}
+		if loc := fp.Body.FindIndex(body); loc != nil {
+			candidate.Fingerprint, candidate.Evidence = fp.Body.String(), evidence(body, loc)
+			sc.scoreTakeover(ctx, candidate, fp, status)
+			return candidate
+		}
+	}
+	return nil
+}
+
+// matchesName reports whether the host or a name in its CNAME chain belongs to the service
+func (fp takeoverFingerprint) matchesName(host string, chain []string) bool {
+	for _, name := range append([]string{host}, chain...) {
+		if fp.CNAME.MatchString(strings.TrimSuffix(strings.ToLower(name), ".")) {
+			return true
+		}
+	}
+	return false
+}
+
+// fetchRoot returns the beginning of the body of a host's root page and its status code, over HTTPS and then HTTP
+func (sc *Scanner) fetchRoot(ctx context.Context, host string) ([]byte, int) {
+	for _, scheme := range []string{"https", "http"} {
+		req, err := http.NewRequestWithContext(ctx, "GET", scheme+"://"+host+"/", nil)
+		if err != nil {
+			return nil, 0
+		}
+		sc.addHeaders(req)
+		res, err := sc.verifyClient.Do(req)
+		if err != nil {
+			continue
+		}
+		body, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1<<20))
+		res.Body.Close()
+		return body, res.StatusCode
+	}
+	return nil, 0
+}
+
+// evidence returns the match and some of the text around it
+func evidence(body []byte, loc []int) string {
+	start, end := loc[0]-80, loc[1]+80
+	if start < 0 {
+		start = 0
+	}
+	if end > len(body) {
+		end = len(body)
+	}
+	return strings.Join(strings.Fields(strings.ToValidUTF8(string(body[start:end]), "")), " ")
+}
@@ -0,0 +1,42 @@
+package secondorder
+
+import "testing"
+
+func TestTakeoverFingerprintMatchesName(t *testing.T) {
+	fingerprints := make(map[string]takeoverFingerprint, len(takeoverFingerprints))
+	for _, fp := range takeoverFingerprints {
+		fingerprints[fp.Service] = fp
+	}
+	tests := []struct {
+		service string
+		host    string
+		chain   []string
+		matches bool
+	}{
+		{"GitHub Pages", "acme.github.io", nil, true},
+		{"GitHub Pages", "docs.example.com", []string{"acme.github.io."}, true},
+		{"GitHub Pages", "docs.example.com", []string{"ACME.GitHub.IO"}, true},
+		{"GitHub Pages", "docs.example.com", []string{"acme.github.io.example.com"}, false},
+		{"GitHub Pages", "github.io", nil, false},
+		{"GitHub Pages", "docs.example.com", nil, false},
+		{"Heroku", "app.example.com", []string{"app.example.com.herokudns.com"}, true},
+		{"AWS S3", "assets.example.com", []string{"assets.example.com.s3.amazonaws.com"}, true},
+		{"AWS S3", "assets.example.com", []string{"assets.s3-website-us-east-1.amazonaws.com"}, true},
+		{"AWS S3", "assets.example.com", []string{"assets.cloudfront.net"}, false},
+		{"Google Cloud Storage", "c.storage.googleapis.com", nil, true},
+		{"Azure", "app.example.com", []string{"edge.example.net", "acme.azurewebsites.net"}, true},
+		{"Azure", "app.example.com", []string{"acme.azurewebsites.net.evil.com"}, false},
+		{"Tumblr", "blog.example.com", []string{"domains.tumblr.com"}, true},
+		{"Tumblr", "blog.example.com", []string{"notdomains.tumblr.com"}, false},
+	}
+	for _, tt := range tests {
+		fp, ok := fingerprints[tt.service]
+		if !ok {
+			t.Errorf("no fingerprint for %s", tt.service)
+			continue
+		}
+		if got := fp.matchesName(tt.host, tt.chain); got != tt.matches {
+			t.Errorf("%s matchesName(%q, %q) = %v, want %v", tt.service, tt.host, tt.chain, got, tt.matches)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package secondorder
+
+import (
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// technology describes how to recognize a framework, CMS, or service from a page
+// a page uses a technology if any of its patterns match
+type technology struct {
+	Name string
+	// Header name -> pattern of its value
+	Headers map[string]*regexp.Regexp
+	// Prefixes of the names of cookies set by the technology
+	Cookies []string
+	// Patterns of the URLs of scripts loaded from the page
+	Scripts []*regexp.Regexp
+	// Pattern of the content of the generator meta tag
+	Generator *regexp.Regexp
+}
+
+var technologies = []technology{
+	{Name: "WordPress", Scripts: []*regexp.Regexp{regexp.MustCompile(`/wp-(?:content|includes)/`)}, Generator: regexp.MustCompile(`(?i)^WordPress`)},
+	{Name: "Drupal", Headers: map[string]*regexp.Regexp{"X-Generator": regexp.MustCompile(`(?i)Drupal`), "X-Drupal-Cache": regexp.MustCompile(``)}, Scripts: []*regexp.Regexp{regexp.MustCompile(`/(?:misc/drupal\.js|core/misc/drupal)`)}, Generator: regexp.MustCompile(`(?i)^Drupal`)},
+	{Name: "Joomla", Generator: regexp.MustCompile(`(?i)^Joomla`), Scripts: []*regexp.Regexp{regexp.MustCompile(`/media/(?:jui|system)/js/`)}},
+	{Name: "Ghost", Generator: regexp.MustCompile(`(?i)^Ghost`)},
+	{Name: "Shopify", Headers: map[string]*regexp.Regexp{"X-ShopId": regexp.MustCompile(``)}, Scripts: []*regexp.Regexp{regexp.MustCompile(`cdn\.shopify\.com`)}},
+	{Name: "Magento", Cookies: []string{"frontend", "mage-"}, Scripts: []*regexp.Regexp{regexp.MustCompile(`/static/version\d+/frontend/|mage/`)}},
+	{Name: "PHP", Headers: map[string]*regexp.Regexp{"X-Powered-By": regexp.MustCompile(`(?i)PHP`)}, Cookies: []string{"PHPSESSID"}},
+	{Name: "ASP.NET", Headers: map[string]*regexp.Regexp{"X-Powered-By": regexp.MustCompile(`(?i)ASP\.NET`), "X-AspNet-Version": regexp.MustCompile(``)}, Cookies: []string{"ASP.NET_SessionId", ".AspNetCore."}},
+	{Name: "Java", Cookies: []string{"JSESSIONID"}},
+	{Name: "Express", Headers: map[string]*regexp.Regexp{"X-Powered-By": regexp.MustCompile(`(?i)^Express`)}},
+	{Name: "Next.js", Headers: map[string]*regexp.Regexp{"X-Powered-By": regexp.MustCompile(`(?i)Next\.js`)}, Scripts: []*regexp.Regexp{regexp.MustCompile(`/_next/static/`)}},
+	{Name: "Nuxt.js", Scripts: []*regexp.Regexp{regexp.MustCompile(`/_nuxt/`)}},
+	{Name: "Laravel", Cookies: []string{"laravel_session", "XSRF-TOKEN"}},
+	{Name: "Django", Cookies: []string{"csrftoken", "django_language"}},
+	{Name: "Ruby on Rails", Headers: map[string]*regexp.Regexp{"X-Runtime": regexp.MustCompile(`^\d`)}, Cookies: []string{"_rails_session"}},
+	{Name: "Nginx", Headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`(?i)nginx`)}},
+	{Name: "Apache", Headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`(?i)apache`)}},
+	{Name: "IIS", Headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`(?i)IIS`)}},
+	{Name: "Cloudflare", Headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`(?i)cloudflare`), "CF-RAY": regexp.MustCompile(``)}, Cookies: []string{"__cf_bm", "__cfduid"}},
+	{Name: "Amazon CloudFront", Headers: map[string]*regexp.Regexp{"X-Amz-Cf-Id": regexp.MustCompile(``), "Via": regexp.MustCompile(`(?i)CloudFront`)}},
+	{Name: "Amazon S3", Headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`AmazonS3`)}},
+	{Name: "Fastly", Headers: map[string]*regexp.Regexp{"X-Served-By": regexp.MustCompile(`cache-`), "Fastly-Debug-Digest": regexp.MustCompile(``)}},
+	{Name: "Akamai", Headers: map[string]*regexp.Regexp{"X-Akamai-Transformed": regexp.MustCompile(``), "Server": regexp.MustCompile(`AkamaiGHost`)}},
+	{Name: "Heroku", Headers: map[string]*regexp.Regexp{"Via": regexp.MustCompile(`vegur`)}},
+	{Name: "GitHub Pages", Headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`GitHub\.com`)}},
+	{Name: "Netlify", Headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`Netlify`), "X-NF-Request-ID": regexp.MustCompile(``)}},
+	{Name: "Vercel", Headers: map[string]*regexp.Regexp{"Server": regexp.MustCompile(`Vercel`), "X-Vercel-Id": regexp.MustCompile(``)}},
+	{Name: "jQuery", Scripts: []*regexp.Regexp{regexp.MustCompile(`jquery[.-][\d.]*(?:min\.)?js`)}},
+	{Name: "React", Scripts: []*regexp.Regexp{regexp.MustCompile(`react(?:-dom)?(?:\.production)?(?:\.min)?\.js`)}},
+	{Name: "Vue.js", Scripts: []*regexp.Regexp{regexp.MustCompile(`vue(?:\.runtime)?(?:\.min)?\.js`)}},
+	{Name: "Angular", Scripts: []*regexp.Regexp{regexp.MustCompile(`angular(?:\.min)?\.js`)}},
+	{Name: "Bootstrap", Scripts: []*regexp.Regexp{regexp.MustCompile(`bootstrap(?:\.bundle)?(?:\.min)?\.js`)}},
+	{Name: "Google Analytics", Scripts: []*regexp.Regexp{regexp.MustCompile(`google-analytics\.com/(?:ga|analytics)\.js|googletagmanager\.com/gtag/js`)}},
+	{Name: "Google Tag Manager", Scripts: []*regexp.Regexp{regexp.MustCompile(`googletagmanager\.com/gtm\.js`)}},
+	{Name: "Segment", Scripts: []*regexp.Regexp{regexp.MustCompile(`cdn\.segment\.com`)}},
+	{Name: "Hotjar", Scripts: []*regexp.Regexp{regexp.MustCompile(`static\.hotjar\.com`)}},
+	{Name: "Intercom", Scripts: []*regexp.Regexp{regexp.MustCompile(`widget\.intercom\.io|js\.intercomcdn\.com`)}},
+	{Name: "Stripe", Scripts: []*regexp.Regexp{regexp.MustCompile(`js\.stripe\.com`)}},
+	{Name: "reCAPTCHA", Scripts: []*regexp.Regexp{regexp.MustCompile(`google\.com/recaptcha/`)}},
+}
+
+// fingerprintResponse returns the technologies recognized from the headers and cookies of a response
+func fingerprintResponse(h *http.Header) []string {
+	if h == nil {
+		return nil
+	}
+	var found []string
+	for _, tech := range technologies {
+		if matchHeaders(tech, *h) || matchCookies(tech, *h) {
+			found = append(found, tech.Name)
+		}
+	}
+	return found
+}
+
+// fingerprintScript returns the technologies recognized from the URL of a script
+func fingerprintScript(src string) []string {
+	var found []string
+	for _, tech := range technologies {
+		for _, re := range tech.Scripts {
+			if re.MatchString(src) {
+				found = append(found, tech.Name)
+				break
+			}
+		}
+	}
+	return found
+}
+
+// fingerprintGenerator returns the technologies recognized from the generator meta tag
+func fingerprintGenerator(content string) []string {
+	var found []string
+	for _, tech := range technologies {
+		if tech.Generator != nil && tech.Generator.MatchString(content) {
+			found = append(found, tech.Name)
+		}
+	}
+	return found
+}
+
+func matchHeaders(tech technology, h http.Header) bool {
+	for name, re := range tech.Headers {
+		for _, value := range h.Values(name) {
+			if re.MatchString(value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func matchCookies(tech technology, h http.Header) bool {
+	for _, cookie := range h.Values("Set-Cookie") {
+		for _, prefix := range tech.Cookies {
+			if strings.HasPrefix(cookie, prefix) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,46 @@
+package secondorder
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// textSink writes every finding as a line of text as soon as it's found, for shell pipelines
+type textSink struct {
+	mu  sync.Mutex
+	w   io.Writer
+	err error
+}
+
+// NewTextSink returns a sink that writes findings to w, one per line like
+// [non-200] [medium] https://cdn.example.com/app.js https://example.com/login
+// with the type, the severity, the resource (or the page when the finding has no resource) and the page it's on
+// w isn't closed by the sink
+func NewTextSink(w io.Writer) FindingSink {
+	return &textSink{w: w}
+}
+
+func (s *textSink) Send(f Finding) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := fmt.Fprintln(s.w, findingLine(f)); err != nil && s.err == nil {
+		s.err = err
+	}
+}
+
+// findingLine is the line of text of a finding: its type, severity, resource and page
+func findingLine(f Finding) string {
+	resource := f.Resource
+	if resource == "" {
+		resource = f.Page
+	}
+	return fmt.Sprintf("[%s] [%s] %s %s", f.Type, f.Level(), resource, f.Page)
+}
+
+// Close reports the first write that failed, nothing is buffered
+func (s *textSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
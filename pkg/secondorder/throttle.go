@@ -0,0 +1,178 @@
+package secondorder
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// Times a request is sent again after 429 responses before the 429 is returned
+	rateLimitedAttempts = 10
+	// Longest Retry-After honored, so a misconfigured server can't stall the run
+	maxRetryAfter = 5 * time.Minute
+	// Pause after a 429 without Retry-After, doubled while the host keeps answering 429
+	minRateLimitPause = time.Second
+)
+
+// throttle spaces out the requests to the hosts that answered 429 Too Many Requests
+// it's shared by the crawls of all targets and the verification requests, so all of them back off together
+type throttle struct {
+	mu    sync.Mutex
+	hosts map[string]*hostThrottle
+}
+
+// hostThrottle is how the requests to a rate limiting host are held back
+type hostThrottle struct {
+	// No request is sent before this
+	until time.Time
+	// Time between two requests, grown by every 429 and shrunk by every other response
+	delay time.Duration
+	// When the next request can be sent
+	next time.Time
+	// Pause after the next 429 without Retry-After
+	pause time.Duration
+}
+
+func newThrottle() *throttle {
+	return &throttle{hosts: make(map[string]*hostThrottle)}
+}
+
+// wait blocks until a request to host is allowed
+func (t *throttle) wait(ctx context.Context, host string) error {
+	t.mu.Lock()
+	h, ok := t.hosts[host]
+	if !ok {
+		t.mu.Unlock()
+		return nil
+	}
+	now := time.Now()
+	at := now
+	if h.until.After(at) {
+		at = h.until
+	}
+	if h.next.After(at) {
+		at = h.next
+	}
+	h.next = at.Add(h.delay)
+	t.mu.Unlock()
+
+	if !at.After(now) {
+		return nil
+	}
+	timer := time.NewTimer(at.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// limited records a 429 from host, pausing its requests for retryAfter, or for an exponential backoff when it's 0
+// and spacing them out further from then on
+func (t *throttle) limited(host string, retryAfter time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.hosts[host]
+	if !ok {
+		h = &hostThrottle{pause: minRateLimitPause}
+		t.hosts[host] = h
+	}
+	pause := retryAfter
+	if pause <= 0 {
+		pause = h.pause
+		if h.pause < maxRetryAfter {
+			h.pause *= 2
+		}
+	}
+	if pause > maxRetryAfter {
+		pause = maxRetryAfter
+	}
+	now := time.Now()
+	// The 429s of the requests that were in flight when the host started rate limiting are only reported once
+	if h.until.Before(now) {
+		logger.Warnf("%s is rate limiting the scan, waiting %s before the next request to it", host, pause.Round(time.Second))
+	}
+	if until := now.Add(pause); until.After(h.until) {
+		h.until = until
+	}
+	if h.delay == 0 {
+		h.delay = 100 * time.Millisecond
+	} else if h.delay < 10*time.Second {
+		h.delay *= 2
+	}
+}
+
+// succeeded speeds the requests to host back up after a response that wasn't a 429
+func (t *throttle) succeeded(host string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	h, ok := t.hosts[host]
+	if !ok {
+		return
+	}
+	h.delay -= h.delay / 10
+	h.pause = minRateLimitPause
+	if h.delay < 10*time.Millisecond {
+		delete(t.hosts, host)
+	}
+}
+
+// throttleTransport waits for the throttle before each request, and sends requests answered with 429 again
+// once the host allows it, instead of reporting them as dead or leaving their page out of the crawl
+type throttleTransport struct {
+	transport http.RoundTripper
+	throttle  *throttle
+}
+
+func (t *throttleTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	host := req.URL.Host
+	for attempt := 1; ; attempt++ {
+		if err := t.throttle.wait(req.Context(), host); err != nil {
+			return nil, err
+		}
+		res, err := t.transport.RoundTrip(req)
+		if err != nil {
+			return nil, err
+		}
+		if res.StatusCode != http.StatusTooManyRequests {
+			t.throttle.succeeded(host)
+			return res, nil
+		}
+		t.throttle.limited(host, retryAfter(res.Header.Get("Retry-After")))
+		// Request bodies that can't be read again are only sent once
+		if attempt == rateLimitedAttempts || (req.Body != nil && req.GetBody == nil) {
+			return res, nil
+		}
+		io.Copy(ioutil.Discard, io.LimitReader(res.Body, 4096))
+		res.Body.Close()
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// retryAfter parses a Retry-After header, in seconds or as an HTTP date, 0 if it's missing or invalid
+func retryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
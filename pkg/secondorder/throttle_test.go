@@ -0,0 +1,120 @@
+package secondorder
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"3", 3 * time.Second},
+		{"0", 0},
+		{"-5", 0},
+		{"soon", 0},
+		{time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), -time.Hour},
+	}
+	for _, tt := range tests {
+		got := retryAfter(tt.value)
+		if tt.want < 0 {
+			if got > 0 {
+				t.Errorf("retryAfter(%q) = %s, want no wait", tt.value, got)
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("retryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+	if got := retryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)); got < 58*time.Second || got > time.Minute {
+		t.Errorf("retryAfter(a date in a minute) = %s", got)
+	}
+}
+
+func TestThrottle(t *testing.T) {
+	th := newThrottle()
+	th.limited("cdn.example.com", 50*time.Millisecond)
+
+	start := time.Now()
+	if err := th.wait(context.Background(), "other.example.com"); err != nil || time.Since(start) > 20*time.Millisecond {
+		t.Errorf("a host that didn't answer 429 is held back")
+	}
+	if err := th.wait(context.Background(), "cdn.example.com"); err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if waited := time.Since(start); waited < 40*time.Millisecond {
+		t.Errorf("waited %s after a Retry-After of 50ms", waited)
+	}
+
+	// The requests that follow are spaced out
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := th.wait(ctx, "cdn.example.com"); err == nil {
+		t.Error("the next request wasn't spaced out, or the wait wasn't cancelled")
+	}
+
+	// Without Retry-After, the pause doubles while the host keeps answering 429
+	th.limited("api.example.com", 0)
+	th.limited("api.example.com", 0)
+	if pause := th.hosts["api.example.com"].pause; pause != 4*minRateLimitPause {
+		t.Errorf("pause after two 429s = %s, want %s", pause, 4*minRateLimitPause)
+	}
+	th.succeeded("api.example.com")
+	if pause := th.hosts["api.example.com"].pause; pause != minRateLimitPause {
+		t.Errorf("pause after a success = %s, want %s", pause, minRateLimitPause)
+	}
+
+	// A Retry-After that's too long is capped
+	th.limited("slow.example.com", 24*time.Hour)
+	if until := time.Until(th.hosts["slow.example.com"].until); until > maxRetryAfter {
+		t.Errorf("held back for %s, the longest wait is %s", until, maxRetryAfter)
+	}
+
+	// A host that stopped rate limiting is forgotten
+	for i := 0; i < 50; i++ {
+		th.succeeded("slow.example.com")
+	}
+	if _, ok := th.hosts["slow.example.com"]; ok {
+		t.Error("a host that answers again is still throttled")
+	}
+}
+
+func TestThrottleTransport(t *testing.T) {
+	var sent []time.Time
+	transport := &throttleTransport{throttle: newThrottle(), transport: roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent = append(sent, time.Now())
+		if len(sent) == 1 {
+			res := respond(req, http.StatusTooManyRequests)
+			res.Header.Set("Retry-After", "1")
+			return res, nil
+		}
+		return respond(req, http.StatusOK), nil
+	})}
+	req, _ := http.NewRequest(http.MethodGet, "https://cdn.example.com/app.js", nil)
+	res, err := transport.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusOK {
+		t.Fatalf("RoundTrip() = %v, %v, want the response of the request sent again", res, err)
+	}
+	if len(sent) != 2 {
+		t.Fatalf("%d requests sent, want 2", len(sent))
+	}
+	if waited := sent[1].Sub(sent[0]); waited < 900*time.Millisecond {
+		t.Errorf("sent again after %s, the Retry-After was 1s", waited)
+	}
+
+	// A body that can't be read again isn't sent twice, the 429 is returned
+	sent = nil
+	transport.throttle = newThrottle()
+	req, _ = http.NewRequest(http.MethodPost, "https://api.example.com/", ioutil.NopCloser(strings.NewReader("{}")))
+	res, err = transport.RoundTrip(req)
+	if err != nil || res.StatusCode != http.StatusTooManyRequests || len(sent) != 1 {
+		t.Errorf("RoundTrip() = %v, %v after %d requests, want the 429 of the only one", res, err, len(sent))
+	}
+}
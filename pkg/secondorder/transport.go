@@ -0,0 +1,162 @@
+package secondorder
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// limitedTransport caps the number of requests in flight across every transport that shares its slots
+// a slot is held until the response body is closed, since reading the body is most of the work
+// if a rate limiter is set, requests are also spaced out to stay under its rate
+// if IP slots are set, the requests to each IP address are capped as well
+type limitedTransport struct {
+	transport http.RoundTripper
+	slots     chan struct{}
+	rate      *rateLimiter
+	ips       *ipSlots
+}
+
+func newLimitedTransport(transport http.RoundTripper, slots chan struct{}, rate *rateLimiter, ips *ipSlots) *limitedTransport {
+	return &limitedTransport{transport: transport, slots: slots, rate: rate, ips: ips}
+}
+
+// newRequestSlots creates the slots shared by the transports of all targets
+func newRequestSlots(limit int) chan struct{} {
+	if limit < 1 {
+		limit = 1
+	}
+	return make(chan struct{}, limit)
+}
+
+func (t *limitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.rate.wait(req.Context()); err != nil {
+		return nil, err
+	}
+	// The IP slot is taken first so a request waiting for a busy server doesn't hold a global slot
+	releaseIP, err := t.ips.acquire(req.Context(), req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case t.slots <- struct{}{}:
+	case <-req.Context().Done():
+		releaseIP()
+		return nil, req.Context().Err()
+	}
+	release := func() {
+		<-t.slots
+		releaseIP()
+	}
+
+	res, err := t.transport.RoundTrip(req)
+	if err != nil {
+		release()
+		return nil, err
+	}
+	res.Body = &releasingBody{ReadCloser: res.Body, release: release}
+	return res, nil
+}
+
+// ipSlots caps the requests in flight to each IP address
+// many targets have hundreds of subdomains behind one origin server, which per-host limits don't protect
+type ipSlots struct {
+	limit    int
+	resolver *cachingResolver
+
+	mu    sync.Mutex
+	slots map[string]chan struct{}
+}
+
+// newIPSlots creates the slots shared by the transports of all targets, a limit of 0 means no limit
+func newIPSlots(limit int, resolver *cachingResolver) *ipSlots {
+	if limit < 1 {
+		return nil
+	}
+	return &ipSlots{limit: limit, resolver: resolver, slots: make(map[string]chan struct{})}
+}
+
+// acquire waits for a slot of the IP address host resolves to, and returns the function that gives it back
+// hosts that don't resolve are limited by name, their requests fail anyway
+func (s *ipSlots) acquire(ctx context.Context, host string) (func(), error) {
+	if s == nil {
+		return func() {}, nil
+	}
+	key := host
+	if net.ParseIP(host) == nil {
+		if addrs, err := s.resolver.LookupHost(ctx, host); err == nil && len(addrs) > 0 {
+			key = addrs[0]
+		}
+	}
+
+	s.mu.Lock()
+	slots, ok := s.slots[key]
+	if !ok {
+		slots = make(chan struct{}, s.limit)
+		s.slots[key] = slots
+	}
+	s.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// releasingBody gives the slot back when the body is closed
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+	once    sync.Once
+}
+
+func (b *releasingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// contextTransport ties the requests of a scan to its context, so cancelling the scan aborts them
+type contextTransport struct {
+	ctx       context.Context
+	transport http.RoundTripper
+}
+
+func (t *contextTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.transport.RoundTrip(req.WithContext(t.ctx))
+}
+
+// timeoutTransport gives each request its own time to respond, including reading the body
+// unlike the timeout of a client, it doesn't cover the time spent waiting for retries and rate limits
+type timeoutTransport struct {
+	transport http.RoundTripper
+	timeout   time.Duration
+}
+
+func (t *timeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, cancel := context.WithTimeout(req.Context(), t.timeout)
+	res, err := t.transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	res.Body = &cancelingBody{ReadCloser: res.Body, cancel: cancel}
+	return res, nil
+}
+
+// cancelingBody releases the context of a request when its body is closed
+type cancelingBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelingBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
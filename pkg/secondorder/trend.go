@@ -0,0 +1,189 @@
+package secondorder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"os"
+	"strings"
+	"time"
+)
+
+// RunSummary is the size of what one run found, a history of them shows whether the exposure of the targets is shrinking
+type RunSummary struct {
+	Time         time.Time `json:"time"`
+	Targets      int       `json:"targets"`
+	Pages        int       `json:"pages"`
+	ThirdParties int       `json:"third_parties"`
+	// Number of findings of each type
+	Findings map[string]int `json:"findings"`
+}
+
+// Summarize counts the results of a run, third parties shared by several targets are counted once
+func Summarize(results []*Result, t time.Time) RunSummary {
+	summary := RunSummary{Time: t, Targets: len(results), Findings: make(map[string]int)}
+	thirdParties := make(map[string]bool)
+	for _, r := range results {
+		summary.Pages += len(r.Pages)
+		for _, host := range r.ThirdParties {
+			thirdParties[host] = true
+		}
+		for _, f := range r.Findings {
+			summary.Findings[f.Type]++
+		}
+	}
+	summary.ThirdParties = len(thirdParties)
+	return summary
+}
+
+// LoadHistory reads the summaries of previous runs, a file that doesn't exist is an empty history
+func LoadHistory(path string) ([]RunSummary, error) {
+	f, err := OpenResultFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var history map[string][]RunSummary
+	if err := json.NewDecoder(f).Decode(&history); err != nil {
+		return nil, fmt.Errorf("couldn't parse history %s: %v", path, err)
+	}
+	return history["Runs"], nil
+}
+
+// WriteHistory saves the summaries of runs, oldest first
+func WriteHistory(path string, history []RunSummary) error {
+	return WriteJSON(path, map[string][]RunSummary{"Runs": history}, "")
+}
+
+// trendSeries is one line of the trend chart
+type trendSeries struct {
+	Name   string
+	Color  string
+	Points string
+	Last   int
+}
+
+var trendColors = []string{"#1f77b4", "#ff7f0e", "#2ca02c", "#d62728", "#9467bd", "#8c564b", "#e377c2", "#7f7f7f", "#bcbd22", "#17becf"}
+
+var trendTemplate = template.Must(template.New("trend").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Second Order trend</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-top: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: right; }
+th:first-child, td:first-child { text-align: left; }
+</style>
+</head>
+<body>
+<h1>Second Order trend</h1>
+<p>{{len .Runs}} runs, from {{.First}} to {{.Last}}</p>
+<svg width="{{.Width}}" height="{{.Height}}" viewBox="0 0 {{.Width}} {{.Height}}">
+<line x1="40" y1="{{.Bottom}}" x2="{{.Right}}" y2="{{.Bottom}}" stroke="#999"/>
+<line x1="40" y1="20" x2="40" y2="{{.Bottom}}" stroke="#999"/>
+<text x="4" y="28" font-size="12">{{.Max}}</text>
+<text x="4" y="{{.Bottom}}" font-size="12">0</text>
+{{range .Series}}<polyline fill="none" stroke="{{.Color}}" stroke-width="2" points="{{.Points}}"/>
+{{end}}</svg>
+<ul>
+{{range .Series}}<li><span style="color: {{.Color}}">&#9632;</span> {{.Name}} ({{.Last}} in the last run)</li>
+{{end}}</ul>
+<table>
+<tr><th>Run</th><th>Targets</th><th>Pages</th><th>Third parties</th>{{range .Types}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}<tr>{{range .}}<td>{{.}}</td>{{end}}</tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// TrendReport renders an HTML page that charts the counts of every run in the history
+func TrendReport(history []RunSummary) ([]byte, error) {
+	if len(history) == 0 {
+		return nil, fmt.Errorf("no runs in the history")
+	}
+
+	types := make(map[string]bool)
+	for _, run := range history {
+		for t := range run.Findings {
+			types[t] = true
+		}
+	}
+	findingTypes := sortedKeys(types)
+
+	values := map[string][]int{}
+	names := append([]string{"Pages", "Third parties"}, findingTypes...)
+	max := 1
+	for _, run := range history {
+		values["Pages"] = append(values["Pages"], run.Pages)
+		values["Third parties"] = append(values["Third parties"], run.ThirdParties)
+		for _, t := range findingTypes {
+			values[t] = append(values[t], run.Findings[t])
+		}
+		for _, name := range names {
+			if v := values[name][len(values[name])-1]; v > max {
+				max = v
+			}
+		}
+	}
+
+	const width, height, left, top = 800, 320, 40, 20
+	right, bottom := width-20, height-20
+	x := func(i int) int {
+		if len(history) == 1 {
+			return left
+		}
+		return left + i*(right-left)/(len(history)-1)
+	}
+	y := func(v int) int {
+		return bottom - v*(bottom-top)/max
+	}
+	var series []trendSeries
+	for i, name := range names {
+		var points []string
+		for j, v := range values[name] {
+			points = append(points, fmt.Sprintf("%d,%d", x(j), y(v)))
+		}
+		series = append(series, trendSeries{
+			Name:   name,
+			Color:  trendColors[i%len(trendColors)],
+			Points: strings.Join(points, " "),
+			Last:   values[name][len(history)-1],
+		})
+	}
+
+	// The latest run is the most interesting one, so it's on top of the table
+	var rows [][]interface{}
+	for i := len(history) - 1; i >= 0; i-- {
+		run := history[i]
+		row := []interface{}{run.Time.Format(time.RFC3339), run.Targets, run.Pages, run.ThirdParties}
+		for _, t := range findingTypes {
+			row = append(row, run.Findings[t])
+		}
+		rows = append(rows, row)
+	}
+
+	var report bytes.Buffer
+	err := trendTemplate.Execute(&report, map[string]interface{}{
+		"Runs":   history,
+		"First":  history[0].Time.Format(time.RFC3339),
+		"Last":   history[len(history)-1].Time.Format(time.RFC3339),
+		"Width":  width,
+		"Height": height,
+		"Right":  right,
+		"Bottom": bottom,
+		"Max":    max,
+		"Series": series,
+		"Types":  findingTypes,
+		"Rows":   rows,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("couldn't render trend report: %v", err)
+	}
+	return report.Bytes(), nil
+}
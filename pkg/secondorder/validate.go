@@ -0,0 +1,226 @@
+package secondorder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// ConfigProblem is something wrong in a configuration file, and where it is
+type ConfigProblem struct {
+	// Path of the field, like VerificationRules[2].Pattern, empty for the whole file
+	Path    string
+	Message string
+}
+
+func (p ConfigProblem) Error() string {
+	if p.Path == "" {
+		return p.Message
+	}
+	return p.Path + ": " + p.Message
+}
+
+// ValidateConfig checks a configuration file without scanning: its syntax, unknown keys, types, patterns,
+// status codes and options that conflict with each other
+// it reports every problem it finds instead of stopping at the first one, so a long scan doesn't start with a broken config
+func ValidateConfig(location string) []ConfigProblem {
+	content, err := readConfigDocument(location)
+	if err != nil {
+		return []ConfigProblem{{Message: err.Error()}}
+	}
+	var problems []ConfigProblem
+	unknownKeys(content, reflect.TypeOf(Config{}), "", &problems)
+
+	if content, err = expandEnv(content, ""); err != nil {
+		return append(problems, ConfigProblem{Message: err.Error()})
+	}
+	expanded, err := json.Marshal(content)
+	if err != nil {
+		return append(problems, ConfigProblem{Message: err.Error()})
+	}
+	var config Config
+	if err := json.Unmarshal(expanded, &config); err != nil {
+		var typeError *json.UnmarshalTypeError
+		if errors.As(err, &typeError) {
+			return append(problems, ConfigProblem{Path: typeError.Field, Message: fmt.Sprintf("expected %s, got %s", typeError.Type, typeError.Value)})
+		}
+		return append(problems, ConfigProblem{Message: err.Error()})
+	}
+	return append(problems, config.problems()...)
+}
+
+// unknownKeys reports the keys of a decoded document that aren't fields of the type it's decoded into
+// they're ignored when the configuration is loaded, so a typo silently disables what it was meant to set
+func unknownKeys(value interface{}, t reflect.Type, path string, problems *[]ConfigProblem) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			switch t.Kind() {
+			case reflect.Map:
+				unknownKeys(v[key], t.Elem(), path+"."+key, problems)
+			case reflect.Struct:
+				field, ok := fieldByKey(t, key)
+				if !ok {
+					*problems = append(*problems, ConfigProblem{Path: strings.TrimPrefix(path+"."+key, "."), Message: "unknown key"})
+					continue
+				}
+				unknownKeys(v[key], field.Type, path+"."+field.Name, problems)
+			}
+		}
+	case []interface{}:
+		if t.Kind() != reflect.Slice {
+			return
+		}
+		for i, element := range v {
+			unknownKeys(element, t.Elem(), fmt.Sprintf("%s[%d]", path, i), problems)
+		}
+	}
+}
+
+// fieldByKey finds the field a key is decoded into, matching names without case like encoding/json
+// the fields of embedded structs count as fields of the struct
+func fieldByKey(t reflect.Type, key string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			if embedded, ok := fieldByKey(field.Type, key); ok {
+				return embedded, true
+			}
+			continue
+		}
+		name := field.Name
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag == "-" {
+			continue
+		} else if tag != "" {
+			name = tag
+		}
+		if field.PkgPath == "" && strings.EqualFold(name, key) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// problems checks every part of a decoded configuration, the checks of compile are run one rule at a time
+// so that each problem is reported with the rule it's in
+func (config Config) problems() []ConfigProblem {
+	var problems []ConfigProblem
+	add := func(path string, err error) {
+		if err != nil {
+			// The errors of the webhook, notifiers, engagement and login already start with their name
+			message := strings.TrimPrefix(err.Error(), strings.ToLower(path)+": ")
+			problems = append(problems, ConfigProblem{Path: path, Message: message})
+		}
+	}
+
+	for _, queries := range []struct {
+		name  string
+		rules Queries
+	}{{"LogQueries", config.LogQueries}, {"LogNon200Queries", config.LogNon200Queries}} {
+		names := make(map[string]bool)
+		for i, rule := range queries.rules.withNames() {
+			path := fmt.Sprintf("%s[%d]", queries.name, i)
+			add(path, rule.validate())
+			if names[rule.Name] {
+				add(path, fmt.Errorf("another rule is named %q", rule.Name))
+			}
+			names[rule.Name] = true
+		}
+	}
+	for i, tag := range config.LogInline {
+		add(fmt.Sprintf("LogInline[%d]", i), validateSelector(tag))
+	}
+
+	for i, rule := range config.VerificationRules {
+		path := fmt.Sprintf("VerificationRules[%d]", i)
+		add(path, rule.compile(config.CredentialContexts))
+		for j, status := range rule.ExpectedStatus {
+			add(fmt.Sprintf("%s.ExpectedStatus[%d]", path, j), checkStatus(status))
+		}
+		// Fields the strategy doesn't use are left over from another strategy
+		if len(rule.ExpectedStatus) > 0 && rule.Strategy != strategyStatus {
+			add(path, fmt.Errorf("ExpectedStatus is only used by the status strategy, not %q", rule.Strategy))
+		}
+		if rule.BodyRegex != "" && rule.Strategy != strategyBody {
+			add(path, fmt.Errorf("BodyRegex is only used by the body strategy, not %q", rule.Strategy))
+		}
+		if rule.Method != "" && rule.Strategy != strategyDefault && rule.Strategy != strategyStatus {
+			add(path, fmt.Errorf("Method is only used by the default and status strategies, not %q", rule.Strategy))
+		}
+	}
+	for i, status := range config.ExcludeStatus {
+		add(fmt.Sprintf("ExcludeStatus[%d]", i), checkStatus(status))
+	}
+
+	if config.Webhook != nil {
+		add("Webhook", config.Webhook.validate())
+	}
+	if config.Slack != nil {
+		add("Slack", config.Slack.validate(notifySlack))
+	}
+	if config.Discord != nil {
+		add("Discord", config.Discord.validate(notifyDiscord))
+	}
+	add("Engagement", config.Engagement.validate())
+	add("Egress", config.Egress.validate())
+	for i, rule := range config.TargetEgress {
+		path := fmt.Sprintf("TargetEgress[%d]", i)
+		if _, err := regexp.Compile(rule.Pattern); err != nil {
+			add(path, fmt.Errorf("invalid target egress pattern %q: %v", rule.Pattern, err))
+		}
+		add(path, rule.Egress.validate())
+	}
+	for _, name := range sortedNames(config.SecretRules) {
+		_, err := compileSecretRules(map[string]string{name: config.SecretRules[name]})
+		add("SecretRules."+name, err)
+	}
+	for _, name := range sortedNames(config.LogBodyRegex) {
+		_, err := compileBodyRules(map[string]string{name: config.LogBodyRegex[name]})
+		add("LogBodyRegex."+name, err)
+	}
+	for i, rule := range config.Rewrites {
+		_, err := compileRewrites([]RewriteRule{rule})
+		add(fmt.Sprintf("Rewrites[%d]", i), err)
+	}
+	add("Origin", validateOrigin(config.Origin))
+	if config.Login != nil {
+		login := *config.Login
+		add("Login", login.compile())
+		if login.SuccessStatus != 0 {
+			add("Login.SuccessStatus", checkStatus(login.SuccessStatus))
+		}
+		if login.ContentType != "" && login.Body == "" {
+			add("Login", errors.New("ContentType is only used with Body"))
+		}
+	}
+	return problems
+}
+
+// checkStatus reports a status code that no server can answer with
+func checkStatus(status int) error {
+	if status < 100 || status > 599 {
+		return fmt.Errorf("%d isn't an HTTP status code", status)
+	}
+	return nil
+}
+
+func sortedNames(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
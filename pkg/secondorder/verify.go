@@ -0,0 +1,387 @@
+package secondorder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Verification strategies
+const (
+	// The URL is dead if it doesn't respond or returns 404, same as URLs without a rule
+	strategyDefault = ""
+	// The URL is alive if it responds with one of ExpectedStatus
+	strategyStatus = "status"
+	// The URL is alive if its body matches BodyRegex
+	strategyBody = "body"
+	// The URL is alive if its host resolves, no HTTP request is sent
+	strategyDNS = "dns"
+	// The URL is never reported
+	strategySkip = "skip"
+)
+
+// Response is how a verified URL responded, so a non-200 URL can be triaged without requesting it again
+type Response struct {
+	// Method of the request the response is for, GET when a HEAD request wasn't allowed
+	Method      string `json:"method,omitempty"`
+	Status      int    `json:"status,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	// Time until the response headers arrived, redirects and retries included
+	TimeMS int64 `json:"time_ms"`
+	// URL the request was redirected to, the status and content type are the ones of this URL
+	Redirect string `json:"redirect,omitempty"`
+	// Why there's no response, like a timeout, a refused connection or NXDOMAIN
+	Error string `json:"error,omitempty"`
+}
+
+// Classes non-200 URLs are grouped by in non-200-url-attributes.json, besides the status codes below 500
+// each one is exploited differently: a host that doesn't resolve can be registered, a server error is usually temporary
+const (
+	classServerError = "5xx"
+	classDNSFailure  = "dns-failure"
+	classNoResponse  = "no-response"
+	// Responses of URLs saved by versions that didn't keep them
+	classUnknown = "unknown"
+)
+
+// class returns the class a non-200 URL is grouped by: its status code, 5xx for server errors, dns-failure
+// when its host doesn't resolve, or no-response for the other errors
+func (r *Response) class() string {
+	switch {
+	case r == nil:
+		return classUnknown
+	case r.Status >= 500:
+		return classServerError
+	case r.Status != 0:
+		return strconv.Itoa(r.Status)
+	case isDNSFailure(r.Error):
+		return classDNSFailure
+	}
+	return classNoResponse
+}
+
+// isDNSFailure reports whether an error is one of the DNS statuses of resolveStatus, or the lookup error of a request
+func isDNSFailure(message string) bool {
+	switch message {
+	case "NXDOMAIN", "dangling CNAME", "SERVFAIL":
+		return true
+	}
+	return strings.HasPrefix(message, "lookup ")
+}
+
+// VerificationRule overrides how URLs matching Pattern are checked for being dead
+// APIs that legitimately return 401/403 to anonymous requests, for example, need a different policy than static assets
+type VerificationRule struct {
+	Pattern        string
+	Strategy       string
+	ExpectedStatus []int
+	BodyRegex      string
+	// Name of the credential context used for the requests, "none" to send no credentials at all
+	// without a context, the headers of the scan are sent
+	Context string
+	// Method of the requests: HEAD, GET, or empty for HEAD and then GET if the server doesn't allow HEAD
+	// the body strategy always sends GET
+	Method string
+
+	pattern      *regexp.Regexp
+	bodyRegex    *regexp.Regexp
+	authenticate func(*http.Request)
+}
+
+// CredentialContext is a set of credentials for verifying third parties that need authentication
+// to tell a dead resource apart from a forbidden one
+type CredentialContext struct {
+	Headers  map[string]string
+	Username string
+	Password string
+}
+
+func (c *CredentialContext) apply(req *http.Request) {
+	for name, value := range c.Headers {
+		req.Header.Set(name, value)
+	}
+	if c.Username != "" || c.Password != "" {
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+}
+
+// compile validates the rule, compiles its regular expressions and looks up its credential context
+func (r *VerificationRule) compile(contexts map[string]*CredentialContext) error {
+	var err error
+	switch r.Context {
+	case "":
+		// Left nil, the Scanner that verifies the URL adds its own headers
+	case "none":
+		r.authenticate = func(*http.Request) {}
+	default:
+		c, ok := contexts[r.Context]
+		if !ok {
+			return fmt.Errorf("verification rule %q: unknown credential context %q", r.Pattern, r.Context)
+		}
+		r.authenticate = c.apply
+	}
+
+	switch r.Method = strings.ToUpper(r.Method); r.Method {
+	case "", http.MethodHead, http.MethodGet:
+	default:
+		return fmt.Errorf("verification rule %q: unknown method %q, use HEAD or GET", r.Pattern, r.Method)
+	}
+	if r.pattern, err = regexp.Compile(r.Pattern); err != nil {
+		return fmt.Errorf("invalid verification rule pattern %q: %v", r.Pattern, err)
+	}
+	switch r.Strategy {
+	case strategyStatus:
+		if len(r.ExpectedStatus) == 0 {
+			return fmt.Errorf("verification rule %q: the status strategy needs ExpectedStatus", r.Pattern)
+		}
+	case strategyBody:
+		if r.bodyRegex, err = regexp.Compile(r.BodyRegex); err != nil {
+			return fmt.Errorf("verification rule %q: invalid BodyRegex: %v", r.Pattern, err)
+		}
+	case strategyDefault, strategyDNS, strategySkip:
+	default:
+		return fmt.Errorf("verification rule %q: unknown strategy %q", r.Pattern, r.Strategy)
+	}
+	return nil
+}
+
+// verdict is whether a URL is dead, shared by every page of every target that references it. done is closed once
+// the URL was verified, so the pages that find it at the same time wait for a single check instead of sending their own
+type verdict struct {
+	done     chan struct{}
+	dangling bool
+	// When the check started
+	checked time.Time
+	// The check was cut short by the cancellation of its scan, the URL is checked again by the next page that finds it
+	cancelled bool
+}
+
+// isDangling reports whether a URL found in a page is dead, using the first verification rule that matches it
+// URLs that don't match any rule are checked with the default policy (no response or 404)
+// in -dns-only mode no HTTP requests are sent, only skip rules and DNS resolution are used
+// each URL is only checked once, the script of a CDN is referenced by almost every page of a site
+// a URL whose check is cancelled isn't dead
+func (sc *Scanner) isDangling(ctx context.Context, u string) bool {
+	return sc.isDanglingSince(ctx, u, time.Time{})
+}
+
+// isDangling is the verdict of the Scanner, except that while monitoring or serving, the URLs checked before the scan started
+// are checked again: a resource that was alive in the previous scan of the target can be dead now
+func (s *scan) isDangling(ctx context.Context, u string) bool {
+	var since time.Time
+	if s.config.Options.Monitor || s.served {
+		since = s.start
+	}
+	return s.isDanglingSince(ctx, u, since)
+}
+
+// isDanglingSince is isDangling, ignoring the verdicts reached by checks that started before since
+func (sc *Scanner) isDanglingSince(ctx context.Context, u string, since time.Time) bool {
+	if strings.HasPrefix(u, "//") {
+		u = "http:" + u
+	}
+	for {
+		entry := &verdict{done: make(chan struct{}), checked: time.Now()}
+		cached, loaded := sc.verdicts.LoadOrStore(u, entry)
+		if !loaded {
+			entry.dangling = sc.checkDangling(ctx, u)
+			if ctx.Err() != nil {
+				entry.dangling, entry.cancelled = false, true
+				sc.verdicts.Delete(u)
+			}
+			close(entry.done)
+			return entry.dangling
+		}
+		entry = cached.(*verdict)
+		select {
+		case <-entry.done:
+		case <-ctx.Done():
+			return false
+		}
+		if entry.cancelled {
+			continue
+		}
+		if entry.checked.Before(since) {
+			// The response of the previous check goes with it, the next check records its own
+			sc.verdicts.Delete(u)
+			sc.responses.Delete(u)
+			sc.refused.Delete(u)
+			continue
+		}
+		return entry.dangling
+	}
+}
+
+// checkDangling verifies a URL and leaves out the ones that answered with an excluded status
+func (sc *Scanner) checkDangling(ctx context.Context, u string) bool {
+	if !sc.verifyDangling(ctx, u) {
+		return false
+	}
+	// A URL that answered with an excluded status isn't reported, whichever rule verified it
+	status := sc.status(u)
+	for _, excluded := range sc.config.ExcludeStatus {
+		if status == excluded {
+			return false
+		}
+	}
+	return true
+}
+
+// verifyDangling checks a URL with the first verification rule that matches it, or the default policy
+func (sc *Scanner) verifyDangling(ctx context.Context, u string) bool {
+	for i := range sc.config.VerificationRules {
+		rule := &sc.config.VerificationRules[i]
+		if rule.pattern.MatchString(u) {
+			if sc.config.Options.DNSOnly && rule.Strategy != strategySkip {
+				return sc.isDNSDangling(ctx, u)
+			}
+			return sc.verify(ctx, rule, u)
+		}
+	}
+	if sc.config.Options.DNSOnly {
+		return sc.isDNSDangling(ctx, u)
+	}
+	return sc.isNotFound(ctx, u)
+}
+
+// verify checks a URL with the strategy of a rule
+func (sc *Scanner) verify(ctx context.Context, r *VerificationRule, u string) bool {
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return false
+	}
+	authenticate := r.authenticate
+	if authenticate == nil {
+		authenticate = sc.addHeaders
+	}
+
+	switch r.Strategy {
+	case strategyDefault:
+		return sc.isNotFoundWith(ctx, u, r.Method, authenticate)
+	case strategySkip:
+		return false
+	case strategyDNS:
+		_, err := sc.resolver.LookupHost(ctx, parsed.Hostname())
+		if isNXDOMAIN(err) {
+			sc.responses.Store(u, &Response{Error: "NXDOMAIN"})
+			return true
+		}
+		return false
+	}
+
+	method := r.Method
+	if r.Strategy == strategyBody {
+		method = http.MethodGet
+	}
+	res, err := sc.send(ctx, u, method, authenticate)
+	if err != nil {
+		return !unsent(err)
+	}
+	defer res.Body.Close()
+
+	if r.Strategy == strategyStatus {
+		for _, status := range r.ExpectedStatus {
+			if res.StatusCode == status {
+				return false
+			}
+		}
+		return true
+	}
+
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return true
+	}
+	return !r.bodyRegex.Match(body)
+}
+
+// send sends a verification request with a method, HEAD by default
+// HEAD requests only read the status code and headers, so large assets aren't downloaded, and the ones
+// the server doesn't allow are sent again with GET unless the method is HEAD
+// how the URL responded is recorded, unless the request was cut short by the cancellation of ctx
+func (sc *Scanner) send(ctx context.Context, u, method string, authenticate func(*http.Request)) (*http.Response, error) {
+	do := func(method string) (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, method, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		authenticate(req)
+		return sc.verifyClient.Do(req)
+	}
+
+	first := method
+	if first == "" {
+		first = http.MethodHead
+	}
+	start := time.Now()
+	res, err := do(first)
+	if err == nil && method == "" && (res.StatusCode == http.StatusMethodNotAllowed || res.StatusCode == http.StatusNotImplemented) {
+		res.Body.Close()
+		res, err = do(http.MethodGet)
+	}
+	if ctx.Err() == nil {
+		sc.record(u, start, res, err)
+	}
+	return res, err
+}
+
+// record keeps how a verified URL responded, or why it didn't
+// requests that weren't sent because the third party got enough of them or the host is internal have no response,
+// only the reason they were refused is kept
+func (sc *Scanner) record(u string, start time.Time, res *http.Response, err error) {
+	// The method and URL the client adds to the error are already known
+	var urlError *url.Error
+	if errors.As(err, &urlError) {
+		err = urlError.Err
+	}
+	if unsent(err) {
+		sc.refused.Store(u, err.Error())
+		return
+	}
+	response := &Response{TimeMS: time.Since(start).Milliseconds()}
+	if err != nil {
+		var dnsError *net.DNSError
+		if errors.As(err, &dnsError) {
+			err = dnsError
+		}
+		response.Error = err.Error()
+	} else {
+		response.Method = res.Request.Method
+		response.Status = res.StatusCode
+		response.ContentType = res.Header.Get("Content-Type")
+		if final := res.Request.URL.String(); final != u {
+			response.Redirect = final
+		}
+	}
+	sc.responses.Store(u, response)
+}
+
+// response returns how a verified URL responded, or nil if it wasn't requested
+func (sc *Scanner) response(u string) *Response {
+	if strings.HasPrefix(u, "//") {
+		u = "http:" + u
+	}
+	if response, ok := sc.responses.Load(u); ok {
+		return response.(*Response)
+	}
+	return nil
+}
+
+// refusal returns why the verification request of a URL wasn't sent, or "" if it was sent or not needed
+func (sc *Scanner) refusal(u string) string {
+	if strings.HasPrefix(u, "//") {
+		u = "http:" + u
+	}
+	if reason, ok := sc.refused.Load(u); ok {
+		return reason.(string)
+	}
+	return ""
+}
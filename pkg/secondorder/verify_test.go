@@ -0,0 +1,177 @@
+package secondorder
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// verifiedSite serves the resources the verification tests check, and records the requests it gets by path
+type verifiedSite struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests map[string][]*http.Request
+}
+
+func newVerifiedSite() *verifiedSite {
+	site := &verifiedSite{requests: make(map[string][]*http.Request)}
+	site.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		site.mu.Lock()
+		site.requests[r.URL.Path] = append(site.requests[r.URL.Path], r)
+		site.mu.Unlock()
+		switch r.URL.Path {
+		case "/alive.js", "/ok.js", "/parked.js":
+			body := "var alive = true"
+			if r.URL.Path == "/parked.js" {
+				body = "This domain is parked"
+			}
+			fmt.Fprint(w, body)
+		case "/forbidden.js":
+			w.WriteHeader(http.StatusForbidden)
+		case "/gone-for-good.js":
+			w.WriteHeader(http.StatusGone)
+		case "/private.js":
+			if r.Header.Get("X-Api-Key") != "secret" {
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		case "/no-head.js":
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusMethodNotAllowed)
+				return
+			}
+			w.WriteHeader(http.StatusNotFound)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return site
+}
+
+// sent returns the requests a path got
+func (site *verifiedSite) sent(path string) []*http.Request {
+	site.mu.Lock()
+	defer site.mu.Unlock()
+	return site.requests[path]
+}
+
+func TestVerificationStrategies(t *testing.T) {
+	site := newVerifiedSite()
+	defer site.Close()
+
+	config := Config{
+		VerificationRules: []VerificationRule{
+			{Pattern: `/forbidden\.js$`, Strategy: strategyStatus, ExpectedStatus: []int{403}},
+			{Pattern: `/gone-for-good\.js$`, Strategy: strategyStatus, ExpectedStatus: []int{200}},
+			{Pattern: `/(?:ok|parked)\.js$`, Strategy: strategyBody, BodyRegex: `alive`},
+			{Pattern: `/private\.js$`, Strategy: strategyStatus, ExpectedStatus: []int{200}, Context: "api"},
+			{Pattern: `/anonymous\.js$`, Context: "none"},
+			{Pattern: `/skipped\.js$`, Strategy: strategySkip},
+			{Pattern: `/get\.js$`, Method: "GET"},
+			{Pattern: `^https?://[^/]*example\.com/`, Strategy: strategyDNS},
+		},
+		CredentialContexts: map[string]*CredentialContext{"api": {Headers: map[string]string{"X-Api-Key": "secret"}}},
+		ExcludeStatus:      []int{410},
+		Headers:            map[string]string{"X-Scan": "1"},
+		Options:            Options{AllowInternal: true},
+	}
+	sc, err := NewScanner(config)
+	if err != nil {
+		t.Fatalf("NewScanner: %v", err)
+	}
+	defer sc.Close()
+	cacheLookups(sc.resolver, map[string][]string{"alive.example.com": {"93.184.216.34"}, "gone.example.com": nil, "gone.example.net": nil})
+
+	tests := []struct {
+		name     string
+		url      string
+		dangling bool
+		// Method of the last request sent for the URL, empty if none was sent
+		method string
+		// What the recorded response says
+		status int
+		error  string
+	}{
+		{name: "default 404", url: site.URL + "/missing.js", dangling: true, method: "HEAD", status: 404},
+		{name: "default 200", url: site.URL + "/alive.js", method: "HEAD", status: 200},
+		{name: "default NXDOMAIN", url: "http://gone.example.net/lib.js", dangling: true, error: "NXDOMAIN"},
+		{name: "HEAD not allowed", url: site.URL + "/no-head.js", dangling: true, method: "GET", status: 404},
+		{name: "method of the rule", url: site.URL + "/get.js", dangling: true, method: "GET", status: 404},
+		{name: "expected status", url: site.URL + "/forbidden.js", method: "HEAD", status: 403},
+		{name: "excluded status", url: site.URL + "/gone-for-good.js", method: "HEAD", status: 410},
+		{name: "body matches", url: site.URL + "/ok.js", method: "GET", status: 200},
+		{name: "body doesn't match", url: site.URL + "/parked.js", dangling: true, method: "GET", status: 200},
+		{name: "credential context", url: site.URL + "/private.js", method: "HEAD", status: 200},
+		{name: "no credentials", url: site.URL + "/anonymous.js", dangling: true, method: "HEAD", status: 404},
+		{name: "skipped", url: site.URL + "/skipped.js"},
+		{name: "DNS resolves", url: "https://alive.example.com/app.js"},
+		{name: "DNS doesn't resolve", url: "https://gone.example.com/app.js", dangling: true, error: "NXDOMAIN"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sc.isDangling(context.Background(), tt.url); got != tt.dangling {
+				t.Errorf("isDangling(%s) = %v, want %v", tt.url, got, tt.dangling)
+			}
+			response := sc.response(tt.url)
+			if tt.method == "" && tt.error == "" {
+				if response != nil {
+					t.Errorf("a response was recorded for a URL that wasn't requested: %+v", response)
+				}
+				return
+			}
+			if response == nil {
+				t.Fatal("no response was recorded")
+			}
+			if response.Method != tt.method || response.Status != tt.status || response.Error != tt.error {
+				t.Errorf("response = %+v, want method %q, status %d and error %q", response, tt.method, tt.status, tt.error)
+			}
+		})
+	}
+
+	if sent := site.sent("/skipped.js"); len(sent) != 0 {
+		t.Errorf("the URL of a skip rule was requested %d times", len(sent))
+	}
+	for _, req := range site.sent("/private.js") {
+		if req.Header.Get("X-Scan") != "" {
+			t.Error("the headers of the scan were sent along with a credential context")
+		}
+	}
+	for _, req := range site.sent("/anonymous.js") {
+		if req.Header.Get("X-Scan") != "" {
+			t.Error("the headers of the scan were sent with the none context")
+		}
+	}
+	if sent := site.sent("/missing.js"); len(sent) != 1 || sent[0].Header.Get("X-Scan") != "1" {
+		t.Error("the headers of the scan weren't sent without a credential context")
+	}
+
+	// Each URL is verified once per run
+	sc.isDangling(context.Background(), site.URL+"/missing.js")
+	if sent := site.sent("/missing.js"); len(sent) != 1 {
+		t.Errorf("a URL verified twice got %d requests", len(sent))
+	}
+}
+
+func TestVerificationRefusesInternalAddresses(t *testing.T) {
+	site := newVerifiedSite()
+	defer site.Close()
+	sc, err := NewScanner(Config{})
+	if err != nil {
+		t.Fatalf("NewScanner: %v", err)
+	}
+	defer sc.Close()
+
+	// A URL that wasn't requested is neither dead nor alive
+	u := site.URL + "/missing.js"
+	if sc.isDangling(context.Background(), u) {
+		t.Error("a URL on a loopback address is dangling")
+	}
+	if sent := site.sent("/missing.js"); len(sent) != 0 {
+		t.Errorf("a URL on a loopback address was requested %d times", len(sent))
+	}
+	if sc.response(u) != nil || sc.refusal(u) == "" {
+		t.Errorf("response = %+v, refusal = %q, want only the refusal", sc.response(u), sc.refusal(u))
+	}
+}
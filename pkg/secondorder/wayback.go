@@ -0,0 +1,236 @@
+package secondorder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// How many pages are compared with their archived versions at the same time
+const waybackThreads = 5
+
+// WaybackDiff lists the third-party domains a page added or removed since its archived snapshot
+type WaybackDiff struct {
+	Snapshot string   `json:"snapshot"`
+	Added    []string `json:"added,omitempty"`
+	Removed  []string `json:"removed,omitempty"`
+}
+
+type waybackDiffs struct {
+	sync.Mutex
+	content map[string]WaybackDiff
+}
+
+// compareWithWayback compares the third-party domains of every crawled page with its snapshot from WaybackMonths ago
+// domains that were recently removed are often dependencies that have just expired
+func (s *scan) compareWithWayback(ctx context.Context) map[string]WaybackDiff {
+	timestamp := time.Now().AddDate(0, -s.config.Options.WaybackMonths, 0).Format("20060102")
+
+	diffs := make(map[string]WaybackDiff)
+	var mu sync.Mutex
+	pages := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < waybackThreads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for page := range pages {
+				snapshot, err := s.closestSnapshot(ctx, page, timestamp)
+				if err != nil || snapshot == "" {
+					continue
+				}
+				archived, err := s.archivedThirdParties(ctx, snapshot)
+				if err != nil {
+					continue
+				}
+				current := s.thirdParties.hosts(page)
+				diff := WaybackDiff{
+					Snapshot: snapshot,
+					Added:    subtractHosts(current, archived),
+					Removed:  subtractHosts(archived, current),
+				}
+				if len(diff.Added) == 0 && len(diff.Removed) == 0 {
+					continue
+				}
+				mu.Lock()
+				diffs[page] = diff
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, p := range s.pages.list() {
+		pages <- p.URL
+	}
+	close(pages)
+	wg.Wait()
+
+	return diffs
+}
+
+// closestSnapshot returns the raw content URL of the archived snapshot of a page closest to timestamp
+func (sc *Scanner) closestSnapshot(ctx context.Context, page, timestamp string) (string, error) {
+	api := "https://archive.org/wayback/available?url=" + url.QueryEscape(page) + "&timestamp=" + timestamp
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, api, nil)
+	if err != nil {
+		return "", err
+	}
+	res, err := sc.verifyClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("wayback machine returned status %d", res.StatusCode)
+	}
+
+	var availability struct {
+		ArchivedSnapshots struct {
+			Closest struct {
+				Available bool   `json:"available"`
+				Timestamp string `json:"timestamp"`
+			} `json:"closest"`
+		} `json:"archived_snapshots"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&availability); err != nil {
+		return "", err
+	}
+	closest := availability.ArchivedSnapshots.Closest
+	if !closest.Available {
+		return "", nil
+	}
+	// The id_ modifier returns the page as it was archived, without the Wayback Machine's rewriting
+	return fmt.Sprintf("https://web.archive.org/web/%sid_/%s", closest.Timestamp, page), nil
+}
+
+// archivedThirdParties returns the third-party domains referenced by an archived page
+func (s *scan) archivedThirdParties(ctx context.Context, snapshot string) (map[string]bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, snapshot, nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.verifyClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("wayback machine returned status %d", res.StatusCode)
+	}
+	doc, err := goquery.NewDocumentFromReader(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	hosts := make(map[string]bool)
+	for _, query := range resourceQueries {
+		_, attr := unpackQuerySelector(query)
+		doc.Find(query).Each(func(_ int, el *goquery.Selection) {
+			if host, ok := s.thirdPartyHost(el.AttrOr(attr, "")); ok {
+				hosts[host] = true
+			}
+		})
+	}
+	return hosts, nil
+}
+
+// thirdPartyHost returns the host of a resource if it's outside the target's domain
+func (s *scan) thirdPartyHost(resource string) (string, bool) {
+	u, err := url.Parse(resource)
+	if err != nil || u.Hostname() == "" {
+		return "", false
+	}
+	if s.checkOrigin(resource, s.target) || s.isNoise(resource) {
+		return "", false
+	}
+	return u.Hostname(), true
+}
+
+// hostSet stores the third-party hosts referenced by each page
+type hostSet struct {
+	sync.Mutex
+	content map[string]map[string]bool
+}
+
+func newHostSet() *hostSet {
+	return &hostSet{content: make(map[string]map[string]bool)}
+}
+
+func (h *hostSet) add(page, host string) {
+	h.Lock()
+	defer h.Unlock()
+	if h.content[page] == nil {
+		h.content[page] = make(map[string]bool)
+	}
+	h.content[page][host] = true
+}
+
+// restore adds the hosts of every page saved by an interrupted run
+func (h *hostSet) restore(pages map[string][]string) {
+	for page, hosts := range pages {
+		for _, host := range hosts {
+			h.add(page, host)
+		}
+	}
+}
+
+func (h *hostSet) hosts(page string) map[string]bool {
+	h.Lock()
+	defer h.Unlock()
+	hosts := make(map[string]bool)
+	for host := range h.content[page] {
+		hosts[host] = true
+	}
+	return hosts
+}
+
+// byPage returns the sorted hosts of every page
+func (h *hostSet) byPage() map[string][]string {
+	h.Lock()
+	defer h.Unlock()
+	pages := make(map[string][]string, len(h.content))
+	for page, hosts := range h.content {
+		pages[page] = sortedKeys(hosts)
+	}
+	return pages
+}
+
+// all returns the hosts of every page, sorted
+func (h *hostSet) all() []string {
+	h.Lock()
+	defer h.Unlock()
+	hosts := make(map[string]bool)
+	for _, pageHosts := range h.content {
+		for host := range pageHosts {
+			hosts[host] = true
+		}
+	}
+	return sortedKeys(hosts)
+}
+
+func subtractHosts(a, b map[string]bool) []string {
+	var diff []string
+	for host := range a {
+		if !b[host] {
+			diff = append(diff, host)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+func (w *waybackDiffs) copy() map[string]WaybackDiff {
+	w.Lock()
+	defer w.Unlock()
+	content := make(map[string]WaybackDiff, len(w.content))
+	for page, diff := range w.content {
+		content[page] = diff
+	}
+	return content
+}
@@ -0,0 +1,220 @@
+package secondorder
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Webhook configures the outbound webhook that receives batches of findings
+type Webhook struct {
+	URL string
+	// Finding types sent to the webhook, like takeover-candidate and secret (default every type)
+	Types []string
+	// Key of the HMAC-SHA256 signature sent in the X-Second-Order-Signature header
+	Secret string
+	// Maximum number of findings per request (default 50)
+	BatchSize int
+	// Seconds to wait before sending an incomplete batch (default 10)
+	FlushSeconds int
+	// Number of times a failed request is retried (default 5)
+	MaxRetries int
+}
+
+// webhookSink batches findings and POSTs them to the webhook, retrying failed requests with exponential backoff
+// once a batch couldn't be delivered, the next ones are only tried once until one goes through, so a webhook that's down
+// doesn't hold the end of the run for minutes per batch
+type webhookSink struct {
+	config   Webhook
+	types    map[string]bool
+	client   *http.Client
+	findings *findingQueue
+	done     chan struct{}
+
+	sync.Mutex
+	errs []error
+	down bool
+}
+
+// validate checks that the webhook has a URL findings can be sent to
+func (config Webhook) validate() error {
+	if config.URL == "" {
+		return errors.New("webhook: URL is required")
+	}
+	if u, err := url.Parse(config.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("webhook: URL %q isn't an http or https URL", config.URL)
+	}
+	if err := checkFindingTypes(config.Types); err != nil {
+		return fmt.Errorf("webhook: %v", err)
+	}
+	return nil
+}
+
+func newWebhookSink(config Webhook) (*webhookSink, error) {
+	if err := config.validate(); err != nil {
+		return nil, err
+	}
+	if config.BatchSize <= 0 {
+		config.BatchSize = 50
+	}
+	if config.FlushSeconds <= 0 {
+		config.FlushSeconds = 10
+	}
+	if config.MaxRetries < 0 {
+		config.MaxRetries = 0
+	} else if config.MaxRetries == 0 {
+		config.MaxRetries = 5
+	}
+
+	w := &webhookSink{
+		config:   config,
+		types:    make(map[string]bool),
+		client:   &http.Client{Timeout: 30 * time.Second},
+		findings: newFindingQueue(),
+		done:     make(chan struct{}),
+	}
+	for _, t := range config.Types {
+		w.types[t] = true
+	}
+	go w.loop()
+	return w, nil
+}
+
+func (w *webhookSink) Send(f Finding) {
+	if len(w.types) > 0 && !w.types[f.Type] {
+		return
+	}
+	w.findings.push(f)
+}
+
+func (w *webhookSink) Close() error {
+	w.findings.close()
+	<-w.done
+	w.Lock()
+	defer w.Unlock()
+	var problems []string
+	if len(w.errs) > 0 {
+		problems = append(problems, fmt.Sprintf("%d batches couldn't be delivered, last error: %v", len(w.errs), w.errs[len(w.errs)-1]))
+	}
+	if dropped := w.findings.droppedCount(); dropped > 0 {
+		problems = append(problems, fmt.Sprintf("%d findings were dropped while the webhook was down or too slow to keep up", dropped))
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("webhook: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func (w *webhookSink) loop() {
+	defer close(w.done)
+	ticker := time.NewTicker(time.Duration(w.config.FlushSeconds) * time.Second)
+	defer ticker.Stop()
+
+	var batch []Finding
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		err := w.deliver(batch)
+		w.Lock()
+		if err != nil {
+			w.errs = append(w.errs, err)
+		}
+		w.down = err != nil
+		w.Unlock()
+		batch = nil
+	}
+
+	for {
+		select {
+		case <-w.findings.ready:
+		case <-ticker.C:
+			flush()
+		}
+		for {
+			findings, done := w.findings.take(w.config.BatchSize - len(batch))
+			batch = append(batch, findings...)
+			if done {
+				flush()
+				return
+			}
+			if len(batch) < w.config.BatchSize {
+				break
+			}
+			flush()
+			// The run is over, retrying a webhook that's down would only hold its end
+			if w.isDown() && w.findings.closing() {
+				w.findings.drop()
+			}
+		}
+	}
+}
+
+// isDown reports whether the last batch couldn't be delivered
+func (w *webhookSink) isDown() bool {
+	w.Lock()
+	defer w.Unlock()
+	return w.down
+}
+
+// deliver POSTs a batch, retrying on network errors, 429 and 5xx responses
+func (w *webhookSink) deliver(batch []Finding) error {
+	body, err := json.Marshal(map[string][]Finding{"findings": batch})
+	if err != nil {
+		return err
+	}
+	mac := hmac.New(sha256.New, []byte(w.config.Secret))
+	mac.Write(body)
+	signature := "sha256=" + hex.EncodeToString(mac.Sum(nil))
+
+	retries := w.config.MaxRetries
+	if w.isDown() {
+		retries = 0
+	}
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		retry, err := w.post(body, signature)
+		if !retry || attempt >= retries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// post sends a batch once, and reports whether the request is worth retrying if it failed
+func (w *webhookSink) post(body []byte, signature string) (bool, error) {
+	req, err := http.NewRequest("POST", w.config.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if w.config.Secret != "" {
+		req.Header.Set("X-Second-Order-Signature", signature)
+	}
+
+	res, err := w.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, res.Body)
+	res.Body.Close()
+	if res.StatusCode == http.StatusTooManyRequests || res.StatusCode >= 500 {
+		return true, fmt.Errorf("webhook returned status %d", res.StatusCode)
+	}
+	if res.StatusCode >= 300 {
+		return false, fmt.Errorf("webhook rejected the batch with status %d", res.StatusCode)
+	}
+	return false, nil
+}
@@ -0,0 +1,152 @@
+package secondorder
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+// webhookReceiver answers the requests of a webhook with statuses in turn, and keeps what it got
+type webhookReceiver struct {
+	*httptest.Server
+	mu         sync.Mutex
+	statuses   []int
+	bodies     [][]byte
+	signatures []string
+}
+
+func newWebhookReceiver(statuses ...int) *webhookReceiver {
+	receiver := &webhookReceiver{statuses: statuses}
+	receiver.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		receiver.mu.Lock()
+		defer receiver.mu.Unlock()
+		receiver.bodies = append(receiver.bodies, body)
+		receiver.signatures = append(receiver.signatures, r.Header.Get("X-Second-Order-Signature"))
+		if len(receiver.statuses) > 0 {
+			w.WriteHeader(receiver.statuses[0])
+			receiver.statuses = receiver.statuses[1:]
+		}
+	}))
+	return receiver
+}
+
+// batches returns the resources of the findings of each request
+func (receiver *webhookReceiver) batches(t *testing.T) [][]string {
+	t.Helper()
+	receiver.mu.Lock()
+	defer receiver.mu.Unlock()
+	var batches [][]string
+	for _, body := range receiver.bodies {
+		var batch struct{ Findings []Finding }
+		if err := json.Unmarshal(body, &batch); err != nil {
+			t.Fatalf("the webhook got a body that isn't JSON: %v", err)
+		}
+		var resources []string
+		for _, f := range batch.Findings {
+			resources = append(resources, f.Resource)
+		}
+		batches = append(batches, resources)
+	}
+	return batches
+}
+
+func TestWebhookBatchesAndSigns(t *testing.T) {
+	receiver := newWebhookReceiver()
+	defer receiver.Close()
+	sink, err := newWebhookSink(Webhook{URL: receiver.URL, Types: []string{FindingDangling, FindingSecret}, Secret: "key", BatchSize: 2})
+	if err != nil {
+		t.Fatalf("newWebhookSink: %v", err)
+	}
+	sink.Send(Finding{Type: FindingDangling, Resource: "a.js"})
+	sink.Send(Finding{Type: FindingNon200, Resource: "skipped.js"})
+	sink.Send(Finding{Type: FindingSecret, Resource: "b.js"})
+	sink.Send(Finding{Type: FindingDangling, Resource: "c.js"})
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	batches := receiver.batches(t)
+	if len(batches) != 2 || len(batches[0]) != 2 || batches[0][0] != "a.js" || batches[0][1] != "b.js" || len(batches[1]) != 1 || batches[1][0] != "c.js" {
+		t.Errorf("batches = %q, want [[a.js b.js] [c.js]]", batches)
+	}
+	for i, body := range receiver.bodies {
+		mac := hmac.New(sha256.New, []byte("key"))
+		mac.Write(body)
+		if want := "sha256=" + hex.EncodeToString(mac.Sum(nil)); receiver.signatures[i] != want {
+			t.Errorf("signature of batch %d = %q, want %q", i, receiver.signatures[i], want)
+		}
+	}
+}
+
+func TestWebhookWithoutSecret(t *testing.T) {
+	receiver := newWebhookReceiver()
+	defer receiver.Close()
+	sink, err := newWebhookSink(Webhook{URL: receiver.URL})
+	if err != nil {
+		t.Fatalf("newWebhookSink: %v", err)
+	}
+	sink.Send(Finding{Type: FindingNon200, Resource: "a.js"})
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if len(receiver.signatures) != 1 || receiver.signatures[0] != "" {
+		t.Errorf("signatures = %q, want one request without a signature", receiver.signatures)
+	}
+}
+
+func TestWebhookRetries(t *testing.T) {
+	// A 503 is retried
+	receiver := newWebhookReceiver(http.StatusServiceUnavailable, http.StatusOK)
+	defer receiver.Close()
+	sink, err := newWebhookSink(Webhook{URL: receiver.URL, MaxRetries: 1})
+	if err != nil {
+		t.Fatalf("newWebhookSink: %v", err)
+	}
+	sink.Send(Finding{Type: FindingNon200, Resource: "a.js"})
+	if err := sink.Close(); err != nil {
+		t.Errorf("Close() = %v after a 503 that was retried", err)
+	}
+	if batches := receiver.batches(t); len(batches) != 2 {
+		t.Errorf("the batch was sent %d times, want 2", len(batches))
+	}
+
+	// A 400 won't go through the next time either
+	rejecting := newWebhookReceiver(http.StatusBadRequest, http.StatusOK)
+	defer rejecting.Close()
+	sink, err = newWebhookSink(Webhook{URL: rejecting.URL, MaxRetries: 1})
+	if err != nil {
+		t.Fatalf("newWebhookSink: %v", err)
+	}
+	sink.Send(Finding{Type: FindingNon200, Resource: "a.js"})
+	if err := sink.Close(); err == nil {
+		t.Error("Close() = nil after the webhook rejected a batch")
+	}
+	if batches := rejecting.batches(t); len(batches) != 1 {
+		t.Errorf("a rejected batch was sent %d times", len(batches))
+	}
+}
+
+func TestWebhookValidate(t *testing.T) {
+	tests := []struct {
+		config Webhook
+		valid  bool
+	}{
+		{Webhook{URL: "https://hooks.example.com/second-order"}, true},
+		{Webhook{}, false},
+		{Webhook{URL: "ftp://hooks.example.com/"}, false},
+		{Webhook{URL: "https:///path"}, false},
+		{Webhook{URL: "https://hooks.example.com/", Types: []string{"not-a-type"}}, false},
+	}
+	for _, tt := range tests {
+		if err := tt.config.validate(); (err == nil) != tt.valid {
+			t.Errorf("validate(%+v) = %v, want valid %v", tt.config, err, tt.valid)
+		}
+	}
+}
@@ -1,59 +1,140 @@
 package main
 
 import (
-	"crypto/tls"
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
 	"encoding/json"
 	"flag"
 	"fmt"
-	"io/ioutil"
-	"log"
-	"math/rand"
-	"net/http"
+	"io"
+	"net"
 	"net/url"
 	"os"
 	"os/signal"
-	"path/filepath"
+	"path"
 	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
-	"github.com/gocolly/colly/v2"
+	"github.com/mhmdiaa/second-order/pkg/secondorder"
 )
 
-// Configuration holds all the data passed from the config file
-// the target is specified in a flag so we don't have to edit the configuration file every time we run the tool
-type Configuration struct {
-	LogQueries       map[string]string
-	LogNon200Queries map[string]string
-	LogInline        []string
-}
+var (
+	targets          Targets
+	targetsFile      string
+	configFile       string
+	outdir           string
+	storeLocation    string
+	compression      string
+	insecure         bool
+	safe             bool
+	allowInternal    bool
+	depth            int
+	threads          int
+	maxThreads       int
+	delay            time.Duration
+	randomDelay      time.Duration
+	maxPerIP         int
+	maxPerThirdParty int
+	querySamples     int
+	maxURLs          int
+	scope            string
+	scopeFile        string
+	maxPages         int
+	maxFindings      int
+	checkLinks       bool
+	respectRobots    bool
+	stateFile        string
+	rulesDir         string
+	recheckRules     bool
+	timeout          time.Duration
+	maxTime          time.Duration
+	retries          int
+	retryBackoff     time.Duration
+	retryStatus      StatusCodes
+	resume           bool
+	redisURL         string
+	redisRun         string
+	monitor          time.Duration
+	parallelTargets  int
+	dnsThreads       int
+	resolver         string
+	verifyThreads    int
+	queueSize        int
+	cmsChecks        bool
+	parseManifests   bool
+	parseOpenAPIs    bool
+	inlineJSON       bool
+	secrets          bool
+	saveScripts      bool
+	filterNoise      bool
+	jsEndpoints      bool
+	inlineScripts    bool
+	inlineBaseline   string
+	scriptBaseline   string
+	graphQL          bool
+	compareTarget    string
+	waybackMonths    int
+	seed             string
+	seedLimit        int
+	seedFile         string
+	seedOnly         bool
+	dnsOnly          bool
+	takeover         bool
+	render           bool
+	precheck         bool
+	historyFile      string
+	failOn           string
+	format           string
+	extraFormats     string
+	formats          map[string]bool
+	jsonlFile        string
+	progress         string
+	stats            bool
+	statsInterval    time.Duration
+	quiet            bool
+	silent           bool
+	verbose          bool
+	logJSON          bool
+	logFile          string
+	jsonRPC          bool
+	serve            bool
+	listen           string
+	apiToken         string
+	headers          Headers
+	logQueries       Queries
+	logNon200        Queries
+	logInline        Targets
+	logInlineJS      bool
+	excludeStatus    StatusCodes
+	noiseHosts       Targets
+	cookies          Cookies
 
-// global variables to store the gathered info
-var loggedQueries = struct {
-	sync.RWMutex
-	content map[string]map[string][]string
-}{content: make(map[string]map[string][]string)}
+	// streamFindings prints the findings of every target to stdout when it finishes
+	streamFindings bool
+)
 
-var loggedNon200Queries = struct {
-	sync.RWMutex
-	content map[string]map[string][]string
-}{content: make(map[string]map[string][]string)}
+// Environment variable holding the token of the API of the serve command
+const apiTokenEnv = "SECOND_ORDER_API_TOKEN"
 
-var loggedInline = struct {
-	sync.RWMutex
-	content map[string]map[string][]string
-}{content: make(map[string]map[string][]string)}
+// Environment variable holding the configuration, as JSON or base64-encoded JSON, when -config isn't given
+const configEnv = "SECOND_ORDER_CONFIG"
 
-var (
-	target     string
-	configFile string
-	outdir     string
-	insecure   bool
-	depth      int
-	threads    int
-	headers    Headers
-)
+// Commented configuration with every field, written by the init command
+//
+//go:embed config/example.yaml
+var exampleConfig []byte
+
+// Configuration used without -config, SECOND_ORDER_CONFIG or query flags: the scripts, stylesheets, frames and links that don't load
+//
+//go:embed config/default.json
+var defaultConfig []byte
 
 type Headers map[string]string
 
@@ -62,312 +143,1160 @@ func (h *Headers) String() string {
 }
 
 func (headers *Headers) Set(h string) error {
-	parts := strings.Split(h, ":")
-	if len(parts) == 2 {
-		name := strings.TrimSpace(parts[0])
-		value := strings.TrimSpace(parts[1])
-		(*headers)[name] = value
+	// Only the first colon separates the name, values like tokens and URLs can have more of them
+	parts := strings.SplitN(h, ":", 2)
+	name := strings.TrimSpace(parts[0])
+	if len(parts) != 2 || name == "" {
+		return fmt.Errorf("%q isn't a header, the format is 'Name: Value'", h)
 	}
+	(*headers)[name] = strings.TrimSpace(parts[1])
+	return nil
+}
+
+// StatusCodes is a comma-separated list of HTTP status codes
+type StatusCodes []int
+
+func (s *StatusCodes) String() string {
+	codes := make([]string, len(*s))
+	for i, code := range *s {
+		codes[i] = strconv.Itoa(code)
+	}
+	return strings.Join(codes, ",")
+}
+
+func (s *StatusCodes) Set(value string) error {
+	*s = nil
+	for _, code := range strings.Split(value, ",") {
+		status, err := strconv.Atoi(strings.TrimSpace(code))
+		if err != nil || status < 100 || status > 599 {
+			return fmt.Errorf("invalid status code %q", code)
+		}
+		*s = append(*s, status)
+	}
+	return nil
+}
+
+// Cookies are name=value pairs, several of them can be given at once separated by semicolons like in a Cookie header
+type Cookies map[string]string
+
+func (c *Cookies) String() string {
+	return ""
+}
+
+func (c *Cookies) Set(value string) error {
+	for _, pair := range strings.Split(value, ";") {
+		parts := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("invalid cookie %q, use name=value", pair)
+		}
+		(*c)[parts[0]] = parts[1]
+	}
+	return nil
+}
+
+// Queries are selector=attribute pairs, like script=src or link[rel=stylesheet]=href
+type Queries map[string]string
+
+func (q *Queries) String() string {
+	return ""
+}
+
+func (q *Queries) Set(value string) error {
+	// The attribute follows the last =, selectors can have = of their own
+	i := strings.LastIndex(value, "=")
+	if i == -1 || strings.TrimSpace(value[:i]) == "" || strings.TrimSpace(value[i+1:]) == "" {
+		return fmt.Errorf("invalid query %q, use tag=attribute", value)
+	}
+	(*q)[strings.TrimSpace(value[:i])] = strings.TrimSpace(value[i+1:])
+	return nil
+}
+
+type Targets []string
 
+func (t *Targets) String() string {
+	return strings.Join(*t, ",")
+}
+
+func (t *Targets) Set(target string) error {
+	*t = append(*t, target)
 	return nil
 }
 
+// Exit codes of a scan, so second-order can gate a CI build
+const (
+	exitClean = 0
+	// A target has findings at least as severe as -fail-on
+	exitFindings = 1
+	// The scan couldn't start, or a target couldn't be scanned
+	exitError = 2
+)
+
+// logger writes the diagnostics of the run to stderr, or to -log-file, so stdout only carries results
+// it's replaced once the flags are parsed
+var logger = secondorder.NewLogger(os.Stderr, secondorder.LogInfo, false)
+
+// fatal logs an error that stops the run and exits with exitError
+func fatal(v ...interface{}) {
+	logger.Errorf("%s", fmt.Sprint(v...))
+	os.Exit(exitError)
+}
+
+func fatalf(format string, v ...interface{}) {
+	logger.Errorf(format, v...)
+	os.Exit(exitError)
+}
+
+// setupLogger replaces the logger with the one the logging flags ask for
+func setupLogger() {
+	if quiet && verbose {
+		fatal("-quiet and -v can't be used together")
+	}
+	level := secondorder.LogInfo
+	if quiet {
+		level = secondorder.LogError
+	} else if verbose {
+		level = secondorder.LogDebug
+	}
+	var out io.Writer = os.Stderr
+	if logFile != "" {
+		f, err := os.OpenFile(logFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			fatal(err)
+		}
+		out = f
+	}
+	logger = secondorder.NewLogger(out, level, logJSON)
+	secondorder.SetLogger(logger)
+}
+
+// failedTargets counts the targets whose scan returned an error
+var failedTargets int32
+
+// exitCode is exitError if a target couldn't be scanned, and exitFindings if a target has findings
+// at least as severe as failOn, none never fails on findings
+func exitCode(results []*secondorder.Result, failOn string) int {
+	if atomic.LoadInt32(&failedTargets) > 0 {
+		return exitError
+	}
+	if failOn == "none" {
+		return exitClean
+	}
+	for _, r := range results {
+		for _, f := range r.Findings {
+			if f.AtLeast(failOn) {
+				return exitFindings
+			}
+		}
+	}
+	return exitClean
+}
+
 func main() {
+	// The exit code of the scan is applied once the deferred closes saved everything
+	code := exitClean
+	defer func() {
+		if code != exitClean {
+			os.Exit(code)
+		}
+	}()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "retest":
+			retest(os.Args[2:])
+			return
+		case "validate":
+			validate(os.Args[2:])
+			return
+		case "init":
+			initConfig(os.Args[2:])
+			return
+		case "diff":
+			code = diff(os.Args[2:])
+			return
+		case "serve":
+			// The scans of the server take the options of a scan, so the flags are the same
+			serve = true
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		}
+	}
+	start := time.Now()
 
-	flag.StringVar(&target, "target", "", "Target URL")
-	flag.StringVar(&configFile, "config", "", "Configuration file")
-	flag.StringVar(&outdir, "output", "output", "Directory to save results in")
+	flag.Var(&targets, "target", "Target URL (can be used more than once)")
+	flag.StringVar(&targetsFile, "targets", "", "File containing target URLs, one per line")
+	flag.StringVar(&configFile, "config", "", "Configuration file, in YAML if it ends with .yaml or .yml and in JSON otherwise (default the JSON or base64-encoded JSON of the SECOND_ORDER_CONFIG environment variable, or the built-in config/default.json)")
+	flag.StringVar(&outdir, "output", "output", "Directory to save results in, or - to write them to stdout as a JSON object per file and line")
+	flag.StringVar(&storeLocation, "store", "", "Where to save results instead of the output directory: sqlite://<path>, postgres://<connection URL> or s3://<bucket>/<prefix>")
+	flag.StringVar(&compression, "compress", "", "Compress output files (gzip or zstd)")
 	flag.BoolVar(&insecure, "insecure", false, "Accept untrusted SSL/TLS certificates")
+	flag.BoolVar(&allowInternal, "allow-internal", false, "Verify the URLs pages reference even on private, loopback, link-local and cloud metadata addresses, which are refused by default")
+	flag.BoolVar(&safe, "safe", false, "Only ever send GET and HEAD requests: refuse to start with features that send others (-graphql, a POST Login), and block the ones rendered pages send")
 	flag.IntVar(&depth, "depth", 1, "Depth to crawl")
-	flag.IntVar(&threads, "threads", 10, "Number of threads")
+	flag.IntVar(&threads, "threads", 10, "Number of threads per host")
+	flag.DurationVar(&delay, "delay", 0, "Time each thread waits after a request before sending the next one, like 2s, to slow the crawl of WAF-protected targets down (use -threads 1 to space every request)")
+	flag.DurationVar(&randomDelay, "random-delay", 0, "Maximum random time added to -delay, so the requests don't come at a fixed interval")
+	flag.IntVar(&maxThreads, "max-threads", 50, "Maximum number of concurrent requests across all targets")
+	flag.StringVar(&scope, "scope", "subdomains", "Hosts to crawl: subdomains (the target's host and its subdomains), strict (only the target's host) or file (the hosts of -scope-file)")
+	flag.StringVar(&scopeFile, "scope-file", "", "File of host patterns for -scope file, one per line like app.example.com or *.example.com, the ones starting with ! are never crawled")
+	flag.IntVar(&maxURLs, "max-urls", 0, "Maximum number of pages of each target to request, to stop runaway crawls (0 for no limit)")
+	flag.IntVar(&maxPages, "max-pages", 0, "Number of crawled pages after which the scan of a target is stopped and what it found is saved (0 for no limit)")
+	flag.IntVar(&maxFindings, "max-findings", 0, "Number of findings after which the scan of a target is stopped and what it found is saved (0 for no limit)")
+	flag.IntVar(&querySamples, "query-samples", 0, "Number of values of each query parameter of a path to crawl, links that only differ in the values of sampled parameters are skipped (0 for no limit)")
+	flag.IntVar(&maxPerIP, "max-per-ip", 0, "Maximum number of concurrent requests to each IP address, across subdomains and targets (0 for no limit)")
+	flag.IntVar(&maxPerThirdParty, "max-per-third-party", 0, "Maximum number of verification requests to each third-party organization (like cloudfront.net), across targets (0 for no limit)")
+	flag.IntVar(&parallelTargets, "parallel-targets", 4, "Number of targets to crawl at the same time")
+	flag.StringVar(&resolver, "resolver", "", "Nameserver every DNS lookup is sent to, like 1.1.1.1:53, or the URL of a DNS over HTTPS server, like https://cloudflare-dns.com/dns-query (default the system's)")
+	flag.IntVar(&dnsThreads, "dns-threads", 20, "Number of concurrent DNS lookups")
+	flag.IntVar(&verifyThreads, "verify-threads", 50, "Number of resources of each target verified at the same time, apart from the threads crawling its pages")
+	flag.IntVar(&queueSize, "queue-size", 1000, "Number of resources of each target waiting to be verified or resolved before the pages that find more wait for room")
+	flag.BoolVar(&cmsChecks, "cms-checks", false, "Check CMS plugin, theme and library references for dead hosts and unregistered names")
+	flag.BoolVar(&parseManifests, "manifests", false, "Parse PWA manifests and browserconfig.xml files and check the URLs in them")
+	flag.BoolVar(&parseOpenAPIs, "openapi", false, "Analyze the Swagger/OpenAPI specs the target references, checking external servers and adding endpoints to the inventory")
+	flag.BoolVar(&graphQL, "graphql", false, "Send introspection queries to the target's GraphQL endpoints, saving their schemas and reporting the ones that allow it")
+	flag.StringVar(&compareTarget, "compare", "", "URL of another environment of the target (e.g. staging) to crawl and compare with it")
+	flag.IntVar(&waybackMonths, "wayback-months", 0, "Compare the third-party domains of crawled pages with their Wayback Machine snapshots from this many months ago")
+	flag.StringVar(&seed, "seed", "", "Start the crawl from more pages than the target: wayback for the HTML pages of its host and subdomains the Wayback Machine archived")
+	flag.IntVar(&seedLimit, "seed-limit", 1000, "Maximum number of pages of -seed each target starts from (-1 for no limit)")
+	flag.StringVar(&seedFile, "seed-file", "", "Burp Suite site map or proxy history saved as XML, or file of URLs one per line, whose pages the crawl of their target starts from as well (the targets are taken from the URLs when none are given)")
+	flag.BoolVar(&seedOnly, "seed-only", false, "Only analyze the pages of -seed-file and -seed, without requesting the targets or following links")
+	flag.DurationVar(&timeout, "timeout", 0, "Time to wait for each response, like 30s (0 for the defaults: 10s for pages and 5s for verification requests)")
+	flag.DurationVar(&maxTime, "max-time", 0, "Maximum duration of the whole run, like 2h, after which the scans are stopped and the results found until then are saved (0 for no limit)")
+	flag.IntVar(&retries, "retries", 2, "Number of times a request that fails or responds with a -retry-on status is sent again, for crawled pages and verified URLs (0 for no retries)")
+	flag.DurationVar(&retryBackoff, "retry-backoff", time.Second, "Time to wait before the first retry, doubled for each of the next ones")
+	flag.Var(&retryStatus, "retry-on", "Comma-separated status codes to retry (default 502,503,504)")
+	flag.StringVar(&rulesDir, "rules", "", "Directory of rule files (secret rules and takeover fingerprints), reloaded when they change during the run")
+	flag.BoolVar(&recheckRules, "rules-recheck", false, "Search the scripts downloaded with -scripts again when the rules are reloaded")
+	flag.StringVar(&stateFile, "state", "", "File to save the crawl state of every target to every 30 seconds and on exit, so an interrupted run can be resumed")
+	flag.StringVar(&redisURL, "redis", "", "redis:// URL of a server to keep the frontier of the targets in, so instances started with the same -redis and -redis-run on different machines crawl them together and push their findings to it")
+	flag.StringVar(&redisRun, "redis-run", "default", "Name of the distributed crawl, a new name starts the crawl of the targets over")
+	flag.DurationVar(&monitor, "monitor", 0, "Scan the targets again every this long, like 6h, until interrupted: only the findings that are new or changed since the previous scan of their target are sent to the sinks, and changes.json lists what appeared, changed or went away")
+	flag.BoolVar(&resume, "resume", false, "Continue the crawls saved in the -state file by an interrupted run, instead of starting over")
+	flag.BoolVar(&checkLinks, "check-links", false, "Resolve the hosts external links point to, and report the ones that don't resolve (expired domains) in dangling-links.json")
+	flag.BoolVar(&respectRobots, "respect-robots", false, "Don't crawl the pages the robots.txt of their host disallows, and wait for the Crawl-delay it sets between two requests to the host (robots.txt is ignored by default)")
+	flag.BoolVar(&dnsOnly, "dns-only", false, "Skip HTTP verification and only resolve referenced hosts (NXDOMAIN, SERVFAIL and dangling CNAME detection)")
+	flag.BoolVar(&filterNoise, "filter-noise", false, "Leave well-managed third parties (googleapis.com, gstatic.com, Cloudflare Insights... and NoiseHosts) out of the results")
+	flag.StringVar(&format, "format", "json", "Output format: json, csv to save the results of each target in results.csv as well, sarif to save the findings of all targets in findings.sarif as well, html to save them in report.html as well, or jsonl to stream findings to stdout as they're found")
+	flag.StringVar(&progress, "progress", "", "Write progress events as JSON lines to stderr, or to a unix socket with unix:<path>, for wrappers that show progress")
+	flag.BoolVar(&stats, "stats", false, "Show the pages crawled, resources queued, findings and requests per second of the scans on stderr every -stats-interval, to tell a slow scan from a stuck one")
+	flag.DurationVar(&statsInterval, "stats-interval", 5*time.Second, "Time between two lines of -stats")
+	flag.BoolVar(&quiet, "quiet", false, "Only log errors")
+	flag.BoolVar(&silent, "silent", false, "Only write findings to stdout, one per line as they're found like [type] [severity] resource page, for shell pipelines (JSON lines with -format jsonl)")
+	flag.BoolVar(&verbose, "v", false, "Log every crawled page and every request that failed as well")
+	flag.BoolVar(&logJSON, "log-json", false, "Log as JSON lines with the time, level, target and message, for programs that parse the diagnostics")
+	flag.StringVar(&logFile, "log-file", "", "File to append the log to, instead of stderr")
+	flag.BoolVar(&jsonRPC, "jsonrpc", false, "Read JSON-RPC 2.0 requests (scan, cancel, status, results, shutdown) from stdin and write the responses and events to stdout, one per line, for programs that drive second-order as a subprocess")
+	flag.StringVar(&listen, "listen", "127.0.0.1:8080", "Address the API of the serve command listens on")
+	flag.StringVar(&apiToken, "api-token", os.Getenv(apiTokenEnv), "Token the requests to the API of the serve command need in an 'Authorization: Bearer <token>' header (default the "+apiTokenEnv+" environment variable, or no token)")
+	flag.StringVar(&extraFormats, "formats", "", "Comma-separated output formats to produce from the same crawl, like json,csv,sarif,html (html saves the findings of all targets in report.html)")
+	flag.StringVar(&jsonlFile, "jsonl-file", "", "File to stream findings to with -format jsonl, instead of stdout")
+	flag.StringVar(&failOn, "fail-on", "info", "Exit with 1 if a target has findings at least this severe: info, low, medium, high, critical, or none to exit with 0 whatever the findings (2 if a target couldn't be scanned)")
+	flag.StringVar(&historyFile, "history", "", "File to add the counts of this run to, the trend of all runs in it is charted in trend.html in the output directory")
+	flag.BoolVar(&inlineJSON, "inline-json", false, "Check the URLs in JSON data scripts and the state single page apps embed in pages (window.__INITIAL_STATE__...)")
+	flag.BoolVar(&inlineScripts, "inline-scripts", false, "Save the inline scripts of every page in inline-scripts.json, and report the ones that differ from the version most pages with them have")
+	flag.StringVar(&inlineBaseline, "inline-baseline", "", "inline-scripts.json or output directory of a previous run, the inline scripts that changed since then are reported (implies -inline-scripts)")
+	flag.BoolVar(&jsEndpoints, "js-endpoints", false, "Extract the URLs, paths, fetch/XHR calls and routes of inline and external scripts into js-endpoints.json")
+	flag.BoolVar(&render, "render", false, "Render pages in a headless Chrome before scraping them, for single page apps (slower, requires Chrome)")
+	flag.StringVar(&scriptBaseline, "script-baseline", "", "scripts.json or output directory of a previous run, the external scripts that changed since then and the ones its pages didn't load are reported (implies -scripts)")
+	flag.BoolVar(&saveScripts, "scripts", false, "Download every external script into the scripts directory of the output, and analyze it like inline scripts")
+	flag.BoolVar(&secrets, "secrets", false, "Search inline and external scripts for secrets (AWS keys, Google API keys, JWTs, Slack tokens...) with the default and SecretRules patterns")
+	flag.BoolVar(&takeover, "takeover", false, "Fingerprint the hosts of external scripts, stylesheets and frames for subdomain takeover (GitHub Pages, Heroku, S3, Azure, Fastly...)")
+	flag.BoolVar(&precheck, "precheck", false, "Probe all targets before crawling and skip the unreachable, parked, or off-scope redirecting ones")
+	logQueries, logNon200 = make(Queries), make(Queries)
+	flag.Var(&logQueries, "log-query", "Log the attribute of every tag like the LogQueries of the config file, as tag=attribute like script=src or link[rel=stylesheet]=href (can be used more than once)")
+	flag.Var(&logNon200, "log-non200", "Log the attribute of every tag if it's a URL that doesn't return 200, like the LogNon200Queries of the config file, as tag=attribute (can be used more than once)")
+	flag.Var(&logInline, "log-inline", "Log the inline content of every tag like the LogInline of the config file (can be used more than once)")
+	flag.BoolVar(&logInlineJS, "log-inline-js", false, "Log the content of inline scripts, same as -log-inline script")
+	flag.Var(&excludeStatus, "exclude-status", "Comma-separated status codes the URLs of LogNon200Queries are never reported with, replacing the ExcludeStatus of the config file")
+	flag.Var(&noiseHosts, "noise-host", "Host left out of the results with -filter-noise, added to the NoiseHosts of the config file (can be used more than once)")
 	headers = make(Headers)
-	flag.Var(&headers, "header", "Header name and value separated by a colon 'Name: Value' (can be used more than once)")
+	flag.Var(&headers, "header", "Header name and value separated by a colon 'Name: Value', overriding the Headers of the config file (can be used more than once)")
+	flag.Var(&headers, "H", "Same as -header")
+	cookies = make(Cookies)
+	flag.Var(&cookies, "cookie", "Cookie sent to the targets and their subdomains, 'name=value' or several separated by semicolons (can be used more than once)")
 	flag.Parse()
+	setupLogger()
+
+	if targetsFile != "" {
+		fileTargets, err := readTargets(targetsFile)
+		if err != nil {
+			fatal(err)
+		}
+		targets = append(targets, fileTargets...)
+	}
+	var seedURLs []string
+	if seedFile != "" {
+		var err error
+		if seedURLs, err = secondorder.LoadSeedFile(seedFile); err != nil {
+			fatal(err)
+		}
+		// The pages of an exported site map are analyzed on their own hosts
+		if len(targets) == 0 {
+			targets = append(targets, secondorder.SeedTargets(seedURLs)...)
+		}
+	}
+	if seedOnly && seedFile == "" && seed == "" {
+		fatal("-seed-only analyzes the pages of -seed-file or -seed, give one of them")
+	}
+	// Targets are piped in (e.g. from subfinder or httpx) when none are given in flags
+	// In JSON-RPC mode stdin carries the requests, and the targets come with them
+	fromStdin := len(targets) == 0 && stdinIsPipe() && !jsonRPC && !serve
 
-	if target == "" || configFile == "" {
-		fmt.Println("[*] You need to specify a target and a config file")
+	// In containers and serverless jobs the whole configuration can be passed in the environment instead of a file
+	envConfig := os.Getenv(configEnv)
+	// The queries of the flags replace the default configuration
+	overridden := len(logQueries) > 0 || len(logNon200) > 0 || len(logInline) > 0 || logInlineJS
+	if len(targets) == 0 && !fromStdin && !jsonRPC && !serve {
+		logger.Errorf("You need to specify a target")
 		flag.PrintDefaults()
-		os.Exit(1)
+		os.Exit(exitError)
 	}
 
-	config, err := getConfigFile(configFile)
-	if err != nil {
-		log.Fatal(err)
+	var err error
+	if formats, err = parseFormats(format, extraFormats); err != nil {
+		fatal(err)
+	}
+	if failOn != "none" {
+		if err := secondorder.ValidateSeverity(failOn); err != nil {
+			fatal("-fail-on: ", err)
+		}
+	}
+	// Findings streamed to stdout would be mixed with the links and the findings printed at the end
+	jsonlToStdout := formats["jsonl"] && jsonlFile == ""
+	location := storeLocation
+	if location == "" {
+		location = outdir
+	}
+	// With -output -, stdout only carries the results
+	resultsToStdout := location == "-"
+	if resultsToStdout && jsonlToStdout {
+		fatal("-format jsonl needs -jsonl-file when the results are written to stdout")
+	}
+	if jsonRPC && (resultsToStdout || jsonlToStdout) {
+		fatal("-jsonrpc needs stdout for its responses, it can't be used with -output - or -format jsonl without -jsonl-file")
+	}
+	if silent && (resultsToStdout || jsonRPC) {
+		fatal("-silent needs stdout for the findings, it can't be used with -output - or -jsonrpc")
+	}
+	if serve && (jsonRPC || len(targets) > 0 || resultsToStdout) {
+		fatal("serve takes its targets from the API and saves their results in the output directory, it can't be used with -jsonrpc, -target, -targets or -output -")
+	}
+	if monitor > 0 && (fromStdin || jsonRPC || serve) {
+		fatal("-monitor scans the same targets again, they must be given with -target or -targets")
 	}
 
-	// Run a goroutine to catch interrupt signals and save the found results before exiting
-	interrupt := make(chan os.Signal, 1)
-	signal.Notify(interrupt, os.Interrupt)
-	go func() {
-		for sig := range interrupt {
-			fmt.Printf("[*] Received a kill signal: %s, saving the results before exiting\n", sig)
-			writeAllResults(config)
-			os.Exit(0)
+	var config secondorder.Config
+	switch {
+	case configFile != "":
+		config, err = secondorder.LoadConfig(configFile)
+	case envConfig != "":
+		config, err = secondorder.ParseConfig(envConfig)
+	case !overridden:
+		config, err = secondorder.ParseConfig(string(defaultConfig))
+	}
+	if err != nil {
+		fatal(err)
+	}
+	if logInlineJS {
+		logInline = append(logInline, "script")
+	}
+	err = config.Override(secondorder.Overrides{
+		LogQueries:       logQueries,
+		LogNon200Queries: logNon200,
+		LogInline:        logInline,
+		NoiseHosts:       noiseHosts,
+		ExcludeStatus:    excludeStatus,
+	})
+	if err != nil {
+		fatal(err)
+	}
+	config.Options = secondorder.Options{
+		Depth:            depth,
+		Threads:          threads,
+		MaxThreads:       maxThreads,
+		Delay:            delay,
+		RandomDelay:      randomDelay,
+		MaxPerIP:         maxPerIP,
+		MaxPerThirdParty: maxPerThirdParty,
+		QuerySamples:     querySamples,
+		Scope:            scope,
+		MaxURLs:          maxURLs,
+		MaxPages:         maxPages,
+		MaxFindings:      maxFindings,
+		CheckLinks:       checkLinks,
+		RespectRobots:    respectRobots,
+		DNSThreads:       dnsThreads,
+		Resolver:         resolver,
+		VerifyThreads:    verifyThreads,
+		QueueSize:        queueSize,
+		Insecure:         insecure,
+		Safe:             safe,
+		AllowInternal:    allowInternal,
+		Headers:          headers,
+		Cookies:          cookies,
+		CMSChecks:        cmsChecks,
+		Manifests:        parseManifests,
+		OpenAPI:          parseOpenAPIs,
+		InlineJSON:       inlineJSON,
+		Secrets:          secrets,
+		SaveScripts:      saveScripts || scriptBaseline != "",
+		FilterNoise:      filterNoise,
+		JSEndpoints:      jsEndpoints,
+		InlineScripts:    inlineScripts || inlineBaseline != "",
+		GraphQL:          graphQL,
+		WaybackMonths:    waybackMonths,
+		Seed:             seed,
+		SeedLimit:        seedLimit,
+		SeedURLs:         seedURLs,
+		SeedOnly:         seedOnly,
+		DNSOnly:          dnsOnly,
+		Takeover:         takeover,
+		Render:           render,
+		Timeout:          timeout,
+		Retries:          retries,
+		RetryBackoff:     retryBackoff,
+		RetryStatus:      retryStatus,
+		RulesDir:         rulesDir,
+		RecheckRules:     recheckRules,
+		StateFile:        stateFile,
+		Resume:           resume,
+		Redis:            redisURL,
+		RedisRun:         redisRun,
+		Monitor:          monitor > 0,
+		Compression:      compression,
+	}
+	if scopeFile != "" {
+		if config.Options.ScopeHosts, err = secondorder.LoadScope(scopeFile); err != nil {
+			fatal(err)
 		}
-	}()
+	}
+	if scriptBaseline != "" {
+		if config.Options.ScriptBaseline, err = secondorder.LoadScripts(scriptBaseline); err != nil {
+			fatal(err)
+		}
+	}
+	if inlineBaseline != "" {
+		if config.Options.InlineBaseline, err = secondorder.LoadInlineScripts(inlineBaseline); err != nil {
+			fatal(err)
+		}
+	}
+	if compareTarget != "" {
+		if fromStdin || len(targets) != 1 {
+			fatal("-compare can only be used with a single target")
+		}
+		targets = append(targets, compareTarget)
+	}
 
-	hostname, err := getHostname(target)
+	// stdout is kept for findings when reading targets from stdin or streaming them
+	if !fromStdin && !jsonlToStdout && !resultsToStdout && !jsonRPC && !silent && !serve {
+		config.Options.LinkOutput = os.Stdout
+	}
+
+	scanner, err := secondorder.NewScanner(config)
+	if err != nil {
+		fatal(err)
+	}
+	store, err := secondorder.OpenResultStore(location, compression)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Target URL is invalid: %v", err)
-		os.Exit(1)
+		fatal(err)
 	}
+	defer store.Close()
 
-	// Instantiate default collector
-	c := colly.NewCollector(
-		colly.MaxDepth(depth),
-		colly.Async(),
-	)
-	c.Limit(&colly.LimitRule{DomainGlob: "*", Parallelism: threads})
+	if formats["jsonl"] {
+		out := os.Stdout
+		if jsonlFile != "" {
+			if out, err = os.Create(jsonlFile); err != nil {
+				fatal(err)
+			}
+			defer out.Close()
+		}
+		scanner.AddSink(secondorder.NewJSONLSink(out))
+	}
+	// With -format jsonl the findings already stream to stdout, as JSON
+	if silent && !jsonlToStdout {
+		scanner.AddSink(secondorder.NewTextSink(os.Stdout))
+	}
+	// The server is an event sink, so it's created before anything runs
+	var rpcServer *secondorder.JSONRPCServer
+	if jsonRPC {
+		rpcServer = secondorder.NewJSONRPCServer(scanner, os.Stdout)
+	}
+	if progress != "" {
+		out, err := progressOutput(progress)
+		if err != nil {
+			fatal(err)
+		}
+		defer out.Close()
+		scanner.AddEventSink(secondorder.NewProgressSink(out))
+	}
 
-	// Allow URLs from the same domain and its subdomains
-	c.URLFilters = []*regexp.Regexp{
-		regexp.MustCompile(".*" + strings.ReplaceAll(hostname, ".", "\\.") + ".*"),
+	// On an interrupt (Ctrl-C, or SIGTERM from timeout, Docker or systemd) the running scans and their in-flight requests
+	// are cancelled, and the results found until then are saved as usual
+	// a second interrupt exits right away, without waiting for the results to be saved
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 2)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-interrupt
+		logger.Infof("Received a kill signal: %s, saving the results before exiting (send it again to exit right away)", sig)
+		cancel()
+		sig = <-interrupt
+		logger.Infof("Received a second kill signal: %s, exiting without saving the results", sig)
+		os.Exit(130)
+	}()
+	if maxTime > 0 {
+		deadline := time.AfterFunc(maxTime-time.Since(start), func() {
+			logger.Infof("Reached the maximum time of %s, saving the results before exiting", maxTime)
+			cancel()
+		})
+		defer deadline.Stop()
 	}
 
-	// Add headers
-	c.OnRequest(func(r *colly.Request) {
-		// Set a random user agent for each request
-		rand.Seed(time.Now().Unix())
-		n := rand.Intn(len(userAgents))
-		r.Headers.Set("User-Agent", userAgents[n])
-		// Add other headers
-		for header, value := range headers {
-			r.Headers.Set(header, value)
+	// The results are returned in the responses to the scan requests instead of being saved
+	if jsonRPC {
+		if err := rpcServer.Serve(ctx, os.Stdin); err != nil {
+			logger.Errorf("Could not read JSON-RPC requests: %v", err)
 		}
-	})
+		if err := scanner.Close(); err != nil {
+			logger.Errorf("Could not deliver findings: %v", err)
+		}
+		return
+	}
+	// The results of every scan are saved under its id
+	if serve {
+		logger.Infof("Listening on %s", listen)
+		if err := secondorder.NewAPIServer(scanner, store, parallelTargets, apiToken).Serve(ctx, listen); err != nil {
+			logger.Errorf("Could not serve the API: %v", err)
+			code = exitError
+		}
+		if err := scanner.Close(); err != nil {
+			logger.Errorf("Could not deliver findings: %v", err)
+		}
+		return
+	}
 
-	// Accept untrusted SSL/TLS certificates based on the value of `-insecure` flag
-	c.WithTransport(&http.Transport{
-		TLSClientConfig: &tls.Config{InsecureSkipVerify: insecure},
-	})
+	if precheck && !fromStdin && !jsonRPC {
+		targets = healthyTargets(ctx, scanner, store, targets)
+		if compareTarget != "" && len(targets) != 2 {
+			fatal("-compare needs both environments to pass the pre-check")
+		}
+	}
 
-	// On every a element which has href attribute call callback
-	c.OnHTML("a[href]", func(e *colly.HTMLElement) {
-		link := e.Attr("href")
-		// Print link if it's in-scope and has not been visited
-		visited, _ := c.HasVisited(link)
-		if checkOrigin(link, target) && !visited {
-			fmt.Println(link)
+	var targetsMu sync.Mutex
+	var queue <-chan *job
+	var jobs []*job
+	if fromStdin {
+		// Findings are printed to stdout so the output can be piped further, unless they're already streamed there
+		streamFindings = !jsonlToStdout && !resultsToStdout && !silent
+		// The number of targets isn't known in advance, so each one gets its own subdirectory
+		targets = nil
+		used := make(map[string]bool)
+		pending := make(chan *job)
+		queue = pending
+		go func() {
+			defer close(pending)
+			var health []secondorder.SeedHealth
+			err := scanTargets(os.Stdin, func(target string) {
+				target = normalizeTarget(target)
+				if precheck {
+					h := scanner.ProbeSeed(ctx, target)
+					health = append(health, h)
+					if !isHealthy(h) {
+						return
+					}
+				}
+				targetsMu.Lock()
+				targets = append(targets, target)
+				targetsMu.Unlock()
+				select {
+				case pending <- &job{target: target, prefix: targetPrefix(target, true, used)}:
+				case <-ctx.Done():
+				}
+			})
+			if err != nil {
+				logger.Errorf("Could not read targets from stdin: %v", err)
+			}
+			if precheck {
+				writeSeedHealth(store, health)
+			}
+		}()
+	} else {
+		used := make(map[string]bool)
+		for _, target := range targets {
+			jobs = append(jobs, &job{target: target, prefix: targetPrefix(target, len(targets) > 1, used)})
 		}
+		queue = queueJobs(ctx, jobs)
+	}
 
-		// Visit link found on page on a new thread
-		e.Request.Visit(link)
-	})
+	for {
+		if stats {
+			stopStats := showStats(scanner, len(jobs), statsInterval)
+			runScans(ctx, scanner, store, queue)
+			stopStats()
+		} else {
+			runScans(ctx, scanner, store, queue)
+		}
 
-	// Register a function that logs HTML attributes
-	for tag, attribute := range config.LogQueries {
-		querySelector := createQuerySelector(tag, attribute)
-		c.OnHTML(querySelector, func(e *colly.HTMLElement) {
-			u := e.Request.URL.String()
-			_, attr := unpackQuerySelector(querySelector)
-			value := e.Attr(attr)
-			loggedQueries.Lock()
-			if _, ok := loggedQueries.content[u]; !ok {
-				loggedQueries.content[u] = make(map[string][]string)
+		// Dangling hosts shared by several targets are the most valuable, so they're listed across targets
+		if fromStdin || len(targets) > 1 {
+			shared := secondorder.Correlate(scanner.Results())
+			err := store.WriteJSON(saveContext, "correlation.json", map[string][]secondorder.SharedHost{"SharedHosts": shared})
+			if err != nil {
+				logger.Errorf("Could not write cross-target correlation: %v", err)
 			}
-			loggedQueries.content[u][querySelector] = append(loggedQueries.content[u][querySelector], value)
-			loggedQueries.Unlock()
-		})
+		}
+
+		// Verification requests per third party, to keep large runs polite and tune -max-per-third-party
+		err = store.WriteJSON(saveContext, "third-party-requests.json", map[string][]secondorder.ThirdPartyRequests{"ThirdPartyRequests": scanner.ThirdPartyRequests()})
+		if err != nil {
+			logger.Errorf("Could not write third-party requests: %v", err)
+		}
+
+		if compareTarget != "" && jobs[0].result != nil && jobs[1].result != nil {
+			diff := secondorder.Compare(jobs[0].result, jobs[1].result)
+			err := store.WriteJSON(saveContext, "environment-diff.json", map[string]secondorder.EnvironmentDiff{"EnvironmentDiff": diff})
+			if err != nil {
+				logger.Errorf("Could not write environment diff: %v", err)
+			}
+		}
+		if formats["sarif"] {
+			err := store.WriteJSON(saveContext, "findings.sarif", secondorder.SARIF(scanner.Results()))
+			if err != nil {
+				logger.Errorf("Could not write SARIF findings: %v", err)
+			}
+		}
+		if formats["html"] {
+			if err := writeHTMLReport(store, scanner.Results()); err != nil {
+				logger.Errorf("Could not write report.html: %v", err)
+			}
+		}
+		targetsMu.Lock()
+		err = writeRunMetadata(store, start, targets, config.Engagement)
+		targetsMu.Unlock()
+		if err != nil {
+			logger.Errorf("Could not write run metadata: %v", err)
+		}
+		if historyFile != "" {
+			if err := writeTrend(store, start, scanner.Results()); err != nil {
+				logger.Errorf("Could not write history: %v", err)
+			}
+		}
+		if monitor == 0 || ctx.Err() != nil {
+			break
+		}
+		// The targets are scanned again until the run is interrupted or reaches -max-time, each scan replaces the results of the previous one
+		next := start.Add(monitor)
+		logger.Infof("Scanning the targets again at %s", next.Format(time.RFC3339))
+		select {
+		case <-time.After(time.Until(next)):
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		start = time.Now()
+		queue = queueJobs(ctx, jobs)
 	}
+	if err := scanner.Close(); err != nil {
+		logger.Errorf("Could not deliver findings: %v", err)
+	}
+	code = exitCode(scanner.Results(), failOn)
+}
 
-	// Register a function that logs URLs from HTML attributes if they return a non-200 response code
-	for tag, attribute := range config.LogNon200Queries {
-		querySelector := createQuerySelector(tag, attribute)
-		c.OnHTML(querySelector, func(e *colly.HTMLElement) {
-			u := e.Request.URL.String()
-			_, attr := unpackQuerySelector(querySelector)
-			value := e.Attr(attr)
+// queueJobs queues jobs for runScans, until ctx is cancelled
+func queueJobs(ctx context.Context, jobs []*job) <-chan *job {
+	queue := make(chan *job)
+	go func() {
+		defer close(queue)
+		for _, j := range jobs {
+			select {
+			case queue <- j:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return queue
+}
+
+// saveContext is what the results are saved with, the interrupt that cancels the scans doesn't cancel it
+// so what was found until then is still saved
+var saveContext = context.Background()
 
-			if isValidURL(value) && isNotFound(value) {
-				loggedNon200Queries.Lock()
-				if _, ok := loggedNon200Queries.content[u]; !ok {
-					loggedNon200Queries.content[u] = make(map[string][]string)
+// job is a target queued for crawling, and the prefix its results are saved under
+type job struct {
+	target string
+	prefix string
+	result *secondorder.Result
+}
+
+// runScans crawls the queued targets concurrently, at most parallelTargets at a time
+// the results of each target are saved as soon as it finishes
+func runScans(ctx context.Context, scanner *secondorder.Scanner, store secondorder.ResultStore, queue <-chan *job) {
+	if parallelTargets < 1 {
+		parallelTargets = 1
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < parallelTargets; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				var j *job
+				select {
+				case j = <-queue:
+				case <-ctx.Done():
+					return
+				}
+				// A closed queue or an interrupt between two targets
+				if j == nil || ctx.Err() != nil {
+					return
+				}
+				result, err := scanner.Run(ctx, j.target)
+				if err != nil {
+					logger.WithTarget(j.target).Errorf("Could not scan: %v", err)
+					atomic.AddInt32(&failedTargets, 1)
+					continue
+				}
+				j.result = result
+				if result.Coverage != nil && result.Coverage.Stopped != "" {
+					logger.WithTarget(j.target).Infof("Stopped the scan once it reached -%s, saving what it found", result.Coverage.Stopped)
+				}
+				if result.Changes != nil {
+					changes := secondorder.CountChanges(result.Changes)
+					logger.WithTarget(j.target).Infof("%d new, %d changed and %d gone findings since the previous scan",
+						changes[secondorder.ChangeNew], changes[secondorder.ChangeChanged], changes[secondorder.ChangeGone])
+				}
+				if err := result.Save(saveContext, store, j.prefix); err != nil {
+					logger.Errorf("Could not write results of %s: %v", j.target, err)
+				}
+				if formats["csv"] {
+					if err := writeCSV(store, j.prefix, result); err != nil {
+						logger.Errorf("Could not write results.csv of %s: %v", j.target, err)
+					}
+				}
+				if streamFindings {
+					printFindings(result.Findings)
 				}
-				loggedNon200Queries.content[u][querySelector] = append(loggedNon200Queries.content[u][querySelector], value)
-				loggedNon200Queries.Unlock()
 			}
-		})
+		}()
 	}
+	wg.Wait()
+}
 
-	for _, tag := range config.LogInline {
-		c.OnHTML(tag, func(e *colly.HTMLElement) {
-			u := e.Request.URL.String()
-			value := e.Text
-			loggedInline.Lock()
-			if _, ok := loggedInline.content[u]; !ok {
-				loggedInline.content[u] = make(map[string][]string)
-			}
-			loggedInline.content[u][tag] = append(loggedInline.content[u][tag], value)
-			loggedInline.Unlock()
+// readTargets reads one target URL per line, skipping empty lines and # comments
+func readTargets(location string) ([]string, error) {
+	f, err := os.Open(location)
+	if err != nil {
+		return nil, fmt.Errorf("could not open targets file: %v", err)
+	}
+	defer f.Close()
 
-		})
+	var list []string
+	if err := scanTargets(f, func(target string) { list = append(list, target) }); err != nil {
+		return nil, fmt.Errorf("could not read targets file: %v", err)
 	}
+	return list, nil
+}
 
-	// Start scraping
-	c.Visit(target)
-	// Wait until threads are finished
-	c.Wait()
+// scanTargets calls found with every target in r as soon as its line is read
+func scanTargets(r io.Reader, found func(string)) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		found(line)
+	}
+	return scanner.Err()
+}
 
-	writeAllResults(config)
+// stdinIsPipe reports whether stdin is a pipe or a file rather than a terminal
+func stdinIsPipe() bool {
+	stat, err := os.Stdin.Stat()
+	if err != nil {
+		return false
+	}
+	return stat.Mode()&os.ModeCharDevice == 0
 }
 
-func writeAllResults(config Configuration) {
-	os.MkdirAll(outdir, os.ModePerm)
+// normalizeTarget turns a bare hostname (like the ones subfinder outputs) into a URL
+func normalizeTarget(target string) string {
+	if strings.Contains(target, "://") {
+		return target
+	}
+	return "https://" + target
+}
 
-	if config.LogQueries != nil {
-		err := writeResults("attributes.json", loggedQueries.content, "LogQueries")
-		if err != nil {
-			log.Printf("Error writing attributes: %v", err)
-		}
+// showStats writes the counts of the scans to stderr every interval until stop is called
+// total is the number of targets, 0 when they're read from stdin and only the ones started so far are known
+func showStats(scanner *secondorder.Scanner, total int, interval time.Duration) (stop func()) {
+	if interval <= 0 {
+		interval = 5 * time.Second
 	}
-	if config.LogInline != nil {
-		err := writeResults("inline.json", loggedInline.content, "LogInline")
-		if err != nil {
-			log.Printf("Error writing inline text: %v", err)
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		began := time.Now()
+		var requests int64
+		last := began
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				s := scanner.Stats()
+				targets := total
+				if targets == 0 {
+					targets = s.Targets
+				}
+				rate := float64(s.Requests-requests) / now.Sub(last).Seconds()
+				requests, last = s.Requests, now
+				fmt.Fprintf(os.Stderr, "[%s] targets %d/%d | pages %d | queued %d | findings %d | requests %d (%.0f/s) | errors %d\n",
+					now.Sub(began).Round(time.Second), s.Finished, targets, s.Pages, s.Queued, s.Findings, s.Requests, rate, s.Errors)
+			}
 		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
 	}
-	if config.LogNon200Queries != nil {
-		err := writeResults("non-200-url-attributes.json", loggedNon200Queries.content, "LogNon200Queries")
-		if err != nil {
-			log.Printf("Error writing non-200 URL attributes: %v", err)
+}
+
+// stdoutLock keeps the findings of targets that finish at the same time from interleaving
+var stdoutLock sync.Mutex
+
+// printFindings writes the findings of a finished target to stdout, one JSON object per line
+func printFindings(findings []secondorder.Finding) {
+	stdoutLock.Lock()
+	defer stdoutLock.Unlock()
+	encoder := json.NewEncoder(os.Stdout)
+	for _, f := range findings {
+		encoder.Encode(f)
+	}
+}
+
+// healthyTargets runs the pre-check, saves its results, reports unhealthy seeds and returns the healthy ones
+func healthyTargets(ctx context.Context, scanner *secondorder.Scanner, store secondorder.ResultStore, targets []string) []string {
+	health := scanner.Precheck(ctx, targets, parallelTargets)
+
+	var healthy []string
+	for _, h := range health {
+		if isHealthy(h) {
+			healthy = append(healthy, h.Target)
 		}
 	}
+	writeSeedHealth(store, health)
+	return healthy
 }
 
-func getConfigFile(location string) (Configuration, error) {
-	f, err := os.Open(location)
-	if err != nil {
-		return Configuration{}, fmt.Errorf("could not open Configuration file: %v", err)
+// isHealthy reports whether a probed seed should be crawled, and prints why it's skipped if it shouldn't
+func isHealthy(h secondorder.SeedHealth) bool {
+	if h.Status == secondorder.SeedHealthy {
+		return true
 	}
-	defer f.Close()
+	detail := h.Error
+	if detail == "" {
+		detail = fmt.Sprintf("status %d, final URL %s", h.StatusCode, h.FinalURL)
+	}
+	logger.WithTarget(h.Target).Infof("Skipping: %s (%s)", h.Status, detail)
+	return false
+}
 
-	decoder := json.NewDecoder(f)
-	config := Configuration{}
-	err = decoder.Decode(&config)
+func writeSeedHealth(store secondorder.ResultStore, health []secondorder.SeedHealth) {
+	err := store.WriteJSON(saveContext, "seeds.json", map[string][]secondorder.SeedHealth{"Seeds": health})
 	if err != nil {
-		return Configuration{}, fmt.Errorf("could not decode Configuration file: %v", err)
+		logger.Errorf("Could not write seed health: %v", err)
 	}
+}
 
-	return config, nil
+// runMetadata is saved in metadata.json at the end of a run
+type runMetadata struct {
+	Start      time.Time               `json:"start"`
+	End        time.Time               `json:"end"`
+	Targets    []string                `json:"targets"`
+	Engagement *secondorder.Engagement `json:"engagement,omitempty"`
 }
 
-func createQuerySelector(tag, attribute string) string {
-	return fmt.Sprintf("%s[%s]", tag, attribute)
+func writeRunMetadata(store secondorder.ResultStore, start time.Time, targets []string, engagement secondorder.Engagement) error {
+	metadata := runMetadata{Start: start, End: time.Now(), Targets: targets}
+	if engagement != (secondorder.Engagement{}) {
+		metadata.Engagement = &engagement
+	}
+	return store.WriteJSON(saveContext, "metadata.json", metadata)
 }
 
-// a[href] -> a, href
-func unpackQuerySelector(q string) (string, string) {
-	parts := strings.Split(q, "[")
-	tag := parts[0]
-	attribute := strings.Trim(parts[1], "]")
+// progressOutput opens where progress events are written: stderr, or a unix socket the wrapper listens on
+func progressOutput(location string) (io.WriteCloser, error) {
+	if location == "stderr" {
+		return nopCloser{os.Stderr}, nil
+	}
+	if strings.HasPrefix(location, "unix:") {
+		conn, err := net.Dial("unix", strings.TrimPrefix(location, "unix:"))
+		if err != nil {
+			return nil, fmt.Errorf("could not connect to the progress socket: %v", err)
+		}
+		return conn, nil
+	}
+	return nil, fmt.Errorf("unknown progress output %q, use stderr or unix:<path>", location)
+}
+
+// nopCloser keeps stderr open when the progress output is closed
+type nopCloser struct {
+	io.Writer
+}
 
-	return tag, attribute
+func (nopCloser) Close() error {
+	return nil
 }
 
-func writeResults(filename string, content map[string]map[string][]string, resultType string) error {
-	output := make(map[string]map[string]map[string][]string)
-	output[resultType] = content
-	JSON, err := json.Marshal(output)
+// writeHTMLReport saves the findings of all targets as an HTML page
+func writeHTMLReport(store secondorder.ResultStore, results []*secondorder.Result) error {
+	report, err := secondorder.HTMLReport(results)
 	if err != nil {
-		return fmt.Errorf("could not marshal the JSON object: %v", err)
+		return err
 	}
-	err = ioutil.WriteFile(filepath.Join(outdir, filename), JSON, 0644)
-	if err != nil {
-		return fmt.Errorf("coudln't write resources to JSON: %v", err)
+	return store.WriteFile(saveContext, "report.html", report)
+}
+
+// parseFormats returns the set of output formats of -format and -formats
+// json is always produced, the other formats are written on top of the JSON results
+func parseFormats(format, extra string) (map[string]bool, error) {
+	formats := map[string]bool{"json": true}
+	names := []string{format}
+	if extra != "" {
+		names = append(names, strings.Split(extra, ",")...)
 	}
-	return nil
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		switch name {
+		case "":
+		case "json", "csv", "sarif", "jsonl", "html":
+			formats[name] = true
+		default:
+			return nil, fmt.Errorf("unknown output format %q, use json, csv, sarif, jsonl or html", name)
+		}
+	}
+	return formats, nil
 }
 
-func getHostname(rawURL string) (string, error) {
-	u, err := url.Parse(rawURL)
-	if err != nil {
-		return "", err
+// writeCSV saves the result of a target as a flat table next to its JSON files
+func writeCSV(store secondorder.ResultStore, prefix string, result *secondorder.Result) error {
+	var table bytes.Buffer
+	if err := result.WriteCSV(&table); err != nil {
+		return err
 	}
-	return u.Hostname(), nil
+	return store.WriteFile(saveContext, path.Join(prefix, "results.csv"), table.Bytes())
 }
 
-func checkOrigin(link, base string) bool {
-	linkurl, err := url.Parse(link)
+// writeTrend adds the counts of this run to the history file, and charts every run in it
+func writeTrend(store secondorder.ResultStore, start time.Time, results []*secondorder.Result) error {
+	history, err := secondorder.LoadHistory(historyFile)
 	if err != nil {
-		return false
+		return err
+	}
+	history = append(history, secondorder.Summarize(results, start))
+	if err := secondorder.WriteHistory(historyFile, history); err != nil {
+		return err
+	}
+	report, err := secondorder.TrendReport(history)
+	if err != nil {
+		return err
 	}
+	return store.WriteFile(saveContext, "trend.html", report)
+}
+
+// targetPrefix returns the prefix the results of a target are saved under
+// with a single target it's the root of the store, otherwise each target gets its own subdirectory
+func targetPrefix(target string, multiple bool, used map[string]bool) string {
+	if !multiple {
+		return ""
+	}
+	hostname := ""
+	if u, err := url.Parse(target); err == nil {
+		hostname = u.Hostname()
+	}
+	if hostname == "" {
+		hostname = "target"
+	}
+	name := regexp.MustCompile(`[^\w.-]`).ReplaceAllString(hostname, "_")
+	prefix := name
+	for i := 2; used[prefix]; i++ {
+		prefix = fmt.Sprintf("%s-%d", name, i)
+	}
+	used[prefix] = true
+	return prefix
+}
 
-	linkhost := linkurl.Hostname()
+// retest verifies the findings saved by previous runs again, without crawling, and saves which ones are still vulnerable
+func retest(args []string) {
+	var inputs Targets
+	retestHeaders := make(Headers)
+	flags := flag.NewFlagSet("retest", flag.ExitOnError)
+	flags.Var(&inputs, "input", "File of findings to retest: non-200-url-attributes.json, dangling-domains.json, dangling-links.json, takeover-candidates.json or the findings of -format jsonl (can be used more than once)")
+	flags.StringVar(&configFile, "config", "", "Configuration file, for its verification rules (default the SECOND_ORDER_CONFIG environment variable, or no rules)")
+	flags.StringVar(&outdir, "output", "output", "Directory to save retest.json in, or - to write it to stdout")
+	flags.IntVar(&threads, "threads", 10, "Number of resources to check at the same time")
+	flags.DurationVar(&timeout, "timeout", 0, "Time to wait for each response, like 30s (0 for the default of 5s)")
+	flags.BoolVar(&dnsOnly, "dns-only", false, "Only resolve the hosts of non-200 URLs, without sending HTTP requests")
+	flags.BoolVar(&allowInternal, "allow-internal", false, "Check the non-200 URLs even on private, loopback, link-local and cloud metadata addresses, which are skipped by default")
+	flags.Var(&retestHeaders, "header", "Header name and value separated by a colon 'Name: Value', overriding the Headers of the config file (can be used more than once)")
+	flags.Var(&retestHeaders, "H", "Same as -header")
+	flags.Parse(args)
+	if len(inputs) == 0 {
+		logger.Errorf("You need to specify a file of findings to retest with -input")
+		flags.PrintDefaults()
+		os.Exit(exitError)
+	}
 
-	baseURL, err := url.Parse(base)
+	var config secondorder.Config
+	var err error
+	switch {
+	case configFile != "":
+		config, err = secondorder.LoadConfig(configFile)
+	case os.Getenv(configEnv) != "":
+		config, err = secondorder.ParseConfig(os.Getenv(configEnv))
+	}
 	if err != nil {
-		return false
+		fatal(err)
+	}
+	config.Options = secondorder.Options{
+		Timeout:       timeout,
+		DNSOnly:       dnsOnly,
+		Headers:       retestHeaders,
+		Retries:       2,
+		AllowInternal: allowInternal,
+	}
+	scanner, err := secondorder.NewScanner(config)
+	if err != nil {
+		fatal(err)
 	}
-	basehost := baseURL.Hostname()
+	defer scanner.Close()
 
-	// check the main domain not the subdomain
-	// checkOrigin ("https://docs.google.com", "https://mail.google.com") => true
-	re, _ := regexp.Compile(`[\w-]*\.[\w]*$`)
-	return re.FindString(linkhost) == re.FindString(basehost)
-}
+	var findings []secondorder.Finding
+	for _, input := range inputs {
+		loaded, err := secondorder.LoadRetestInput(input)
+		if err != nil {
+			fatal(err)
+		}
+		findings = append(findings, loaded...)
+	}
+	// An interrupt stops the checks, and the resources checked until then are saved
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	results := scanner.Retest(ctx, findings, threads)
 
-func isValidURL(s string) bool {
-	u, err := url.ParseRequestURI(s)
+	store, err := secondorder.OpenResultStore(outdir, "")
 	if err != nil {
-		return false
+		fatal(err)
 	}
-	if u.IsAbs() {
-		return true
+	defer store.Close()
+	if err := store.WriteJSON(saveContext, "retest.json", map[string][]secondorder.RetestResult{"Retest": results}); err != nil {
+		fatalf("Could not write retest.json: %v", err)
 	}
-	return false
+	counts := make(map[string]int)
+	for _, r := range results {
+		counts[r.Status]++
+	}
+	logger.Infof("%d of %d resources are still vulnerable, %d are fixed, %d weren't checked", counts[secondorder.RetestVulnerable], len(results), counts[secondorder.RetestFixed], counts[secondorder.RetestSkipped])
 }
 
-func isNotFound(url string) bool {
-	// Golang's native HTTP client can't read URLs in this format: "//example.com"
-	if strings.HasPrefix(url, "//") {
-		return isNotFound("http:" + url)
+// diff lists the findings that are new, changed or gone between the results of two runs
+// it returns exitFindings when there are new or changed findings, so it can gate alerts
+func diff(args []string) int {
+	var asJSON bool
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	flags.BoolVar(&asJSON, "json", false, "Write the changes as a JSON object instead of one per line")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: second-order diff [-json] <old output> <new output>")
+		flags.PrintDefaults()
 	}
-	client := http.Client{
-		Timeout: 5 * time.Second,
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		logger.Errorf("You need to specify the output directories (or findings.json files) of the two runs to compare")
+		flags.Usage()
+		return exitError
 	}
-
-	req, err := http.NewRequest("GET", url, nil)
+	before, err := secondorder.LoadFindings(flags.Arg(0))
 	if err != nil {
-		return false
+		fatal(err)
 	}
-	for name, value := range headers {
-		req.Header.Set(name, value)
+	after, err := secondorder.LoadFindings(flags.Arg(1))
+	if err != nil {
+		fatal(err)
 	}
 
-	res, err := client.Do(req)
-	// If it doesn't respond at all, it could be an unregistered domain
+	changes := secondorder.DiffFindings(before, after)
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "    ")
+		err = encoder.Encode(map[string][]secondorder.FindingChange{"Changes": changes})
+	} else {
+		err = secondorder.WriteChanges(os.Stdout, changes)
+	}
 	if err != nil {
-		return true
+		fatal(err)
 	}
-	if res.StatusCode == 404 {
-		return true
+	counts := secondorder.CountChanges(changes)
+	logger.Infof("%d new, %d changed and %d gone findings", counts[secondorder.ChangeNew], counts[secondorder.ChangeChanged], counts[secondorder.ChangeGone])
+	if counts[secondorder.ChangeNew]+counts[secondorder.ChangeChanged] > 0 {
+		return exitFindings
 	}
-	return false
+	return exitClean
+}
+
+// validate checks a configuration file and prints every problem in it, so a long scan doesn't start with a broken one
+func validate(args []string) {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	flags.StringVar(&configFile, "config", "", "Configuration file to check")
+	flags.Parse(args)
+	if configFile == "" {
+		logger.Errorf("You need to specify the configuration file to check with -config")
+		flags.PrintDefaults()
+		os.Exit(exitError)
+	}
+
+	problems := secondorder.ValidateConfig(configFile)
+	for _, problem := range problems {
+		fmt.Printf("%s: %v\n", configFile, problem)
+	}
+	if len(problems) > 0 {
+		os.Exit(exitError)
+	}
+	fmt.Printf("%s is valid\n", configFile)
 }
 
-var userAgents = []string{
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/108.0.0.0 Safari/537.36",
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/108.0.0.0 Safari/537.36 Edg/108.0.1462.54",
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:108.0) Gecko/20100101 Firefox/108.0",
-	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/108.0.0.0 Safari/537.36",
-	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.2 Safari/605.1.15",
-	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_6) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/108.0.0.0 Safari/537.36",
-	"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_14_6) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.2 Safari/605.1.15",
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/108.0.0.0 Safari/537.36 Edg/108.0.1462.46",
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:108.0) Gecko/20100101 Firefox/108.0",
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/92.0.4515.131 Safari/537.36 Edg/92.0.902.67",
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/89.0.4389.82 Safari/537.36",
-	"Mozilla/5.0 (Macintosh; Intel Mac OS X 11_0_0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/89.0.4389.82 Safari/537.36",
-	"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/89.0.4389.82 Safari/537.36",
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:87.0) Gecko/20100101 Firefox/87.0",
-	"Mozilla/5.0 (Macintosh; Intel Mac OS X 11_0_0; rv:87.0) Gecko/20100101 Firefox/87.0",
-	"Mozilla/5.0 (X11; Linux x86_64; rv:87.0) Gecko/20100101 Firefox/87.0",
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/86.0.4240.198 Safari/537.36 Edg/87.0.664.59",
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/86.0.4240.198 Safari/537.36 Edg/87.0.664.56",
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:85.0) Gecko/20100101 Firefox/85.0",
-	"Mozilla/5.0 (Macintosh; Intel Mac OS X 11_0_0; rv:85.0) Gecko/20100101 Firefox/85.0",
-	"Mozilla/5.0 (X11; Linux x86_64; rv:85.0) Gecko/20100101 Firefox/85.0",
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.141 Safari/537.36 Edg/87.0.664.75",
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.141 Safari/537.36 Edg/87.0.664.72",
-	"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/87.0.4280.141 Safari/537.36",
+// initConfig writes the example configuration, with a comment on every field, to start a configuration from
+func initConfig(args []string) {
+	var output string
+	var force bool
+	flags := flag.NewFlagSet("init", flag.ExitOnError)
+	flags.StringVar(&output, "output", "config.yaml", "File to write the configuration to, or - to write it to stdout")
+	flags.BoolVar(&force, "force", false, "Overwrite the file if it exists")
+	flags.Parse(args)
+
+	if output == "-" {
+		os.Stdout.Write(exampleConfig)
+		return
+	}
+	mode := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		mode = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(output, mode, 0644)
+	if os.IsExist(err) {
+		fatalf("%s already exists, use -force to overwrite it", output)
+	} else if err != nil {
+		fatal(err)
+	}
+	if _, err := f.Write(exampleConfig); err != nil {
+		fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		fatal(err)
+	}
+	logger.Infof("Wrote %s, check it after editing with: second-order validate -config %s", output, output)
 }